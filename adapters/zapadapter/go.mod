@@ -0,0 +1,16 @@
+module github.com/jakewan/sudsy/adapters/zapadapter
+
+go 1.22.4
+
+require (
+	github.com/jakewan/sudsy v0.0.0
+	go.uber.org/zap v1.27.0
+)
+
+require (
+	go.uber.org/multierr v1.10.0 // indirect
+	golang.org/x/crypto v0.32.0 // indirect
+)
+
+// The adapter is developed alongside the framework.
+replace github.com/jakewan/sudsy => ../..
@@ -0,0 +1,47 @@
+// Package zapadapter sends the messages logged by sudsy to a zap logger:
+//
+//	sudsy.SetLogger(zapadapter.New(logger))
+//
+// The adapter is a separate module, so that sudsy does not depend on zap.
+package zapadapter
+
+import (
+	"github.com/jakewan/sudsy"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// New returns a sudsy.Logger logging messages at the matching level of l,
+// with the logging component and ID as the "component" and "id" fields.
+func New(l *zap.Logger) sudsy.Logger {
+	return &logger{l: l}
+}
+
+type logger struct {
+	l *zap.Logger
+}
+
+// Log implements sudsy.Logger.
+func (l *logger) Log(e sudsy.LogEntry) {
+	if ce := l.l.Check(level(e.Level), e.Message); ce != nil {
+		fields := []zap.Field{zap.String("component", e.Component)}
+		if e.ID != "" {
+			fields = append(fields, zap.String("id", e.ID))
+		}
+		ce.Write(fields...)
+	}
+}
+
+// level returns the zap level of messages of level l.
+func level(l sudsy.LogLevel) zapcore.Level {
+	switch l {
+	case sudsy.LogLevelError:
+		return zap.ErrorLevel
+	case sudsy.LogLevelWarn:
+		return zap.WarnLevel
+	case sudsy.LogLevelInfo:
+		return zap.InfoLevel
+	default:
+		return zap.DebugLevel
+	}
+}
@@ -0,0 +1,18 @@
+module github.com/jakewan/sudsy/adapters/zerologadapter
+
+go 1.22.4
+
+require (
+	github.com/jakewan/sudsy v0.0.0
+	github.com/rs/zerolog v1.33.0
+)
+
+require (
+	github.com/mattn/go-colorable v0.1.14 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	golang.org/x/crypto v0.32.0 // indirect
+	golang.org/x/sys v0.29.0 // indirect
+)
+
+// The adapter is developed alongside the framework.
+replace github.com/jakewan/sudsy => ../..
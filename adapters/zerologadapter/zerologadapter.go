@@ -0,0 +1,45 @@
+// Package zerologadapter sends the messages logged by sudsy to a zerolog
+// logger:
+//
+//	sudsy.SetLogger(zerologadapter.New(logger))
+//
+// The adapter is a separate module, so that sudsy does not depend on zerolog.
+package zerologadapter
+
+import (
+	"github.com/jakewan/sudsy"
+	"github.com/rs/zerolog"
+)
+
+// New returns a sudsy.Logger logging messages at the matching level of l,
+// with the logging component and ID as the "component" and "id" fields.
+func New(l zerolog.Logger) sudsy.Logger {
+	return &logger{l: l}
+}
+
+type logger struct {
+	l zerolog.Logger
+}
+
+// Log implements sudsy.Logger.
+func (l *logger) Log(e sudsy.LogEntry) {
+	event := l.l.WithLevel(level(e.Level)).Str("component", e.Component)
+	if e.ID != "" {
+		event = event.Str("id", e.ID)
+	}
+	event.Msg(e.Message)
+}
+
+// level returns the zerolog level of messages of level l.
+func level(l sudsy.LogLevel) zerolog.Level {
+	switch l {
+	case sudsy.LogLevelError:
+		return zerolog.ErrorLevel
+	case sudsy.LogLevelWarn:
+		return zerolog.WarnLevel
+	case sudsy.LogLevelInfo:
+		return zerolog.InfoLevel
+	default:
+		return zerolog.DebugLevel
+	}
+}
@@ -0,0 +1,258 @@
+package benchmarks
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jakewan/sudsy"
+	"github.com/jakewan/sudsy/internal/application"
+)
+
+// routeCounts are the sizes of the routing tables benchmarked, from a small
+// service to a large API gateway.
+var routeCounts = []int{10, 100, 5000}
+
+type paramsKey struct{}
+
+// sectionOpt holds the section options returned by the sudsy package.
+type sectionOpt = func(application.Section)
+
+// newSection returns a section at /api/ configured with opts.
+func newSection(opts []sectionOpt) application.Section {
+	s := sudsy.NewApplicationSection("/api/")
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+func TestMain(m *testing.M) {
+	// Debug logging would otherwise dominate the measurements.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// routeOpts returns options registering n routes under /api/, a mix of
+// static routes and routes capturing one or two path parameters, and a
+// catch-all route serving /api/files/.
+func routeOpts(n int) []sectionOpt {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	result := make([]sectionOpt, 0, n+1)
+	for i := range n {
+		var pattern string
+		switch i % 3 {
+		case 0:
+			pattern = fmt.Sprintf("/api/resource%d", i)
+		case 1:
+			pattern = fmt.Sprintf("/api/resource%d/:id", i)
+		default:
+			pattern = fmt.Sprintf("/api/resource%d/:id/items/:itemID", i)
+		}
+		result = append(result, sudsy.WithPathPatternHandler(pattern, ok, paramsKey{}))
+	}
+	result = append(result, sudsy.WithPathPatternHandler("/api/files/*path", ok, paramsKey{}))
+	return result
+}
+
+// newHandler returns the handler of a section at /api/ with n routes and the
+// given additional options.
+func newHandler(tb testing.TB, n int, opts ...sectionOpt) http.Handler {
+	tb.Helper()
+	sectionOpts := routeOpts(n)
+	sectionOpts = append(sectionOpts, opts...)
+	return newSection(sectionOpts).NewHandler()
+}
+
+// requestPath returns a path matching the last route registered by routeOpts,
+// which is the worst case for routers scanning the table in order.
+func requestPath(n int) string {
+	i := n - 1
+	switch i % 3 {
+	case 0:
+		return fmt.Sprintf("/api/resource%d", i)
+	case 1:
+		return fmt.Sprintf("/api/resource%d/42", i)
+	default:
+		return fmt.Sprintf("/api/resource%d/42/items/7", i)
+	}
+}
+
+// catchAllPath matches the catch-all route registered by routeOpts.
+const catchAllPath = "/api/files/css/site.css"
+
+// missPath matches no route registered by routeOpts.
+const missPath = "/api/missing/route"
+
+type variant struct {
+	name  string
+	opts  []sectionOpt
+	setup func(*http.Request)
+}
+
+var variants = []variant{
+	{name: "plain"},
+	{
+		name: "ratelimited",
+		opts: []sectionOpt{
+			// The budget is never exhausted, so that every request reaches
+			// the route.
+			sudsy.WithRateLimitingSessionConfig(1<<62, time.Minute, time.Minute),
+		},
+	},
+	{
+		name: "cached",
+		opts: []sectionOpt{sudsy.WithRouteCache(1024)},
+	},
+	{
+		name: "authed",
+		opts: []sectionOpt{
+			sudsy.WithBasicAuth("user", "secret", "benchmarks"),
+		},
+		setup: func(r *http.Request) {
+			r.SetBasicAuth("user", "secret")
+		},
+	},
+}
+
+func BenchmarkRouting(b *testing.B) {
+	for _, v := range variants {
+		for _, n := range routeCounts {
+			b.Run(fmt.Sprintf("%s/routes=%d", v.name, n), func(b *testing.B) {
+				h := newHandler(b, n, v.opts...)
+				r := httptest.NewRequest(http.MethodGet, requestPath(n), nil)
+				if v.setup != nil {
+					v.setup(r)
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if w.Code != http.StatusNoContent {
+					b.Fatalf("unexpected status %d", w.Code)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for range b.N {
+					h.ServeHTTP(discardResponseWriter{header: http.Header{}}, r)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkRoutingParallel(b *testing.B) {
+	for _, v := range variants {
+		b.Run(v.name, func(b *testing.B) {
+			n := routeCounts[1]
+			h := newHandler(b, n, v.opts...)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				r := httptest.NewRequest(http.MethodGet, requestPath(n), nil)
+				if v.setup != nil {
+					v.setup(r)
+				}
+				for pb.Next() {
+					h.ServeHTTP(discardResponseWriter{header: http.Header{}}, r)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkNotFound(b *testing.B) {
+	for _, n := range routeCounts {
+		b.Run(fmt.Sprintf("routes=%d", n), func(b *testing.B) {
+			h := newHandler(b, n)
+			r := httptest.NewRequest(http.MethodGet, missPath, nil)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				h.ServeHTTP(discardResponseWriter{header: http.Header{}}, r)
+			}
+		})
+	}
+}
+
+func BenchmarkRegistration(b *testing.B) {
+	for _, n := range routeCounts {
+		b.Run(fmt.Sprintf("routes=%d", n), func(b *testing.B) {
+			opts := routeOpts(n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				newSection(opts)
+			}
+		})
+	}
+}
+
+// lookup is a kind of request served in TestAllocsPerRequest, matching a
+// route registered by routeOpts whatever the number of routes, or none.
+type lookup struct {
+	name   string
+	path   string
+	status int
+}
+
+var lookups = []lookup{
+	{name: "static", path: "/api/resource0", status: http.StatusNoContent},
+	{name: "params", path: "/api/resource2/42/items/7", status: http.StatusNoContent},
+	{name: "catchall", path: catchAllPath, status: http.StatusNoContent},
+	{name: "miss", path: missPath, status: http.StatusNotFound},
+}
+
+// maxAllocsPerRequest bounds, by variant and lookup, the allocations made
+// serving a request, whatever the number of routes. Lower a bound when an
+// optimization allows it, so that it is guarded from then on.
+var maxAllocsPerRequest = map[string]map[string]float64{
+	"plain":       {"static": 17, "params": 25, "catchall": 27, "miss": 19},
+	"cached":      {"static": 17, "params": 20, "catchall": 20, "miss": 19},
+	"ratelimited": {"static": 46, "params": 54, "catchall": 56, "miss": 48},
+	"authed":      {"static": 22, "params": 30, "catchall": 32, "miss": 24},
+}
+
+func TestAllocsPerRequest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("allocation assertions skipped in short mode")
+	}
+	for _, n := range routeCounts {
+		for _, v := range variants {
+			h := newHandler(t, n, v.opts...)
+			for _, l := range lookups {
+				t.Run(fmt.Sprintf("%s/routes=%d/%s", v.name, n, l.name), func(t *testing.T) {
+					r := httptest.NewRequest(http.MethodGet, l.path, nil)
+					if v.setup != nil {
+						v.setup(r)
+					}
+					w := httptest.NewRecorder()
+					h.ServeHTTP(w, r)
+					if w.Code != l.status {
+						t.Fatalf("got status %d, want %d", w.Code, l.status)
+					}
+					allocs := testing.AllocsPerRun(100, func() {
+						h.ServeHTTP(discardResponseWriter{header: http.Header{}}, r)
+					})
+					if limit := maxAllocsPerRequest[v.name][l.name]; allocs > limit {
+						t.Errorf("%.0f allocations per request, want at most %.0f", allocs, limit)
+					}
+				})
+			}
+		}
+	}
+}
+
+// discardResponseWriter is a minimal http.ResponseWriter, so that the
+// measurements reflect the server rather than the recorder.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w discardResponseWriter) Header() http.Header         { return w.header }
+func (w discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w discardResponseWriter) WriteHeader(int)             {}
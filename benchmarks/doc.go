@@ -0,0 +1,7 @@
+// Package benchmarks measures request handling through sections built with the
+// public API, using realistic routing tables with and without rate limiting
+// and authentication. Allocation assertions guard against regressions in the
+// hot path, and the benchmarks validate performance-motivated changes:
+//
+//	go test ./benchmarks -bench . -benchmem
+package benchmarks
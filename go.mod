@@ -2,4 +2,4 @@ module github.com/jakewan/sudsy
 
 go 1.22.4
 
-require github.com/vardius/shutdown v1.0.2
+require golang.org/x/crypto v0.32.0
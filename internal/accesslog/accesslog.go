@@ -0,0 +1,117 @@
+// Package accesslog writes an entry per request served to an access log, in
+// the combined log format, and provides a log file rotated by size or time.
+package accesslog
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/realip"
+	"github.com/jakewan/sudsy/internal/responseinfo"
+)
+
+var logger = common.NewLogger("accesslog")
+
+// Logger writes the entries to a writer.
+type Logger struct {
+	locker sync.Mutex
+	w      io.WriteCloser
+}
+
+// New returns a Logger writing the entries to w.
+func New(w io.WriteCloser) *Logger {
+	return &Logger{w: w}
+}
+
+// Hook writes the entry of the response described by info. It is a
+// responseinfo.Hook.
+func (l *Logger) Hook(info *responseinfo.Info) {
+	entry := appendEntry(make([]byte, 0, 256), info, time.Now().Add(-info.Latency))
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	if _, err := l.w.Write(entry); err != nil {
+		logger.Debug("", "Error writing access log: %s", err)
+	}
+}
+
+// Close closes the writer.
+func (l *Logger) Close() error {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	return l.w.Close()
+}
+
+// appendEntry appends the combined log format entry of the request received
+// at receivedAt, e.g.:
+//
+//	192.0.2.1 - - [17/Oct/2026:20:52:31 +0000] "GET /items?page=2 HTTP/1.1" 200 1234 "-" "curl/8.5.0"
+func appendEntry(b []byte, info *responseinfo.Info, receivedAt time.Time) []byte {
+	r := info.Request
+	b = append(b, clientHost(r)...)
+	b = append(b, " - - ["...)
+	b = receivedAt.AppendFormat(b, "02/Jan/2006:15:04:05 -0700")
+	b = append(b, "] \""...)
+	b = appendEscaped(b, r.Method)
+	b = append(b, ' ')
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	b = appendEscaped(b, uri)
+	b = append(b, ' ')
+	b = appendEscaped(b, r.Proto)
+	b = append(b, "\" "...)
+	b = strconv.AppendInt(b, int64(info.Status), 10)
+	b = append(b, ' ')
+	if info.Size > 0 {
+		b = strconv.AppendInt(b, info.Size, 10)
+	} else {
+		b = append(b, '-')
+	}
+	b = append(b, " \""...)
+	b = appendEscaped(b, headerOrDash(r, "referer"))
+	b = append(b, "\" \""...)
+	b = appendEscaped(b, headerOrDash(r, "user-agent"))
+	return append(b, "\"\n"...)
+}
+
+// clientHost returns the client address resolved by the application, or the
+// host of the connection's remote address.
+func clientHost(r *http.Request) string {
+	if ip, ok := realip.FromContext(r.Context()); ok {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func headerOrDash(r *http.Request, name string) string {
+	if v := r.Header.Get(name); v != "" {
+		return v
+	}
+	return "-"
+}
+
+// appendEscaped appends s with quotes, backslashes and non-printable bytes
+// escaped, so that clients cannot forge entries.
+func appendEscaped(b []byte, s string) []byte {
+	const hexDigits = "0123456789abcdef"
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c < 0x20 || c >= 0x7f:
+			b = append(b, '\\', 'x', hexDigits[c>>4], hexDigits[c&0xf])
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
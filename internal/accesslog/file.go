@@ -0,0 +1,254 @@
+package accesslog
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the format of the rotation time in the names of
+// rotated files, e.g. "access-20261017T205231.000.log".
+const backupTimeFormat = "20060102T150405.000"
+
+// FileConfig configures a File.
+type FileConfig struct {
+	Path string
+	// MaxSize is the size in bytes beyond which the file is rotated, or 0
+	// for no limit.
+	MaxSize int64
+	// Interval rotates the file whenever the time crosses a multiple of it
+	// since the zero time in UTC, e.g. every day at midnight UTC for 24
+	// hours, or 0 for no time-based rotation.
+	Interval time.Duration
+	// MaxBackups is the number of rotated files kept, or 0 to keep them all.
+	MaxBackups int
+	// MaxAge is how long rotated files are kept, or 0 to keep them
+	// regardless of age.
+	MaxAge time.Duration
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// File is a log file rotated by size or time. Rotated files are renamed with
+// the rotation time, and compressed and removed in the background.
+type File struct {
+	config FileConfig
+
+	locker   sync.Mutex
+	f        *os.File
+	size     int64
+	rotateAt time.Time
+
+	mill     chan struct{}
+	millDone chan struct{}
+}
+
+// OpenFile opens or creates the file at c.Path for appending, and its
+// directory if needed.
+func OpenFile(c FileConfig) (*File, error) {
+	f := &File{
+		config:   c,
+		mill:     make(chan struct{}, 1),
+		millDone: make(chan struct{}),
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	go f.runMill()
+	// Files rotated by a previous process may remain to be processed.
+	f.mill <- struct{}{}
+	return f, nil
+}
+
+// Write implements io.Writer, rotating the file first if writing p would
+// exceed the maximum size, or the rotation time has passed.
+func (f *File) Write(p []byte) (int, error) {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	if f.f == nil {
+		return 0, os.ErrClosed
+	}
+	exceedsSize := f.config.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.config.MaxSize
+	if exceedsSize || (!f.rotateAt.IsZero() && !time.Now().Before(f.rotateAt)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close implements io.Closer, waiting for rotated files to be processed.
+func (f *File) Close() error {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	if f.f == nil {
+		return os.ErrClosed
+	}
+	err := f.f.Close()
+	f.f = nil
+	close(f.mill)
+	<-f.millDone
+	return err
+}
+
+func (f *File) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.config.Path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.f = file
+	f.size = info.Size()
+	if f.config.Interval > 0 {
+		f.rotateAt = time.Now().Truncate(f.config.Interval).Add(f.config.Interval)
+	}
+	return nil
+}
+
+func (f *File) rotate() error {
+	if err := f.f.Close(); err != nil {
+		return err
+	}
+	f.f = nil
+	renameErr := os.Rename(f.config.Path, f.backupPath(time.Now()))
+	// Logging goes on in the current file if it cannot be renamed.
+	if err := f.open(); err != nil || renameErr != nil {
+		return errors.Join(renameErr, err)
+	}
+	select {
+	case f.mill <- struct{}{}:
+	default:
+		// The mill is already due to run.
+	}
+	return nil
+}
+
+// backupPath returns the path of the file rotated at t.
+func (f *File) backupPath(t time.Time) string {
+	prefix, ext := f.backupNameParts()
+	name := prefix + t.UTC().Format(backupTimeFormat) + ext
+	return filepath.Join(filepath.Dir(f.config.Path), name)
+}
+
+// backupNameParts returns the parts of the names of rotated files preceding
+// and following the rotation time.
+func (f *File) backupNameParts() (string, string) {
+	base := filepath.Base(f.config.Path)
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "-", ext
+}
+
+func (f *File) runMill() {
+	defer close(f.millDone)
+	for range f.mill {
+		if err := f.processBackups(); err != nil {
+			logger.Debug("mill", "Error processing rotated files of %s: %s", f.config.Path, err)
+		}
+	}
+}
+
+type backup struct {
+	path       string
+	rotatedAt  time.Time
+	compressed bool
+}
+
+// processBackups removes the rotated files beyond the retention limits and
+// compresses the others.
+func (f *File) processBackups() error {
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	// Newest first.
+	slices.SortFunc(backups, func(a, b backup) int {
+		return b.rotatedAt.Compare(a.rotatedAt)
+	})
+	var errs []error
+	for i, b := range backups {
+		expired := f.config.MaxAge > 0 && time.Since(b.rotatedAt) > f.config.MaxAge
+		if (f.config.MaxBackups > 0 && i >= f.config.MaxBackups) || expired {
+			errs = append(errs, os.Remove(b.path))
+			continue
+		}
+		if f.config.Compress && !b.compressed {
+			errs = append(errs, compress(b.path))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// backups returns the rotated files.
+func (f *File) backups() ([]backup, error) {
+	dir := filepath.Dir(f.config.Path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	prefix, ext := f.backupNameParts()
+	var result []backup
+	for _, e := range entries {
+		name := e.Name()
+		b := backup{path: filepath.Join(dir, name)}
+		if b.compressed = strings.HasSuffix(name, ext+".gz"); b.compressed {
+			name = strings.TrimSuffix(name, ".gz")
+		}
+		if !e.Type().IsRegular() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+		if err != nil {
+			continue
+		}
+		b.rotatedAt = t
+		result = append(result, b)
+	}
+	return result, nil
+}
+
+// compress replaces the file at path with its gzipped copy.
+func compress(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(dst.Name())
+		}
+	}()
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,126 @@
+// Package admin provides an HTTP handler exposing operational introspection
+// and controls for a running application.
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/application"
+	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/lifecycle"
+	"github.com/jakewan/sudsy/internal/ratelimiting"
+	"github.com/jakewan/sudsy/internal/recovery"
+	"github.com/jakewan/sudsy/internal/runtimestats"
+	"github.com/jakewan/sudsy/internal/unmatched"
+)
+
+var logger = common.NewLogger("admin")
+
+type Dependencies interface {
+	Drain()
+	Draining() bool
+	EnableRoute(sectionRoot, route string, methods []string) error
+	PanicStats() map[string][]recovery.RouteStats
+	RateLimitingBans() map[string][]ratelimiting.Ban
+	Routes() []application.RouteInfo
+	ShutdownProgress() lifecycle.Progress
+	UnmatchedPaths() map[string][]unmatched.PathCount
+}
+
+// NewHandler returns a handler serving the admin API beneath root.
+func NewHandler(deps Dependencies, root string) http.Handler {
+	h := &handler{deps: deps}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /panics", h.getPanics)
+	mux.HandleFunc("GET /ratelimiting/bans", h.getRateLimitingBans)
+	mux.HandleFunc("GET /routes", h.getRoutes)
+	mux.HandleFunc("GET /runtime", h.getRuntime)
+	mux.HandleFunc("POST /routes/enable", h.postRoutesEnable)
+	mux.HandleFunc("GET /shutdown", h.getShutdown)
+	mux.HandleFunc("GET /ready", h.getReady)
+	mux.HandleFunc("POST /drain", h.postDrain)
+	mux.HandleFunc("GET /unmatched", h.getUnmatched)
+	return http.StripPrefix(trimTrailingSlash(root), mux)
+}
+
+type handler struct {
+	deps Dependencies
+}
+
+func (h *handler) getPanics(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, h.deps.PanicStats())
+}
+
+// getRateLimitingBans serves the clients currently banned, keyed by section
+// root.
+func (h *handler) getRateLimitingBans(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, h.deps.RateLimitingBans())
+}
+
+// getReady serves the readiness check, which fails once the application is
+// draining so load balancers stop routing traffic to it.
+func (h *handler) getReady(w http.ResponseWriter, _ *http.Request) {
+	if h.deps.Draining() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+func (h *handler) postDrain(w http.ResponseWriter, _ *http.Request) {
+	h.deps.Drain()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) getShutdown(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, h.deps.ShutdownProgress())
+}
+
+func (h *handler) getRoutes(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, h.deps.Routes())
+}
+
+// getRuntime serves process uptime along with goroutine, memory, GC and file
+// descriptor statistics.
+func (h *handler) getRuntime(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, runtimestats.Collect(time.Now()))
+}
+
+// postRoutesEnable re-enables a route disabled after repeated panics. The
+// section root, route pattern and the methods served by the route's handler
+// are taken from the "section", "route" and "methods" query parameters, the
+// methods separated by commas as reported by GET /panics.
+func (h *handler) postRoutesEnable(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var methods []string
+	if v := q.Get("methods"); v != "" {
+		methods = strings.Split(v, ",")
+	}
+	if err := h.deps.EnableRoute(q.Get("section"), q.Get("route"), methods); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) getUnmatched(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, h.deps.UnmatchedPaths())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Debug("", "Error writing response: %s", err)
+	}
+}
+
+func trimTrailingSlash(s string) string {
+	if len(s) > 0 && s[len(s)-1] == '/' {
+		return s[:len(s)-1]
+	}
+	return s
+}
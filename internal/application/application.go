@@ -2,35 +2,236 @@ package application
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"net"
+	"io"
 	"net/http"
 	"os"
-	"slices"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/jakewan/sudsy/internal/accesslog"
 	"github.com/jakewan/sudsy/internal/common"
-	"github.com/vardius/shutdown"
+	"github.com/jakewan/sudsy/internal/cors"
+	"github.com/jakewan/sudsy/internal/drain"
+	"github.com/jakewan/sudsy/internal/events"
+	"github.com/jakewan/sudsy/internal/flags"
+	"github.com/jakewan/sudsy/internal/lifecycle"
+	"github.com/jakewan/sudsy/internal/metrics"
+	"github.com/jakewan/sudsy/internal/ratelimiting"
+	"github.com/jakewan/sudsy/internal/realip"
+	"github.com/jakewan/sudsy/internal/recovery"
+	"github.com/jakewan/sudsy/internal/responseinfo"
+	"github.com/jakewan/sudsy/internal/sampling"
+	"github.com/jakewan/sudsy/internal/smuggling"
+	"github.com/jakewan/sudsy/internal/tlscert"
+	"github.com/jakewan/sudsy/internal/tlspolicy"
+	"github.com/jakewan/sudsy/internal/unmatched"
+	"github.com/jakewan/sudsy/internal/urlpathpatternhandler"
 )
 
 var (
 	logger = common.NewLogger("application")
+	// hotPathLogger logs the messages of every request.
+	hotPathLogger = common.NewSampledLogger("application")
 )
 
 type Application interface {
 	AddAfterShutdownFunc(f func())
 	AddBeforeShutdownFunc(f func())
+	AddDrainFunc(name string, timeout time.Duration, f drain.Func) (unregister func())
+	AddEventObserver(events.Observer)
+	// AddGlobalMiddleware wraps the handler dispatching requests to the
+	// sections of each server with mw, the first added outermost.
+	AddGlobalMiddleware(mw func(http.Handler) http.Handler)
+	// AddGlobalRateLimitingSessionConfig adds a session config to the
+	// application-wide rate limiter, which counts a client's requests to
+	// every section against a single budget.
+	AddGlobalRateLimitingSessionConfig(maxRequests int64, sessionDuration, banDuration time.Duration)
+	AddOnResponseHook(responseinfo.Hook)
+	// AddPropagatedHeader copies the named inbound header into the request
+	// context, for propagation to outbound requests and response hooks.
+	AddPropagatedHeader(name string)
 	AddSection(Section) error
+	// AddConfigError records an error of the application's configuration,
+	// such as that of a section an option failed to add, which Err, DryRun
+	// and ListenAndServe report.
+	AddConfigError(error)
+	Drain()
+	Draining() bool
+	// EnableRoute re-enables the handler of the route pattern serving
+	// methods in the section at sectionRoot, see Section.EnableRoute.
+	EnableRoute(sectionRoot, route string, methods []string) error
+	// Err returns the errors recorded with AddConfigError.
+	Err() error
+	// GroomRateLimiting evicts idle rate limiting cache entries of every
+	// section and of the application-wide limiter.
+	GroomRateLimiting()
+	// DryRun configures the application's servers without starting them,
+	// returning the error ListenAndServe would fail with. The application
+	// must not be started afterwards.
+	DryRun() error
 	ListenAndServe()
+	PanicStats() map[string][]recovery.RouteStats
+	RateLimitingBans() map[string][]ratelimiting.Ban
+	Routes() []RouteInfo
+	// SetAccessLog enables writing an entry per request served to the writer
+	// returned by open, which is called when the application starts and
+	// closed after shutdown.
+	SetAccessLog(open func() (io.WriteCloser, error))
+	SetCORSConfig(cors.Config)
+	SetDrainConnectionClose(bool)
+	// SetDevMode enables development mode in every section.
+	SetDevMode(bool)
+	SetErrorReporter(recovery.Reporter)
+	SetHijackedConnectionTimeout(time.Duration)
+	// SetIdleShutdownTimeout enables stopping the application once no
+	// requests have been served for the given duration.
+	SetIdleShutdownTimeout(time.Duration)
+	SetFlagProvider(flags.Provider)
+	SetGlobalRateLimitingLazyExpiration(bool)
+	SetKeepAlivesEnabled(bool)
+	// SetListenRetryPeriod sets how long binding an address in use is
+	// retried at startup before failing.
+	SetListenRetryPeriod(time.Duration)
+	SetMaxRequestsPerConnection(int64)
+	// SetMetricsLabelValueLimits sets the number of distinct values of the
+	// given labels reported to the metrics recorder, beyond which values are
+	// replaced with metrics.OtherLabelValue. It replaces the default limits.
+	SetMetricsLabelValueLimits(map[string]int)
+	SetMetricsRecorder(metrics.Recorder)
+	SetOCSPStapling(bool)
+	SetRealIPResolver(realip.Resolver)
+	// SetRouteTable enables writing a table of the sections, with their
+	// middleware, and routes to w once the servers are configured at
+	// startup, or logging it if w is nil.
+	SetRouteTable(w io.Writer)
+	// SetServerListenHost restricts the interface the servers bind to. The
+	// empty host binds all interfaces.
+	SetServerListenHost(string)
 	SetServerListenPort(int)
+	SetSessionTicketKeyProvider(tlscert.SessionTicketKeyProvider)
+	SetSmugglingConfig(smuggling.Config)
+	SetSessionTicketKeyRotationInterval(time.Duration)
+	SetShutdownProgressInterval(time.Duration)
+	SetTLSCertificateFiles(certFile, keyFile string)
+	// SetTraceSampler enables sampling the trace context of requests.
+	SetTraceSampler(sampling.Sampler)
+	SetTLSCipherSuites(...uint16)
+	SetTLSClientAuth(tls.ClientAuthType)
+	SetTLSClientCAFile(string)
+	SetTLSCurvePreferences(...tls.CurveID)
+	SetTLSMinVersion(uint16)
+	SetTLSNextProtos(...string)
+	ShutdownProgress() lifecycle.Progress
+	UnmatchedPaths() map[string][]unmatched.PathCount
+	// URLFor returns the path of the route with the given name in any
+	// section, see Section.URLFor.
+	URLFor(name string, params map[string]string) (string, error)
 }
 
 type application struct {
 	afterShutdownFuncs  []func()
 	beforeShutdownFuncs []func()
+	configErrs          []error
+	metrics             metrics.Recorder
 	sections            []Section
+	serverListenHost    string
 	serverListenPort    int
+
+	// metricsRecorder is the recorder set, to which metrics are passed
+	// through a cardinality guard.
+	metricsRecorder         metrics.Recorder
+	metricsLabelValueLimits map[string]int
+
+	// shutdownProgressInterval is how often shutdown progress is logged while
+	// the server drains.
+	shutdownProgressInterval time.Duration
+
+	lifecycleHandlers []lifecycle.MiddlewareHandler
+
+	shuttingDown atomic.Bool
+
+	draining atomic.Bool
+
+	// drainConnectionClose causes responses served while draining to close
+	// their connections.
+	drainConnectionClose bool
+
+	devMode bool
+
+	keepAlivesDisabled bool
+
+	listenRetryPeriod time.Duration
+
+	maxRequestsPerConnection int64
+
+	tlsCertFile string
+
+	tlsKeyFile string
+
+	tlsPolicy tlspolicy.Policy
+
+	ocspStapling bool
+
+	sessionTicketKeyProvider tlscert.SessionTicketKeyProvider
+
+	sessionTicketKeyRotationInterval time.Duration
+
+	tlsClientCAFile string
+
+	realIPResolver realip.Resolver
+
+	// smugglingConfig enables request smuggling hardening when set.
+	smugglingConfig *smuggling.Config
+
+	// traceSampler samples the trace context of requests when set.
+	traceSampler sampling.Sampler
+
+	// propagatedHeaders are copied from inbound requests to outbound ones.
+	propagatedHeaders []string
+
+	onResponseHooks []responseinfo.Hook
+
+	// globalMiddlewares wrap the sections of each server.
+	globalMiddlewares []func(http.Handler) http.Handler
+
+	routeTable       bool
+	routeTableWriter io.Writer
+
+	accessLogOpen func() (io.WriteCloser, error)
+	accessLog     *accesslog.Logger
+
+	eventBus events.Bus
+
+	flagProvider flags.Provider
+
+	errorReporter recovery.Reporter
+
+	drainCoordinator drain.Coordinator
+
+	corsConfig *cors.Config
+
+	globalRateLimitingConfigs []sectionRateLimitingConfig
+
+	// globalRateLimitingLazyExpiration disables background grooming of the
+	// application-wide limiter.
+	globalRateLimitingLazyExpiration bool
+
+	// globalRateLimiter is created with the servers when global rate
+	// limiting session configs have been added.
+	globalRateLimiter ratelimiting.MiddlewareHandler
+
+	// idleShutdownTimeout enables idle shutdown when positive.
+	idleShutdownTimeout time.Duration
+
+	// hijackedConnectionTimeout is how long shutdown waits for hijacked
+	// connections to be closed before closing them forcibly.
+	hijackedConnectionTimeout time.Duration
 }
 
 // AddAfterShutdownFunc implements Application.
@@ -43,36 +244,442 @@ func (a *application) AddBeforeShutdownFunc(f func()) {
 	a.beforeShutdownFuncs = append(a.beforeShutdownFuncs, f)
 }
 
+// AddDrainFunc implements Application.
+func (a *application) AddDrainFunc(name string, timeout time.Duration, f drain.Func) func() {
+	return a.drainCoordinator.Register(name, timeout, f)
+}
+
+// Drain implements Application.
+func (a *application) Drain() {
+	if !a.draining.Swap(true) {
+		logger.Debug("", "Draining")
+	}
+}
+
+// Draining implements Application.
+func (a *application) Draining() bool {
+	return a.draining.Load()
+}
+
+// SetDrainConnectionClose implements Application.
+func (a *application) SetDrainConnectionClose(v bool) {
+	a.drainConnectionClose = v
+}
+
+// SetDevMode implements Application.
+func (a *application) SetDevMode(v bool) {
+	a.devMode = v
+	for _, s := range a.sections {
+		s.SetDevMode(v)
+	}
+}
+
+// SetKeepAlivesEnabled implements Application.
+func (a *application) SetKeepAlivesEnabled(v bool) {
+	a.keepAlivesDisabled = !v
+}
+
+// SetListenRetryPeriod implements Application.
+func (a *application) SetListenRetryPeriod(d time.Duration) {
+	a.listenRetryPeriod = d
+}
+
+// SetMaxRequestsPerConnection implements Application.
+func (a *application) SetMaxRequestsPerConnection(n int64) {
+	a.maxRequestsPerConnection = n
+}
+
+// SetOCSPStapling implements Application.
+func (a *application) SetOCSPStapling(v bool) {
+	a.ocspStapling = v
+}
+
+// SetRealIPResolver implements Application.
+func (a *application) SetRealIPResolver(r realip.Resolver) {
+	a.realIPResolver = r
+}
+
+// SetSmugglingConfig implements Application.
+func (a *application) SetSmugglingConfig(c smuggling.Config) {
+	a.smugglingConfig = &c
+}
+
+// AddPropagatedHeader implements Application.
+func (a *application) AddPropagatedHeader(name string) {
+	a.propagatedHeaders = append(a.propagatedHeaders, name)
+}
+
+// SetTraceSampler implements Application.
+func (a *application) SetTraceSampler(s sampling.Sampler) {
+	a.traceSampler = s
+}
+
+// SetSessionTicketKeyProvider implements Application.
+func (a *application) SetSessionTicketKeyProvider(p tlscert.SessionTicketKeyProvider) {
+	a.sessionTicketKeyProvider = p
+}
+
+// SetSessionTicketKeyRotationInterval implements Application.
+func (a *application) SetSessionTicketKeyRotationInterval(d time.Duration) {
+	a.sessionTicketKeyRotationInterval = d
+}
+
+// SetRouteTable implements Application.
+func (a *application) SetRouteTable(w io.Writer) {
+	a.routeTable = true
+	a.routeTableWriter = w
+}
+
+// SetTLSCertificateFiles implements Application.
+func (a *application) SetTLSCertificateFiles(certFile, keyFile string) {
+	a.tlsCertFile = certFile
+	a.tlsKeyFile = keyFile
+}
+
+// SetTLSCipherSuites implements Application.
+func (a *application) SetTLSCipherSuites(ids ...uint16) {
+	a.tlsPolicy.CipherSuites = ids
+}
+
+// SetTLSClientAuth implements Application.
+func (a *application) SetTLSClientAuth(t tls.ClientAuthType) {
+	a.tlsPolicy.ClientAuth = t
+}
+
+// SetTLSClientCAFile implements Application.
+func (a *application) SetTLSClientCAFile(f string) {
+	a.tlsClientCAFile = f
+	if a.tlsPolicy.ClientAuth == tls.NoClientCert {
+		a.tlsPolicy.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+}
+
+// SetTLSCurvePreferences implements Application.
+func (a *application) SetTLSCurvePreferences(ids ...tls.CurveID) {
+	a.tlsPolicy.CurvePreferences = ids
+}
+
+// SetTLSMinVersion implements Application.
+func (a *application) SetTLSMinVersion(v uint16) {
+	a.tlsPolicy.MinVersion = v
+}
+
+// SetTLSNextProtos implements Application.
+func (a *application) SetTLSNextProtos(protos ...string) {
+	a.tlsPolicy.NextProtos = protos
+}
+
+// EnableRoute implements Application.
+func (a *application) EnableRoute(sectionRoot, route string, methods []string) error {
+	if normalized, err := normalizeRoot(sectionRoot); err == nil {
+		sectionRoot = normalized
+	}
+	for _, s := range a.sections {
+		if s.Root() == sectionRoot {
+			if !s.EnableRoute(route, methods) {
+				return fmt.Errorf("route %s in section %s is not disabled", recovery.NewRoute(route, methods), sectionRoot)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("section not found for root %s", sectionRoot)
+}
+
+// GroomRateLimiting implements Application.
+func (a *application) GroomRateLimiting() {
+	if a.globalRateLimiter != nil {
+		a.globalRateLimiter.GroomNow()
+	}
+	for _, s := range a.sections {
+		s.GroomRateLimiting()
+	}
+}
+
+// RateLimitingBans implements Application.
+func (a *application) RateLimitingBans() map[string][]ratelimiting.Ban {
+	result := make(map[string][]ratelimiting.Ban, len(a.sections))
+	for _, s := range a.sections {
+		result[s.Root()] = s.RateLimitingBans()
+	}
+	return result
+}
+
+// PanicStats implements Application.
+func (a *application) PanicStats() map[string][]recovery.RouteStats {
+	result := make(map[string][]recovery.RouteStats, len(a.sections))
+	for _, s := range a.sections {
+		result[s.Root()] = s.PanicStats()
+	}
+	return result
+}
+
+// Routes implements Application.
+func (a *application) Routes() []RouteInfo {
+	result := []RouteInfo{}
+	for _, s := range a.sections {
+		result = append(result, s.Routes()...)
+	}
+	return result
+}
+
+// UnmatchedPaths implements Application.
+func (a *application) UnmatchedPaths() map[string][]unmatched.PathCount {
+	result := make(map[string][]unmatched.PathCount, len(a.sections))
+	for _, s := range a.sections {
+		result[s.Root()] = s.UnmatchedPaths()
+	}
+	return result
+}
+
+// URLFor implements Application.
+func (a *application) URLFor(name string, params map[string]string) (string, error) {
+	for _, s := range a.sections {
+		result, err := s.URLFor(name, params)
+		if !errors.Is(err, urlpathpatternhandler.ErrUnknownRouteName) {
+			return result, err
+		}
+	}
+	return "", fmt.Errorf("%w: %q", urlpathpatternhandler.ErrUnknownRouteName, name)
+}
+
+// checkRouteNames returns an error if the sections have routes with the same
+// name.
+func checkRouteNames(a, b Section) error {
+	names := map[string]struct{}{}
+	for _, r := range a.Routes() {
+		if r.Name != "" {
+			names[r.Name] = struct{}{}
+		}
+	}
+	for _, r := range b.Routes() {
+		if _, found := names[r.Name]; found {
+			return fmt.Errorf("%w: %q in sections %s and %s", urlpathpatternhandler.ErrDuplicateRouteName, r.Name, a.Root(), b.Root())
+		}
+	}
+	return nil
+}
+
+// SetMetricsLabelValueLimits implements Application.
+func (a *application) SetMetricsLabelValueLimits(limits map[string]int) {
+	a.metricsLabelValueLimits = limits
+	if a.metricsRecorder != nil {
+		a.setMetrics(metrics.NewCardinalityGuard(a.metricsRecorder, limits))
+	}
+}
+
+// SetMetricsRecorder implements Application.
+func (a *application) SetMetricsRecorder(r metrics.Recorder) {
+	a.metricsRecorder = r
+	a.setMetrics(metrics.NewCardinalityGuard(r, a.metricsLabelValueLimits))
+}
+
+// setMetrics sets the recorder of the application and its sections.
+func (a *application) setMetrics(r metrics.Recorder) {
+	a.metrics = r
+	for _, s := range a.sections {
+		s.SetMetricsRecorder(r)
+	}
+}
+
+// SetCORSConfig implements Application.
+func (a *application) SetCORSConfig(c cors.Config) {
+	a.corsConfig = &c
+	for _, s := range a.sections {
+		s.SetApplicationCORSConfig(a.corsConfig)
+	}
+}
+
+// SetErrorReporter implements Application.
+func (a *application) SetErrorReporter(r recovery.Reporter) {
+	a.errorReporter = r
+	for _, s := range a.sections {
+		s.SetErrorReporter(r)
+	}
+}
+
+// SetGlobalRateLimitingLazyExpiration implements Application.
+func (a *application) SetGlobalRateLimitingLazyExpiration(v bool) {
+	a.globalRateLimitingLazyExpiration = v
+}
+
+// SetHijackedConnectionTimeout implements Application.
+func (a *application) SetHijackedConnectionTimeout(d time.Duration) {
+	a.hijackedConnectionTimeout = d
+}
+
+// SetIdleShutdownTimeout implements Application.
+func (a *application) SetIdleShutdownTimeout(d time.Duration) {
+	a.idleShutdownTimeout = d
+}
+
+// SetFlagProvider implements Application.
+func (a *application) SetFlagProvider(p flags.Provider) {
+	a.flagProvider = p
+	for _, s := range a.sections {
+		s.SetFlagProvider(p)
+	}
+}
+
+// AddEventObserver implements Application.
+func (a *application) AddEventObserver(o events.Observer) {
+	a.eventBus.Subscribe(o)
+}
+
+// AddGlobalMiddleware implements Application.
+func (a *application) AddGlobalMiddleware(mw func(http.Handler) http.Handler) {
+	a.globalMiddlewares = append(a.globalMiddlewares, mw)
+}
+
+// AddGlobalRateLimitingSessionConfig implements Application.
+func (a *application) AddGlobalRateLimitingSessionConfig(maxRequests int64, sessionDuration, banDuration time.Duration) {
+	a.globalRateLimitingConfigs = append(a.globalRateLimitingConfigs, sectionRateLimitingConfig{
+		maxRequests:     maxRequests,
+		sessionDuration: sessionDuration,
+		banDuration:     banDuration,
+	})
+}
+
+// SetAccessLog implements Application.
+func (a *application) SetAccessLog(open func() (io.WriteCloser, error)) {
+	a.accessLogOpen = open
+}
+
+// AddOnResponseHook implements Application.
+func (a *application) AddOnResponseHook(h responseinfo.Hook) {
+	a.onResponseHooks = append(a.onResponseHooks, h)
+}
+
+// SetServerListenHost implements Application.
+func (a *application) SetServerListenHost(host string) {
+	a.serverListenHost = host
+}
+
 // SetServerListenPort implements Application.
 func (a *application) SetServerListenPort(port int) {
 	a.serverListenPort = port
 }
 
+// SetShutdownProgressInterval implements Application.
+func (a *application) SetShutdownProgressInterval(d time.Duration) {
+	a.shutdownProgressInterval = d
+}
+
+// ShutdownProgress implements Application.
+func (a *application) ShutdownProgress() lifecycle.Progress {
+	result := lifecycle.Progress{
+		ShuttingDown:      a.shuttingDown.Load(),
+		PendingDrainFuncs: a.drainCoordinator.Pending(),
+	}
+	for _, h := range a.lifecycleHandlers {
+		result.InFlightRequests += h.InFlightRequests()
+		result.HijackedConnections += h.HijackedConnections()
+	}
+	return result
+}
+
+// waitHijackedConnections waits, up to the configured timeout, for handlers
+// to close the connections they hijacked, then closes any remaining.
+func (a *application) waitHijackedConnections() {
+	ctx, cancel := context.WithTimeout(context.Background(), a.hijackedConnectionTimeout)
+	defer cancel()
+	for _, h := range a.lifecycleHandlers {
+		if !h.WaitHijackedConnections(ctx) {
+			logger.Debug("", "Closing %d hijacked connections still open", h.HijackedConnections())
+			h.CloseHijackedConnections()
+		}
+	}
+}
+
+func (a *application) reportShutdownProgress(done <-chan struct{}) {
+	ticker := time.NewTicker(a.shutdownProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			p := a.ShutdownProgress()
+			logger.Debug(
+				"",
+				"Shutdown in progress: %d in-flight requests, %d hijacked connections, pending drain functions %v",
+				p.InFlightRequests,
+				p.HijackedConnections,
+				p.PendingDrainFuncs,
+			)
+		}
+	}
+}
+
 func (a *application) AddSection(s Section) error {
-	rootsObserved := []string{}
-	for _, s := range a.sections {
-		rootsObserved = append(rootsObserved, s.Root())
+	if err := s.Err(); err != nil {
+		return err
 	}
-	if slices.Contains(rootsObserved, s.Root()) {
-		return fmt.Errorf("duplicate section found for root %s", s.Root())
+	if _, err := normalizeRoot(s.Root()); err != nil {
+		return err
 	}
+	for _, other := range a.sections {
+		if err := checkRootOverlap(other, s); err != nil {
+			return err
+		}
+		if err := checkRouteNames(other, s); err != nil {
+			return err
+		}
+	}
+	s.SetMetricsRecorder(a.metrics)
+	s.SetEventBus(a.eventBus)
+	s.SetFlagProvider(a.flagProvider)
+	s.SetErrorReporter(a.errorReporter)
+	s.SetApplicationCORSConfig(a.corsConfig)
+	s.SetDevMode(a.devMode)
 	a.sections = append(a.sections, s)
 	return nil
 }
 
+// AddConfigError implements Application.
+func (a *application) AddConfigError(err error) {
+	a.configErrs = append(a.configErrs, err)
+}
+
+// Err implements Application.
+func (a *application) Err() error {
+	return errors.Join(a.configErrs...)
+}
+
+// DryRun implements Application.
+func (a *application) DryRun() error {
+	_, err := a.newServers(context.Background(), true)
+	return err
+}
+
 func (a *application) ListenAndServe() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	mux := http.NewServeMux()
-	for _, s := range a.sections {
-		mux.Handle(s.Root(), s.NewHandler())
+	servers, err := a.newServers(ctx, false)
+	if err != nil {
+		logger.Debug("", "Error configuring servers: %s", err)
+		a.errorReporter.Report(ctx, fmt.Errorf("configuring servers: %w", err), nil)
+		os.Exit(1)
+	}
+	if a.routeTable {
+		a.writeRouteTable()
 	}
 
-	httpServer := &http.Server{
-		Addr:        fmt.Sprintf(":%d", a.serverListenPort),
-		Handler:     mux,
-		BaseContext: func(_ net.Listener) context.Context { return ctx },
+	shutdownServers := func(ctx context.Context) {
+		var wg sync.WaitGroup
+		for _, srv := range servers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := srv.httpServer.Shutdown(ctx); err != nil {
+					logger.Debug("", "shutdown error on %s: %v", srv.httpServer.Addr, err)
+					a.errorReporter.Report(ctx, fmt.Errorf("shutting down %s: %w", srv.httpServer.Addr, err), nil)
+				} else {
+					logger.Debug("", "gracefully stopped %s", srv.httpServer.Addr)
+				}
+			}()
+		}
+		wg.Wait()
 	}
 
 	stop := func() {
@@ -81,13 +688,41 @@ func (a *application) ListenAndServe() {
 			f()
 		}
 
+		a.shuttingDown.Store(true)
+		a.eventBus.Publish(events.Event{
+			Type: events.ShutdownStarted,
+			Time: time.Now(),
+		})
+		progressDone := make(chan struct{})
+		go a.reportShutdownProgress(progressDone)
+
+		// Servers do not track hijacked connections, so their handlers are
+		// notified separately.
+		for _, h := range a.lifecycleHandlers {
+			h.NotifyShutdown()
+		}
+
 		gracefulCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		if err := httpServer.Shutdown(gracefulCtx); err != nil {
-			logger.Debug("", "shutdown error: %v", err)
-		} else {
-			logger.Debug("", "gracefully stopped")
+		// Drain functions run alongside server shutdown, since servers wait
+		// for the streams they stop to finish.
+		var drainWG sync.WaitGroup
+		drainWG.Add(1)
+		go func() {
+			defer drainWG.Done()
+			if err := a.drainCoordinator.Run(gracefulCtx); err != nil {
+				a.errorReporter.Report(gracefulCtx, err, nil)
+			}
+		}()
+		shutdownServers(gracefulCtx)
+		drainWG.Wait()
+		a.waitHijackedConnections()
+		close(progressDone)
+		if a.accessLog != nil {
+			if err := a.accessLog.Close(); err != nil {
+				a.errorReporter.Report(context.Background(), fmt.Errorf("closing access log: %w", err), nil)
+			}
 		}
 
 		// Process anything the caller would like to do after shutting down.
@@ -96,26 +731,50 @@ func (a *application) ListenAndServe() {
 		}
 	}
 
-	// Run server.
+	// Run servers.
 	go func() {
 		// Start async processes.
 		var wg sync.WaitGroup
+		if a.globalRateLimiter != nil {
+			a.globalRateLimiter.BeforeStart(&wg)
+		}
 		for _, s := range a.sections {
 			s.BeforeStart(&wg)
 		}
+		for _, srv := range servers {
+			srv.beforeStart(&wg)
+		}
 
-		// Start the HTTP server.
-		err := httpServer.ListenAndServe()
+		// Start the HTTP servers. If any fails unexpectedly the others are
+		// stopped too.
+		errs := make(chan error, len(servers))
+		for _, srv := range servers {
+			go func() {
+				errs <- srv.listenAndServe()
+			}()
+		}
 		var exitCode int
-		if err != http.ErrServerClosed {
-			logger.Debug("", "ListenAndServe responded with unexpected error: %s", err)
-			exitCode = 1
+		for range servers {
+			if err := <-errs; err != http.ErrServerClosed {
+				logger.Debug("", "ListenAndServe responded with unexpected error: %s", err)
+				a.errorReporter.Report(ctx, fmt.Errorf("serving: %w", err), nil)
+				if exitCode == 0 {
+					exitCode = 1
+					go shutdownServers(context.Background())
+				}
+			}
 		}
 
 		// Stop async processess and wait for them to complete.
+		if a.globalRateLimiter != nil {
+			a.globalRateLimiter.AfterShutdown()
+		}
 		for _, s := range a.sections {
 			s.AfterShutdown()
 		}
+		for _, srv := range servers {
+			srv.afterShutdown()
+		}
 		wg.Wait()
 
 		if exitCode != 0 {
@@ -126,15 +785,85 @@ func (a *application) ListenAndServe() {
 	startedAt := time.Now()
 	logger.Debug("", "Server started at %s", startedAt.Format(time.RFC3339))
 
-	// Block until the shutdown signal is received.
-	shutdown.GracefulStop(stop)
+	// Block until the shutdown signal is received or the application idles.
+	a.awaitStop(startedAt, stop)
+}
+
+// awaitStop blocks until a termination signal is received or, with idle
+// shutdown enabled, no requests have been served for the idle shutdown
+// timeout, then calls stop. A second signal received while stopping
+// terminates the process.
+func (a *application) awaitStop(startedAt time.Time, stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	idle := make(chan struct{})
+	if a.idleShutdownTimeout > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go a.watchIdle(startedAt, idle, done)
+	}
+	select {
+	case sig := <-signals:
+		logger.Debug("", "Received %s, shutting down", sig)
+	case <-idle:
+		logger.Debug("", "No requests for %s, shutting down", a.idleShutdownTimeout)
+		a.eventBus.Publish(events.Event{
+			Type: events.IdleShutdown,
+			Time: time.Now(),
+		})
+	}
+	go func() {
+		sig := <-signals
+		logger.Debug("", "Received %s while shutting down, terminating", sig)
+		os.Exit(1)
+	}()
+	stop()
+}
+
+// watchIdle closes idle once no requests have been in flight, nor hijacked
+// connections open, for the idle shutdown timeout, or returns when done is
+// closed.
+func (a *application) watchIdle(startedAt time.Time, idle chan<- struct{}, done <-chan struct{}) {
+	ticker := time.NewTicker(min(max(a.idleShutdownTimeout/10, 10*time.Millisecond), time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			lastActive := startedAt
+			busy := false
+			for _, h := range a.lifecycleHandlers {
+				if h.InFlightRequests() > 0 || h.HijackedConnections() > 0 {
+					busy = true
+					break
+				}
+				if t := h.LastActive(); t.After(lastActive) {
+					lastActive = t
+				}
+			}
+			if !busy && now.Sub(lastActive) >= a.idleShutdownTimeout {
+				close(idle)
+				return
+			}
+		}
+	}
 }
 
 func NewApplication() Application {
 	return &application{
 		afterShutdownFuncs:  []func(){},
 		beforeShutdownFuncs: []func(){},
+		drainCoordinator:    drain.NewCoordinator(),
+		eventBus:            events.NewBus(),
+		metrics:             metrics.NewNoopRecorder(),
 		sections:            []Section{},
 		serverListenPort:    8080,
+
+		metricsLabelValueLimits: metrics.DefaultLabelValueLimits(),
+
+		hijackedConnectionTimeout: 5 * time.Second,
+		shutdownProgressInterval:  time.Second,
+		tlsPolicy:                 tlspolicy.NewDefaultPolicy(),
 	}
 }
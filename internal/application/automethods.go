@@ -0,0 +1,49 @@
+package application
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/jakewan/sudsy/internal/urlpathpatternhandler"
+)
+
+// answerOptions answers an OPTIONS request that no route serves with the
+// methods served by matches.
+func answerOptions(w http.ResponseWriter, matches []urlpathpatternhandler.Handler) {
+	w.Header().Set("allow", strings.Join(urlpathpatternhandler.AllowedMethods(matches), ", "))
+	w.Header().Set("content-length", "0")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// headResponseWriter discards the body written by a GET handler serving a
+// HEAD request, so that middlewares neither buffer, transform nor count it.
+// The content type is still detected from the body the handler writes first.
+type headResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (w *headResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Write implements http.ResponseWriter.
+func (w *headResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("content-type") == "" && len(b) > 0 {
+			w.Header().Set("content-type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	return len(b), nil
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *headResponseWriter) WriteHeader(code int) {
+	// Informational responses leave the final header to come.
+	if code >= 200 {
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,48 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jakewan/sudsy/internal/recovery"
+)
+
+// devModeResponseHeaders override response headers in development mode, so
+// that browsers neither cache responses nor pin the host to HTTPS.
+var devModeResponseHeaders = http.Header{
+	"Cache-Control":             {"no-store"},
+	"Etag":                      nil,
+	"Expires":                   nil,
+	"Last-Modified":             nil,
+	"Strict-Transport-Security": nil,
+}
+
+// DevModeFromContext reports whether the section serving the request is in
+// development mode.
+func DevModeFromContext(ctx context.Context) bool {
+	return sectionHandlerDependenciesFromContext(ctx).DevMode
+}
+
+// handleStatusInternalServerErrorVerbose responds with the details of the
+// error, including the stack trace of recovered panics, for use in
+// development mode.
+func handleStatusInternalServerErrorVerbose(w http.ResponseWriter, r *http.Request, err error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d %s\n\n%s %s\n", http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), r.Method, r.URL)
+	if err != nil {
+		fmt.Fprintf(&b, "\n%s\n", err)
+	}
+	var panicErr *recovery.PanicError
+	if errors.As(err, &panicErr) {
+		fmt.Fprintf(&b, "\n%s", panicErr.Stack)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusInternalServerError)
+	if _, err := w.Write([]byte(b.String())); err != nil {
+		logger.Debug("", "Error writing response: %s", err)
+	}
+}
@@ -0,0 +1,50 @@
+package application
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/urlpathpatternhandler"
+)
+
+type fuzzSectionDependencies struct{}
+
+func (fuzzSectionDependencies) Now() time.Time { return time.Time{} }
+
+// FuzzSectionRouting registers the newline-separated patterns with a section
+// and serves a request for requestPath, which must never panic.
+func FuzzSectionRouting(f *testing.F) {
+	log.SetOutput(io.Discard)
+	f.Add("/api/users/:id\n/api/users/:id/posts\n/api/health", "/api/users/42")
+	f.Add("/api/:a\n/api/:b", "/api/x")
+	f.Add("/\n/:x", "")
+	f.Add("/api/items/:id", "/api/items/1/extra")
+	f.Fuzz(func(t *testing.T, patterns, requestPath string) {
+		s := NewSection(fuzzSectionDependencies{}, "/")
+		for _, p := range strings.Split(patterns, "\n") {
+			addPathPatternHandler(t, s, p)
+		}
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL = &url.URL{Path: requestPath}
+		s.NewHandler().ServeHTTP(httptest.NewRecorder(), r)
+	})
+}
+
+// addPathPatternHandler registers the pattern, tolerating the errors returned
+// for ambiguous and invalid patterns.
+func addPathPatternHandler(t *testing.T, s Section, pattern string) {
+	err := s.AddPathPatternHandler(pattern, http.NotFoundHandler(), struct{}{}, urlpathpatternhandler.Config{})
+	if err != nil &&
+		!errors.Is(err, urlpathpatternhandler.ErrAmbiguousCaptureVariableNames) &&
+		!errors.Is(err, urlpathpatternhandler.ErrMisplacedCatchAll) &&
+		!errors.Is(err, urlpathpatternhandler.ErrUnnamedCatchAll) {
+		t.Fatalf("registering %q: %v", pattern, err)
+	}
+}
@@ -0,0 +1,179 @@
+package application
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/auth"
+	"github.com/jakewan/sudsy/internal/basicauth"
+	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/ratelimiting"
+	"github.com/jakewan/sudsy/internal/urlpathpatternhandler"
+)
+
+// Group registers routes under a shared path prefix within a section. The
+// group's authentication, rate limiting and middleware apply to requests
+// whose path starts with the prefix, in addition to the section's.
+type Group interface {
+	// AddMiddleware wraps the group's routes with mw, the first added
+	// outermost.
+	AddMiddleware(mw func(http.Handler) http.Handler)
+	// AddPathPatternHandler adds a route for the prefix followed by pattern,
+	// returning an error as Section.AddPathPatternHandler does.
+	AddPathPatternHandler(pattern string, handler http.Handler, contextKey any, config urlpathpatternhandler.Config) error
+	AddRateLimitingSessionConfig(maxRequests int64, sessionDuration, banDuration time.Duration)
+	// AddRouteConfigFunc adds a function applied to the config of the routes
+	// added to the group or its nested groups from then on.
+	AddRouteConfigFunc(f func(*urlpathpatternhandler.Config))
+	// Group returns a group nested within the group, whose prefix follows
+	// the group's.
+	Group(prefix string) Group
+	// Mount mounts handler at the prefix following the group's, as
+	// Section.Mount does.
+	Mount(prefix string, handler http.Handler, config urlpathpatternhandler.Config) error
+	Prefix() string
+	SetBasicAuth(username, password, realm string)
+}
+
+type group struct {
+	section *section
+	parent  *group
+	prefix  string
+
+	basicAuthUsername   string
+	basicAuthPassword   string
+	basicAuthRealm      string
+	middlewares         []func(http.Handler) http.Handler
+	rateLimitingConfigs []sectionRateLimitingConfig
+	routeConfigFuncs    []func(*urlpathpatternhandler.Config)
+}
+
+// Group implements Section.
+func (s *section) Group(prefix string) Group {
+	g := &group{section: s, prefix: normalizeGroupPrefix(prefix)}
+	s.groups = append(s.groups, g)
+	return g
+}
+
+// normalizeGroupPrefix returns prefix with a leading and without a trailing
+// slash, so that it can be followed by patterns.
+func normalizeGroupPrefix(prefix string) string {
+	return "/" + strings.Trim(prefix, "/")
+}
+
+// AddMiddleware implements Group.
+func (g *group) AddMiddleware(mw func(http.Handler) http.Handler) {
+	g.middlewares = append(g.middlewares, mw)
+}
+
+// AddPathPatternHandler implements Group.
+func (g *group) AddPathPatternHandler(
+	pattern string,
+	handler http.Handler,
+	contextKey any,
+	config urlpathpatternhandler.Config,
+) error {
+	// Outer groups' functions apply first, so that inner groups can
+	// override them.
+	var funcs []func(*urlpathpatternhandler.Config)
+	for p := g; p != nil; p = p.parent {
+		funcs = slices.Concat(p.routeConfigFuncs, funcs)
+	}
+	for _, f := range funcs {
+		f(&config)
+	}
+	if g.prefix != "/" {
+		pattern = g.prefix + "/" + strings.TrimPrefix(pattern, "/")
+	}
+	return g.section.AddPathPatternHandler(pattern, handler, contextKey, config)
+}
+
+// AddRateLimitingSessionConfig implements Group.
+func (g *group) AddRateLimitingSessionConfig(maxRequests int64, sessionDuration, banDuration time.Duration) {
+	g.rateLimitingConfigs = append(g.rateLimitingConfigs, sectionRateLimitingConfig{
+		maxRequests:     maxRequests,
+		sessionDuration: sessionDuration,
+		banDuration:     banDuration,
+	})
+}
+
+// AddRouteConfigFunc implements Group.
+func (g *group) AddRouteConfigFunc(f func(*urlpathpatternhandler.Config)) {
+	g.routeConfigFuncs = append(g.routeConfigFuncs, f)
+}
+
+// Group implements Group.
+func (g *group) Group(prefix string) Group {
+	child := &group{section: g.section, parent: g, prefix: g.prefix}
+	if p := normalizeGroupPrefix(prefix); p != "/" {
+		child.prefix = strings.TrimSuffix(g.prefix, "/") + p
+	}
+	g.section.groups = append(g.section.groups, child)
+	return child
+}
+
+// Prefix implements Group.
+func (g *group) Prefix() string {
+	return g.prefix
+}
+
+// SetBasicAuth implements Group.
+func (g *group) SetBasicAuth(username, password, realm string) {
+	g.basicAuthUsername = username
+	g.basicAuthPassword = password
+	g.basicAuthRealm = realm
+}
+
+// matches reports whether r is for a path within the group.
+func (g *group) matches(r *http.Request) bool {
+	return urlpathpatternhandler.MatchPattern(strings.TrimSuffix(g.prefix, "/")+"/*", urlpathpatternhandler.RoutingPath(r.URL))
+}
+
+// newHandlers returns the handlers enforcing the group's settings before
+// passing requests within the group to next, outermost last.
+func (g *group) newHandlers(next common.MiddlewareHandler) []common.MiddlewareHandler {
+	s := g.section
+	var result []common.MiddlewareHandler
+	inner := next
+	for i := len(g.middlewares) - 1; i >= 0; i-- {
+		inner = middleware{wrap: g.middlewares[i]}.newHandler(inner)
+		result = append(result, inner)
+	}
+	if g.basicAuthUsername != "" && g.basicAuthPassword != "" && g.basicAuthRealm != "" {
+		inner = auth.NewMiddlewareHandler(
+			&authDependencies{
+				eventBus:       s.eventBus,
+				now:            s.deps.Now,
+				sectionRoot:    s.root,
+				statusHandlers: s.statusHandlers,
+			},
+			inner,
+			basicauth.NewAuthenticator(g.basicAuthUsername, g.basicAuthPassword, g.basicAuthRealm),
+		)
+		result = append(result, inner)
+	}
+	if len(g.rateLimitingConfigs) > 0 {
+		h := ratelimiting.NewMiddlewareHandler(s.newRateLimitingDependencies(rateLimitingScopeGroup), inner)
+		for _, c := range g.rateLimitingConfigs {
+			h.AddSessionConfig(c.maxRequests, c.sessionDuration, c.banDuration)
+		}
+		if s.rateLimitingHostResolver != nil {
+			h.SetHostResolver(s.rateLimitingHostResolver)
+		}
+		if s.rateLimitingIPv6PrefixLength > 0 {
+			h.SetIPv6PrefixLength(s.rateLimitingIPv6PrefixLength)
+		}
+		h.SetUseRemoteAddrForInvalidAddress(s.rateLimitingUseRemoteAddrForInvalidAddress)
+		for _, f := range s.rateLimitingExemptFuncs() {
+			h.AddExemptFunc(f)
+		}
+		inner = h
+		result = append(result, inner)
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return append(result, &middlewareHandler{wrapped: inner, next: next, predicate: g.matches})
+}
@@ -0,0 +1,128 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidSectionHost = errors.New("invalid section host")
+
+// normalizeHost returns host lowercased and without a trailing dot, or an
+// error if it is neither a host name nor a wildcard of the form
+// "*.example.com".
+func normalizeHost(host string) (string, error) {
+	result := strings.TrimSuffix(strings.ToLower(host), ".")
+	name := strings.TrimPrefix(result, "*.")
+	if name == "" || strings.ContainsAny(name, "*/:?#[] ") {
+		return "", fmt.Errorf("%w %q: must be a host name or a wildcard such as *.example.com", ErrInvalidSectionHost, host)
+	}
+	return result, nil
+}
+
+// requestHost returns the host of r without its port, lowercased and without
+// a trailing dot.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// hostsOverlap reports whether sections bound to the given hosts can receive
+// the same requests for a root they share. Sections bound to no host serve
+// the hosts no other section is bound to, so they only overlap each other.
+func hostsOverlap(l, r []string) bool {
+	if len(l) == 0 || len(r) == 0 {
+		return len(l) == len(r)
+	}
+	for _, h := range l {
+		if slices.Contains(r, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// newSectionsHandler returns a handler dispatching requests to sections by
+// root, and by host for sections bound to hosts.
+func newSectionsHandler(sections []Section) http.Handler {
+	router := &hostRouter{
+		exact:         map[string]*http.ServeMux{},
+		defaultServer: http.NewServeMux(),
+	}
+	wildcards := map[string]*http.ServeMux{}
+	for _, s := range sections {
+		h := s.NewHandler()
+		if len(s.Hosts()) == 0 {
+			router.defaultServer.Handle(s.Root(), h)
+			continue
+		}
+		for _, host := range s.Hosts() {
+			muxes := router.exact
+			if suffix, found := strings.CutPrefix(host, "*"); found {
+				host = suffix
+				muxes = wildcards
+			}
+			mux, found := muxes[host]
+			if !found {
+				mux = http.NewServeMux()
+				muxes[host] = mux
+			}
+			mux.Handle(s.Root(), h)
+		}
+	}
+	if len(router.exact) == 0 && len(wildcards) == 0 {
+		return router.defaultServer
+	}
+	for suffix, mux := range wildcards {
+		router.wildcards = append(router.wildcards, hostWildcard{suffix: suffix, mux: mux})
+	}
+	// Longer suffixes are more specific.
+	slices.SortFunc(router.wildcards, func(l, r hostWildcard) int {
+		return len(r.suffix) - len(l.suffix)
+	})
+	return router
+}
+
+type hostWildcard struct {
+	// suffix includes the leading dot, e.g. ".example.com".
+	suffix string
+	mux    *http.ServeMux
+}
+
+// hostRouter dispatches requests to the sections bound to their host, then
+// to those bound to matching wildcards, the most specific first, then to
+// the sections bound to no host, the first of them with a root matching the
+// request path serving it.
+type hostRouter struct {
+	exact         map[string]*http.ServeMux
+	wildcards     []hostWildcard
+	defaultServer *http.ServeMux
+}
+
+// ServeHTTP implements http.Handler.
+func (h *hostRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := requestHost(r)
+	if mux, found := h.exact[host]; found {
+		if handler, pattern := mux.Handler(r); pattern != "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	for _, wc := range h.wildcards {
+		if !strings.HasSuffix(host, wc.suffix) {
+			continue
+		}
+		if handler, pattern := wc.mux.Handler(r); pattern != "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	hotPathLogger.Debug("", "Serving host %s with the sections bound to no host", host)
+	h.defaultServer.ServeHTTP(w, r)
+}
@@ -0,0 +1,71 @@
+package application
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/jakewan/sudsy/internal/urlpathpatternhandler"
+)
+
+// mountPathToken is the catch-all token ending the patterns of mounted
+// handlers.
+const mountPathToken = "*mounted"
+
+type mountContextKey struct{}
+
+// Mount implements Section.
+func (s *section) Mount(prefix string, handler http.Handler, config urlpathpatternhandler.Config) error {
+	prefix = normalizeGroupPrefix(prefix)
+	return s.AddPathPatternHandler(mountPattern(prefix), newMountedHandler(prefix, handler), mountContextKey{}, config)
+}
+
+// Mount implements Group.
+func (g *group) Mount(prefix string, handler http.Handler, config urlpathpatternhandler.Config) error {
+	prefix = normalizeGroupPrefix(prefix)
+	full := g.prefix
+	if prefix != "/" {
+		full = strings.TrimSuffix(g.prefix, "/") + prefix
+	}
+	return g.AddPathPatternHandler(mountPattern(prefix), newMountedHandler(full, handler), mountContextKey{}, config)
+}
+
+// mountPattern returns the pattern matching prefix and the paths beneath it.
+func mountPattern(prefix string) string {
+	return strings.TrimSuffix(prefix, "/") + "/" + mountPathToken
+}
+
+// newMountedHandler returns a handler passing requests to h with prefix
+// removed from their path, which is "/" for the prefix itself.
+func newMountedHandler(prefix string, h http.Handler) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return &mountedHandler{
+		prefix:  prefix,
+		handler: h,
+		strip:   http.StripPrefix(prefix, h),
+	}
+}
+
+type mountedHandler struct {
+	prefix  string
+	handler http.Handler
+	strip   http.Handler
+}
+
+// ServeHTTP implements http.Handler.
+func (h *mountedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == h.prefix {
+		r2 := new(http.Request)
+		*r2 = *r
+		u := *r.URL
+		u.Path = h.prefix + "/"
+		u.RawPath = ""
+		r2.URL = &u
+		r = r2
+	}
+	h.strip.ServeHTTP(w, r)
+}
+
+// Unwrap returns the mounted handler, which identifies the route.
+func (h *mountedHandler) Unwrap() http.Handler {
+	return h.handler
+}
@@ -0,0 +1,57 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+var ErrInvalidSectionRoot = errors.New("invalid section root")
+
+// normalizeRoot returns root cleaned and with a trailing slash, so that it
+// matches the whole subtree beneath it when registered with a ServeMux, e.g.
+// "/api" and "/api/" both become "/api/".
+func normalizeRoot(root string) (string, error) {
+	if !strings.HasPrefix(root, "/") {
+		return "", fmt.Errorf("%w %q: must start with /", ErrInvalidSectionRoot, root)
+	}
+	if strings.ContainsAny(root, "?#{}") {
+		return "", fmt.Errorf("%w %q: must be a plain path", ErrInvalidSectionRoot, root)
+	}
+	result := path.Clean(root)
+	if result != "/" {
+		result += "/"
+	}
+	return result, nil
+}
+
+// checkRootOverlap returns an error if two sections bound to overlapping
+// hosts have the same root, or are served on the same port with roots nesting
+// one within the other, in which case the inner section would silently
+// shadow part of the outer section. A section rooted at "/" is a catch-all
+// and may contain others.
+func checkRootOverlap(a, b Section) error {
+	if !hostsOverlap(a.Hosts(), b.Hosts()) {
+		return nil
+	}
+	if a.Root() == b.Root() {
+		return fmt.Errorf("duplicate section found for root %s", a.Root())
+	}
+	if a.ListenPort() != b.ListenPort() || a.Root() == "/" || b.Root() == "/" {
+		return nil
+	}
+	outer, inner := a, b
+	if len(outer.Root()) > len(inner.Root()) {
+		outer, inner = inner, outer
+	}
+	if strings.HasPrefix(inner.Root(), outer.Root()) {
+		return fmt.Errorf(
+			"section root %s overlaps section root %s, which would no longer receive requests beneath %s",
+			inner.Root(),
+			outer.Root(),
+			inner.Root(),
+		)
+	}
+	return nil
+}
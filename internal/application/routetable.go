@@ -0,0 +1,108 @@
+package application
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Middleware implements Section.
+func (s *section) Middleware() []string {
+	result := []string{}
+	for i := len(s.activeMiddlewareHandlers) - 1; i >= 0; i-- {
+		name := middlewareName(s.activeMiddlewareHandlers[i])
+		if name == "" {
+			continue
+		}
+		if prefix, found := s.activeMiddlewareGroups[i]; found {
+			name += " (" + prefix + ")"
+		}
+		result = append(result, name)
+	}
+	return result
+}
+
+// middlewareName identifies h, by the function of middleware added to a
+// section or group and by package otherwise, e.g. "ratelimiting". The
+// handlers routing requests and applying groups are not named.
+func middlewareName(h any) string {
+	switch h := h.(type) {
+	case *sectionHandler:
+		return ""
+	case *middlewareHandler:
+		return h.name
+	case *stripPrefixHandler:
+		return "stripprefix"
+	}
+	pkg, _, _ := strings.Cut(strings.TrimLeft(fmt.Sprintf("%T", h), "*"), ".")
+	return pkg
+}
+
+// funcName returns the name of the function f, e.g. "main.logRequests".
+func funcName(f any) string {
+	if fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return fmt.Sprintf("%T", f)
+}
+
+// writeRouteTable writes the application's sections, with their middleware,
+// and routes, sorted by section root and pattern, to the route table writer,
+// or logs them if there is none.
+func (a *application) writeRouteTable() {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	sections := slices.Clone(a.sections)
+	slices.SortFunc(sections, func(l, r Section) int {
+		return strings.Compare(l.Root(), r.Root())
+	})
+	fmt.Fprintln(tw, "SECTION\tPORT\tMIDDLEWARE")
+	for _, s := range sections {
+		port := s.ListenPort()
+		if port == 0 {
+			port = a.serverListenPort
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Root(), strconv.Itoa(port), orDash(strings.Join(s.Middleware(), ", ")))
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "SECTION\tPATTERN\tMETHODS\tNAME\tHANDLER")
+	routes := a.Routes()
+	slices.SortStableFunc(routes, func(l, r RouteInfo) int {
+		return cmp.Or(
+			strings.Compare(l.SectionRoot, r.SectionRoot),
+			strings.Compare(l.Pattern, r.Pattern),
+			slices.Compare(l.Methods, r.Methods),
+		)
+	})
+	for _, r := range routes {
+		methods := "*"
+		if len(r.Methods) > 0 {
+			methods = strings.Join(r.Methods, ",")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.SectionRoot, r.Pattern, methods, orDash(r.Name), r.Handler)
+	}
+	if err := tw.Flush(); err != nil {
+		logger.Debug("", "Error formatting route table: %s", err)
+		return
+	}
+	if a.routeTableWriter == nil {
+		logger.Debug("", "Route table:\n%s", buf.String())
+		return
+	}
+	if _, err := a.routeTableWriter.Write(buf.Bytes()); err != nil {
+		logger.Debug("", "Error writing route table: %s", err)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -1,34 +1,208 @@
 package application
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/jakewan/sudsy/internal/auth"
 	"github.com/jakewan/sudsy/internal/basicauth"
+	"github.com/jakewan/sudsy/internal/binding"
+	"github.com/jakewan/sudsy/internal/buffering"
+	"github.com/jakewan/sudsy/internal/clientgone"
 	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/concurrency"
+	"github.com/jakewan/sudsy/internal/cors"
+	"github.com/jakewan/sudsy/internal/csp"
+	"github.com/jakewan/sudsy/internal/deadline"
+	"github.com/jakewan/sudsy/internal/errorpages"
+	"github.com/jakewan/sudsy/internal/events"
+	"github.com/jakewan/sudsy/internal/flags"
+	"github.com/jakewan/sudsy/internal/headers"
+	"github.com/jakewan/sudsy/internal/methodoverride"
+	"github.com/jakewan/sudsy/internal/metrics"
+	"github.com/jakewan/sudsy/internal/minify"
+	"github.com/jakewan/sudsy/internal/pathclean"
+	"github.com/jakewan/sudsy/internal/query"
 	"github.com/jakewan/sudsy/internal/ratelimiting"
+	"github.com/jakewan/sudsy/internal/realip"
+	"github.com/jakewan/sudsy/internal/recovery"
+	"github.com/jakewan/sudsy/internal/responseheaders"
+	"github.com/jakewan/sudsy/internal/responseinfo"
+	"github.com/jakewan/sudsy/internal/shedding"
+	"github.com/jakewan/sudsy/internal/templates"
+	"github.com/jakewan/sudsy/internal/tenant"
+	"github.com/jakewan/sudsy/internal/unmatched"
 	"github.com/jakewan/sudsy/internal/urlpathpatternhandler"
+	"github.com/jakewan/sudsy/internal/workerpool"
 )
 
 type HandlerFuncWithError func(http.ResponseWriter, *http.Request, error)
 
+// HandlerFuncWithRateInfo produces responses to rate limited requests given
+// the ban and the session config that triggered it.
+type HandlerFuncWithRateInfo func(http.ResponseWriter, *http.Request, ratelimiting.RateInfo)
+
 type Section interface {
-	AddPathPatternHandler(pattern string, handler http.Handler, contextKey any)
+	AddAllowedContentTypes(...string)
+	AddAuthenticator(auth.Authenticator)
+	AddAuthExemptPattern(pattern string)
+	AddDefaultResponseHeader(name, value string)
+	// AddHost binds the section to requests for host, given as a host name
+	// or a wildcard such as "*.example.com" matching its subdomains.
+	// Sections bound to no host serve the requests for other hosts, and the
+	// requests whose path is beneath no root of the sections bound to their
+	// host. An invalid host is reported by Err.
+	AddHost(host string)
+	// AddPathPatternHandler returns an error if the pattern is invalid or
+	// ambiguous with the patterns added before, in which case the handler is
+	// not added. The error is also reported by Err.
+	AddPathPatternHandler(pattern string, handler http.Handler, contextKey any, config urlpathpatternhandler.Config) error
+	// AddMiddleware wraps the section's handlers with mw, for the requests
+	// for which predicate returns true, or all requests if it is nil.
+	AddMiddleware(mw func(http.Handler) http.Handler, predicate func(*http.Request) bool)
+	// AddMiddlewareAt is like AddMiddleware, installing mw at the position
+	// given in the section's middleware chain.
+	AddMiddlewareAt(position MiddlewarePosition, mw func(http.Handler) http.Handler, predicate func(*http.Request) bool)
+	AddOnResponseHook(responseinfo.Hook)
+	// AddResponseMutator buffers the section's responses so that m can
+	// rewrite them before they are written.
+	AddResponseMutator(m buffering.Mutator)
+	AddRateLimitingExemptPattern(pattern string)
 	AddRateLimitingSessionConfig(maxRequests int64, sessionDuration, banDuration time.Duration)
+	AddRateLimitingTierSessionConfig(tier string, maxRequests int64, sessionDuration, banDuration time.Duration)
 	AfterShutdown()
 	BeforeStart(*sync.WaitGroup)
+	// EnableRoute re-enables the handler of the route pattern serving
+	// methods, disabled after repeated panics, reporting whether it was
+	// disabled.
+	EnableRoute(route string, methods []string) bool
+	// Err returns the errors of the registrations made so far, which sections
+	// added to an application must not have.
+	Err() error
+	// Group returns a group of routes sharing the path prefix within the
+	// section.
+	Group(prefix string) Group
+	// Hosts returns the hosts the section is bound to, see AddHost.
+	Hosts() []string
+	ListenPort() int
+	// Middleware returns the names of the middleware handling the section's
+	// requests once NewHandler has been called, outermost first. The names
+	// of middleware applying to a group only are followed by its prefix.
+	Middleware() []string
+	// Mount passes requests for prefix and the paths beneath it to handler,
+	// with prefix removed from their path, returning an error as
+	// AddPathPatternHandler does.
+	Mount(prefix string, handler http.Handler, config urlpathpatternhandler.Config) error
 	NewHandler() http.Handler
+	PanicStats() []recovery.RouteStats
 	Root() string
+	Routes() []RouteInfo
+	ServerTimeouts() ServerTimeouts
+	SetAuthOptional(bool)
 	SetBasicAuthPassword(string)
 	SetBasicAuthRealm(string)
 	SetBasicAuthUsername(string)
+	SetAdaptiveConcurrency(concurrency.Config)
+	SetApplicationCORSConfig(*cors.Config)
+	SetConnectionClose(bool)
+	SetCORSConfig(cors.Config)
+	SetContentSecurityPolicy(csp.Config)
+	SetDevMode(bool)
+	SetErrorReporter(recovery.Reporter)
+	SetEventBus(events.Bus)
+	SetFeatureFlag(flags.Gate)
+	SetFlagProvider(flags.Provider)
+	// SetGlobalRateLimiter sets the application-wide rate limiter, whose
+	// cache is shared by every section.
+	SetGlobalRateLimiter(ratelimiting.MiddlewareHandler)
+	// SetHTMLErrorPages marks the section as serving HTML, so that its 404,
+	// 429 and 500 responses are styled error pages, rendered with the given
+	// templates if not nil.
+	SetHTMLErrorPages(*templates.Set)
+	SetHeaderLimits(headers.Limits)
+	SetListenPort(int)
+	SetLoadSheddingClassifier(shedding.Classifier)
+	SetLoadSheddingLimits(shedding.Limits)
+	SetMaxPanicsPerMinute(int)
+	SetMetricsRecorder(metrics.Recorder)
+	SetMethodOverride(methodoverride.Config)
+	// SetMinification minifies the section's HTML, CSS and JavaScript
+	// responses, buffering them like response mutators.
+	SetMinification(minify.Config)
+	SetQueryLimits(query.Limits)
+	SetRequestTimeoutMax(time.Duration)
+	// SetResponseBufferLimit sets the size beyond which responses are no
+	// longer buffered for the response mutators.
+	SetResponseBufferLimit(int64)
+	SetRateLimitingGroomingInterval(time.Duration)
+	// SetRejectNonCanonicalPaths passes requests whose paths contain
+	// dot-segments or duplicate slashes to the bad request handler instead of
+	// cleaning them.
+	SetRejectNonCanonicalPaths(bool)
 	SetRateLimitingHostCacheEntryIdleDuration(time.Duration)
+	SetRateLimitingLazyExpiration(bool)
+	SetRateLimitingHostResolver(realip.Resolver)
+	SetRateLimitingIPv6PrefixLength(int)
+	SetRateLimitingPreflightExempt(bool)
+	SetRateLimitingTierResolver(ratelimiting.TierResolver)
+	SetRateLimitingUseRemoteAddrForInvalidAddress(bool)
+	GroomRateLimiting()
+	RateLimitingBans() []ratelimiting.Ban
+	SetServerTimeouts(ServerTimeouts)
 	SetSimpleHandler(handler http.Handler)
+	// SetSPAFallback serves the GET and HEAD requests accepting HTML that
+	// match no route with handler instead of the 404 handler.
+	SetSPAFallback(handler http.Handler)
+	// SetStrictContextKeys rejects the routes added from then on that share
+	// their context key with a route capturing different variables, see
+	// urlpathpatternhandler.CheckContextKey, which are otherwise only logged.
+	SetStrictContextKeys(bool)
+	// SetPrefixedParamNames stores the values captured by the routes added
+	// from then on keyed by capture token, e.g. ":id", rather than by
+	// variable name.
+	SetPrefixedParamNames(bool)
+	SetStripPrefix(bool)
+	SetTenantResolver(tenant.Resolver)
+	// SetTrailingSlash sets the handling of requests matching a route only
+	// with their trailing slash removed or added.
+	SetTrailingSlash(urlpathpatternhandler.TrailingSlash)
+	// SetRouteCacheSize caches the routes matching up to size of the paths
+	// last requested, see urlpathpatternhandler.NewRoutes.
+	SetRouteCacheSize(size int)
 	SetStatusBadRequestHandlerFunc(HandlerFuncWithError)
+	SetStatusHandlerFunc(code int, h HandlerFuncWithError)
 	SetStatusNotFoundHandlerFunc(http.HandlerFunc)
 	SetStatusTooManyRequestsHandlerFunc(http.HandlerFunc)
+	SetStatusTooManyRequestsHandlerFuncWithRateInfo(HandlerFuncWithRateInfo)
+	SetStatusUnsupportedMediaTypeHandlerFunc(HandlerFuncWithError)
+	SetTLSCertificateFiles(certFile, keyFile string)
+	SetUnmatchedPathLimit(int)
+	SetValidator(binding.Validator)
+	SetWorkerPool(workerpool.Config)
+	TLSCertificateFiles() (certFile, keyFile string)
+	UnmatchedPaths() []unmatched.PathCount
+	// URLFor returns the path of the route with the given name, see
+	// urlpathpatternhandler.BuildPath, or an error wrapping
+	// urlpathpatternhandler.ErrUnknownRouteName if there is none.
+	URLFor(name string, params map[string]string) (string, error)
+}
+
+// RouteInfo describes a route registered with a section. Handler identifies
+// the route's handler, see urlpathpatternhandler.HandlerName.
+type RouteInfo struct {
+	SectionRoot string                         `json:"sectionRoot"`
+	Name        string                         `json:"name,omitempty"`
+	Pattern     string                         `json:"pattern"`
+	Methods     []string                       `json:"methods,omitempty"`
+	Handler     string                         `json:"handler,omitempty"`
+	Metadata    urlpathpatternhandler.Metadata `json:"metadata"`
 }
 
 type SectionDependencies interface {
@@ -36,6 +210,8 @@ type SectionDependencies interface {
 }
 
 type sectionRateLimitingConfig struct {
+	// tier is empty for the default session configs.
+	tier            string
 	maxRequests     int64
 	sessionDuration time.Duration
 	banDuration     time.Duration
@@ -44,22 +220,63 @@ type sectionRateLimitingConfig struct {
 type section struct {
 	deps SectionDependencies
 
-	statusBadRequestHandlerFunc HandlerFuncWithError
-
-	statusNotFoundHandlerFunc http.HandlerFunc
-
-	statusTooManyRequestsHandlerFunc http.HandlerFunc
+	statusHandlers statusHandlers
+	// statusTooManyRequestsHandler takes precedence over the 429 entry of
+	// statusHandlers when set.
+	statusTooManyRequestsHandler HandlerFuncWithRateInfo
 
 	simpleHandler http.Handler
+	// spaFallback serves the page navigations matching no route.
+	spaFallback http.Handler
+	// trailingSlash handles requests matching a route only with their
+	// trailing slash removed or added.
+	trailingSlash urlpathpatternhandler.TrailingSlash
 
 	urlPathPatternHandlers []urlpathpatternhandler.Handler
+	// routeValidator holds urlPathPatternHandlers, so that each handler
+	// added is checked against those of the same shape only.
+	routeValidator urlpathpatternhandler.Validator
+	// routes indexes urlPathPatternHandlers once NewHandler has been called.
+	routes *urlpathpatternhandler.Routes
+	// routeCacheSize is the number of paths whose routes are cached, or 0.
+	routeCacheSize int
 
 	rateLimitingHostCacheEntryIdleDuration time.Duration
 
+	// rateLimitingGroomingInterval overrides the default interval of
+	// background grooming when positive.
+	rateLimitingGroomingInterval time.Duration
+
+	// rateLimitingLazyExpiration disables background grooming.
+	rateLimitingLazyExpiration bool
+
 	activeMiddlewareHandlers []common.MiddlewareHandler
+	// activeMiddlewareGroups maps the indices of the active middleware
+	// handlers scoped to a group to the group's prefix.
+	activeMiddlewareGroups map[int]string
 
 	rateLimitingConfigs []sectionRateLimitingConfig
 
+	rateLimitingHostResolver realip.Resolver
+
+	rateLimitingTierResolver ratelimiting.TierResolver
+
+	// rateLimitingPreflightExempt excludes CORS preflight requests answered
+	// by the section from rate limiting.
+	rateLimitingPreflightExempt bool
+
+	rateLimitingExemptPatterns []string
+
+	rateLimitingIPv6PrefixLength int
+
+	rateLimitingUseRemoteAddrForInvalidAddress bool
+
+	// rateLimitingHandler is set once the section's handler is created with
+	// rate limiting configured.
+	rateLimitingHandler ratelimiting.MiddlewareHandler
+
+	globalRateLimiter ratelimiting.MiddlewareHandler
+
 	root string
 
 	basicAuthUsername string
@@ -67,6 +284,134 @@ type section struct {
 	basicAuthPassword string
 
 	basicAuthRealm string
+
+	authenticators []auth.Authenticator
+
+	authExemptPatterns []string
+
+	authOptional bool
+
+	validator binding.Validator
+
+	metrics metrics.Recorder
+
+	panicTracker recovery.Tracker
+
+	// connectionClose forces every response from the section to close its
+	// connection.
+	connectionClose bool
+
+	// listenPort binds the section to its own server when non-zero.
+	listenPort int
+
+	// workerPool runs handlers on a bounded pool of goroutines when set.
+	workerPool *workerpool.Config
+
+	// adaptiveConcurrency enables adaptive concurrency limiting when set.
+	adaptiveConcurrency *concurrency.Config
+
+	// loadSheddingLimits enables load shedding when set.
+	loadSheddingLimits *shedding.Limits
+
+	loadSheddingClassifier shedding.Classifier
+
+	serverTimeouts ServerTimeouts
+
+	tlsCertFile string
+
+	tlsKeyFile string
+
+	unmatchedPathTracker unmatched.Tracker
+
+	onResponseHooks []responseinfo.Hook
+
+	eventBus events.Bus
+
+	// headerLimits enables header validation when non-nil.
+	headerLimits *headers.Limits
+
+	// allowedContentTypes restricts the media types of request bodies for
+	// routes that do not set their own.
+	allowedContentTypes []string
+
+	// featureFlag gates the whole section behind a runtime feature flag.
+	featureFlag flags.Gate
+
+	flagProvider flags.Provider
+
+	errorReporter recovery.Reporter
+
+	// tenantResolver enables tenant partitioning when non-nil.
+	tenantResolver tenant.Resolver
+
+	// applicationCORSConfig is the application's cross-origin configuration,
+	// which sectionCORSConfig and route configurations override.
+	applicationCORSConfig *cors.Config
+
+	sectionCORSConfig *cors.Config
+
+	// stripPrefix causes the section's handlers, patterns and middleware to
+	// see request paths relative to the section root.
+	stripPrefix bool
+
+	// rejectNonCanonicalPaths rejects requests whose paths are not clean
+	// instead of cleaning them.
+	rejectNonCanonicalPaths bool
+
+	// requestTimeoutMax enables client requested timeouts when positive,
+	// capping them.
+	requestTimeoutMax time.Duration
+
+	// queryLimits enables query string validation when non-nil.
+	queryLimits *query.Limits
+
+	// methodOverride enables method overrides when non-nil.
+	methodOverride *methodoverride.Config
+
+	// middlewares wrap the section's handlers, the first added outermost.
+	middlewares []middleware
+
+	// defaultResponseHeaders are added to responses lacking them.
+	defaultResponseHeaders http.Header
+
+	// devMode trades safety and performance for convenience in development,
+	// as set by the application.
+	devMode bool
+
+	// htmlErrorPages enables HTML error pages when set.
+	htmlErrorPages *htmlErrorPagesConfig
+
+	// contentSecurityPolicy enables CSP headers and nonces when set.
+	contentSecurityPolicy *csp.Config
+
+	// responseMutators enable response buffering when not empty.
+	responseMutators    []buffering.Mutator
+	responseBufferLimit int64
+
+	// minification enables the minification of buffered responses when set.
+	minification *minify.Config
+
+	// registrationErrs are reported by Err.
+	registrationErrs []error
+
+	// hosts are the normalized hosts the section is bound to.
+	hosts []string
+
+	// strictContextKeys rejects routes sharing their context key with a
+	// route capturing different variables, which are otherwise logged.
+	strictContextKeys bool
+
+	// prefixedParamNames keys the values captured by routes by capture
+	// token, for compatibility with handlers predating variable name keys.
+	prefixedParamNames bool
+
+	// groups are the route groups of the section, nested groups following
+	// the groups they are nested in.
+	groups []*group
+}
+
+type htmlErrorPagesConfig struct {
+	templates *templates.Set
 }
 
 // SetSimpleHandler implements Section.
@@ -77,23 +422,102 @@ func (s *section) SetSimpleHandler(handler http.Handler) {
 	s.simpleHandler = handler
 }
 
+// SetSPAFallback implements Section.
+func (s *section) SetSPAFallback(handler http.Handler) {
+	s.spaFallback = handler
+}
+
+// SetRouteCacheSize implements Section.
+func (s *section) SetRouteCacheSize(size int) {
+	s.routeCacheSize = size
+}
+
+// SetTrailingSlash implements Section.
+func (s *section) SetTrailingSlash(p urlpathpatternhandler.TrailingSlash) {
+	s.trailingSlash = p
+}
+
+// AddAuthenticator implements Section.
+func (s *section) AddAuthenticator(a auth.Authenticator) {
+	s.authenticators = append(s.authenticators, a)
+}
+
+// AddAuthExemptPattern implements Section.
+func (s *section) AddAuthExemptPattern(pattern string) {
+	s.authExemptPatterns = append(s.authExemptPatterns, pattern)
+}
+
 // AddPathPatternHandler implements Section.
 func (s *section) AddPathPatternHandler(
 	pattern string,
 	handler http.Handler,
 	contextKey any,
-) {
-	patternHandler := urlpathpatternhandler.NewHandler(pattern, handler, contextKey)
-	s.urlPathPatternHandlers = append(s.urlPathPatternHandlers, patternHandler)
-	if err := urlpathpatternhandler.ValidateResponders(
-		s.urlPathPatternHandlers,
-	); err != nil {
-		panic(err)
-	}
-	slices.SortFunc(
-		s.urlPathPatternHandlers,
-		urlpathpatternhandler.ComparePatternHandlers,
-	)
+	config urlpathpatternhandler.Config,
+) error {
+	if s.prefixedParamNames {
+		config.PrefixedParamNames = true
+	}
+	patternHandler := urlpathpatternhandler.NewHandler(pattern, handler, contextKey, config)
+	if err := s.routeValidator.Validate(patternHandler); err != nil {
+		s.registrationErrs = append(s.registrationErrs, err)
+		return err
+	}
+	if err := s.routeValidator.CheckContextKey(patternHandler); err != nil {
+		if s.strictContextKeys {
+			s.registrationErrs = append(s.registrationErrs, err)
+			return err
+		}
+		logger.Debug("", "Warning: %s", err)
+	}
+	s.routeValidator.Add(patternHandler)
+	// Handlers of the same pattern remain in the order they were added, in
+	// which they are selected.
+	i := sort.Search(len(s.urlPathPatternHandlers), func(i int) bool {
+		return urlpathpatternhandler.ComparePatternHandlers(s.urlPathPatternHandlers[i], patternHandler) > 0
+	})
+	s.urlPathPatternHandlers = slices.Insert(s.urlPathPatternHandlers, i, patternHandler)
+	return nil
+}
+
+// lookup returns the section's handlers matching requestPath, using the
+// index built by NewHandler if any.
+func (s *section) lookup(requestPath string) []urlpathpatternhandler.Handler {
+	if s.routes == nil {
+		return urlpathpatternhandler.Lookup(s.urlPathPatternHandlers, requestPath)
+	}
+	return s.routes.Lookup(requestPath)
+}
+
+// AddHost implements Section.
+func (s *section) AddHost(host string) {
+	normalized, err := normalizeHost(host)
+	if err != nil {
+		s.registrationErrs = append(s.registrationErrs, err)
+		return
+	}
+	if !slices.Contains(s.hosts, normalized) {
+		s.hosts = append(s.hosts, normalized)
+	}
+}
+
+// Hosts implements Section.
+func (s *section) Hosts() []string {
+	return s.hosts
+}
+
+// Err implements Section.
+func (s *section) Err() error {
+	return errors.Join(s.registrationErrs...)
+}
+
+// AddOnResponseHook implements Section.
+func (s *section) AddOnResponseHook(h responseinfo.Hook) {
+	s.onResponseHooks = append(s.onResponseHooks, h)
+}
+
+// AddResponseMutator implements Section.
+func (s *section) AddResponseMutator(m buffering.Mutator) {
+	s.responseMutators = append(s.responseMutators, m)
 }
 
 // AddRateLimitingSessionConfig implements Section.
@@ -105,6 +529,26 @@ func (s *section) AddRateLimitingSessionConfig(maxRequests int64, sessionDuratio
 	})
 }
 
+// AddRateLimitingExemptPattern implements Section.
+func (s *section) AddRateLimitingExemptPattern(pattern string) {
+	s.rateLimitingExemptPatterns = append(s.rateLimitingExemptPatterns, pattern)
+}
+
+// AddRateLimitingTierSessionConfig implements Section.
+func (s *section) AddRateLimitingTierSessionConfig(
+	tier string,
+	maxRequests int64,
+	sessionDuration time.Duration,
+	banDuration time.Duration,
+) {
+	s.rateLimitingConfigs = append(s.rateLimitingConfigs, sectionRateLimitingConfig{
+		tier:            tier,
+		maxRequests:     maxRequests,
+		sessionDuration: sessionDuration,
+		banDuration:     banDuration,
+	})
+}
+
 // AfterShutdown implements Section.
 func (s *section) AfterShutdown() {
 	for _, h := range s.activeMiddlewareHandlers {
@@ -119,11 +563,83 @@ func (s *section) BeforeStart(wg *sync.WaitGroup) {
 	}
 }
 
+// EnableRoute implements Section.
+func (s *section) EnableRoute(route string, methods []string) bool {
+	tracked := recovery.NewRoute(route, methods)
+	if !s.panicTracker.Enable(tracked) {
+		return false
+	}
+	s.metrics.SetGauge("sudsy_route_disabled", routeDisabledLabels(s.root, tracked), 0)
+	return true
+}
+
+// routeDisabledLabels returns the labels of the sudsy_route_disabled gauge
+// of route.
+func routeDisabledLabels(sectionRoot string, route recovery.Route) metrics.Labels {
+	return metrics.Labels{"section": sectionRoot, "route": route.Pattern, "methods": route.Methods}
+}
+
+// ListenPort implements Section.
+func (s *section) ListenPort() int {
+	return s.listenPort
+}
+
+// PanicStats implements Section.
+func (s *section) PanicStats() []recovery.RouteStats {
+	return s.panicTracker.Stats()
+}
+
 // Root implements Section.
 func (s *section) Root() string {
 	return s.root
 }
 
+// Routes implements Section.
+func (s *section) Routes() []RouteInfo {
+	if s.simpleHandler != nil {
+		return []RouteInfo{{
+			SectionRoot: s.root,
+			Pattern:     simpleHandlerRoute,
+			Handler:     urlpathpatternhandler.HandlerName(s.simpleHandler),
+		}}
+	}
+	result := make([]RouteInfo, 0, len(s.urlPathPatternHandlers))
+	for _, h := range s.urlPathPatternHandlers {
+		result = append(result, RouteInfo{
+			SectionRoot: s.root,
+			Name:        h.Config().Name,
+			Pattern:     h.Pattern(),
+			Methods:     h.Config().Methods,
+			Handler:     h.HandlerName(),
+			Metadata:    h.Config().Metadata,
+		})
+	}
+	return result
+}
+
+// URLFor implements Section.
+func (s *section) URLFor(name string, params map[string]string) (string, error) {
+	for _, h := range s.urlPathPatternHandlers {
+		if h.Config().Name != name {
+			continue
+		}
+		result, err := urlpathpatternhandler.BuildPath(h.Pattern(), params)
+		if err != nil {
+			return "", fmt.Errorf("route %q: %w", name, err)
+		}
+		if s.stripPrefix {
+			result = strings.TrimSuffix(s.root, "/") + result
+		}
+		return result, nil
+	}
+	return "", fmt.Errorf("%w: %q", urlpathpatternhandler.ErrUnknownRouteName, name)
+}
+
+// SetAuthOptional implements Section.
+func (s *section) SetAuthOptional(v bool) {
+	s.authOptional = v
+}
+
 // SetBasicAuthPassword implements Section.
 func (s *section) SetBasicAuthPassword(password string) {
 	s.basicAuthPassword = password
@@ -139,119 +655,970 @@ func (s *section) SetBasicAuthUsername(username string) {
 	s.basicAuthUsername = username
 }
 
+// ServerTimeouts implements Section.
+func (s *section) ServerTimeouts() ServerTimeouts {
+	return s.serverTimeouts
+}
+
+// SetLoadSheddingClassifier implements Section.
+func (s *section) SetLoadSheddingClassifier(c shedding.Classifier) {
+	s.loadSheddingClassifier = c
+}
+
+// SetLoadSheddingLimits implements Section.
+func (s *section) SetLoadSheddingLimits(l shedding.Limits) {
+	s.loadSheddingLimits = &l
+}
+
+// SetListenPort implements Section.
+func (s *section) SetListenPort(port int) {
+	s.listenPort = port
+}
+
+// SetServerTimeouts implements Section.
+func (s *section) SetServerTimeouts(t ServerTimeouts) {
+	s.serverTimeouts = t
+}
+
+// SetTLSCertificateFiles implements Section.
+func (s *section) SetTLSCertificateFiles(certFile, keyFile string) {
+	s.tlsCertFile = certFile
+	s.tlsKeyFile = keyFile
+}
+
+// SetUnmatchedPathLimit implements Section.
+func (s *section) SetUnmatchedPathLimit(n int) {
+	s.unmatchedPathTracker.SetLimit(n)
+}
+
+// UnmatchedPaths implements Section.
+func (s *section) UnmatchedPaths() []unmatched.PathCount {
+	return s.unmatchedPathTracker.Stats()
+}
+
+// TLSCertificateFiles implements Section.
+func (s *section) TLSCertificateFiles() (string, string) {
+	return s.tlsCertFile, s.tlsKeyFile
+}
+
+// SetEventBus implements Section.
+func (s *section) SetEventBus(b events.Bus) {
+	s.eventBus = b
+}
+
+// SetHeaderLimits implements Section.
+func (s *section) SetHeaderLimits(l headers.Limits) {
+	s.headerLimits = &l
+}
+
+// AddAllowedContentTypes implements Section.
+func (s *section) AddAllowedContentTypes(mediaTypes ...string) {
+	s.allowedContentTypes = append(s.allowedContentTypes, mediaTypes...)
+}
+
+// SetFeatureFlag implements Section.
+func (s *section) SetFeatureFlag(g flags.Gate) {
+	s.featureFlag = g
+}
+
+// SetErrorReporter implements Section.
+func (s *section) SetErrorReporter(r recovery.Reporter) {
+	s.errorReporter = r
+}
+
+// SetFlagProvider implements Section.
+func (s *section) SetFlagProvider(p flags.Provider) {
+	s.flagProvider = p
+}
+
+// SetTenantResolver implements Section.
+func (s *section) SetTenantResolver(r tenant.Resolver) {
+	s.tenantResolver = r
+}
+
+// SetAdaptiveConcurrency implements Section.
+func (s *section) SetAdaptiveConcurrency(c concurrency.Config) {
+	s.adaptiveConcurrency = &c
+}
+
+// SetApplicationCORSConfig implements Section.
+func (s *section) SetApplicationCORSConfig(c *cors.Config) {
+	s.applicationCORSConfig = c
+}
+
+// SetCORSConfig implements Section.
+func (s *section) SetCORSConfig(c cors.Config) {
+	s.sectionCORSConfig = &c
+}
+
+// corsConfigured reports whether any cross-origin configuration applies to
+// the section or its routes.
+func (s *section) corsConfigured() bool {
+	return s.applicationCORSConfig != nil ||
+		s.sectionCORSConfig != nil ||
+		slices.ContainsFunc(s.urlPathPatternHandlers, func(h urlpathpatternhandler.Handler) bool {
+			return h.Config().CORS != nil
+		})
+}
+
+// SetRejectNonCanonicalPaths implements Section.
+func (s *section) SetRejectNonCanonicalPaths(v bool) {
+	s.rejectNonCanonicalPaths = v
+}
+
+// SetPrefixedParamNames implements Section.
+func (s *section) SetPrefixedParamNames(v bool) {
+	s.prefixedParamNames = v
+}
+
+// SetStrictContextKeys implements Section.
+func (s *section) SetStrictContextKeys(v bool) {
+	s.strictContextKeys = v
+}
+
+// SetStripPrefix implements Section.
+func (s *section) SetStripPrefix(v bool) {
+	s.stripPrefix = v
+}
+
+// SetRequestTimeoutMax implements Section.
+func (s *section) SetRequestTimeoutMax(d time.Duration) {
+	s.requestTimeoutMax = d
+}
+
+// AddDefaultResponseHeader implements Section.
+func (s *section) AddDefaultResponseHeader(name, value string) {
+	if s.defaultResponseHeaders == nil {
+		s.defaultResponseHeaders = http.Header{}
+	}
+	s.defaultResponseHeaders.Add(name, value)
+}
+
+// AddMiddleware implements Section.
+func (s *section) AddMiddleware(mw func(http.Handler) http.Handler, predicate func(*http.Request) bool) {
+	s.AddMiddlewareAt(MiddlewareInnermost, mw, predicate)
+}
+
+// AddMiddlewareAt implements Section.
+func (s *section) AddMiddlewareAt(position MiddlewarePosition, mw func(http.Handler) http.Handler, predicate func(*http.Request) bool) {
+	s.middlewares = append(s.middlewares, middleware{wrap: mw, predicate: predicate, position: position})
+}
+
+// SetContentSecurityPolicy implements Section.
+func (s *section) SetContentSecurityPolicy(c csp.Config) {
+	s.contentSecurityPolicy = &c
+}
+
+// SetDevMode implements Section.
+func (s *section) SetDevMode(v bool) {
+	s.devMode = v
+}
+
+// SetHTMLErrorPages implements Section.
+func (s *section) SetHTMLErrorPages(t *templates.Set) {
+	s.htmlErrorPages = &htmlErrorPagesConfig{templates: t}
+}
+
+// SetResponseBufferLimit implements Section.
+func (s *section) SetResponseBufferLimit(n int64) {
+	s.responseBufferLimit = n
+}
+
+// SetMinification implements Section.
+func (s *section) SetMinification(c minify.Config) {
+	s.minification = &c
+}
+
+// SetMethodOverride implements Section.
+func (s *section) SetMethodOverride(c methodoverride.Config) {
+	s.methodOverride = &c
+}
+
+// SetQueryLimits implements Section.
+func (s *section) SetQueryLimits(l query.Limits) {
+	s.queryLimits = &l
+}
+
+// SetConnectionClose implements Section.
+func (s *section) SetConnectionClose(v bool) {
+	s.connectionClose = v
+}
+
+// SetMaxPanicsPerMinute implements Section.
+func (s *section) SetMaxPanicsPerMinute(n int) {
+	s.panicTracker.SetMaxPanicsPerMinute(n)
+}
+
+// SetMetricsRecorder implements Section.
+func (s *section) SetMetricsRecorder(r metrics.Recorder) {
+	s.metrics = r
+}
+
 // SetRateLimitingHostCacheEntryIdleDuration implements Section.
 func (s *section) SetRateLimitingHostCacheEntryIdleDuration(d time.Duration) {
 	s.rateLimitingHostCacheEntryIdleDuration = d
 }
 
+// SetRateLimitingHostResolver implements Section.
+func (s *section) SetRateLimitingHostResolver(r realip.Resolver) {
+	s.rateLimitingHostResolver = r
+}
+
+// GroomRateLimiting implements Section.
+func (s *section) GroomRateLimiting() {
+	if s.rateLimitingHandler != nil {
+		s.rateLimitingHandler.GroomNow()
+	}
+}
+
+// SetRateLimitingGroomingInterval implements Section.
+func (s *section) SetRateLimitingGroomingInterval(d time.Duration) {
+	s.rateLimitingGroomingInterval = d
+}
+
+// SetRateLimitingLazyExpiration implements Section.
+func (s *section) SetRateLimitingLazyExpiration(v bool) {
+	s.rateLimitingLazyExpiration = v
+}
+
+// RateLimitingBans implements Section.
+func (s *section) RateLimitingBans() []ratelimiting.Ban {
+	if s.rateLimitingHandler == nil {
+		return []ratelimiting.Ban{}
+	}
+	return s.rateLimitingHandler.ListBans()
+}
+
+// SetRateLimitingIPv6PrefixLength implements Section.
+func (s *section) SetRateLimitingIPv6PrefixLength(bits int) {
+	s.rateLimitingIPv6PrefixLength = bits
+}
+
+// SetRateLimitingPreflightExempt implements Section.
+func (s *section) SetRateLimitingPreflightExempt(v bool) {
+	s.rateLimitingPreflightExempt = v
+}
+
+// SetRateLimitingUseRemoteAddrForInvalidAddress implements Section.
+func (s *section) SetRateLimitingUseRemoteAddrForInvalidAddress(v bool) {
+	s.rateLimitingUseRemoteAddrForInvalidAddress = v
+}
+
+// SetGlobalRateLimiter implements Section.
+func (s *section) SetGlobalRateLimiter(h ratelimiting.MiddlewareHandler) {
+	s.globalRateLimiter = h
+}
+
+// SetRateLimitingTierResolver implements Section.
+func (s *section) SetRateLimitingTierResolver(r ratelimiting.TierResolver) {
+	s.rateLimitingTierResolver = r
+}
+
 // SetStatusBadRequestHandlerFunc implements Section.
 func (s *section) SetStatusBadRequestHandlerFunc(h HandlerFuncWithError) {
-	s.statusBadRequestHandlerFunc = h
+	s.SetStatusHandlerFunc(http.StatusBadRequest, h)
+}
+
+// SetStatusHandlerFunc implements Section.
+func (s *section) SetStatusHandlerFunc(code int, h HandlerFuncWithError) {
+	s.statusHandlers[code] = h
+}
+
+// SetStatusUnsupportedMediaTypeHandlerFunc implements Section.
+func (s *section) SetStatusUnsupportedMediaTypeHandlerFunc(h HandlerFuncWithError) {
+	s.SetStatusHandlerFunc(http.StatusUnsupportedMediaType, h)
 }
 
 // SetStatusNotFoundHandlerFunc implements Section.
 func (s *section) SetStatusNotFoundHandlerFunc(h http.HandlerFunc) {
-	s.statusNotFoundHandlerFunc = h
+	s.SetStatusHandlerFunc(http.StatusNotFound, ignoreError(h))
 }
 
 // SetStatusTooManyRequestsHandlerFunc implements Section.
 func (s *section) SetStatusTooManyRequestsHandlerFunc(h http.HandlerFunc) {
-	s.statusTooManyRequestsHandlerFunc = h
+	s.SetStatusHandlerFunc(http.StatusTooManyRequests, ignoreError(h))
+}
+
+// SetStatusTooManyRequestsHandlerFuncWithRateInfo implements Section.
+func (s *section) SetStatusTooManyRequestsHandlerFuncWithRateInfo(h HandlerFuncWithRateInfo) {
+	s.statusTooManyRequestsHandler = h
+}
+
+// SetValidator implements Section.
+func (s *section) SetValidator(v binding.Validator) {
+	s.validator = v
+}
+
+// SetWorkerPool implements Section.
+func (s *section) SetWorkerPool(c workerpool.Config) {
+	s.workerPool = &c
 }
 
 func (s *section) NewHandler() http.Handler {
 	logger.Debug("", "Creating HTTP handler for %+v", s)
+	if s.htmlErrorPages != nil {
+		for _, code := range []int{http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError} {
+			if _, found := s.statusHandlers[code]; !found {
+				s.statusHandlers[code] = errorpages.NewHandlerFunc(code, s.htmlErrorPages.templates, s.devMode)
+			}
+		}
+	}
+	if _, found := s.statusHandlers[http.StatusInternalServerError]; s.devMode && !found {
+		s.statusHandlers[http.StatusInternalServerError] = handleStatusInternalServerErrorVerbose
+	}
+	s.routes = urlpathpatternhandler.NewRoutes(s.urlPathPatternHandlers, s.routeCacheSize)
 	var outermost common.MiddlewareHandler
 	outermost = newSectionHandler(
 		s.newSectionHandlerDependencies(),
 		s.simpleHandler,
-		s.urlPathPatternHandlers,
+		s.routes,
 	)
 	s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	// Added middleware sees requests once they have passed the built-in
+	// middleware, and runs on the worker pool if any.
+	outermost = s.wrapMiddlewares(outermost, MiddlewareInnermost)
+	// Groups' settings apply after the section's, those of nested groups
+	// last.
+	for i := len(s.groups) - 1; i >= 0; i-- {
+		if handlers := s.groups[i].newHandlers(outermost); len(handlers) > 0 {
+			outermost = handlers[len(handlers)-1]
+			if s.activeMiddlewareGroups == nil {
+				s.activeMiddlewareGroups = map[int]string{}
+			}
+			for j := range handlers {
+				s.activeMiddlewareGroups[len(s.activeMiddlewareHandlers)+j] = s.groups[i].prefix
+			}
+			s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, handlers...)
+		}
+	}
+	if s.workerPool != nil {
+		outermost = workerpool.NewMiddlewareHandler(&workerPoolDependencies{section: s}, outermost, *s.workerPool)
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
+	if s.loadSheddingLimits != nil {
+		// Requests are classified after authentication, so that classifiers
+		// can consider the principal.
+		outermost = shedding.NewMiddlewareHandler(&sheddingDependencies{section: s}, outermost, *s.loadSheddingLimits)
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
+	if s.adaptiveConcurrency != nil {
+		// Shed requests never count against the limit, so that low priority
+		// traffic is rejected before the limit is reached.
+		outermost = concurrency.NewMiddlewareHandler(&concurrencyDependencies{section: s}, outermost, *s.adaptiveConcurrency)
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
+	outermost = s.wrapMiddlewares(outermost, MiddlewareAfterAuth)
+	authenticators := slices.Clone(s.authenticators)
 	if s.basicAuthUsername != "" && s.basicAuthPassword != "" && s.basicAuthRealm != "" {
-		outermost = basicauth.NewMiddlewareHandler(
-			outermost,
+		authenticators = append(authenticators, basicauth.NewAuthenticator(
 			s.basicAuthUsername,
 			s.basicAuthPassword,
 			s.basicAuthRealm,
-		)
-		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+		))
 	} else {
 		logger.Debug("", "Basic auth not configured")
 	}
+	if len(authenticators) > 0 {
+		outermost = func() common.MiddlewareHandler {
+			h := auth.NewMiddlewareHandler(
+				&authDependencies{
+					eventBus:       s.eventBus,
+					now:            s.deps.Now,
+					sectionRoot:    s.root,
+					statusHandlers: s.statusHandlers,
+				},
+				outermost,
+				authenticators...,
+			)
+			for _, p := range s.authExemptPatterns {
+				h.AddExemptPattern(p)
+			}
+			h.SetOptional(s.authOptional)
+			return h
+		}()
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
+	outermost = s.wrapMiddlewares(outermost, MiddlewareBeforeAuth)
+	if s.corsConfigured() {
+		// Preflight requests carry no credentials, so they are answered ahead
+		// of authentication.
+		outermost = cors.NewMiddlewareHandler(&corsDependencies{section: s}, outermost)
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
 	if len(s.rateLimitingConfigs) > 0 {
 		outermost = func() common.MiddlewareHandler {
 			h := ratelimiting.NewMiddlewareHandler(
-				s.newRateLimitingDependencies(),
+				s.newRateLimitingDependencies(rateLimitingScopeSection),
 				outermost,
 			)
 			for _, c := range s.rateLimitingConfigs {
-				h.AddSessionConfig(c.maxRequests, c.sessionDuration, c.banDuration)
+				if c.tier == "" {
+					h.AddSessionConfig(c.maxRequests, c.sessionDuration, c.banDuration)
+				} else {
+					h.AddTierSessionConfig(c.tier, c.maxRequests, c.sessionDuration, c.banDuration)
+				}
 			}
 			if s.rateLimitingHostCacheEntryIdleDuration > 0 {
 				h.SetHostCacheEntryIdleDuration(s.rateLimitingHostCacheEntryIdleDuration)
 			}
+			if s.rateLimitingLazyExpiration {
+				h.SetHostCacheGroomingInterval(0)
+			} else if s.rateLimitingGroomingInterval > 0 {
+				h.SetHostCacheGroomingInterval(s.rateLimitingGroomingInterval)
+			}
+			if s.rateLimitingHostResolver != nil {
+				h.SetHostResolver(s.rateLimitingHostResolver)
+			}
+			if s.rateLimitingTierResolver != nil {
+				h.SetTierResolver(s.rateLimitingTierResolver)
+			}
+			if s.rateLimitingIPv6PrefixLength > 0 {
+				h.SetIPv6PrefixLength(s.rateLimitingIPv6PrefixLength)
+			}
+			h.SetUseRemoteAddrForInvalidAddress(s.rateLimitingUseRemoteAddrForInvalidAddress)
+			for _, f := range s.rateLimitingExemptFuncs() {
+				h.AddExemptFunc(f)
+			}
+			s.rateLimitingHandler = h
 			return h
 		}()
 		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
 	} else {
 		logger.Debug("", "Rate limiting not configured")
 	}
+	if s.globalRateLimiter != nil {
+		// Requests count against the application's limits ahead of the
+		// section's, with the section's exemptions and status handlers.
+		outermost = s.globalRateLimiter.Wrap(
+			s.newRateLimitingDependencies(rateLimitingScopeApplication),
+			outermost,
+			s.rateLimitingExemptFuncs()...,
+		)
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
+	if s.requestTimeoutMax > 0 {
+		outermost = deadline.NewMiddlewareHandler(
+			&deadlineDependencies{section: s},
+			outermost,
+			s.requestTimeoutMax,
+		)
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
+	if s.tenantResolver != nil {
+		outermost = tenant.NewMiddlewareHandler(
+			&statusDependencies{statusHandlers: s.statusHandlers},
+			s.tenantResolver,
+			outermost,
+		)
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
+	if s.methodOverride != nil {
+		// Overrides apply ahead of everything considering the method, but
+		// after the query and headers have been validated.
+		outermost = methodoverride.NewMiddlewareHandler(
+			&statusDependencies{statusHandlers: s.statusHandlers},
+			outermost,
+			*s.methodOverride,
+		)
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
+	if s.queryLimits != nil {
+		outermost = query.NewMiddlewareHandler(
+			&statusDependencies{statusHandlers: s.statusHandlers},
+			outermost,
+			*s.queryLimits,
+		)
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
+	if s.headerLimits != nil {
+		outermost = headers.NewMiddlewareHandler(
+			&statusDependencies{statusHandlers: s.statusHandlers},
+			outermost,
+			*s.headerLimits,
+		)
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
+	if prefix := strings.TrimSuffix(s.root, "/"); s.stripPrefix && prefix != "" {
+		// Hooks still see the full request path.
+		outermost = &stripPrefixHandler{Handler: http.StripPrefix(prefix, outermost)}
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
+	// Paths are cleaned before the prefix is stripped, so that dot-segments
+	// cannot escape the section.
+	outermost = pathclean.NewMiddlewareHandler(
+		&statusDependencies{statusHandlers: s.statusHandlers},
+		outermost,
+		s.rejectNonCanonicalPaths,
+	)
+	s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	mutators := slices.Clip(s.responseMutators)
+	if s.minification != nil {
+		// Other mutators see the responses as written by the handlers.
+		mutators = append(mutators, minify.NewMutator(*s.minification))
+	}
+	if len(mutators) > 0 {
+		// Responses written by the built-in middleware are buffered too.
+		outermost = buffering.NewMiddlewareHandler(outermost, s.responseBufferLimit, mutators...)
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
+	if s.contentSecurityPolicy != nil {
+		// The nonce is available to the response mutators.
+		outermost = csp.NewMiddlewareHandler(outermost, *s.contentSecurityPolicy)
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
+	if len(s.defaultResponseHeaders) > 0 || s.devMode {
+		// Responses written by the built-in middleware get the defaults too.
+		var overrides http.Header
+		if s.devMode {
+			overrides = devModeResponseHeaders
+		}
+		outermost = responseheaders.NewMiddlewareHandler(outermost, s.defaultResponseHeaders, overrides)
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
+	// Disconnects are detected after every other middleware has returned.
+	outermost = clientgone.NewMiddlewareHandler(&clientGoneDependencies{section: s}, outermost)
+	s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	if len(s.onResponseHooks) > 0 {
+		outermost = responseinfo.NewMiddlewareHandler(s.deps, outermost, s.onResponseHooks...)
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
 	return outermost
 }
 
-func (s *section) newRateLimitingDependencies() ratelimiting.Dependencies {
+// rateLimitingExemptFuncs returns the functions reporting whether requests are
+// exempt from both the section's and the application-wide rate limiting.
+func (s *section) rateLimitingExemptFuncs() []func(*http.Request) bool {
+	result := []func(*http.Request) bool{}
+	for _, p := range s.rateLimitingExemptPatterns {
+		result = append(result, func(r *http.Request) bool {
+			return urlpathpatternhandler.MatchPattern(p, urlpathpatternhandler.RoutingPath(r.URL))
+		})
+	}
+	if s.rateLimitingPreflightExempt {
+		corsDeps := &corsDependencies{section: s}
+		result = append(result, func(r *http.Request) bool {
+			// Only preflights answered by the CORS middleware handler are
+			// exempt, since others reach the route's handler.
+			if !cors.IsPreflight(r) {
+				return false
+			}
+			_, found := corsDeps.Config(r)
+			return found
+		})
+	}
+	return result
+}
+
+const (
+	rateLimitingScopeApplication = "application"
+	rateLimitingScopeSection     = "section"
+	rateLimitingScopeGroup       = "group"
+)
+
+func (s *section) newRateLimitingDependencies(scope string) ratelimiting.Dependencies {
 	return &rateLimitingDependencies{
-		statusBadRequestHandlerFunc:      s.statusBadRequestHandlerFunc,
-		statusTooManyRequestsHandlerFunc: s.statusTooManyRequestsHandlerFunc,
-		now:                              s.deps.Now,
+		eventBus:       s.eventBus,
+		metrics:        s.metrics,
+		scope:          scope,
+		sectionRoot:    s.root,
+		statusHandlers: s.statusHandlers,
+		now:            s.deps.Now,
+
+		statusTooManyRequestsHandler: s.statusTooManyRequestsHandler,
 	}
 }
 
 func (s *section) newSectionHandlerDependencies() sectionHandlerDependencies {
 	return sectionHandlerDependencies{
-		StatusNotFoundHandlerFunc: s.statusNotFoundHandlerFunc,
+		AllowedContentTypes:  s.allowedContentTypes,
+		ConnectionClose:      s.connectionClose,
+		DevMode:              s.devMode,
+		ErrorReporter:        s.errorReporter,
+		EventBus:             s.eventBus,
+		FeatureFlag:          s.featureFlag,
+		FlagProvider:         s.flagProvider,
+		Metrics:              s.metrics,
+		Now:                  s.deps.Now,
+		PanicTracker:         s.panicTracker,
+		SectionRoot:          s.root,
+		SPAFallback:          s.spaFallback,
+		StatusHandlers:       s.statusHandlers,
+		TrailingSlash:        s.trailingSlash,
+		UnmatchedPathTracker: s.unmatchedPathTracker,
+		Validator:            s.validator,
 	}
 }
 
+// NewSection returns a section serving the subtree beneath root. The root is
+// normalized to end with a slash; invalid roots are reported by
+// Application.AddSection.
 func NewSection(deps SectionDependencies, root string) Section {
+	if normalized, err := normalizeRoot(root); err == nil {
+		root = normalized
+	}
 	return &section{
-		deps: deps,
-		root: root,
+		deps:           deps,
+		root:           root,
+		statusHandlers: statusHandlers{},
+		eventBus:       events.NewBus(),
+		metrics:        metrics.NewNoopRecorder(),
+		panicTracker:   recovery.NewTracker(),
+
+		unmatchedPathTracker: unmatched.NewTracker(),
+	}
+}
+
+type corsDependencies struct {
+	section *section
+}
+
+// Config implements cors.Dependencies. The application, section and matched
+// route configurations are merged in that order.
+func (d *corsDependencies) Config(r *http.Request) (cors.Config, bool) {
+	var result cors.Config
+	found := false
+	for _, c := range []*cors.Config{
+		d.section.applicationCORSConfig,
+		d.section.sectionCORSConfig,
+		d.routeConfig(r),
+	} {
+		if c != nil {
+			result = cors.Merge(result, *c)
+			found = true
+		}
+	}
+	return result, found
+}
+
+func (d *corsDependencies) routeConfig(r *http.Request) *cors.Config {
+	method := r.Method
+	if cors.IsPreflight(r) {
+		// Preflights are answered for the route serving the actual request.
+		method = r.Header.Get("access-control-request-method")
+	}
+	h, found := urlpathpatternhandler.SelectMethod(
+		d.section.lookup(urlpathpatternhandler.RoutingPath(r.URL)),
+		method,
+	)
+	if !found {
+		return nil
+	}
+	return h.Config().CORS
+}
+
+type clientGoneDependencies struct {
+	section *section
+}
+
+// AddCounter implements clientgone.Dependencies.
+func (d *clientGoneDependencies) AddCounter(name string, labels map[string]string, delta float64) {
+	result := metrics.Labels{"section": d.section.root}
+	for k, v := range labels {
+		result[k] = v
+	}
+	d.section.metrics.AddCounter(name, result, delta)
+}
+
+type concurrencyDependencies struct {
+	section *section
+}
+
+// AddCounter implements concurrency.Dependencies.
+func (d *concurrencyDependencies) AddCounter(name string, delta float64) {
+	d.section.metrics.AddCounter(name, metrics.Labels{"section": d.section.root}, delta)
+}
+
+// HandleStatusServiceUnavailable implements concurrency.Dependencies.
+func (d *concurrencyDependencies) HandleStatusServiceUnavailable(w http.ResponseWriter, r *http.Request, err error) {
+	d.section.statusHandlers.handle(http.StatusServiceUnavailable, w, r, err)
+}
+
+// Now implements concurrency.Dependencies.
+func (d *concurrencyDependencies) Now() time.Time {
+	return d.section.deps.Now()
+}
+
+// SetGauge implements concurrency.Dependencies.
+func (d *concurrencyDependencies) SetGauge(name string, value float64) {
+	d.section.metrics.SetGauge(name, metrics.Labels{"section": d.section.root}, value)
+}
+
+type deadlineDependencies struct {
+	section *section
+}
+
+// AddCounter implements deadline.Dependencies.
+func (d *deadlineDependencies) AddCounter(name string, labels map[string]string, delta float64) {
+	result := metrics.Labels{"section": d.section.root}
+	for k, v := range labels {
+		result[k] = v
+	}
+	d.section.metrics.AddCounter(name, result, delta)
+}
+
+// HandleStatusBadRequest implements deadline.Dependencies.
+func (d *deadlineDependencies) HandleStatusBadRequest(w http.ResponseWriter, r *http.Request, err error) {
+	d.section.statusHandlers.handle(http.StatusBadRequest, w, r, err)
+}
+
+type sheddingDependencies struct {
+	section *section
+}
+
+// AddCounter implements shedding.Dependencies.
+func (d *sheddingDependencies) AddCounter(name string, labels map[string]string, delta float64) {
+	result := metrics.Labels{"section": d.section.root}
+	for k, v := range labels {
+		result[k] = v
+	}
+	d.section.metrics.AddCounter(name, result, delta)
+}
+
+// Classify implements shedding.Dependencies. The priority of the matched
+// route takes precedence over the section's classifier.
+func (d *sheddingDependencies) Classify(r *http.Request) shedding.Priority {
+	h, _ := urlpathpatternhandler.SelectRequest(
+		d.section.lookup(urlpathpatternhandler.RoutingPath(r.URL)),
+		r,
+	)
+	if h != nil {
+		if p := h.Config().Priority; p != nil {
+			return *p
+		}
+	}
+	if d.section.loadSheddingClassifier != nil {
+		return d.section.loadSheddingClassifier(r)
+	}
+	return shedding.PriorityNormal
+}
+
+// HandleStatusServiceUnavailable implements shedding.Dependencies.
+func (d *sheddingDependencies) HandleStatusServiceUnavailable(w http.ResponseWriter, r *http.Request, err error) {
+	d.section.statusHandlers.handle(http.StatusServiceUnavailable, w, r, err)
+}
+
+// Now implements shedding.Dependencies.
+func (d *sheddingDependencies) Now() time.Time {
+	return d.section.deps.Now()
+}
+
+type workerPoolDependencies struct {
+	section *section
+}
+
+// AddCounter implements workerpool.Dependencies.
+func (d *workerPoolDependencies) AddCounter(name string, labels map[string]string, delta float64) {
+	result := metrics.Labels{"section": d.section.root}
+	for k, v := range labels {
+		result[k] = v
+	}
+	d.section.metrics.AddCounter(name, result, delta)
+}
+
+// HandleStatusServiceUnavailable implements workerpool.Dependencies.
+func (d *workerPoolDependencies) HandleStatusServiceUnavailable(w http.ResponseWriter, r *http.Request, err error) {
+	d.section.statusHandlers.handle(http.StatusServiceUnavailable, w, r, err)
+}
+
+// stripPrefixHandler adapts http.StripPrefix to common.MiddlewareHandler.
+type stripPrefixHandler struct {
+	http.Handler
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *stripPrefixHandler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *stripPrefixHandler) BeforeStart(*sync.WaitGroup) {}
+
+// MiddlewarePosition is the position of middleware added to a section in
+// its middleware chain.
+type MiddlewarePosition int
+
+const (
+	// MiddlewareInnermost runs middleware once the request has passed the
+	// built-in middleware, on the worker pool if any, next to the route's
+	// handler.
+	MiddlewareInnermost MiddlewarePosition = iota
+	// MiddlewareAfterAuth runs middleware once the request is authenticated,
+	// ahead of load shedding, adaptive concurrency and the worker pool.
+	MiddlewareAfterAuth
+	// MiddlewareBeforeAuth runs middleware ahead of authentication, once the
+	// request has passed rate limiting and CORS, which answers preflights.
+	MiddlewareBeforeAuth
+)
+
+type middleware struct {
+	wrap      func(http.Handler) http.Handler
+	predicate func(*http.Request) bool
+	position  MiddlewarePosition
+}
+
+func (m middleware) newHandler(next common.MiddlewareHandler) common.MiddlewareHandler {
+	result := &middlewareHandler{
+		name:      funcName(m.wrap),
+		wrapped:   m.wrap(next),
+		next:      next,
+		predicate: m.predicate,
+	}
+	// Middleware returning next as is must not start it twice.
+	if h, ok := result.wrapped.(common.MiddlewareHandler); ok && h != next {
+		result.lifecycle = h
+	}
+	return result
+}
+
+// wrapMiddlewares wraps outermost with the middleware added at position, the
+// first added outermost.
+func (s *section) wrapMiddlewares(outermost common.MiddlewareHandler, position MiddlewarePosition) common.MiddlewareHandler {
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		if s.middlewares[i].position != position {
+			continue
+		}
+		outermost = s.middlewares[i].newHandler(outermost)
+		s.activeMiddlewareHandlers = append(s.activeMiddlewareHandlers, outermost)
+	}
+	return outermost
+}
+
+// middlewareHandler adapts middleware added to the section to
+// common.MiddlewareHandler, bypassing it for requests not matching its
+// predicate.
+type middlewareHandler struct {
+	// name identifies the middleware in route tables, and is empty for the
+	// handlers applying a group's middleware to its requests.
+	name      string
+	wrapped   http.Handler
+	next      http.Handler
+	predicate func(*http.Request) bool
+	// lifecycle is the wrapped handler, if added middleware returned a
+	// common.MiddlewareHandler, which is started and shut down with the
+	// section.
+	lifecycle common.MiddlewareHandler
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *middlewareHandler) AfterShutdown() {
+	if h.lifecycle != nil {
+		h.lifecycle.AfterShutdown()
+	}
+}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *middlewareHandler) BeforeStart(wg *sync.WaitGroup) {
+	if h.lifecycle != nil {
+		h.lifecycle.BeforeStart(wg)
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *middlewareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.predicate != nil && !h.predicate(r) {
+		h.next.ServeHTTP(w, r)
+		return
 	}
+	h.wrapped.ServeHTTP(w, r)
+}
+
+type statusDependencies struct {
+	statusHandlers statusHandlers
+}
+
+// HandleStatusBadRequest implements headers.Dependencies,
+// methodoverride.Dependencies, pathclean.Dependencies, query.Dependencies and
+// tenant.Dependencies.
+func (d *statusDependencies) HandleStatusBadRequest(w http.ResponseWriter, req *http.Request, err error) {
+	d.statusHandlers.handle(http.StatusBadRequest, w, req, err)
+}
+
+type authDependencies struct {
+	eventBus       events.Bus
+	now            func() time.Time
+	sectionRoot    string
+	statusHandlers statusHandlers
+}
+
+// HandleStatusUnauthorized implements auth.Dependencies.
+func (a *authDependencies) HandleStatusUnauthorized(w http.ResponseWriter, req *http.Request, err error) {
+	a.eventBus.Publish(events.Event{
+		Type:        events.AuthFailed,
+		Time:        a.now(),
+		Request:     req,
+		SectionRoot: a.sectionRoot,
+		Err:         err,
+	})
+	a.statusHandlers.handle(http.StatusUnauthorized, w, req, err)
 }
 
 type rateLimitingDependencies struct {
-	statusBadRequestHandlerFunc      HandlerFuncWithError
-	statusTooManyRequestsHandlerFunc http.HandlerFunc
-	now                              func() time.Time
+	eventBus       events.Bus
+	metrics        metrics.Recorder
+	scope          string
+	sectionRoot    string
+	statusHandlers statusHandlers
+	now            func() time.Time
+
+	statusTooManyRequestsHandler HandlerFuncWithRateInfo
+}
+
+// AddCounter implements ratelimiting.Dependencies.
+func (r *rateLimitingDependencies) AddCounter(name string, delta float64) {
+	r.metrics.AddCounter(name, r.labels(), delta)
+}
+
+// SetGauge implements ratelimiting.Dependencies.
+func (r *rateLimitingDependencies) SetGauge(name string, value float64) {
+	r.metrics.SetGauge(name, r.labels(), value)
+}
+
+// labels distinguishes the metrics of the application-wide limiter from those
+// of section limiters by scope.
+func (r *rateLimitingDependencies) labels() metrics.Labels {
+	return metrics.Labels{"scope": r.scope, "section": r.sectionRoot}
 }
 
 // HandleStatusBadRequest implements ratelimiting.Dependencies.
 func (r *rateLimitingDependencies) HandleStatusBadRequest(w http.ResponseWriter, req *http.Request, err error) {
-	if r.statusBadRequestHandlerFunc != nil {
-		r.statusBadRequestHandlerFunc(w, req, err)
-	} else {
-		w.WriteHeader(http.StatusBadRequest)
-		if _, err := w.Write([]byte("Bad Request")); err != nil {
-			logger.Debug("", "Error writing response: %s", err)
-		}
-	}
+	r.statusHandlers.handle(http.StatusBadRequest, w, req, err)
 }
 
 // HandleStatusTooManyRequests implements ratelimiting.Dependencies.
-func (r *rateLimitingDependencies) HandleStatusTooManyRequests(w http.ResponseWriter, req *http.Request) {
-	if r.statusTooManyRequestsHandlerFunc != nil {
-		r.statusTooManyRequestsHandlerFunc(w, req)
-	} else {
-		w.WriteHeader(http.StatusTooManyRequests)
-		if _, err := w.Write([]byte("Too Many Requests")); err != nil {
-			logger.Debug("", "Error writing response: %s", err)
+func (r *rateLimitingDependencies) HandleStatusTooManyRequests(w http.ResponseWriter, req *http.Request, info ratelimiting.RateInfo) {
+	now := r.now()
+	err := &ratelimiting.BanError{RateInfo: info}
+	r.eventBus.Publish(events.Event{
+		Type:        events.RateLimited,
+		Time:        now,
+		Request:     req,
+		SectionRoot: r.sectionRoot,
+		Err:         err,
+	})
+	if retryAfter, ok := info.RetryAfter(now); ok {
+		// Retry-After is in whole seconds; round up so clients retrying
+		// promptly are not rejected again.
+		w.Header().Set("retry-after", strconv.FormatInt(int64((retryAfter+time.Second-1)/time.Second), 10))
+	}
+	if r.statusTooManyRequestsHandler != nil {
+		if ri, found := responseinfo.FromContext(req.Context()); found {
+			ri.Err = err
 		}
+		r.statusTooManyRequestsHandler(w, req, info)
+		return
 	}
+	r.statusHandlers.handle(http.StatusTooManyRequests, w, req, err)
 }
 
 // Now implements ratelimiting.Dependencies.
 func (r *rateLimitingDependencies) Now() time.Time {
 	return r.now()
 }
+
+// ignoreError adapts an http.HandlerFunc to HandlerFuncWithError.
+func ignoreError(h http.HandlerFunc) HandlerFuncWithError {
+	if h == nil {
+		return nil
+	}
+	return func(w http.ResponseWriter, r *http.Request, _ error) {
+		h(w, r)
+	}
+}
@@ -1,22 +1,63 @@
 package application
 
 import (
+	"fmt"
+	"maps"
 	"net/http"
-	"slices"
+	"path"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/jakewan/sudsy/internal/binding"
+	"github.com/jakewan/sudsy/internal/clientgone"
 	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/events"
+	"github.com/jakewan/sudsy/internal/flags"
+	"github.com/jakewan/sudsy/internal/metrics"
+	"github.com/jakewan/sudsy/internal/pathparams"
+	"github.com/jakewan/sudsy/internal/propagation"
+	"github.com/jakewan/sudsy/internal/recovery"
+	"github.com/jakewan/sudsy/internal/responseinfo"
+	"github.com/jakewan/sudsy/internal/rules"
+	"github.com/jakewan/sudsy/internal/tenant"
+	"github.com/jakewan/sudsy/internal/unmatched"
 	"github.com/jakewan/sudsy/internal/urlpathpatternhandler"
 )
 
+// simpleHandlerRoute identifies the section's simple handler when tracking
+// per-route state.
+const simpleHandlerRoute = "*"
+
+// spaFallbackRoute identifies the section's single-page application fallback
+// when tracking per-route state.
+const spaFallbackRoute = "spa-fallback"
+
 type sectionHandlerDependencies struct {
-	StatusNotFoundHandlerFunc http.HandlerFunc
+	AllowedContentTypes  []string
+	ConnectionClose      bool
+	DevMode              bool
+	ErrorReporter        recovery.Reporter
+	EventBus             events.Bus
+	FeatureFlag          flags.Gate
+	FlagProvider         flags.Provider
+	Metrics              metrics.Recorder
+	Now                  func() time.Time
+	PanicTracker         recovery.Tracker
+	SectionRoot          string
+	SPAFallback          http.Handler
+	StatusHandlers       statusHandlers
+	TrailingSlash        urlpathpatternhandler.TrailingSlash
+	UnmatchedPathTracker unmatched.Tracker
+	Validator            binding.Validator
 }
 
 type sectionHandler struct {
-	deps                   sectionHandlerDependencies
-	simpleHandler          http.Handler
-	urlPathPatternHandlers []urlpathpatternhandler.Handler
+	deps          sectionHandlerDependencies
+	simpleHandler http.Handler
+	routes        *urlpathpatternhandler.Routes
 }
 
 // AfterShutdown implements MiddlewareHandler.
@@ -27,36 +68,294 @@ func (s *sectionHandler) BeforeStart(*sync.WaitGroup) {}
 
 // ServeHTTP implements http.Handler.
 func (s *sectionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	logger.Debug("", "Inside sectionHandler.ServeHTTP: %s", r.URL.Path)
+	hotPathLogger.Debug("", "Inside sectionHandler.ServeHTTP: %s", r.URL.Path)
+	ctx := newSectionHandlerDependenciesContext(r.Context(), &s.deps)
+	if s.deps.Validator != nil {
+		ctx = binding.NewContext(ctx, s.deps.Validator)
+	}
+	r = r.WithContext(ctx)
+	if status, disabled := s.deps.FeatureFlag.Disabled(s.deps.FlagProvider, r); disabled {
+		logger.Debug("", "Section %s is disabled by flag %s", s.deps.SectionRoot, s.deps.FeatureFlag.Flag)
+		s.deps.StatusHandlers.handle(status, w, r, s.deps.FeatureFlag.Err())
+		return
+	}
+	if s.deps.ConnectionClose {
+		w.Header().Set("connection", "close")
+	}
 	if s.simpleHandler != nil {
-		s.simpleHandler.ServeHTTP(w, r)
-	} else if idx, found := slices.BinarySearchFunc(
-		s.urlPathPatternHandlers,
-		r.URL.Path,
-		urlpathpatternhandler.ComparePatternHandlerToPath,
-	); found {
-		logger.Debug("", "Found handler at index %d", idx)
-		s.urlPathPatternHandlers[idx].ServeHTTP(w, r)
+		s.serveRoute(w, r, simpleHandlerRoute, s.simpleHandler, urlpathpatternhandler.Config{}, nil)
+	} else if matches := s.routes.Lookup(urlpathpatternhandler.RoutingPath(r.URL)); len(matches) > 0 {
+		s.serveMatches(w, r, matches)
+	} else if alt, matches := s.trailingSlashAlternative(r); len(matches) > 0 {
+		if s.deps.TrailingSlash == urlpathpatternhandler.TrailingSlashRedirect {
+			hotPathLogger.Debug("", "Redirecting %s to %s", r.URL.Path, alt)
+			redirectTrailingSlash(w, r)
+			return
+		}
+		hotPathLogger.Debug("", "Serving %s as %s", r.URL.Path, alt)
+		// The trailing slash is a separator in the decoded and escaped
+		// paths alike.
+		u := *r.URL
+		u.Path, _ = urlpathpatternhandler.ToggleTrailingSlash(u.Path)
+		if u.RawPath != "" {
+			u.RawPath, _ = urlpathpatternhandler.ToggleTrailingSlash(u.RawPath)
+		}
+		r2 := *r
+		r2.URL = &u
+		s.serveMatches(w, &r2, matches)
+	} else if s.deps.SPAFallback != nil && acceptsSPAFallback(r) {
+		hotPathLogger.Debug("", "Serving SPA fallback for %s", r.URL.Path)
+		s.serveRoute(w, r, spaFallbackRoute, s.deps.SPAFallback, urlpathpatternhandler.Config{}, nil)
+	} else {
+		hotPathLogger.Debug("", "Handler not found")
+		s.deps.UnmatchedPathTracker.Record(r.URL.Path)
+		s.deps.Metrics.AddCounter(
+			"sudsy_unmatched_requests_total",
+			requestLabels(r, metrics.Labels{"section": s.deps.SectionRoot}),
+			1,
+		)
+		s.deps.StatusHandlers.handle(http.StatusNotFound, w, r, nil)
+	}
+}
+
+// serveMatches serves the request with the handler among the ones matching
+// its path serving its method, whose matchers it satisfies.
+func (s *sectionHandler) serveMatches(w http.ResponseWriter, r *http.Request, matches []urlpathpatternhandler.Handler) {
+	h, methodAllowed := urlpathpatternhandler.SelectRequest(matches, r)
+	if !methodAllowed && r.Method == http.MethodOptions && urlpathpatternhandler.AnswersOptions(matches) {
+		hotPathLogger.Debug("", "Answering OPTIONS for %s", r.URL.Path)
+		answerOptions(w, matches)
+		return
+	}
+	if !methodAllowed {
+		hotPathLogger.Debug("", "Method %s not allowed for %s", r.Method, r.URL.Path)
+		w.Header().Set("allow", strings.Join(urlpathpatternhandler.AllowedMethods(matches), ", "))
+		s.deps.StatusHandlers.handle(
+			http.StatusMethodNotAllowed,
+			w,
+			r,
+			fmt.Errorf("%w: %s", urlpathpatternhandler.ErrMethodNotAllowed, r.Method),
+		)
+		return
+	}
+	if h == nil {
+		hotPathLogger.Debug("", "No route for %s matches the request's attributes", r.URL.Path)
+		s.deps.StatusHandlers.handle(http.StatusNotFound, w, r, urlpathpatternhandler.ErrMatchersNotSatisfied)
+		return
+	}
+	if h.Config().Metadata.Deprecated {
+		logger.Debug("", "Deprecated route %s requested", h.Pattern())
+		s.deps.Metrics.AddCounter(
+			"sudsy_deprecated_route_requests_total",
+			requestLabels(r, metrics.Labels{"section": s.deps.SectionRoot, "route": h.Pattern()}),
+			1,
+		)
+	}
+	if urlpathpatternhandler.ServesAsGet(h, r.Method) {
+		w = &headResponseWriter{ResponseWriter: w}
+	}
+	params, err := s.routes.Params(h, r.URL)
+	if err != nil {
+		logger.Debug("", "Rejecting request to route %s: %s", h.Pattern(), err)
+		s.deps.StatusHandlers.handle(http.StatusBadRequest, w, r, err)
+		return
+	}
+	s.serveRoute(w, r, h.Pattern(), h, h.Config(), params)
+}
+
+// serveRoute invokes the handler matched for the request, isolating any panic
+// to the current request and disabling the route if it panics too often.
+// Requests violating the route's content type restrictions or validation
+// rules are rejected before the handler runs.
+func (s *sectionHandler) serveRoute(
+	w http.ResponseWriter,
+	r *http.Request,
+	route string,
+	h http.Handler,
+	config urlpathpatternhandler.Config,
+	params map[string]string,
+) {
+	if info, found := responseinfo.FromContext(r.Context()); found {
+		info.Route = route
+	}
+	s.deps.EventBus.Publish(events.Event{
+		Type:        events.RouteMatched,
+		Time:        s.deps.Now(),
+		Request:     r,
+		SectionRoot: s.deps.SectionRoot,
+		Route:       route,
+	})
+	// The handlers of a pattern serving different methods are disabled
+	// apart.
+	tracked := recovery.NewRoute(route, config.Methods)
+	if s.deps.PanicTracker.Disabled(tracked) {
+		logger.Debug("", "Route %s is disabled", tracked)
+		s.deps.StatusHandlers.handle(
+			http.StatusServiceUnavailable,
+			w,
+			r,
+			fmt.Errorf("route %s is disabled", tracked),
+		)
+		return
+	}
+	if status, disabled := config.FeatureFlag.Disabled(s.deps.FlagProvider, r); disabled {
+		logger.Debug("", "Route %s is disabled by flag %s", route, config.FeatureFlag.Flag)
+		s.deps.StatusHandlers.handle(status, w, r, config.FeatureFlag.Err())
+		return
+	}
+	contentTypes := config.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = s.deps.AllowedContentTypes
+	}
+	if err := rules.CheckContentType(r, contentTypes); err != nil {
+		logger.Debug("", "Rejecting request to route %s: %s", route, err)
+		s.deps.StatusHandlers.handle(http.StatusUnsupportedMediaType, w, r, err)
+		return
+	}
+	if err := config.Rules.Check(r, params); err != nil {
+		logger.Debug("", "Request violates rules of route %s: %s", route, err)
+		s.deps.StatusHandlers.handle(http.StatusBadRequest, w, r, err)
+		return
+	}
+	if len(params) > 0 {
+		r = r.WithContext(pathparams.NewContext(r.Context(), params))
+	}
+	if config.TraceSampler != nil {
+		v, _ := propagation.FromContext(r.Context())
+		r = r.WithContext(propagation.NewContext(r.Context(), propagation.Sample(r, v, config.TraceSampler)))
+	}
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		if v == http.ErrAbortHandler {
+			panic(v)
+		}
+		stack := debug.Stack()
+		panicErr := &recovery.PanicError{Route: route, Value: v, Stack: stack}
+		if clientgone.Detect(r, panicErr) {
+			// Panics caused by the client disconnecting, e.g. on a failed
+			// write, are not failures of the route.
+			logger.Debug("", "Recovered from panic in route %s after the client disconnected: %v", route, v)
+			return
+		}
+		logger.Debug("", "Recovered from panic in route %s: %v\n%s", route, v, stack)
+		s.deps.ErrorReporter.Report(r.Context(), panicErr, stack)
+		s.deps.EventBus.Publish(events.Event{
+			Type:        events.PanicRecovered,
+			Time:        s.deps.Now(),
+			Request:     r,
+			SectionRoot: s.deps.SectionRoot,
+			Route:       route,
+			Err:         panicErr,
+		})
+		disabled := s.deps.PanicTracker.Record(tracked, s.deps.Now())
+		labels := metrics.Labels{"section": s.deps.SectionRoot, "route": route}
+		s.deps.Metrics.AddCounter("sudsy_handler_panics_total", requestLabels(r, labels), 1)
+		if disabled {
+			s.deps.Metrics.SetGauge("sudsy_route_disabled", routeDisabledLabels(s.deps.SectionRoot, tracked), 1)
+		}
+		s.deps.StatusHandlers.handle(
+			http.StatusInternalServerError,
+			w,
+			r,
+			panicErr,
+		)
+	}()
+	if ph, ok := h.(urlpathpatternhandler.Handler); ok {
+		// The handler is given its own copy of the values, which may be
+		// shared with the requests for the same path.
+		ph.ServeHTTPWithParams(w, r, maps.Clone(params))
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+// trailingSlashAlternative returns the path of r with its trailing slash
+// removed or added, and the handlers matching it, if the section's trailing
+// slash policy allows it.
+func (s *sectionHandler) trailingSlashAlternative(r *http.Request) (string, []urlpathpatternhandler.Handler) {
+	if s.deps.TrailingSlash == urlpathpatternhandler.TrailingSlashStrict {
+		return "", nil
+	}
+	alt, found := urlpathpatternhandler.ToggleTrailingSlash(urlpathpatternhandler.RoutingPath(r.URL))
+	if !found {
+		return "", nil
+	}
+	return alt, s.routes.Lookup(alt)
+}
+
+// redirectTrailingSlash redirects r to its path with the trailing slash
+// removed or added, keeping the query. The location is relative, so that it
+// is correct when the section's prefix has been stripped.
+func redirectTrailingSlash(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.EscapedPath()
+	var target string
+	if strings.HasSuffix(p, "/") {
+		// The last segment is followed by the slash, e.g. "/users/42/"
+		// redirects to "../42".
+		target = "../" + path.Base(p)
 	} else {
-		logger.Debug("", "Handler not found")
-		if s.deps.StatusNotFoundHandlerFunc != nil {
-			s.deps.StatusNotFoundHandlerFunc(w, r)
-		} else {
-			w.WriteHeader(http.StatusNotFound)
-			if _, err := w.Write([]byte("Not found")); err != nil {
-				logger.Debug("", "Error writing response: %s", err)
+		// "./" keeps segments containing a colon from being read as a
+		// scheme.
+		target = "./" + path.Base(p) + "/"
+	}
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	code := http.StatusPermanentRedirect
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		code = http.StatusMovedPermanently
+	}
+	w.Header().Set("location", target)
+	w.WriteHeader(code)
+}
+
+// acceptsSPAFallback reports whether r is a page navigation, a GET or HEAD
+// request accepting HTML, as opposed to a request for an API or an asset.
+func acceptsSPAFallback(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	for _, v := range r.Header.Values("accept") {
+		for _, mediaRange := range strings.Split(v, ",") {
+			mediaType, params, _ := strings.Cut(mediaRange, ";")
+			if !strings.EqualFold(strings.TrimSpace(mediaType), "text/html") {
+				continue
+			}
+			// Clients can exclude HTML with a zero quality value.
+			for _, p := range strings.Split(params, ";") {
+				if name, value, _ := strings.Cut(p, "="); strings.EqualFold(strings.TrimSpace(name), "q") {
+					if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q == 0 {
+						return false
+					}
+				}
 			}
+			return true
 		}
 	}
+	return false
+}
+
+// requestLabels returns labels with the request's tenant added, if resolved.
+func requestLabels(r *http.Request, labels metrics.Labels) metrics.Labels {
+	id, ok := tenant.FromContext(r.Context())
+	if !ok {
+		return labels
+	}
+	result := maps.Clone(labels)
+	result["tenant"] = id
+	return result
 }
 
 func newSectionHandler(
 	deps sectionHandlerDependencies,
 	simpleHandler http.Handler,
-	urlPathHandlers []urlpathpatternhandler.Handler) common.MiddlewareHandler {
+	routes *urlpathpatternhandler.Routes) common.MiddlewareHandler {
 	return &sectionHandler{
-		deps:                   deps,
-		simpleHandler:          simpleHandler,
-		urlPathPatternHandlers: urlPathHandlers,
+		deps:          deps,
+		simpleHandler: simpleHandler,
+		routes:        routes,
 	}
 }
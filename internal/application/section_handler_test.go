@@ -0,0 +1,83 @@
+package application
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jakewan/sudsy/internal/urlpathpatternhandler"
+)
+
+// TestEscapedSlashAuthExemption checks that a slash escaped within a segment
+// does not exempt a request for a protected route from authentication.
+func TestEscapedSlashAuthExemption(t *testing.T) {
+	s := NewSection(fuzzSectionDependencies{}, "/")
+	s.SetBasicAuthUsername("user")
+	s.SetBasicAuthPassword("password")
+	s.SetBasicAuthRealm("test")
+	s.AddAuthExemptPattern("/static/:file")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+	for _, pattern := range []string{"/static/:file", "/:user"} {
+		if err := s.AddPathPatternHandler(pattern, ok, struct{}{}, urlpathpatternhandler.Config{}); err != nil {
+			t.Fatalf("registering %q: %v", pattern, err)
+		}
+	}
+	h := s.NewHandler()
+	for target, want := range map[string]int{
+		"/static/x":   http.StatusOK,
+		"/alice":      http.StatusUnauthorized,
+		"/static%2Fx": http.StatusUnauthorized,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != want {
+			t.Errorf("GET %s: got status %d, want %d", target, w.Code, want)
+		}
+	}
+}
+
+// TestPanicDisablesMethodsOfHandler checks that a handler disabled after
+// panicking does not disable the handlers of its pattern serving other
+// methods, and that it is re-enabled by its methods.
+func TestPanicDisablesMethodsOfHandler(t *testing.T) {
+	s := NewSection(fuzzSectionDependencies{}, "/")
+	s.SetMaxPanicsPerMinute(1)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		panic("failure")
+	})
+	if err := s.AddPathPatternHandler("/items", ok, struct{}{}, urlpathpatternhandler.Config{
+		Methods: []string{http.MethodGet},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddPathPatternHandler("/items", panicking, struct{}{}, urlpathpatternhandler.Config{
+		Methods: []string{http.MethodPost},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	h := s.NewHandler()
+	serve := func(method string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/items", nil))
+		return w.Code
+	}
+	for range 2 {
+		serve(http.MethodPost)
+	}
+	if code := serve(http.MethodPost); code != http.StatusServiceUnavailable {
+		t.Fatalf("POST: got status %d after repeated panics, want %d", code, http.StatusServiceUnavailable)
+	}
+	if code := serve(http.MethodGet); code != http.StatusOK {
+		t.Errorf("GET: got status %d, want %d", code, http.StatusOK)
+	}
+	if s.EnableRoute("/items", []string{http.MethodGet}) {
+		t.Error("enabled the GET handler, which was not disabled")
+	}
+	if !s.EnableRoute("/items", []string{http.MethodPost}) {
+		t.Error("POST handler not enabled")
+	}
+	if code := serve(http.MethodPost); code != http.StatusInternalServerError {
+		t.Errorf("POST: got status %d once enabled, want %d", code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,386 @@
+package application
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/accesslog"
+	"github.com/jakewan/sudsy/internal/clientcert"
+	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/events"
+	"github.com/jakewan/sudsy/internal/forwarded"
+	"github.com/jakewan/sudsy/internal/lifecycle"
+	"github.com/jakewan/sudsy/internal/metrics"
+	"github.com/jakewan/sudsy/internal/propagation"
+	"github.com/jakewan/sudsy/internal/ratelimiting"
+	"github.com/jakewan/sudsy/internal/realip"
+	"github.com/jakewan/sudsy/internal/recovery"
+	"github.com/jakewan/sudsy/internal/responseinfo"
+	"github.com/jakewan/sudsy/internal/smuggling"
+	"github.com/jakewan/sudsy/internal/tlscert"
+)
+
+// ServerTimeouts configures the timeouts of an HTTP server. Zero values mean
+// no timeout.
+type ServerTimeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+// server is a single HTTP listener and, when serving TLS, the manager of its
+// certificate.
+type server struct {
+	httpServer  *http.Server
+	certManager tlscert.Manager
+	// listenRetryPeriod is how long binding a busy address is retried.
+	listenRetryPeriod time.Duration
+	// inspectConnections wraps the listener of cleartext servers to detect
+	// ambiguously framed requests.
+	inspectConnections bool
+	// middlewareHandlers are the handlers returned by global middleware
+	// implementing common.MiddlewareHandler.
+	middlewareHandlers []common.MiddlewareHandler
+}
+
+func (s *server) afterShutdown() {
+	if s.certManager != nil {
+		s.certManager.AfterShutdown()
+	}
+	for _, h := range s.middlewareHandlers {
+		h.AfterShutdown()
+	}
+}
+
+func (s *server) beforeStart(wg *sync.WaitGroup) {
+	for i := len(s.middlewareHandlers) - 1; i >= 0; i-- {
+		s.middlewareHandlers[i].BeforeStart(wg)
+	}
+	if s.certManager != nil {
+		s.certManager.BeforeStart(wg)
+	}
+}
+
+func (s *server) listenAndServe() error {
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+	// The certificate manager supplies the certificate through the TLS
+	// config, which is served as is rather than cloned by ServeTLS, so that
+	// the session ticket keys it rotates reach new connections.
+	if s.certManager != nil {
+		return s.httpServer.Serve(tls.NewListener(ln, s.httpServer.TLSConfig))
+	}
+	if s.inspectConnections {
+		ln = smuggling.NewListener(ln)
+	}
+	return s.httpServer.Serve(ln)
+}
+
+// listenRetryMaxBackoff caps the delay between attempts to bind a busy
+// address.
+const listenRetryMaxBackoff = 2 * time.Second
+
+// listen binds the server's address, retrying with exponential backoff while
+// it is in use, e.g. by the previous instance during a rolling restart, until
+// the listen retry period has elapsed.
+func (s *server) listen() (net.Listener, error) {
+	deadline := time.Now().Add(s.listenRetryPeriod)
+	backoff := 100 * time.Millisecond
+	for {
+		ln, err := net.Listen("tcp", s.httpServer.Addr)
+		if err == nil {
+			return ln, nil
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) || time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+		logger.Debug("listen", "Address %s in use, retrying in %s", s.httpServer.Addr, backoff)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, listenRetryMaxBackoff)
+	}
+}
+
+// newServers returns the server for the application listen port, hosting
+// every section without a listen port of its own, followed by one server per
+// section bound to its own port. A dry run leaves the access log unopened.
+func (a *application) newServers(ctx context.Context, dryRun bool) ([]*server, error) {
+	if err := a.Err(); err != nil {
+		return nil, err
+	}
+	if a.tlsClientCAFile != "" {
+		pool, err := loadCertPool(a.tlsClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client CAs: %w", err)
+		}
+		a.tlsPolicy.ClientCAs = pool
+	}
+	if a.accessLogOpen != nil && a.accessLog == nil && !dryRun {
+		w, err := a.accessLogOpen()
+		if err != nil {
+			return nil, fmt.Errorf("opening access log: %w", err)
+		}
+		a.accessLog = accesslog.New(w)
+	}
+	a.lifecycleHandlers = nil
+	a.globalRateLimiter = a.newGlobalRateLimiter()
+	for _, s := range a.sections {
+		s.SetGlobalRateLimiter(a.globalRateLimiter)
+	}
+
+	result := []*server{}
+	ports := map[int]string{}
+	shared := []Section{}
+	for _, s := range a.sections {
+		if s.ListenPort() == 0 {
+			shared = append(shared, s)
+			continue
+		}
+		if other, found := ports[s.ListenPort()]; found {
+			return nil, fmt.Errorf(
+				"sections %s and %s are both bound to port %d",
+				other,
+				s.Root(),
+				s.ListenPort(),
+			)
+		}
+		ports[s.ListenPort()] = s.Root()
+		certFile, keyFile := s.TLSCertificateFiles()
+		srv, err := a.newServer(ctx, s.ListenPort(), newSectionsHandler([]Section{s}), s.ServerTimeouts(), certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("section %s: %w", s.Root(), err)
+		}
+		result = append(result, srv)
+	}
+	if len(shared) > 0 || len(result) == 0 {
+		if other, found := ports[a.serverListenPort]; found {
+			return nil, fmt.Errorf(
+				"section %s is bound to the application port %d",
+				other,
+				a.serverListenPort,
+			)
+		}
+		srv, err := a.newServer(ctx, a.serverListenPort, newSectionsHandler(shared), ServerTimeouts{}, a.tlsCertFile, a.tlsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		result = append([]*server{srv}, result...)
+	}
+	return result, nil
+}
+
+func (a *application) newServer(
+	ctx context.Context,
+	port int,
+	handler http.Handler,
+	timeouts ServerTimeouts,
+	certFile string,
+	keyFile string,
+) (*server, error) {
+	// Global middleware sees requests once the server's middleware has
+	// resolved their client address and trace context, and its responses are
+	// seen by the response hooks.
+	var middlewareHandlers []common.MiddlewareHandler
+	for i := len(a.globalMiddlewares) - 1; i >= 0; i-- {
+		next := handler
+		handler = a.globalMiddlewares[i](next)
+		if h, ok := handler.(common.MiddlewareHandler); ok && !sameHandler(handler, next) {
+			middlewareHandlers = append(middlewareHandlers, h)
+		}
+	}
+	hooks := a.onResponseHooks
+	if a.accessLog != nil {
+		hooks = append(slices.Clip(hooks), a.accessLog.Hook)
+	}
+	if len(hooks) > 0 {
+		handler = responseinfo.NewMiddlewareHandler(&clockDependencies{}, handler, hooks...)
+	}
+	handler = propagation.NewMiddlewareHandler(handler, propagation.Config{
+		Headers: a.propagatedHeaders,
+		Sampler: a.traceSampler,
+	})
+	if a.realIPResolver != nil {
+		handler = realip.NewMiddlewareHandler(a.realIPResolver, handler)
+	}
+	handler = forwarded.NewMiddlewareHandler(handler)
+	if a.tlsClientCAFile != "" {
+		handler = clientcert.NewMiddlewareHandler(handler)
+	}
+	connContext := lifecycle.NewConnContext
+	if a.smugglingConfig != nil {
+		handler = smuggling.NewMiddlewareHandler(
+			&smugglingDependencies{eventBus: a.eventBus, metrics: a.metrics},
+			handler,
+			*a.smugglingConfig,
+		)
+		connContext = func(ctx context.Context, c net.Conn) context.Context {
+			return smuggling.NewConnContext(lifecycle.NewConnContext(ctx, c), c)
+		}
+	}
+	lifecycleHandler := lifecycle.NewMiddlewareHandler(
+		&lifecycleDependencies{
+			closeConnections: func() bool {
+				return a.drainConnectionClose && a.Draining()
+			},
+			maxRequestsPerConnection: a.maxRequestsPerConnection,
+		},
+		handler,
+	)
+	a.lifecycleHandlers = append(a.lifecycleHandlers, lifecycleHandler)
+
+	result := &server{
+		listenRetryPeriod: a.listenRetryPeriod,
+		httpServer: &http.Server{
+			Addr:              net.JoinHostPort(a.serverListenHost, strconv.Itoa(port)),
+			Handler:           lifecycleHandler,
+			ReadHeaderTimeout: timeouts.ReadHeader,
+			ReadTimeout:       timeouts.Read,
+			WriteTimeout:      timeouts.Write,
+			IdleTimeout:       timeouts.Idle,
+			BaseContext:       func(_ net.Listener) context.Context { return ctx },
+			ConnContext:       connContext,
+		},
+		inspectConnections: a.smugglingConfig != nil,
+		middlewareHandlers: middlewareHandlers,
+	}
+	if a.keepAlivesDisabled {
+		result.httpServer.SetKeepAlivesEnabled(false)
+	}
+	if certFile != "" {
+		result.certManager = a.newCertManager(certFile, keyFile)
+		result.httpServer.TLSConfig = a.tlsPolicy.NewConfig()
+		if err := result.certManager.Configure(result.httpServer.TLSConfig); err != nil {
+			return nil, fmt.Errorf("configuring TLS: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// sameHandler reports whether l and r are the same handler, as when
+// middleware returns the handler it wraps as is.
+func sameHandler(l, r http.Handler) bool {
+	t := reflect.TypeOf(l)
+	return t == reflect.TypeOf(r) && t.Comparable() && l == r
+}
+
+// newGlobalRateLimiter returns the application-wide rate limiter, or nil if no
+// session configs have been added. Requests it rejects are answered by the
+// serving section, whose handler wraps it.
+func (a *application) newGlobalRateLimiter() ratelimiting.MiddlewareHandler {
+	if len(a.globalRateLimitingConfigs) == 0 {
+		return nil
+	}
+	h := ratelimiting.NewMiddlewareHandler(
+		&rateLimitingDependencies{
+			eventBus:       a.eventBus,
+			metrics:        a.metrics,
+			scope:          rateLimitingScopeApplication,
+			statusHandlers: statusHandlers{},
+			now:            time.Now,
+		},
+		http.NotFoundHandler(),
+	)
+	for _, c := range a.globalRateLimitingConfigs {
+		h.AddSessionConfig(c.maxRequests, c.sessionDuration, c.banDuration)
+	}
+	if a.globalRateLimitingLazyExpiration {
+		h.SetHostCacheGroomingInterval(0)
+	}
+	// Clients are identified as the servers resolve them, whichever section
+	// serves their requests.
+	if a.realIPResolver != nil {
+		h.SetHostResolver(a.realIPResolver)
+	}
+	return h
+}
+
+func (a *application) newCertManager(certFile, keyFile string) tlscert.Manager {
+	m := tlscert.NewManager(&certManagerDependencies{errorReporter: a.errorReporter}, certFile, keyFile)
+	m.SetOCSPStapling(a.ocspStapling)
+	if a.sessionTicketKeyProvider != nil {
+		m.SetSessionTicketKeyProvider(a.sessionTicketKeyProvider)
+	}
+	if a.sessionTicketKeyRotationInterval > 0 {
+		m.SetSessionTicketKeyRotationInterval(a.sessionTicketKeyRotationInterval)
+	}
+	return m
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pemCerts, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		return nil, errors.New("no certificates found in " + file)
+	}
+	return pool, nil
+}
+
+type clockDependencies struct{}
+
+// Now implements responseinfo.Dependencies and, through
+// certManagerDependencies, tlscert.Dependencies.
+func (t *clockDependencies) Now() time.Time {
+	return time.Now()
+}
+
+type certManagerDependencies struct {
+	clockDependencies
+	errorReporter recovery.Reporter
+}
+
+// ReportError implements tlscert.Dependencies.
+func (d *certManagerDependencies) ReportError(err error) {
+	d.errorReporter.Report(context.Background(), err, nil)
+}
+
+type lifecycleDependencies struct {
+	closeConnections         func() bool
+	maxRequestsPerConnection int64
+}
+
+// CloseConnections implements lifecycle.Dependencies.
+func (l *lifecycleDependencies) CloseConnections() bool {
+	return l.closeConnections()
+}
+
+// MaxRequestsPerConnection implements lifecycle.Dependencies.
+func (l *lifecycleDependencies) MaxRequestsPerConnection() int64 {
+	return l.maxRequestsPerConnection
+}
+
+type smugglingDependencies struct {
+	eventBus events.Bus
+	metrics  metrics.Recorder
+}
+
+// AddCounter implements smuggling.Dependencies.
+func (d *smugglingDependencies) AddCounter(name string, labels map[string]string, delta float64) {
+	d.metrics.AddCounter(name, labels, delta)
+}
+
+// ReportSuspiciousRequest implements smuggling.Dependencies.
+func (d *smugglingDependencies) ReportSuspiciousRequest(req *http.Request, err *smuggling.Error) {
+	d.eventBus.Publish(events.Event{
+		Type:    events.SuspiciousRequest,
+		Time:    time.Now(),
+		Request: req,
+		Err:     err,
+	})
+}
@@ -0,0 +1,73 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/jakewan/sudsy/internal/binding"
+	"github.com/jakewan/sudsy/internal/clientgone"
+	"github.com/jakewan/sudsy/internal/responseinfo"
+)
+
+// statusHandlers maps HTTP status codes to the handlers producing responses
+// with them.
+type statusHandlers map[int]HandlerFuncWithError
+
+// handle responds to the request with the status code, using the registered
+// handler when there is one. Nothing is written to clients that have
+// disconnected, so that their handlers do not report the error.
+func (h statusHandlers) handle(code int, w http.ResponseWriter, r *http.Request, err error) {
+	if info, found := responseinfo.FromContext(r.Context()); found && err != nil {
+		info.Err = err
+	}
+	if clientgone.Detect(r, err) {
+		logger.Debug("", "Not responding with status %d to disconnected client: %v", code, err)
+		return
+	}
+	if f, found := h[code]; found && f != nil {
+		f(w, r, err)
+		return
+	}
+	var validationErr *binding.ValidationError
+	if code == http.StatusBadRequest && errors.As(err, &validationErr) {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(validationErr); err != nil {
+			logger.Debug("", "Error writing response: %s", err)
+		}
+		return
+	}
+	w.WriteHeader(code)
+	if _, err := w.Write([]byte(http.StatusText(code))); err != nil {
+		logger.Debug("", "Error writing response: %s", err)
+	}
+}
+
+type sectionHandlerDependenciesContextKey struct{}
+
+func newSectionHandlerDependenciesContext(ctx context.Context, deps *sectionHandlerDependencies) context.Context {
+	return context.WithValue(ctx, sectionHandlerDependenciesContextKey{}, deps)
+}
+
+func sectionHandlerDependenciesFromContext(ctx context.Context) *sectionHandlerDependencies {
+	if deps, ok := ctx.Value(sectionHandlerDependenciesContextKey{}).(*sectionHandlerDependencies); ok {
+		return deps
+	}
+	return &sectionHandlerDependencies{}
+}
+
+// HandleStatus responds to the request with the status code using the
+// handler registered for it by the section serving the request, falling back
+// to a default response.
+func HandleStatus(w http.ResponseWriter, r *http.Request, code int, err error) {
+	deps := sectionHandlerDependenciesFromContext(r.Context())
+	deps.StatusHandlers.handle(code, w, r, err)
+}
+
+// HandleStatusBadRequest responds to the request using the bad request
+// handler of the section serving it, falling back to a default response.
+func HandleStatusBadRequest(w http.ResponseWriter, r *http.Request, err error) {
+	HandleStatus(w, r, http.StatusBadRequest, err)
+}
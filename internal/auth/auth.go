@@ -0,0 +1,180 @@
+// Package auth provides an HTTP middleware handler authenticating requests
+// against one or more schemes evaluated in order.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/urlpathpatternhandler"
+)
+
+var logger = common.NewLogger("auth")
+
+// Principal identifies an authenticated client.
+type Principal struct {
+	// Scheme is the authentication scheme that accepted the credentials.
+	Scheme string
+	// Name identifies the client within the scheme, e.g. a username or the
+	// subject of a token.
+	Name string
+	// Attributes holds any further details, e.g. token claims.
+	Attributes map[string]any
+}
+
+// Authenticator implements a single authentication scheme.
+type Authenticator interface {
+	// Authenticate checks the credentials presented with the request. It
+	// returns a nil Principal and nil error when no credentials for the
+	// scheme are present.
+	Authenticate(r *http.Request) (*Principal, error)
+	// Challenge returns the WWW-Authenticate challenge for the scheme.
+	Challenge() string
+}
+
+type contextKey struct{}
+
+// PrincipalFromContext returns the authenticated principal stored in ctx.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok
+}
+
+type Dependencies interface {
+	HandleStatusUnauthorized(http.ResponseWriter, *http.Request, error)
+}
+
+type MiddlewareHandler interface {
+	common.MiddlewareHandler
+	// AddExemptPattern excludes requests with paths matching pattern from
+	// authentication.
+	AddExemptPattern(pattern string)
+	// SetOptional lets requests without valid credentials through without a
+	// principal instead of rejecting them.
+	SetOptional(bool)
+}
+
+// NewMiddlewareHandler returns a handler accepting requests authenticated by
+// any of the authenticators, which are evaluated in order. Rejected requests
+// receive a single 401 response challenging for every scheme.
+func NewMiddlewareHandler(
+	deps Dependencies,
+	next http.Handler,
+	authenticators ...Authenticator,
+) MiddlewareHandler {
+	return &handler{
+		deps:           deps,
+		next:           next,
+		authenticators: authenticators,
+	}
+}
+
+type handler struct {
+	deps           Dependencies
+	next           http.Handler
+	authenticators []Authenticator
+	exemptPatterns []string
+	optional       bool
+}
+
+// AddExemptPattern implements MiddlewareHandler.
+func (h *handler) AddExemptPattern(pattern string) {
+	h.exemptPatterns = append(h.exemptPatterns, pattern)
+}
+
+// SetOptional implements MiddlewareHandler.
+func (h *handler) SetOptional(v bool) {
+	h.optional = v
+}
+
+// AfterShutdown implements MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// CORS preflight requests exclude credentials.
+	if req.Method == "OPTIONS" {
+		h.next.ServeHTTP(w, req)
+		return
+	}
+	for _, p := range h.exemptPatterns {
+		// Patterns match the path the section routes, so that escaped
+		// slashes cannot exempt requests for protected routes.
+		if urlpathpatternhandler.MatchPattern(p, urlpathpatternhandler.RoutingPath(req.URL)) {
+			h.next.ServeHTTP(w, req)
+			return
+		}
+	}
+	var authErr error
+	for _, a := range h.authenticators {
+		principal, err := a.Authenticate(req)
+		if err != nil {
+			logger.Debug("ServeHTTP", "Authentication failed: %s", err)
+			authErr = errors.Join(authErr, err)
+			continue
+		}
+		if principal != nil {
+			h.next.ServeHTTP(w, req.WithContext(
+				context.WithValue(req.Context(), contextKey{}, principal),
+			))
+			return
+		}
+	}
+	if h.optional {
+		h.next.ServeHTTP(w, req)
+		return
+	}
+	for _, a := range h.authenticators {
+		w.Header().Add("www-authenticate", a.Challenge())
+	}
+	if authErr == nil {
+		authErr = errors.New("no credentials")
+	}
+	h.deps.HandleStatusUnauthorized(w, req, authErr)
+}
+
+// NewBearerAuthenticator returns an Authenticator for bearer tokens (e.g.
+// JWTs), delegating verification to validate.
+func NewBearerAuthenticator(
+	realm string,
+	validate func(ctx context.Context, token string) (*Principal, error),
+) Authenticator {
+	return &bearerAuthenticator{realm: realm, validate: validate}
+}
+
+type bearerAuthenticator struct {
+	realm    string
+	validate func(ctx context.Context, token string) (*Principal, error)
+}
+
+// Authenticate implements Authenticator.
+func (b *bearerAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, found := strings.Cut(r.Header.Get("authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "bearer") {
+		return nil, nil
+	}
+	p, err := b.validate(r.Context(), strings.TrimSpace(token))
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, errors.New("token rejected")
+	}
+	if p.Scheme == "" {
+		p.Scheme = "Bearer"
+	}
+	return p, nil
+}
+
+// Challenge implements Authenticator.
+func (b *bearerAuthenticator) Challenge() string {
+	return fmt.Sprintf(`Bearer realm="%s"`, b.realm)
+}
@@ -0,0 +1,203 @@
+// Package binding decodes request data (JSON bodies, query strings and form
+// values) into Go values and runs an optional Validator over the result.
+package binding
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Validator is invoked on every value after it has been bound. Adapters for
+// validation frameworks such as go-playground/validator implement this
+// interface.
+type Validator interface {
+	Validate(v any) error
+}
+
+// ValidatorFunc adapts an ordinary function to the Validator interface.
+type ValidatorFunc func(v any) error
+
+// Validate implements Validator.
+func (f ValidatorFunc) Validate(v any) error {
+	return f(v)
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a bound value fails validation. Validators
+// may return a *ValidationError directly to report individual fields; any
+// other error is wrapped.
+type ValidationError struct {
+	Fields []FieldError
+	Err    error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("validation failed: %s", e.Err)
+	}
+	parts := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		parts = append(parts, fmt.Sprintf("%s: %s", f.Field, f.Message))
+	}
+	return fmt.Sprintf("validation failed: %s", strings.Join(parts, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// MarshalJSON renders the error as the body of a structured 400 response.
+func (e *ValidationError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Error  string       `json:"error"`
+		Fields []FieldError `json:"fields,omitempty"`
+	}{
+		Error:  e.Error(),
+		Fields: e.Fields,
+	})
+}
+
+type validatorContextKey struct{}
+
+// NewContext returns a copy of ctx carrying the given Validator.
+func NewContext(ctx context.Context, v Validator) context.Context {
+	return context.WithValue(ctx, validatorContextKey{}, v)
+}
+
+// ValidatorFromContext returns the Validator stored in ctx, if any.
+func ValidatorFromContext(ctx context.Context) (Validator, bool) {
+	v, ok := ctx.Value(validatorContextKey{}).(Validator)
+	return v, ok && v != nil
+}
+
+// JSON decodes the request body into v and validates the result.
+func JSON(r *http.Request, v any) error {
+	if r.Body == nil {
+		return errors.New("missing request body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding JSON body: %w", err)
+	}
+	return validate(r.Context(), v)
+}
+
+// Query decodes the URL query string into the struct pointed to by v and
+// validates the result. Fields are matched using the "form" struct tag,
+// falling back to the field name.
+func Query(r *http.Request, v any) error {
+	if err := decodeValues(r.URL.Query(), v); err != nil {
+		return fmt.Errorf("decoding query: %w", err)
+	}
+	return validate(r.Context(), v)
+}
+
+// Form decodes the parsed form (body and query) into the struct pointed to by
+// v and validates the result.
+func Form(r *http.Request, v any) error {
+	if err := r.ParseForm(); err != nil {
+		return fmt.Errorf("parsing form: %w", err)
+	}
+	if err := decodeValues(r.Form, v); err != nil {
+		return fmt.Errorf("decoding form: %w", err)
+	}
+	return validate(r.Context(), v)
+}
+
+func validate(ctx context.Context, v any) error {
+	validator, ok := ValidatorFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if err := validator.Validate(v); err != nil {
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			return validationErr
+		}
+		return &ValidationError{Err: err}
+	}
+	return nil
+}
+
+func decodeValues(values url.Values, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("target must be a non-nil pointer to a struct")
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("form"); ok {
+			if tag == "-" {
+				continue
+			}
+			name = tag
+		}
+		raw, found := values[name]
+		if !found || len(raw) < 1 {
+			continue
+		}
+		fv := rv.Field(i)
+		if fv.Kind() == reflect.Slice {
+			slice := reflect.MakeSlice(fv.Type(), len(raw), len(raw))
+			for j, s := range raw {
+				if err := setValue(slice.Index(j), s); err != nil {
+					return fmt.Errorf("field %s: %w", name, err)
+				}
+			}
+			fv.Set(slice)
+		} else if err := setValue(fv, raw[0]); err != nil {
+			return fmt.Errorf("field %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setValue(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported kind %s", v.Kind())
+	}
+	return nil
+}
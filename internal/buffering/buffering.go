@@ -0,0 +1,168 @@
+// Package buffering provides an HTTP middleware handler buffering responses,
+// so that mutators can rewrite them once the handler has returned, e.g. to
+// inject markup into HTML pages.
+package buffering
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+// DefaultLimit is the size beyond which responses are no longer buffered,
+// unless configured otherwise.
+const DefaultLimit = 1 << 20
+
+var logger = common.NewLogger("buffering")
+
+// Response is a buffered response. Mutators may change any of its fields.
+type Response struct {
+	Status int
+	// Header is the header of the response, which has not been written yet.
+	Header http.Header
+	Body   []byte
+}
+
+// Mutator rewrites a buffered response before it is written.
+type Mutator func(*http.Request, *Response)
+
+// NewMiddlewareHandler returns a handler buffering responses of up to limit
+// bytes and applying the mutators to them in order. Larger responses, and
+// responses flushed or hijacked by the handler, are written unmodified as
+// they are produced. A limit of zero selects DefaultLimit.
+func NewMiddlewareHandler(next http.Handler, limit int64, mutators ...Mutator) common.MiddlewareHandler {
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	return &handler{next: next, limit: limit, mutators: mutators}
+}
+
+type handler struct {
+	next     http.Handler
+	limit    int64
+	mutators []Mutator
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bw := &responseWriter{ResponseWriter: w, limit: h.limit}
+	h.next.ServeHTTP(bw, r)
+	if bw.streaming {
+		return
+	}
+	resp := &Response{
+		Status: bw.status,
+		Header: w.Header(),
+		Body:   bw.body.Bytes(),
+	}
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+	for _, m := range h.mutators {
+		m(r, resp)
+	}
+	if bodyAllowed(resp.Status) && r.Method != http.MethodHead {
+		// The body may have changed length.
+		resp.Header.Set("content-length", strconv.Itoa(len(resp.Body)))
+	}
+	w.WriteHeader(resp.Status)
+	if _, err := w.Write(resp.Body); err != nil {
+		logger.Debug("ServeHTTP", "Error writing response: %s", err)
+	}
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	limit     int64
+	status    int
+	body      bytes.Buffer
+	streaming bool
+}
+
+// stream stops buffering, writing what has been buffered so far.
+func (w *responseWriter) stream() error {
+	if w.streaming {
+		return nil
+	}
+	w.streaming = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(w.body.Bytes())
+	w.body = bytes.Buffer{}
+	return err
+}
+
+// Flush implements http.Flusher.
+func (w *responseWriter) Flush() {
+	if err := w.stream(); err != nil {
+		logger.Debug("Flush", "Error writing response: %s", err)
+		return
+	}
+	if err := http.NewResponseController(w.ResponseWriter).Flush(); err != nil {
+		logger.Debug("Flush", "Error flushing response: %s", err)
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	// Nothing is written once the connection is hijacked.
+	w.streaming = true
+	return hijacker.Hijack()
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Write implements http.ResponseWriter.
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.Write(b)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if int64(w.body.Len()+len(b)) > w.limit {
+		if err := w.stream(); err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	return w.body.Write(b)
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *responseWriter) WriteHeader(code int) {
+	switch {
+	case w.streaming:
+		w.ResponseWriter.WriteHeader(code)
+	case code < 200:
+		// Informational responses are not buffered.
+		w.ResponseWriter.WriteHeader(code)
+	case w.status == 0:
+		w.status = code
+	}
+}
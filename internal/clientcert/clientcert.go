@@ -0,0 +1,79 @@
+// Package clientcert provides an HTTP middleware handler exposing details of
+// the verified TLS client certificate through the request context.
+package clientcert
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"sync"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+// Certificate describes the verified client certificate presented on a
+// mutual TLS connection.
+type Certificate struct {
+	CommonName     string
+	DNSNames       []string
+	EmailAddresses []string
+	URIs           []string
+	// FingerprintSHA256 is the hex-encoded SHA-256 digest of the DER encoded
+	// leaf certificate.
+	FingerprintSHA256 string
+	// Chain is the verified chain, starting with the leaf certificate.
+	Chain []*x509.Certificate
+}
+
+type contextKey struct{}
+
+// FromContext returns the client certificate stored in ctx, if any.
+func FromContext(ctx context.Context) (*Certificate, bool) {
+	c, ok := ctx.Value(contextKey{}).(*Certificate)
+	return c, ok
+}
+
+func NewMiddlewareHandler(next http.Handler) common.MiddlewareHandler {
+	return &handler{next: next}
+}
+
+type handler struct {
+	next http.Handler
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		r = r.WithContext(context.WithValue(
+			r.Context(),
+			contextKey{},
+			newCertificate(r.TLS.VerifiedChains[0]),
+		))
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+func newCertificate(chain []*x509.Certificate) *Certificate {
+	leaf := chain[0]
+	fingerprint := sha256.Sum256(leaf.Raw)
+	uris := make([]string, 0, len(leaf.URIs))
+	for _, u := range leaf.URIs {
+		uris = append(uris, u.String())
+	}
+	return &Certificate{
+		CommonName:        leaf.Subject.CommonName,
+		DNSNames:          leaf.DNSNames,
+		EmailAddresses:    leaf.EmailAddresses,
+		URIs:              uris,
+		FingerprintSHA256: hex.EncodeToString(fingerprint[:]),
+		Chain:             chain,
+	}
+}
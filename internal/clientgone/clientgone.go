@@ -0,0 +1,69 @@
+// Package clientgone detects requests whose client disconnected before the
+// response completed, so that the errors they cause are not reported as
+// failures of the server.
+package clientgone
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"syscall"
+
+	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/responseinfo"
+)
+
+var logger = common.NewLogger("clientgone")
+
+// Detect reports whether the client of r has disconnected, or err, if not
+// nil, was caused by the connection to the client breaking. The server
+// cancels the context of requests whose client has disconnected, whereas
+// request timeouts end contexts with their own cause.
+func Detect(r *http.Request, err error) bool {
+	ctx := r.Context()
+	if ctx.Err() != nil && context.Cause(ctx) == context.Canceled {
+		return true
+	}
+	return err != nil && (errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET))
+}
+
+type Dependencies interface {
+	// AddCounter reports a metric, labeled by the caller.
+	AddCounter(name string, labels map[string]string, delta float64)
+}
+
+// NewMiddlewareHandler returns a handler counting the requests whose client
+// disconnected before the response completed in the
+// sudsy_client_disconnects_total metric and marking them as ClientGone for
+// OnResponse hooks.
+func NewMiddlewareHandler(deps Dependencies, next http.Handler) common.MiddlewareHandler {
+	return &handler{
+		deps: deps,
+		next: next,
+	}
+}
+
+type handler struct {
+	deps Dependencies
+	next http.Handler
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.next.ServeHTTP(w, r)
+	if !Detect(r, nil) {
+		return
+	}
+	logger.Debug("ServeHTTP", "Client disconnected before the response to %s completed", r.URL.Path)
+	h.deps.AddCounter("sudsy_client_disconnects_total", nil, 1)
+	if info, found := responseinfo.FromContext(r.Context()); found {
+		info.ClientGone = true
+	}
+}
@@ -3,12 +3,54 @@ package common
 import (
 	"fmt"
 	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Logger interface {
 	Debug(id, format string, v ...any)
 }
 
+// LogLevel is the severity of a LogEntry.
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+// LogEntry is a message logged by a package of the framework.
+type LogEntry struct {
+	// Component is the name of the logging package, e.g. "ratelimiting".
+	Component string
+	// ID identifies the logging function or step, and may be empty.
+	ID      string
+	Level   LogLevel
+	Message string
+}
+
+// LogSink receives the messages of every Logger, instead of the standard
+// logger.
+type LogSink interface {
+	Log(LogEntry)
+}
+
+var sink atomic.Pointer[LogSink]
+
+// SetLogSink sends the messages of every Logger to s. A nil s restores the
+// standard logger.
+func SetLogSink(s LogSink) {
+	if s == nil {
+		sink.Store(nil)
+		return
+	}
+	sink.Store(&s)
+}
+
 func NewLogger(messagePrefix string) Logger {
 	return &logger{
 		messagePrefix: messagePrefix,
@@ -21,9 +63,104 @@ type logger struct {
 
 // Debug implements Logger.
 func (l *logger) Debug(id, format string, v ...any) {
+	if s := sink.Load(); s != nil {
+		(*s).Log(LogEntry{
+			Component: l.messagePrefix,
+			ID:        id,
+			Level:     LogLevelDebug,
+			Message:   fmt.Sprintf(format, v...),
+		})
+		return
+	}
 	idPart := ""
 	if id != "" {
 		idPart = fmt.Sprintf(" - %s", id)
 	}
 	log.Printf("%s%s - %s", l.messagePrefix, idPart, fmt.Sprintf(format, v...))
 }
+
+// LogSampling limits the messages of sampled loggers, which log on hot paths
+// such as routing and rate limiting for every request. Each sampled logger
+// is limited separately.
+type LogSampling struct {
+	// EveryN logs one in N messages, if greater than 1.
+	EveryN uint64
+	// PerSecond logs up to the given number of messages per second, allowing
+	// bursts of up to a second's worth, if positive.
+	PerSecond float64
+}
+
+var logSampling atomic.Pointer[LogSampling]
+
+// SetLogSampling limits the messages of sampled loggers. The zero value
+// disables sampling.
+func SetLogSampling(c LogSampling) {
+	if c.EveryN <= 1 && c.PerSecond <= 0 {
+		logSampling.Store(nil)
+		return
+	}
+	logSampling.Store(&c)
+}
+
+// NewSampledLogger returns a Logger for hot paths, whose messages are
+// limited as configured by SetLogSampling. Logged messages report how many
+// were dropped before them.
+func NewSampledLogger(messagePrefix string) Logger {
+	return &sampledLogger{
+		logger: logger{messagePrefix: messagePrefix},
+	}
+}
+
+type sampledLogger struct {
+	logger
+
+	count   atomic.Uint64
+	dropped atomic.Uint64
+
+	locker sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// Debug implements Logger.
+func (l *sampledLogger) Debug(id, format string, v ...any) {
+	c := logSampling.Load()
+	if c == nil {
+		l.logger.Debug(id, format, v...)
+		return
+	}
+	if !l.sample(c) {
+		l.dropped.Add(1)
+		return
+	}
+	if dropped := l.dropped.Swap(0); dropped > 0 {
+		format += " (%d messages dropped)"
+		v = append(v, dropped)
+	}
+	l.logger.Debug(id, format, v...)
+}
+
+// sample reports whether the current message is logged.
+func (l *sampledLogger) sample(c *LogSampling) bool {
+	if c.EveryN > 1 && (l.count.Add(1)-1)%c.EveryN != 0 {
+		return false
+	}
+	if c.PerSecond <= 0 {
+		return true
+	}
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	now := time.Now()
+	burst := math.Max(c.PerSecond, 1)
+	if l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens = math.Min(burst, l.tokens+now.Sub(l.last).Seconds()*c.PerSecond)
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,162 @@
+// Package concurrency provides an HTTP middleware handler capping the number of
+// requests in flight with a limit that adapts to observed latency: it grows
+// additively while latency stays close to the lowest recently observed and
+// shrinks multiplicatively once latency rises beyond it, so that downstream
+// resources are protected without a hand-tuned cap.
+package concurrency
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+var (
+	ErrLimitExceeded = errors.New("concurrency limit exceeded")
+
+	logger = common.NewLogger("concurrency")
+)
+
+// Config configures the adaptive limit. Zero values select the defaults.
+type Config struct {
+	// InitialLimit is the limit before any latency has been observed,
+	// defaulting to 20.
+	InitialLimit int
+	// MinLimit and MaxLimit bound the limit, defaulting to 1 and 1000.
+	MinLimit int
+	MaxLimit int
+	// Tolerance is the ratio of latency to the lowest recently observed
+	// latency beyond which the limit shrinks, defaulting to 2.
+	Tolerance float64
+	// Backoff is the factor by which the limit shrinks, defaulting to 0.9.
+	Backoff float64
+	// BaselineWindow is how long the lowest observed latency is kept as the
+	// baseline before being measured afresh, defaulting to 30 seconds.
+	BaselineWindow time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.InitialLimit <= 0 {
+		c.InitialLimit = 20
+	}
+	if c.MinLimit <= 0 {
+		c.MinLimit = 1
+	}
+	if c.MaxLimit <= 0 {
+		c.MaxLimit = 1000
+	}
+	if c.MaxLimit < c.MinLimit {
+		c.MaxLimit = c.MinLimit
+	}
+	if c.Tolerance <= 1 {
+		c.Tolerance = 2
+	}
+	if c.Backoff <= 0 || c.Backoff >= 1 {
+		c.Backoff = 0.9
+	}
+	if c.BaselineWindow <= 0 {
+		c.BaselineWindow = 30 * time.Second
+	}
+	return c
+}
+
+type Dependencies interface {
+	Now() time.Time
+	HandleStatusServiceUnavailable(http.ResponseWriter, *http.Request, error)
+	// AddCounter and SetGauge report metrics, labeled by the caller.
+	AddCounter(name string, delta float64)
+	SetGauge(name string, value float64)
+}
+
+func NewMiddlewareHandler(deps Dependencies, next http.Handler, config Config) common.MiddlewareHandler {
+	config = config.withDefaults()
+	return &handler{
+		deps:   deps,
+		next:   next,
+		config: config,
+		locker: &sync.Mutex{},
+		limit:  math.Min(math.Max(float64(config.InitialLimit), float64(config.MinLimit)), float64(config.MaxLimit)),
+	}
+}
+
+type handler struct {
+	deps   Dependencies
+	next   http.Handler
+	config Config
+
+	locker   sync.Locker
+	inFlight int
+	// limit is fractional so that additive increases of 1/limit per request
+	// grow it by about one per round of requests.
+	limit float64
+	// baseline is the lowest latency observed since baselineStartedAt.
+	baseline          time.Duration
+	baselineStartedAt time.Time
+	// decreasedAt is when the limit last shrank. It shrinks at most once
+	// per baseline latency, since requests already in flight were admitted
+	// under the previous limit.
+	decreasedAt time.Time
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.acquire() {
+		logger.Debug("ServeHTTP", "Rejecting request for %s", r.URL.Path)
+		h.deps.AddCounter("sudsy_concurrency_rejections_total", 1)
+		h.deps.HandleStatusServiceUnavailable(w, r, ErrLimitExceeded)
+		return
+	}
+	startedAt := h.deps.Now()
+	defer func() {
+		now := h.deps.Now()
+		h.release(now, now.Sub(startedAt))
+	}()
+	h.next.ServeHTTP(w, r)
+}
+
+func (h *handler) acquire() bool {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	if h.inFlight >= int(h.limit) {
+		return false
+	}
+	h.inFlight++
+	return true
+}
+
+// release records the latency of a completed request and adjusts the limit.
+func (h *handler) release(t time.Time, latency time.Duration) {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	inFlight := h.inFlight
+	h.inFlight--
+	if h.baseline == 0 || latency < h.baseline || t.Sub(h.baselineStartedAt) > h.config.BaselineWindow {
+		h.baseline = max(latency, time.Microsecond)
+		h.baselineStartedAt = t
+	}
+	previous := int(h.limit)
+	if float64(latency) > h.config.Tolerance*float64(h.baseline) {
+		if t.Sub(h.decreasedAt) >= h.baseline {
+			h.limit = math.Max(h.limit*h.config.Backoff, float64(h.config.MinLimit))
+			h.decreasedAt = t
+		}
+	} else if 2*inFlight >= int(h.limit) {
+		// Only grow while the limit is being used, so that it tracks
+		// capacity that has actually been observed.
+		h.limit = math.Min(h.limit+1/h.limit, float64(h.config.MaxLimit))
+	}
+	if current := int(h.limit); current != previous {
+		logger.Debug("release", "Limit changed from %d to %d (latency %s, baseline %s)", previous, current, latency, h.baseline)
+		h.deps.SetGauge("sudsy_concurrency_limit", float64(current))
+	}
+}
@@ -0,0 +1,169 @@
+// Package cors provides an HTTP middleware handler implementing cross-origin
+// resource sharing, with configuration resolved per request so applications,
+// sections and routes can each override it.
+package cors
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+var logger = common.NewLogger("cors")
+
+// Config describes the cross-origin requests allowed. When configs are merged,
+// nil slices, a nil AllowCredentials and a zero MaxAge inherit the value of
+// the config being overridden.
+type Config struct {
+	// AllowedOrigins lists origins such as "https://example.com". "*" allows
+	// any origin and "https://*.example.com" allows any subdomain.
+	AllowedOrigins []string
+	// AllowedMethods defaults to GET, HEAD and POST.
+	AllowedMethods []string
+	// AllowedHeaders lists request headers allowed in preflighted requests.
+	// If empty the headers requested by the preflight are allowed.
+	AllowedHeaders []string
+	// ExposedHeaders lists response headers readable by scripts.
+	ExposedHeaders   []string
+	AllowCredentials *bool
+	// MaxAge is how long preflight responses may be cached.
+	MaxAge time.Duration
+}
+
+// Merge returns base with the fields set in override replacing its own.
+func Merge(base, override Config) Config {
+	result := base
+	if override.AllowedOrigins != nil {
+		result.AllowedOrigins = override.AllowedOrigins
+	}
+	if override.AllowedMethods != nil {
+		result.AllowedMethods = override.AllowedMethods
+	}
+	if override.AllowedHeaders != nil {
+		result.AllowedHeaders = override.AllowedHeaders
+	}
+	if override.ExposedHeaders != nil {
+		result.ExposedHeaders = override.ExposedHeaders
+	}
+	if override.AllowCredentials != nil {
+		result.AllowCredentials = override.AllowCredentials
+	}
+	if override.MaxAge != 0 {
+		result.MaxAge = override.MaxAge
+	}
+	return result
+}
+
+// IsPreflight reports whether r is a CORS preflight request.
+func IsPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("origin") != "" &&
+		r.Header.Get("access-control-request-method") != ""
+}
+
+func (c Config) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	return slices.ContainsFunc(c.AllowedOrigins, func(allowed string) bool {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		prefix, suffix, found := strings.Cut(allowed, "*")
+		return found &&
+			len(origin) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(origin, prefix) &&
+			strings.HasSuffix(origin, suffix)
+	})
+}
+
+func (c Config) credentials() bool {
+	return c.AllowCredentials != nil && *c.AllowCredentials
+}
+
+type Dependencies interface {
+	// Config returns the configuration applying to the request, reporting
+	// false if cross-origin requests are not configured for it.
+	Config(r *http.Request) (Config, bool)
+}
+
+func NewMiddlewareHandler(deps Dependencies, next http.Handler) common.MiddlewareHandler {
+	return &handler{
+		deps: deps,
+		next: next,
+	}
+}
+
+type handler struct {
+	deps Dependencies
+	next http.Handler
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("origin")
+	if origin == "" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	config, found := h.deps.Config(r)
+	if !found {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	header := w.Header()
+	header.Add("vary", "Origin")
+	preflight := IsPreflight(r)
+	if preflight {
+		header.Add("vary", "Access-Control-Request-Method")
+		header.Add("vary", "Access-Control-Request-Headers")
+	}
+	if !config.allowsOrigin(origin) {
+		logger.Debug("ServeHTTP", "Origin %s not allowed", origin)
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	if config.credentials() || !slices.Contains(config.AllowedOrigins, "*") {
+		header.Set("access-control-allow-origin", origin)
+	} else {
+		header.Set("access-control-allow-origin", "*")
+	}
+	if config.credentials() {
+		header.Set("access-control-allow-credentials", "true")
+	}
+	if !preflight {
+		if len(config.ExposedHeaders) > 0 {
+			header.Set("access-control-expose-headers", strings.Join(config.ExposedHeaders, ", "))
+		}
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	methods := config.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	header.Set("access-control-allow-methods", strings.Join(methods, ", "))
+	if len(config.AllowedHeaders) > 0 {
+		header.Set("access-control-allow-headers", strings.Join(config.AllowedHeaders, ", "))
+	} else if requested := r.Header.Get("access-control-request-headers"); requested != "" {
+		header.Set("access-control-allow-headers", requested)
+	}
+	if config.MaxAge > 0 {
+		header.Set("access-control-max-age", strconv.Itoa(int(config.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,71 @@
+// Package csp provides an HTTP middleware handler generating a nonce for every
+// request and setting a Content-Security-Policy header allowing the scripts
+// and styles carrying it.
+package csp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+// NoncePlaceholder is replaced in policies by the nonce source expression of
+// the request, e.g. "'nonce-mv0vHn3mNg8ktb2ET7/RCg=='".
+const NoncePlaceholder = "{nonce}"
+
+// Config configures the policy.
+type Config struct {
+	// Policy is the value of the header, e.g. "script-src 'self' {nonce}".
+	Policy string
+	// ReportOnly sets the Content-Security-Policy-Report-Only header
+	// instead, so that violations are reported without being blocked.
+	ReportOnly bool
+}
+
+type contextKey struct{}
+
+// NonceFromContext returns the nonce generated for the request.
+func NonceFromContext(ctx context.Context) (string, bool) {
+	nonce, ok := ctx.Value(contextKey{}).(string)
+	return nonce, ok
+}
+
+func NewMiddlewareHandler(next http.Handler, config Config) common.MiddlewareHandler {
+	header := "Content-Security-Policy"
+	if config.ReportOnly {
+		header = "Content-Security-Policy-Report-Only"
+	}
+	return &handler{next: next, policy: config.Policy, header: header}
+}
+
+type handler struct {
+	next   http.Handler
+	policy string
+	header string
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	nonce := newNonce()
+	// Handlers may still replace the header.
+	w.Header().Set(h.header, strings.ReplaceAll(h.policy, NoncePlaceholder, "'nonce-"+nonce+"'"))
+	h.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, nonce)))
+}
+
+func newNonce() string {
+	var b [16]byte
+	// Read never returns an error.
+	_, _ = rand.Read(b[:])
+	return base64.StdEncoding.EncodeToString(b[:])
+}
@@ -0,0 +1,242 @@
+// Package deadline provides an HTTP middleware handler deriving request
+// context deadlines from timeouts requested by clients.
+package deadline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/responseinfo"
+)
+
+var (
+	ErrInvalidTimeout = errors.New("invalid request timeout")
+	// ErrRequestTimeout is the cause of the contexts of requests whose
+	// timeout has passed.
+	ErrRequestTimeout = errors.New("request timeout")
+
+	logger = common.NewLogger("deadline")
+)
+
+// ParseRequestTimeout parses an X-Request-Timeout header value, given either
+// as a Go duration such as "1.5s" or as a number of seconds.
+func ParseRequestTimeout(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 || seconds > float64(1<<62)/float64(time.Second) {
+			return 0, fmt.Errorf("%w: %s", ErrInvalidTimeout, value)
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidTimeout, value)
+	}
+	return d, nil
+}
+
+// ParseGRPCTimeout parses a grpc-timeout header value: up to eight digits
+// followed by one of the units H, M, S, m, u or n.
+func ParseGRPCTimeout(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 || len(value) > 9 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidTimeout, value)
+	}
+	n, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidTimeout, value)
+	}
+	var unit time.Duration
+	switch value[len(value)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrInvalidTimeout, value)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// Cause is what ended a request before it completed.
+type Cause string
+
+const (
+	// CauseClient is the client disconnecting.
+	CauseClient Cause = "client"
+	// CauseHandler is the timeout passing while the handler was not waiting
+	// for an upstream call.
+	CauseHandler Cause = "handler"
+	// CauseUpstream is the timeout passing during an upstream call made with
+	// a client using NewTransport.
+	CauseUpstream Cause = "upstream"
+)
+
+type Dependencies interface {
+	// AddCounter reports a metric, labeled by the caller as well as with the
+	// given labels.
+	AddCounter(name string, labels map[string]string, delta float64)
+	HandleStatusBadRequest(http.ResponseWriter, *http.Request, error)
+}
+
+// NewMiddlewareHandler returns a handler applying the timeout requested in the
+// X-Request-Timeout or grpc-timeout header to the request context, capped at
+// max. Requests with malformed timeouts are passed to the bad request handler.
+// Requests ended by their timeout or by the client disconnecting are counted
+// in the sudsy_request_timeouts_total metric, labeled with their Cause, which
+// is also reported to OnResponse hooks.
+func NewMiddlewareHandler(deps Dependencies, next http.Handler, max time.Duration) common.MiddlewareHandler {
+	return &handler{
+		deps: deps,
+		next: next,
+		max:  max,
+	}
+}
+
+type handler struct {
+	deps Dependencies
+	next http.Handler
+	max  time.Duration
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	timeout, found, err := requestedTimeout(r)
+	if err != nil {
+		logger.Debug("ServeHTTP", "Rejecting request: %s", err)
+		h.deps.HandleStatusBadRequest(w, r, err)
+		return
+	}
+	if !found {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	if h.max > 0 && timeout > h.max {
+		timeout = h.max
+	}
+	t := &tracker{}
+	ctx, cancel := context.WithTimeoutCause(r.Context(), timeout, ErrRequestTimeout)
+	defer cancel()
+	h.next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, trackerContextKey{}, t)))
+	cause, found := attribute(r.Context(), ctx, t)
+	if !found {
+		return
+	}
+	logger.Debug("ServeHTTP", "Request for %s with a %s timeout ended before completing, caused by the %s", r.URL.Path, timeout, cause)
+	h.deps.AddCounter("sudsy_request_timeouts_total", map[string]string{"cause": string(cause)}, 1)
+	if info, found := responseinfo.FromContext(r.Context()); found {
+		info.TimeoutCause = string(cause)
+	}
+}
+
+// attribute returns the cause of ctx, derived from parent with the request
+// timeout, ending before the request completed, if it did.
+func attribute(parent, ctx context.Context, t *tracker) (Cause, bool) {
+	switch {
+	case parent.Err() != nil:
+		// The server cancels the context of requests whose client has
+		// disconnected.
+		return CauseClient, true
+	case !errors.Is(context.Cause(ctx), ErrRequestTimeout):
+		return "", false
+	case t.upstreamTimedOut.Load():
+		return CauseUpstream, true
+	default:
+		return CauseHandler, true
+	}
+}
+
+type trackerContextKey struct{}
+
+// tracker records the upstream calls of a request ended by its timeout.
+type tracker struct {
+	upstreamTimedOut atomic.Bool
+}
+
+// NewTransport returns a RoundTripper recording the calls ended by the
+// timeout of the request they are made for, so that the timeout is
+// attributed to the upstream server. A nil base uses http.DefaultTransport.
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	tr, found := r.Context().Value(trackerContextKey{}).(*tracker)
+	if !found {
+		return t.base.RoundTrip(r)
+	}
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		tr.record(r.Context())
+		return nil, err
+	}
+	resp.Body = &body{ReadCloser: resp.Body, ctx: r.Context(), tracker: tr}
+	return resp, nil
+}
+
+// record records the failure of a call made with ctx, if the request timeout
+// ended it.
+func (t *tracker) record(ctx context.Context) {
+	if errors.Is(context.Cause(ctx), ErrRequestTimeout) {
+		t.upstreamTimedOut.Store(true)
+	}
+}
+
+// body records the failure to read a response body of an upstream call.
+type body struct {
+	io.ReadCloser
+	ctx     context.Context
+	tracker *tracker
+}
+
+// Read implements io.Reader.
+func (b *body) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.tracker.record(b.ctx)
+	}
+	return n, err
+}
+
+func requestedTimeout(r *http.Request) (time.Duration, bool, error) {
+	if v := r.Header.Get("x-request-timeout"); v != "" {
+		d, err := ParseRequestTimeout(v)
+		return d, err == nil, err
+	}
+	if v := r.Header.Get("grpc-timeout"); v != "" {
+		d, err := ParseGRPCTimeout(v)
+		return d, err == nil, err
+	}
+	return 0, false, nil
+}
@@ -0,0 +1,61 @@
+// Package download serves content as a file attachment.
+package download
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+var logger = common.NewLogger("download")
+
+// Serve replies to the request with content as an attachment named name.
+// Range requests and conditional requests are handled by http.ServeContent,
+// using an ETag derived from the content's size and modtime unless the
+// response already carries one.
+func Serve(w http.ResponseWriter, r *http.Request, name string, content io.ReadSeeker, modtime time.Time) {
+	h := w.Header()
+	name = path.Base(name)
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": name})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	h.Set("content-disposition", disposition)
+	if h.Get("content-type") == "" {
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h.Set("content-type", contentType)
+	}
+	h.Set("x-content-type-options", "nosniff")
+	if h.Get("etag") == "" {
+		if etag, err := newETag(content, modtime); err == nil {
+			h.Set("etag", etag)
+		} else {
+			logger.Debug("Serve", "Not setting ETag: %s", err)
+		}
+	}
+	http.ServeContent(w, r, name, modtime, content)
+}
+
+// newETag returns a strong validator from the size of content and modtime,
+// leaving content positioned at its start.
+func newETag(content io.ReadSeeker, modtime time.Time) (string, error) {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if modtime.IsZero() {
+		return "", fmt.Errorf("unknown modification time")
+	}
+	return fmt.Sprintf(`"%x-%x"`, size, modtime.UnixNano()), nil
+}
@@ -0,0 +1,138 @@
+// Package drain coordinates stopping long-running background work, such as
+// streaming responses and task queues, when the application shuts down.
+package drain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+var logger = common.NewLogger("drain")
+
+// Func stops a piece of background work, returning once it has finished or
+// ctx is done.
+type Func func(ctx context.Context) error
+
+type Coordinator interface {
+	// Pending returns the names of drain functions registered or still
+	// running, sorted by name.
+	Pending() []string
+	// Register adds a drain function run at shutdown with its own timeout.
+	// A zero timeout bounds it by the context passed to Run instead. The
+	// returned function unregisters it, e.g. once a stream ends on its own.
+	Register(name string, timeout time.Duration, f Func) (unregister func())
+	// Run invokes every registered drain function in parallel and waits for
+	// them to return, joining any errors.
+	Run(ctx context.Context) error
+}
+
+func NewCoordinator() Coordinator {
+	return &coordinator{
+		entries: map[uint64]entry{},
+	}
+}
+
+type entry struct {
+	name    string
+	timeout time.Duration
+	f       Func
+}
+
+type coordinator struct {
+	mu      sync.Mutex
+	nextID  uint64
+	entries map[uint64]entry
+	running map[uint64]string
+}
+
+// Pending implements Coordinator.
+func (c *coordinator) Pending() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := []string{}
+	if c.running != nil {
+		for _, name := range c.running {
+			result = append(result, name)
+		}
+	} else {
+		for _, e := range c.entries {
+			result = append(result, e.name)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// Register implements Coordinator.
+func (c *coordinator) Register(name string, timeout time.Duration, f Func) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.nextID
+	c.nextID++
+	c.entries[id] = entry{name: name, timeout: timeout, f: f}
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.entries, id)
+	}
+}
+
+// Run implements Coordinator.
+func (c *coordinator) Run(ctx context.Context) error {
+	c.mu.Lock()
+	entries := c.entries
+	c.entries = map[uint64]entry{}
+	c.running = map[uint64]string{}
+	for id, e := range entries {
+		c.running[id] = e.name
+	}
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(entries))
+	for id, e := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				delete(c.running, id)
+			}()
+			startedAt := time.Now()
+			if err := c.run(ctx, e); err != nil {
+				logger.Debug("Run", "Drain function %s failed after %s: %s", e.name, time.Since(startedAt), err)
+				errs <- fmt.Errorf("draining %s: %w", e.name, err)
+				return
+			}
+			logger.Debug("Run", "Drain function %s completed in %s", e.name, time.Since(startedAt))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	var result []error
+	for err := range errs {
+		result = append(result, err)
+	}
+	return errors.Join(result...)
+}
+
+func (c *coordinator) run(ctx context.Context, e entry) (err error) {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), e.timeout)
+		defer cancel()
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return e.f(ctx)
+}
@@ -0,0 +1,109 @@
+// Package errorpages renders styled HTML error pages, using custom templates
+// when provided.
+package errorpages
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/recovery"
+	"github.com/jakewan/sudsy/internal/templates"
+)
+
+// DefaultTemplateName is the name of the custom template rendering error
+// pages for status codes without a template named after them, e.g. "404".
+const DefaultTemplateName = "error"
+
+var logger = common.NewLogger("errorpages")
+
+// Page is the data error page templates are executed with.
+type Page struct {
+	Status     int
+	StatusText string
+	Request    *http.Request
+	// Error and Stack describe the error in development mode, and are empty
+	// otherwise. Stack is only set for recovered panics.
+	Error string
+	Stack string
+}
+
+var defaultTemplate = template.Must(template.New(DefaultTemplateName).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Status}} {{.StatusText}}</title>
+<style>
+body { margin: 0; font-family: system-ui, -apple-system, sans-serif; color: #1f2328; background: #f6f8fa; }
+main { max-width: 40rem; margin: 15vh auto 0; padding: 2rem; background: #fff; border: 1px solid #d0d7de; border-radius: 6px; }
+h1 { margin: 0 0 .5rem; font-size: 1.5rem; }
+.status { color: #656d76; font-size: 3rem; font-weight: 300; }
+pre { overflow-x: auto; padding: 1rem; font-size: .8rem; background: #f6f8fa; border-radius: 6px; }
+</style>
+</head>
+<body>
+<main>
+<div class="status">{{.Status}}</div>
+<h1>{{.StatusText}}</h1>
+{{if .Error}}<pre>{{.Error}}</pre>{{end}}
+{{if .Stack}}<pre>{{.Stack}}</pre>{{end}}
+</main>
+</body>
+</html>
+`))
+
+// NewHandlerFunc returns a function responding with the error page for the
+// status code, rendered with the custom templates if set is not nil. The
+// custom templates are parsed afresh for every response in development mode,
+// which also adds the details of the error to the page.
+func NewHandlerFunc(code int, set *templates.Set, devMode bool) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		page := Page{
+			Status:     code,
+			StatusText: http.StatusText(code),
+			Request:    r,
+		}
+		if devMode && err != nil {
+			page.Error = err.Error()
+			var panicErr *recovery.PanicError
+			if errors.As(err, &panicErr) {
+				page.Stack = string(panicErr.Stack)
+			}
+		}
+		var body bytes.Buffer
+		if renderErr := lookup(code, set, devMode).Execute(&body, page); renderErr != nil {
+			logger.Debug("", "Error rendering page for status %d: %s", code, renderErr)
+			http.Error(w, page.StatusText, code)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(code)
+		if _, err := w.Write(body.Bytes()); err != nil {
+			logger.Debug("", "Error writing response: %s", err)
+		}
+	}
+}
+
+// lookup returns the custom template named after the status code, falling
+// back to the one named DefaultTemplateName and then to the built-in
+// template.
+func lookup(code int, set *templates.Set, devMode bool) *template.Template {
+	if set == nil {
+		return defaultTemplate
+	}
+	t, err := set.Template(devMode)
+	if err != nil {
+		logger.Debug("lookup", "Error parsing templates: %s", err)
+		return defaultTemplate
+	}
+	for _, name := range []string{strconv.Itoa(code), DefaultTemplateName} {
+		if result := t.Lookup(name); result != nil {
+			return result
+		}
+	}
+	return defaultTemplate
+}
@@ -0,0 +1,68 @@
+// Package events provides a registry of observers notified of request
+// lifecycle events, so cross-cutting concerns need not wrap every
+// middleware.
+package events
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	AuthFailed        Type = "AuthFailed"
+	IdleShutdown      Type = "IdleShutdown"
+	PanicRecovered    Type = "PanicRecovered"
+	RateLimited       Type = "RateLimited"
+	RouteMatched      Type = "RouteMatched"
+	ShutdownStarted   Type = "ShutdownStarted"
+	SuspiciousRequest Type = "SuspiciousRequest"
+)
+
+type Event struct {
+	Type Type
+	Time time.Time
+	// Request is the request the event relates to, if any.
+	Request *http.Request
+	// SectionRoot is the root of the section the event relates to, if any.
+	SectionRoot string
+	// Route is the pattern of the route the event relates to, if any.
+	Route string
+	Err   error
+}
+
+// Observer is called synchronously for every event published, so it should
+// return promptly.
+type Observer func(Event)
+
+type Bus interface {
+	Publish(Event)
+	Subscribe(Observer)
+}
+
+func NewBus() Bus {
+	return &bus{locker: &sync.RWMutex{}}
+}
+
+type bus struct {
+	locker    *sync.RWMutex
+	observers []Observer
+}
+
+// Publish implements Bus.
+func (b *bus) Publish(e Event) {
+	b.locker.RLock()
+	defer b.locker.RUnlock()
+	for _, o := range b.observers {
+		o(e)
+	}
+}
+
+// Subscribe implements Bus.
+func (b *bus) Subscribe(o Observer) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	b.observers = append(b.observers, o)
+}
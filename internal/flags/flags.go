@@ -0,0 +1,51 @@
+// Package flags gates sections and routes behind runtime feature flags.
+package flags
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var ErrFeatureDisabled = errors.New("feature disabled")
+
+// Provider reports whether a feature flag is enabled for a request. Providers
+// are consulted on every gated request and must be safe for concurrent use.
+type Provider interface {
+	Enabled(r *http.Request, flag string) bool
+}
+
+// ProviderFunc adapts an ordinary function to the Provider interface.
+type ProviderFunc func(r *http.Request, flag string) bool
+
+// Enabled implements Provider.
+func (f ProviderFunc) Enabled(r *http.Request, flag string) bool {
+	return f(r, flag)
+}
+
+// Gate associates a flag with the status returned while it is disabled. The
+// zero value gates nothing.
+type Gate struct {
+	Flag string
+	// DisabledStatus is the response status while the flag is disabled,
+	// http.StatusNotFound if zero.
+	DisabledStatus int
+}
+
+// Disabled reports whether the gate's flag is disabled for the request, along
+// with the status to respond with. A nil provider enables every flag.
+func (g Gate) Disabled(p Provider, r *http.Request) (int, bool) {
+	if g.Flag == "" || p == nil || p.Enabled(r, g.Flag) {
+		return 0, false
+	}
+	if g.DisabledStatus == 0 {
+		return http.StatusNotFound, true
+	}
+	return g.DisabledStatus, true
+}
+
+// Err returns the error passed to status handlers for requests rejected by
+// the gate.
+func (g Gate) Err() error {
+	return fmt.Errorf("%w: %s", ErrFeatureDisabled, g.Flag)
+}
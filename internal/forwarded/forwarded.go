@@ -0,0 +1,157 @@
+// Package forwarded parses the Forwarded header defined by RFC 7239 and
+// provides an HTTP middleware handler exposing the result through the request
+// context.
+package forwarded
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+// Element is a single forwarded-element, added by one proxy.
+type Element struct {
+	// For identifies the node making the request to the proxy, e.g.
+	// "192.0.2.60", "[2001:db8:cafe::17]:4711" or an obfuscated identifier.
+	For string
+	// By identifies the interface on which the proxy received the request.
+	By string
+	// Host is the Host request header as received by the proxy.
+	Host string
+	// Proto is the protocol used to make the request to the proxy.
+	Proto string
+}
+
+// ForHost returns the host part of the For node, without any port or
+// brackets. Obfuscated identifiers and "unknown" yield false.
+func (e Element) ForHost() (string, bool) {
+	return nodeHost(e.For)
+}
+
+type contextKey struct{}
+
+// FromContext returns the Forwarded elements stored in ctx, from the one added
+// by the proxy furthest from the server to the nearest.
+func FromContext(ctx context.Context) ([]Element, bool) {
+	e, ok := ctx.Value(contextKey{}).([]Element)
+	return e, ok
+}
+
+// Parse returns the elements found across all values of the Forwarded header.
+// Malformed pairs are skipped.
+func Parse(values []string) []Element {
+	result := []Element{}
+	for _, v := range values {
+		for _, rawElement := range split(v, ',') {
+			e := Element{}
+			found := false
+			for _, pair := range split(rawElement, ';') {
+				name, value, ok := strings.Cut(pair, "=")
+				if !ok {
+					continue
+				}
+				value = unquote(strings.TrimSpace(value))
+				switch strings.ToLower(strings.TrimSpace(name)) {
+				case "for":
+					e.For = value
+				case "by":
+					e.By = value
+				case "host":
+					e.Host = value
+				case "proto":
+					e.Proto = strings.ToLower(value)
+				default:
+					continue
+				}
+				found = true
+			}
+			if found {
+				result = append(result, e)
+			}
+		}
+	}
+	return result
+}
+
+func NewMiddlewareHandler(next http.Handler) common.MiddlewareHandler {
+	return &handler{next: next}
+}
+
+type handler struct {
+	next http.Handler
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if values := r.Header.Values("forwarded"); len(values) > 0 {
+		if elements := Parse(values); len(elements) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), contextKey{}, elements))
+		}
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// split splits s on sep, ignoring separators within quoted strings.
+func split(s string, sep byte) []string {
+	result := []string{}
+	inQuotes := false
+	escaped := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\' && inQuotes:
+			escaped = true
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == sep && !inQuotes:
+			result = append(result, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(result, s[start:])
+}
+
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var b strings.Builder
+	escaped := false
+	for i := 1; i < len(s)-1; i++ {
+		if !escaped && s[i] == '\\' {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func nodeHost(node string) (string, bool) {
+	if node == "" || strings.EqualFold(node, "unknown") || strings.HasPrefix(node, "_") {
+		return "", false
+	}
+	if strings.HasPrefix(node, "[") {
+		if host, _, err := net.SplitHostPort(node); err == nil {
+			return host, true
+		}
+		return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]"), true
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host, true
+	}
+	return node, true
+}
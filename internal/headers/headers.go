@@ -0,0 +1,172 @@
+// Package headers provides request header helpers and an HTTP middleware
+// handler rejecting malformed or oversized headers before they reach
+// handlers.
+package headers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+var (
+	ErrHeaderTooLarge  = errors.New("header too large")
+	ErrMalformedHeader = errors.New("malformed header")
+	ErrTooManyHeaders  = errors.New("too many headers")
+
+	logger = common.NewLogger("headers")
+)
+
+// hopByHopHeaders are meaningful only for a single transport-level
+// connection and are not forwarded by proxies (RFC 9110 section 7.6.1).
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Limits configures header validation. Zero values disable the
+// corresponding check.
+type Limits struct {
+	// MaxCount is the maximum number of header field lines.
+	MaxCount int
+	// MaxValueBytes is the maximum length of a single header value.
+	MaxValueBytes int
+	// MaxTotalBytes is the maximum combined length of all header names and
+	// values.
+	MaxTotalBytes int
+	// StripHopByHop removes hop-by-hop headers, including any named by the
+	// Connection header, before the request reaches handlers. This prevents
+	// protocol upgrades such as websockets.
+	StripHopByHop bool
+}
+
+// SplitList returns the elements of a comma-separated header across all of
+// its field lines, trimmed of surrounding whitespace and with empty elements
+// removed.
+func SplitList(h http.Header, name string) []string {
+	result := []string{}
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				result = append(result, part)
+			}
+		}
+	}
+	return result
+}
+
+// Validate checks the header against the limits and for malformed names and
+// values. The returned error wraps one of the package's error values.
+func Validate(h http.Header, limits Limits) error {
+	count := 0
+	total := 0
+	for name, values := range h {
+		if !validName(name) {
+			return fmt.Errorf("%w: invalid name %q", ErrMalformedHeader, name)
+		}
+		for _, v := range values {
+			count++
+			total += len(name) + len(v)
+			if limits.MaxValueBytes > 0 && len(v) > limits.MaxValueBytes {
+				return fmt.Errorf("%w: %s value is %d bytes", ErrHeaderTooLarge, name, len(v))
+			}
+			if !validValue(v) {
+				return fmt.Errorf("%w: invalid %s value", ErrMalformedHeader, name)
+			}
+		}
+	}
+	if limits.MaxCount > 0 && count > limits.MaxCount {
+		return fmt.Errorf("%w: %d headers", ErrTooManyHeaders, count)
+	}
+	if limits.MaxTotalBytes > 0 && total > limits.MaxTotalBytes {
+		return fmt.Errorf("%w: %d bytes in total", ErrHeaderTooLarge, total)
+	}
+	return nil
+}
+
+// StripHopByHop removes hop-by-hop headers from h.
+func StripHopByHop(h http.Header) {
+	for _, name := range SplitList(h, "Connection") {
+		h.Del(name)
+	}
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
+
+type Dependencies interface {
+	HandleStatusBadRequest(http.ResponseWriter, *http.Request, error)
+}
+
+func NewMiddlewareHandler(deps Dependencies, next http.Handler, limits Limits) common.MiddlewareHandler {
+	return &handler{
+		deps:   deps,
+		next:   next,
+		limits: limits,
+	}
+}
+
+type handler struct {
+	deps   Dependencies
+	next   http.Handler
+	limits Limits
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := Validate(r.Header, h.limits); err != nil {
+		logger.Debug("ServeHTTP", "Rejecting request: %s", err)
+		h.deps.HandleStatusBadRequest(w, r, err)
+		return
+	}
+	if h.limits.StripHopByHop {
+		r = r.Clone(r.Context())
+		StripHopByHop(r.Header)
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// validName reports whether name is an RFC 9110 token.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			continue
+		}
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// validValue rejects control characters other than horizontal tab, including
+// the CR and LF left behind by obs-fold line folding.
+func validValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,242 @@
+// Package lifecycle provides an HTTP middleware handler tracking in-flight
+// requests and hijacked connections so shutdown progress can be reported and
+// hijacked connections, which http.Server.Shutdown ignores, can be waited for.
+package lifecycle
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+var logger = common.NewLogger("lifecycle")
+
+// Progress describes the work remaining while the application shuts down.
+type Progress struct {
+	ShuttingDown        bool  `json:"shuttingDown"`
+	InFlightRequests    int64 `json:"inFlightRequests"`
+	HijackedConnections int64 `json:"hijackedConnections"`
+	// PendingDrainFuncs names the drain functions yet to complete.
+	PendingDrainFuncs []string `json:"pendingDrainFuncs"`
+}
+
+type Dependencies interface {
+	// CloseConnections reports whether responses should ask clients to close
+	// their connections, e.g. while the application is draining.
+	CloseConnections() bool
+	// MaxRequestsPerConnection is the number of requests served on a
+	// connection before it is closed. Zero means unlimited.
+	MaxRequestsPerConnection() int64
+}
+
+type connRequestCountContextKey struct{}
+
+type shutdownContextKey struct{}
+
+// ShutdownFromContext returns a channel closed when the application starts
+// shutting down, letting handlers that hijack connections, e.g. for
+// websockets, close them gracefully.
+func ShutdownFromContext(ctx context.Context) (<-chan struct{}, bool) {
+	ch, ok := ctx.Value(shutdownContextKey{}).(chan struct{})
+	return ch, ok
+}
+
+// NewConnContext is intended for use as http.Server.ConnContext. It attaches
+// the per-connection request counter used to enforce
+// Dependencies.MaxRequestsPerConnection.
+func NewConnContext(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, connRequestCountContextKey{}, &atomic.Int64{})
+}
+
+type MiddlewareHandler interface {
+	common.MiddlewareHandler
+	// CloseHijackedConnections closes any hijacked connections still open.
+	CloseHijackedConnections()
+	HijackedConnections() int64
+	InFlightRequests() int64
+	// LastActive returns when a request last started or completed, or the
+	// zero time if none has been served.
+	LastActive() time.Time
+	// NotifyShutdown closes the channel returned by ShutdownFromContext.
+	NotifyShutdown()
+	// WaitHijackedConnections waits until every hijacked connection has been
+	// closed or ctx is done, reporting whether all were closed.
+	WaitHijackedConnections(ctx context.Context) bool
+}
+
+func NewMiddlewareHandler(deps Dependencies, next http.Handler) MiddlewareHandler {
+	return &handler{
+		deps:                deps,
+		next:                next,
+		hijackedConnections: map[*hijackedConn]struct{}{},
+		shutdown:            make(chan struct{}),
+	}
+}
+
+// hijackedConnectionPollInterval is how often WaitHijackedConnections checks
+// for remaining connections.
+const hijackedConnectionPollInterval = 50 * time.Millisecond
+
+type handler struct {
+	deps             Dependencies
+	next             http.Handler
+	inFlightRequests atomic.Int64
+	// lastActive is when a request last started or completed, in Unix
+	// nanoseconds.
+	lastActive atomic.Int64
+
+	hijackedConnectionsLocker sync.Mutex
+	hijackedConnections       map[*hijackedConn]struct{}
+
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+}
+
+// AfterShutdown implements MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// CloseHijackedConnections implements MiddlewareHandler.
+func (h *handler) CloseHijackedConnections() {
+	h.hijackedConnectionsLocker.Lock()
+	conns := make([]*hijackedConn, 0, len(h.hijackedConnections))
+	for c := range h.hijackedConnections {
+		conns = append(conns, c)
+	}
+	h.hijackedConnectionsLocker.Unlock()
+	for _, c := range conns {
+		logger.Debug("CloseHijackedConnections", "Closing hijacked connection from %s", c.RemoteAddr())
+		if err := c.Close(); err != nil {
+			logger.Debug("CloseHijackedConnections", "Error closing connection: %s", err)
+		}
+	}
+}
+
+// HijackedConnections implements MiddlewareHandler.
+func (h *handler) HijackedConnections() int64 {
+	h.hijackedConnectionsLocker.Lock()
+	defer h.hijackedConnectionsLocker.Unlock()
+	return int64(len(h.hijackedConnections))
+}
+
+// InFlightRequests implements MiddlewareHandler.
+func (h *handler) InFlightRequests() int64 {
+	return h.inFlightRequests.Load()
+}
+
+// LastActive implements MiddlewareHandler.
+func (h *handler) LastActive() time.Time {
+	if n := h.lastActive.Load(); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+// NotifyShutdown implements MiddlewareHandler.
+func (h *handler) NotifyShutdown() {
+	h.shutdownOnce.Do(func() {
+		close(h.shutdown)
+	})
+}
+
+// WaitHijackedConnections implements MiddlewareHandler.
+func (h *handler) WaitHijackedConnections(ctx context.Context) bool {
+	ticker := time.NewTicker(hijackedConnectionPollInterval)
+	defer ticker.Stop()
+	for h.HijackedConnections() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.inFlightRequests.Add(1)
+	h.lastActive.Store(time.Now().UnixNano())
+	defer func() {
+		h.lastActive.Store(time.Now().UnixNano())
+		h.inFlightRequests.Add(-1)
+	}()
+	if h.deps.CloseConnections() || h.connectionExhausted(r) {
+		w.Header().Set("connection", "close")
+	}
+	r = r.WithContext(context.WithValue(r.Context(), shutdownContextKey{}, h.shutdown))
+	h.next.ServeHTTP(&responseWriter{ResponseWriter: w, handler: h}, r)
+}
+
+func (h *handler) connectionExhausted(r *http.Request) bool {
+	max := h.deps.MaxRequestsPerConnection()
+	if max < 1 {
+		return false
+	}
+	count, ok := r.Context().Value(connRequestCountContextKey{}).(*atomic.Int64)
+	if !ok {
+		return false
+	}
+	return count.Add(1) >= max
+}
+
+// responseWriter counts connections hijacked by downstream handlers until
+// they are closed.
+type responseWriter struct {
+	http.ResponseWriter
+	handler *handler
+}
+
+// Flush implements http.Flusher.
+func (w *responseWriter) Flush() {
+	if err := http.NewResponseController(w.ResponseWriter).Flush(); err != nil {
+		logger.Debug("Flush", "Error flushing response: %s", err)
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &hijackedConn{Conn: conn, handler: w.handler}
+	w.handler.hijackedConnectionsLocker.Lock()
+	w.handler.hijackedConnections[result] = struct{}{}
+	w.handler.hijackedConnectionsLocker.Unlock()
+	return result, rw, nil
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type hijackedConn struct {
+	net.Conn
+	handler   *handler
+	closeOnce sync.Once
+}
+
+// Close implements net.Conn.
+func (c *hijackedConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.handler.hijackedConnectionsLocker.Lock()
+		defer c.handler.hijackedConnectionsLocker.Unlock()
+		delete(c.handler.hijackedConnections, c)
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,64 @@
+//go:build linux
+
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+// journaldSocket is the socket of the journal's native protocol, see
+// systemd-journald.service(8).
+const journaldSocket = "/run/systemd/journal/socket"
+
+// NewJournald returns a sink sending messages to the systemd journal, with
+// the logging component and ID in the SUDSY_COMPONENT and SUDSY_ID fields.
+// Messages too large for a datagram are dropped.
+func NewJournald(c Config) (common.LogSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldSink{conn: conn, config: c, tag: c.tag()}, nil
+}
+
+type journaldSink struct {
+	conn   *net.UnixConn
+	config Config
+	tag    string
+}
+
+// Log implements common.LogSink.
+func (s *journaldSink) Log(e common.LogEntry) {
+	var b bytes.Buffer
+	writeJournalField(&b, "MESSAGE", message(e))
+	writeJournalField(&b, "PRIORITY", strconv.Itoa(int(s.config.severity(e.Level))))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", s.tag)
+	writeJournalField(&b, "SUDSY_COMPONENT", e.Component)
+	if e.ID != "" {
+		writeJournalField(&b, "SUDSY_ID", e.ID)
+	}
+	// A sink has no caller to report errors to.
+	_, _ = s.conn.Write(b.Bytes())
+}
+
+// writeJournalField writes a field in the journal's native format, in which
+// values containing newlines are preceded by their length.
+func writeJournalField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
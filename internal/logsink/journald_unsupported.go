@@ -0,0 +1,15 @@
+//go:build !linux
+
+package logsink
+
+import (
+	"fmt"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+// NewJournald returns an error wrapping ErrUnsupported, since the journal is
+// only available on Linux.
+func NewJournald(c Config) (common.LogSink, error) {
+	return nil, fmt.Errorf("journald: %w", ErrUnsupported)
+}
@@ -0,0 +1,74 @@
+// Package logsink provides log sinks writing the framework's messages to the
+// system logging services of hosts that do not capture standard output.
+package logsink
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+// Severity is a syslog severity, see RFC 5424. The journal uses the same
+// values for the priority of its entries.
+type Severity int
+
+const (
+	SeverityEmergency Severity = iota
+	SeverityAlert
+	SeverityCritical
+	SeverityError
+	SeverityWarning
+	SeverityNotice
+	SeverityInfo
+	SeverityDebug
+)
+
+// ErrUnsupported is wrapped by the errors returned for sinks the platform
+// does not provide.
+var ErrUnsupported = errors.ErrUnsupported
+
+// Config configures a sink.
+type Config struct {
+	// Tag identifies the application in the messages, the program name if
+	// empty.
+	Tag string
+	// Severities overrides the severity of the messages of the given levels.
+	// By default debug, info, warn and error messages have the debug, info,
+	// warning and error severities.
+	Severities map[common.LogLevel]Severity
+}
+
+// tag returns the tag of the messages.
+func (c Config) tag() string {
+	if c.Tag != "" {
+		return c.Tag
+	}
+	return filepath.Base(os.Args[0])
+}
+
+// severity returns the severity of messages of the given level.
+func (c Config) severity(l common.LogLevel) Severity {
+	if s, ok := c.Severities[l]; ok {
+		return s
+	}
+	switch l {
+	case common.LogLevelError:
+		return SeverityError
+	case common.LogLevelWarn:
+		return SeverityWarning
+	case common.LogLevelInfo:
+		return SeverityInfo
+	default:
+		return SeverityDebug
+	}
+}
+
+// message returns the text of e, formatted like the standard logger's.
+func message(e common.LogEntry) string {
+	if e.ID == "" {
+		return e.Component + " - " + e.Message
+	}
+	return e.Component + " - " + e.ID + " - " + e.Message
+}
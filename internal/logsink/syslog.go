@@ -0,0 +1,50 @@
+//go:build !windows && !plan9
+
+package logsink
+
+import (
+	"log/syslog"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+// NewSyslog returns a sink sending messages to the syslog daemon at raddr
+// over network, e.g. "udp", or to the local daemon if network is empty, with
+// the daemon facility.
+func NewSyslog(network, raddr string, c Config) (common.LogSink, error) {
+	w, err := syslog.Dial(network, raddr, syslog.LOG_DAEMON, c.tag())
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w, config: c}, nil
+}
+
+type syslogSink struct {
+	w      *syslog.Writer
+	config Config
+}
+
+// Log implements common.LogSink.
+func (s *syslogSink) Log(e common.LogEntry) {
+	m := message(e)
+	// The writer reconnects on failures, and a sink has no caller to report
+	// the errors it cannot recover from to.
+	switch s.config.severity(e.Level) {
+	case SeverityEmergency:
+		_ = s.w.Emerg(m)
+	case SeverityAlert:
+		_ = s.w.Alert(m)
+	case SeverityCritical:
+		_ = s.w.Crit(m)
+	case SeverityError:
+		_ = s.w.Err(m)
+	case SeverityWarning:
+		_ = s.w.Warning(m)
+	case SeverityNotice:
+		_ = s.w.Notice(m)
+	case SeverityInfo:
+		_ = s.w.Info(m)
+	default:
+		_ = s.w.Debug(m)
+	}
+}
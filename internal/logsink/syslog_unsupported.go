@@ -0,0 +1,15 @@
+//go:build windows || plan9
+
+package logsink
+
+import (
+	"fmt"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+// NewSyslog returns an error wrapping ErrUnsupported, since the platform has
+// no syslog.
+func NewSyslog(network, raddr string, c Config) (common.LogSink, error) {
+	return nil, fmt.Errorf("syslog: %w", ErrUnsupported)
+}
@@ -0,0 +1,92 @@
+// Package methodoverride provides an HTTP middleware handler translating POST
+// requests into PUT, PATCH or DELETE requests as directed by the
+// X-HTTP-Method-Override header or a form field, for clients such as HTML
+// forms that can only send GET and POST requests.
+package methodoverride
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+const Header = "X-HTTP-Method-Override"
+
+var (
+	ErrInvalidMethod = errors.New("invalid method override")
+
+	logger = common.NewLogger("methodoverride")
+)
+
+// Config configures the sources of the overriding method. The header is
+// always honored.
+type Config struct {
+	// FormField is the name of the form field holding the overriding method,
+	// such as "_method", or empty to ignore forms. The form is parsed when
+	// the field is read, so the parsed values remain available to handlers
+	// through the request's PostForm field.
+	FormField string
+}
+
+type Dependencies interface {
+	HandleStatusBadRequest(http.ResponseWriter, *http.Request, error)
+}
+
+func NewMiddlewareHandler(deps Dependencies, next http.Handler, config Config) common.MiddlewareHandler {
+	return &handler{deps: deps, next: next, config: config}
+}
+
+type handler struct {
+	deps   Dependencies
+	next   http.Handler
+	config Config
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	override := r.Header.Get(Header)
+	if override == "" && h.config.FormField != "" && isForm(r) {
+		override = r.PostFormValue(h.config.FormField)
+	}
+	if override == "" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	method := strings.ToUpper(strings.TrimSpace(override))
+	switch method {
+	case http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		logger.Debug("ServeHTTP", "Rejecting override of POST %s with %q", r.URL.Path, override)
+		h.deps.HandleStatusBadRequest(w, r, fmt.Errorf("%w: %q", ErrInvalidMethod, override))
+		return
+	}
+	// The request is copied, keeping any parsed form.
+	r = r.WithContext(r.Context())
+	r.Method = method
+	h.next.ServeHTTP(w, r)
+}
+
+// isForm reports whether r carries a form body, so that other bodies are
+// left unread.
+func isForm(r *http.Request) bool {
+	contentType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+	switch strings.ToLower(strings.TrimSpace(contentType)) {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+// Package metrics defines the abstraction through which sudsy reports
+// operational metrics. Exporters implement Recorder.
+package metrics
+
+import (
+	"maps"
+	"sync"
+)
+
+type Labels map[string]string
+
+type Recorder interface {
+	// AddCounter adds delta to the counter identified by name and labels.
+	AddCounter(name string, labels Labels, delta float64)
+	// SetGauge sets the gauge identified by name and labels to value.
+	SetGauge(name string, labels Labels, value float64)
+	// Observe records value in the distribution identified by name and labels.
+	Observe(name string, labels Labels, value float64)
+}
+
+func NewNoopRecorder() Recorder {
+	return noopRecorder{}
+}
+
+type noopRecorder struct{}
+
+// AddCounter implements Recorder.
+func (noopRecorder) AddCounter(string, Labels, float64) {}
+
+// SetGauge implements Recorder.
+func (noopRecorder) SetGauge(string, Labels, float64) {}
+
+// Observe implements Recorder.
+func (noopRecorder) Observe(string, Labels, float64) {}
+
+// OtherLabelValue replaces the values of limited labels beyond their limit.
+const OtherLabelValue = "other"
+
+// DefaultLabelValueLimits returns the default number of distinct values of
+// the labels whose values derive from requests, so that clients requesting
+// random paths or hosts cannot create unbounded numbers of series.
+func DefaultLabelValueLimits() map[string]int {
+	return map[string]int{
+		"host":   1000,
+		"route":  1000,
+		"tenant": 1000,
+	}
+}
+
+// NewCardinalityGuard returns a Recorder passing metrics to next, with the
+// values of the labels in limits replaced with OtherLabelValue once the label
+// has had as many distinct values as its limit.
+func NewCardinalityGuard(next Recorder, limits map[string]int) Recorder {
+	seen := make(map[string]map[string]struct{}, len(limits))
+	for name := range limits {
+		seen[name] = map[string]struct{}{}
+	}
+	return &cardinalityGuard{next: next, limits: limits, seen: seen}
+}
+
+type cardinalityGuard struct {
+	next   Recorder
+	limits map[string]int
+
+	locker sync.RWMutex
+	seen   map[string]map[string]struct{}
+}
+
+// AddCounter implements Recorder.
+func (g *cardinalityGuard) AddCounter(name string, labels Labels, delta float64) {
+	g.next.AddCounter(name, g.guard(labels), delta)
+}
+
+// SetGauge implements Recorder.
+func (g *cardinalityGuard) SetGauge(name string, labels Labels, value float64) {
+	g.next.SetGauge(name, g.guard(labels), value)
+}
+
+// Observe implements Recorder.
+func (g *cardinalityGuard) Observe(name string, labels Labels, value float64) {
+	g.next.Observe(name, g.guard(labels), value)
+}
+
+// guard returns labels with the values beyond their label's limit replaced,
+// copying labels if any is.
+func (g *cardinalityGuard) guard(labels Labels) Labels {
+	result := labels
+	copied := false
+	for name, value := range labels {
+		if _, limited := g.limits[name]; !limited || value == OtherLabelValue || g.admit(name, value) {
+			continue
+		}
+		if !copied {
+			result = maps.Clone(labels)
+			copied = true
+		}
+		result[name] = OtherLabelValue
+	}
+	return result
+}
+
+// admit reports whether value is among the values recorded for the label,
+// recording it if the label is within its limit.
+func (g *cardinalityGuard) admit(name, value string) bool {
+	g.locker.RLock()
+	_, found := g.seen[name][value]
+	g.locker.RUnlock()
+	if found {
+		return true
+	}
+	g.locker.Lock()
+	defer g.locker.Unlock()
+	values := g.seen[name]
+	if _, found := values[value]; found {
+		return true
+	}
+	if len(values) >= g.limits[name] {
+		return false
+	}
+	values[value] = struct{}{}
+	return true
+}
@@ -0,0 +1,211 @@
+package metrics
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TagStyle selects how a StatsD recorder encodes labels.
+type TagStyle int
+
+const (
+	// TagStyleDatadog appends labels as DogStatsD tags, e.g.
+	// "requests:1|c|#section:/api/".
+	TagStyleDatadog TagStyle = iota
+	// TagStyleInfluxDB appends labels to the metric name, as understood by
+	// Telegraf, e.g. "requests,section=/api/:1|c".
+	TagStyleInfluxDB
+	// TagStyleNone drops labels, for servers without tag support.
+	TagStyleNone
+)
+
+const (
+	defaultStatsDAddress       = "127.0.0.1:8125"
+	defaultStatsDFlushInterval = time.Second
+	// defaultStatsDMaxPacketSize fits in an Ethernet frame with IPv6 and UDP
+	// headers.
+	defaultStatsDMaxPacketSize = 1432
+)
+
+// StatsDConfig configures a StatsD recorder.
+type StatsDConfig struct {
+	// Address is the host and UDP port of the server, "127.0.0.1:8125" if
+	// empty.
+	Address string
+	// Prefix is prepended to the metric names, e.g. "myapp.".
+	Prefix   string
+	TagStyle TagStyle
+	// FlushInterval is how often buffered metrics are sent, every second if
+	// zero. Metrics are also sent once they fill a packet.
+	FlushInterval time.Duration
+	// MaxPacketSize is the size in bytes of the largest packet sent, 1432 if
+	// zero.
+	MaxPacketSize int
+}
+
+// StatsDRecorder is a Recorder pushing metrics to a StatsD server, such as
+// the Datadog agent or Telegraf. Counters, gauges and observations are sent
+// as "c", "g" and "h" metrics.
+type StatsDRecorder struct {
+	config StatsDConfig
+	conn   net.Conn
+
+	locker sync.Mutex
+	buf    []byte
+
+	done        chan struct{}
+	flushLoopWG sync.WaitGroup
+}
+
+// NewStatsDRecorder returns a recorder sending metrics to the server, which
+// must be closed to send the last buffered metrics.
+func NewStatsDRecorder(c StatsDConfig) (*StatsDRecorder, error) {
+	if c.Address == "" {
+		c.Address = defaultStatsDAddress
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = defaultStatsDFlushInterval
+	}
+	if c.MaxPacketSize <= 0 {
+		c.MaxPacketSize = defaultStatsDMaxPacketSize
+	}
+	conn, err := net.Dial("udp", c.Address)
+	if err != nil {
+		return nil, err
+	}
+	r := &StatsDRecorder{
+		config: c,
+		conn:   conn,
+		buf:    make([]byte, 0, c.MaxPacketSize),
+		done:   make(chan struct{}),
+	}
+	r.flushLoopWG.Add(1)
+	go r.flushLoop()
+	return r, nil
+}
+
+// AddCounter implements Recorder.
+func (r *StatsDRecorder) AddCounter(name string, labels Labels, delta float64) {
+	r.record(name, labels, delta, "c")
+}
+
+// SetGauge implements Recorder.
+func (r *StatsDRecorder) SetGauge(name string, labels Labels, value float64) {
+	if value < 0 && r.config.TagStyle != TagStyleDatadog {
+		// Other servers take signed values as changes of the gauge, so it
+		// is reset first.
+		r.record(name, labels, 0, "g")
+	}
+	r.record(name, labels, value, "g")
+}
+
+// Observe implements Recorder.
+func (r *StatsDRecorder) Observe(name string, labels Labels, value float64) {
+	r.record(name, labels, value, "h")
+}
+
+// Close sends the buffered metrics and closes the connection.
+func (r *StatsDRecorder) Close() error {
+	close(r.done)
+	r.flushLoopWG.Wait()
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.flush()
+	return r.conn.Close()
+}
+
+func (r *StatsDRecorder) record(name string, labels Labels, value float64, metricType string) {
+	line := r.appendLine(make([]byte, 0, 128), name, labels, value, metricType)
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if len(r.buf) > 0 && len(r.buf)+1+len(line) > r.config.MaxPacketSize {
+		r.flush()
+	}
+	if len(r.buf) > 0 {
+		r.buf = append(r.buf, '\n')
+	}
+	r.buf = append(r.buf, line...)
+}
+
+// appendLine appends the line of a metric in the configured tag style.
+func (r *StatsDRecorder) appendLine(b []byte, name string, labels Labels, value float64, metricType string) []byte {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	b = append(b, r.config.Prefix...)
+	b = appendSanitized(b, name)
+	if r.config.TagStyle == TagStyleInfluxDB {
+		for _, k := range names {
+			b = append(b, ',')
+			b = appendSanitized(b, k)
+			b = append(b, '=')
+			b = appendSanitized(b, labels[k])
+		}
+	}
+	b = append(b, ':')
+	b = strconv.AppendFloat(b, value, 'f', -1, 64)
+	b = append(b, '|')
+	b = append(b, metricType...)
+	if r.config.TagStyle == TagStyleDatadog && len(names) > 0 {
+		b = append(b, "|#"...)
+		for i, k := range names {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendSanitized(b, k)
+			b = append(b, ':')
+			b = appendSanitized(b, labels[k])
+		}
+	}
+	return b
+}
+
+// appendSanitized appends s with the characters delimiting the parts of a
+// line replaced with underscores.
+func appendSanitized(b []byte, s string) []byte {
+	if !strings.ContainsAny(s, ":|,#= \n") {
+		return append(b, s...)
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case ':', '|', ',', '#', '=', ' ', '\n':
+			b = append(b, '_')
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+func (r *StatsDRecorder) flushLoop() {
+	defer r.flushLoopWG.Done()
+	ticker := time.NewTicker(r.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.locker.Lock()
+			r.flush()
+			r.locker.Unlock()
+		}
+	}
+}
+
+// flush sends the buffered metrics. The locker must be held.
+func (r *StatsDRecorder) flush() {
+	if len(r.buf) == 0 {
+		return
+	}
+	// Metrics are dropped if the server is unreachable, like StatsD
+	// clients do.
+	_, _ = r.conn.Write(r.buf)
+	r.buf = r.buf[:0]
+}
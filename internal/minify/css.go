@@ -0,0 +1,82 @@
+package minify
+
+import (
+	"bytes"
+	"strings"
+)
+
+// cssSeparators are the characters around which whitespace is insignificant.
+// Whitespace is only insignificant after colons, since it separates a
+// descendant's pseudo-class from its ancestor in selectors.
+const (
+	cssSeparators    = "{};,>"
+	cssSeparatorsEnd = cssSeparators + ":"
+)
+
+// CSS minifies a style sheet, removing comments and whitespace that does not
+// separate tokens, as well as semicolons ending blocks.
+func CSS(src []byte) []byte {
+	out := make([]byte, 0, len(src))
+	// space records whitespace or comments pending before the next token.
+	space := false
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case isSpace(c):
+			space = true
+			i++
+			continue
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += end + 4
+			}
+			// Comments separate tokens like whitespace.
+			space = true
+			continue
+		}
+		if space && len(out) > 0 &&
+			!strings.ContainsRune(cssSeparatorsEnd, rune(out[len(out)-1])) &&
+			!strings.ContainsRune(cssSeparators, rune(c)) {
+			out = append(out, ' ')
+		}
+		space = false
+		switch c {
+		case '"', '\'':
+			end := quotedEnd(src, i)
+			out = append(out, src[i:end]...)
+			i = end
+		case '}':
+			if len(out) > 0 && out[len(out)-1] == ';' {
+				out = out[:len(out)-1]
+			}
+			out = append(out, c)
+			i++
+		default:
+			out = append(out, c)
+			i++
+		}
+	}
+	return out
+}
+
+// quotedEnd returns the index following the string starting with the quote
+// at src[start], or len(src) if it is not terminated.
+func quotedEnd(src []byte, start int) int {
+	quote := src[start]
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		}
+	}
+	return len(src)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,161 @@
+package minify
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+)
+
+// rawElements are the elements whose content is not markup.
+var rawElements = []string{"pre", "script", "style", "textarea"}
+
+var scriptTypes = []string{"", "application/javascript", "module", "text/javascript"}
+
+// HTML minifies a document, removing comments other than conditional ones and
+// collapsing whitespace between tags, except in pre and textarea elements.
+// Tags are left as is. Inline scripts and style sheets are minified.
+func HTML(src []byte) []byte {
+	out := make([]byte, 0, len(src))
+	space := false
+	for i := 0; i < len(src); {
+		c := src[i]
+		if isSpace(c) {
+			space = true
+			i++
+			continue
+		}
+		if bytes.HasPrefix(src[i:], []byte("<!--")) && !bytes.HasPrefix(src[i:], []byte("<!--[")) {
+			end := bytes.Index(src[i+4:], []byte("-->"))
+			if end < 0 {
+				// Unterminated comments are left as is.
+				out = append(out, src[i:]...)
+				break
+			}
+			i += end + 7
+			continue
+		}
+		if space && len(out) > 0 {
+			// Runs of whitespace render as a single space.
+			out = append(out, ' ')
+		}
+		space = false
+		if c != '<' {
+			out = append(out, c)
+			i++
+			continue
+		}
+		end := tagEnd(src, i)
+		tag := src[i:end]
+		out = append(out, tag...)
+		i = end
+		name := tagName(tag)
+		if !slices.Contains(rawElements, name) || bytes.HasSuffix(tag, []byte("/>")) {
+			continue
+		}
+		contentEnd := closingTagIndex(src, i, name)
+		content := src[i:contentEnd]
+		switch {
+		case name == "script" && slices.Contains(scriptTypes, strings.ToLower(attribute(tag, "type"))):
+			content = bytes.TrimSpace(JS(content))
+		case name == "style":
+			content = bytes.TrimSpace(CSS(content))
+		}
+		out = append(out, content...)
+		i = contentEnd
+	}
+	return out
+}
+
+// tagEnd returns the index following the tag starting at src[start], skipping
+// quoted attribute values, or len(src) if it is not terminated.
+func tagEnd(src []byte, start int) int {
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case '"', '\'':
+			i = attributeValueEnd(src, i) - 1
+		case '>':
+			return i + 1
+		}
+	}
+	return len(src)
+}
+
+// attributeValueEnd returns the index following the attribute value starting
+// with the quote at src[start], or len(src) if it is not terminated.
+func attributeValueEnd(src []byte, start int) int {
+	if end := bytes.IndexByte(src[start+1:], src[start]); end >= 0 {
+		return start + end + 2
+	}
+	return len(src)
+}
+
+// tagName returns the lowercase name of an opening tag, or "" for other
+// markup.
+func tagName(tag []byte) string {
+	end := 1
+	for end < len(tag) && isIdentifierByte(tag[end]) {
+		end++
+	}
+	return strings.ToLower(string(tag[1:end]))
+}
+
+// closingTagIndex returns the index of the closing tag of the element named
+// name whose content starts at src[start], or len(src) if there is none.
+func closingTagIndex(src []byte, start int, name string) int {
+	closing := []byte("</" + name)
+	for i := start; i < len(src); i++ {
+		if src[i] == '<' && len(src)-i >= len(closing) && bytes.EqualFold(src[i:i+len(closing)], closing) {
+			return i
+		}
+	}
+	return len(src)
+}
+
+// attribute returns the value of the attribute named name in tag, or "" if
+// the tag lacks it.
+func attribute(tag []byte, name string) string {
+	// Skip the tag name.
+	i := 1
+	for i < len(tag) && !isSpace(tag[i]) && tag[i] != '>' {
+		i++
+	}
+	for i < len(tag) {
+		for i < len(tag) && (isSpace(tag[i]) || tag[i] == '/') {
+			i++
+		}
+		nameStart := i
+		for i < len(tag) && !isSpace(tag[i]) && tag[i] != '=' && tag[i] != '>' && tag[i] != '/' {
+			i++
+		}
+		attrName := string(tag[nameStart:i])
+		if attrName == "" {
+			return ""
+		}
+		for i < len(tag) && isSpace(tag[i]) {
+			i++
+		}
+		value := ""
+		if i < len(tag) && tag[i] == '=' {
+			i++
+			for i < len(tag) && isSpace(tag[i]) {
+				i++
+			}
+			switch {
+			case i < len(tag) && (tag[i] == '"' || tag[i] == '\''):
+				end := attributeValueEnd(tag, i)
+				value = string(tag[i+1 : max(end-1, i+1)])
+				i = end
+			default:
+				valueStart := i
+				for i < len(tag) && !isSpace(tag[i]) && tag[i] != '>' {
+					i++
+				}
+				value = string(tag[valueStart:i])
+			}
+		}
+		if strings.EqualFold(attrName, name) {
+			return value
+		}
+	}
+	return ""
+}
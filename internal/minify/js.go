@@ -0,0 +1,183 @@
+package minify
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+)
+
+// jsSeparators are the characters around which spaces are insignificant.
+// Newlines are kept unless they follow jsLineEnds, since removing them could
+// change where semicolons are inserted.
+const (
+	jsSeparators = "{}()[];,:="
+	jsLineEnds   = "{;,"
+)
+
+// jsRegexpPrecedents are the characters after which a slash starts a regular
+// expression rather than a division.
+const jsRegexpPrecedents = "(,=:[!&|?{};+-*%<>~^"
+
+var jsRegexpKeywords = []string{
+	"await", "case", "delete", "do", "else", "in", "instanceof", "new",
+	"of", "return", "throw", "typeof", "void", "yield",
+}
+
+// JS minifies a script, removing comments and collapsing whitespace while
+// leaving strings, template literals and regular expressions intact.
+func JS(src []byte) []byte {
+	out := make([]byte, 0, len(src))
+	// braces tracks the open braces, true for those opening a substitution
+	// in a template literal.
+	var braces []bool
+	// space and newline record whitespace or comments pending before the
+	// next token.
+	space, newline := false, false
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case isSpace(c):
+			space = true
+			newline = newline || c == '\n' || c == '\r'
+			i++
+			continue
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			end := bytes.IndexAny(src[i:], "\r\n")
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += end
+			}
+			space = true
+			continue
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				end = len(src) - i - 2
+			}
+			// Comments spanning lines count as line terminators.
+			newline = newline || bytes.ContainsAny(src[i+2:i+2+end], "\r\n")
+			i = min(i+end+4, len(src))
+			space = true
+			continue
+		}
+		if space && len(out) > 0 {
+			prev := out[len(out)-1]
+			switch {
+			case newline && !strings.ContainsRune(jsLineEnds, rune(prev)):
+				out = append(out, '\n')
+			case !newline && !strings.ContainsRune(jsSeparators, rune(prev)) &&
+				!strings.ContainsRune(jsSeparators, rune(c)):
+				out = append(out, ' ')
+			}
+		}
+		space, newline = false, false
+		switch {
+		case c == '"' || c == '\'':
+			end := quotedEnd(src, i)
+			out = append(out, src[i:end]...)
+			i = end
+		case c == '`':
+			end, substitution := templateEnd(src, i+1)
+			out = append(out, src[i:end]...)
+			i = end
+			if substitution {
+				braces = append(braces, true)
+			}
+		case c == '{':
+			braces = append(braces, false)
+			out = append(out, c)
+			i++
+		case c == '}' && len(braces) > 0 && braces[len(braces)-1]:
+			// The substitution ends, and the template literal resumes.
+			braces = braces[:len(braces)-1]
+			end, substitution := templateEnd(src, i+1)
+			out = append(out, src[i:end]...)
+			i = end
+			if substitution {
+				braces = append(braces, true)
+			}
+		case c == '}':
+			if len(braces) > 0 {
+				braces = braces[:len(braces)-1]
+			}
+			out = append(out, c)
+			i++
+		case c == '/' && jsRegexpAllowed(out):
+			end := regexpEnd(src, i)
+			out = append(out, src[i:end]...)
+			i = end
+		default:
+			out = append(out, c)
+			i++
+		}
+	}
+	return out
+}
+
+// templateEnd returns the index following the end of the template literal
+// text starting at src[start], which is either the closing backtick or the
+// "${" opening a substitution, in which case substitution is true.
+func templateEnd(src []byte, start int) (end int, substitution bool) {
+	for i := start; i < len(src); i++ {
+		switch {
+		case src[i] == '\\':
+			i++
+		case src[i] == '`':
+			return i + 1, false
+		case src[i] == '$' && i+1 < len(src) && src[i+1] == '{':
+			return i + 2, true
+		}
+	}
+	return len(src), false
+}
+
+// regexpEnd returns the index following the regular expression literal
+// starting at src[start], excluding its flags, or the end of the line if it
+// is not terminated.
+func regexpEnd(src []byte, start int) int {
+	class := false
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '[':
+			class = true
+		case ']':
+			class = false
+		case '/':
+			if !class {
+				return i + 1
+			}
+		case '\n', '\r':
+			return i
+		}
+	}
+	return len(src)
+}
+
+// jsRegexpAllowed reports whether a slash following out starts a regular
+// expression.
+func jsRegexpAllowed(out []byte) bool {
+	end := len(out)
+	for end > 0 && isSpace(out[end-1]) {
+		end--
+	}
+	if end >= 2 && (string(out[end-2:end]) == "++" || string(out[end-2:end]) == "--") {
+		// Postfix operators precede divisions.
+		return false
+	}
+	if end == 0 || strings.ContainsRune(jsRegexpPrecedents, rune(out[end-1])) {
+		return true
+	}
+	start := end
+	for start > 0 && isIdentifierByte(out[start-1]) {
+		start--
+	}
+	return slices.Contains(jsRegexpKeywords, string(out[start:end]))
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
@@ -0,0 +1,73 @@
+// Package minify provides a response mutator minifying buffered HTML, CSS and
+// JavaScript responses. The minifiers are conservative: they remove comments
+// and collapse whitespace, leaving anything they cannot safely rewrite as is.
+package minify
+
+import (
+	"mime"
+	"net/http"
+	"slices"
+
+	"github.com/jakewan/sudsy/internal/buffering"
+	"github.com/jakewan/sudsy/internal/urlpathpatternhandler"
+)
+
+// DefaultMinSize is the size below which responses are not minified, unless
+// configured otherwise.
+const DefaultMinSize = 1024
+
+var minifiers = map[string]func([]byte) []byte{
+	"text/html":              HTML,
+	"text/css":               CSS,
+	"text/javascript":        JS,
+	"application/javascript": JS,
+}
+
+// Config configures the minification of responses.
+type Config struct {
+	// MinSize is the size in bytes below which responses are left as is.
+	// Zero selects DefaultMinSize.
+	MinSize int
+	// ContentTypes restricts minification to the given media types, among
+	// text/html, text/css, text/javascript and application/javascript. Empty
+	// means all of them.
+	ContentTypes []string
+	// ExcludePatterns lists the request paths whose responses are left as
+	// is, matched like path patterns against the full request path, e.g.
+	// "/downloads/*".
+	ExcludePatterns []string
+}
+
+// NewMutator returns a buffering.Mutator minifying the responses selected by
+// config. The media type of responses lacking a Content-Type header is
+// detected from their body.
+func NewMutator(config Config) buffering.Mutator {
+	if config.MinSize <= 0 {
+		config.MinSize = DefaultMinSize
+	}
+	return func(r *http.Request, resp *buffering.Response) {
+		if len(resp.Body) < config.MinSize || resp.Header.Get("content-encoding") != "" {
+			return
+		}
+		for _, p := range config.ExcludePatterns {
+			if urlpathpatternhandler.MatchPattern(p, urlpathpatternhandler.RoutingPath(r.URL)) {
+				return
+			}
+		}
+		contentType := resp.Header.Get("content-type")
+		if contentType == "" {
+			contentType = http.DetectContentType(resp.Body)
+			// The minified body must not be sniffed differently.
+			resp.Header.Set("content-type", contentType)
+		}
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return
+		}
+		minify, ok := minifiers[mediaType]
+		if !ok || (len(config.ContentTypes) > 0 && !slices.Contains(config.ContentTypes, mediaType)) {
+			return
+		}
+		resp.Body = minify(resp.Body)
+	}
+}
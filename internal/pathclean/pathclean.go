@@ -0,0 +1,81 @@
+// Package pathclean provides an HTTP middleware handler canonicalizing request
+// paths before routing, so that dot-segments and duplicate slashes neither
+// break pattern matching nor reach handlers serving files.
+package pathclean
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+var (
+	ErrNonCanonicalPath = errors.New("non-canonical request path")
+
+	logger = common.NewLogger("pathclean")
+)
+
+// Clean returns the canonical form of requestPath, rooted, without "." and
+// ".." segments or duplicate slashes, keeping a trailing slash so that it can
+// be handled by the section's trailing slash policy, e.g. "//a/../b/" becomes
+// "/b/".
+func Clean(requestPath string) string {
+	if requestPath == "" {
+		return "/"
+	}
+	result := path.Clean("/" + requestPath)
+	if strings.HasSuffix(requestPath, "/") && result != "/" {
+		result += "/"
+	}
+	return result
+}
+
+type Dependencies interface {
+	HandleStatusBadRequest(http.ResponseWriter, *http.Request, error)
+}
+
+// NewMiddlewareHandler returns a handler passing requests to next with their
+// path cleaned, or, if reject is true, passing requests with non-canonical
+// paths to the bad request handler instead.
+func NewMiddlewareHandler(deps Dependencies, next http.Handler, reject bool) common.MiddlewareHandler {
+	return &handler{deps: deps, next: next, reject: reject}
+}
+
+type handler struct {
+	deps   Dependencies
+	next   http.Handler
+	reject bool
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cleaned := Clean(r.URL.Path)
+	if cleaned == r.URL.Path {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	if h.reject {
+		logger.Debug("ServeHTTP", "Rejecting non-canonical path %q", r.URL.Path)
+		h.deps.HandleStatusBadRequest(w, r, fmt.Errorf("%w: %q", ErrNonCanonicalPath, r.URL.Path))
+		return
+	}
+	logger.Debug("ServeHTTP", "Cleaned path %q to %q", r.URL.Path, cleaned)
+	u := *r.URL
+	u.Path = cleaned
+	// The escaped form is derived from the cleaned path.
+	u.RawPath = ""
+	r = r.WithContext(r.Context())
+	r.URL = &u
+	h.next.ServeHTTP(w, r)
+}
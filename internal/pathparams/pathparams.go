@@ -0,0 +1,106 @@
+// Package pathparams carries the values captured from request paths by route
+// patterns in request contexts, and converts them to typed values.
+package pathparams
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidParam = errors.New("invalid path parameter")
+	ErrMissingParam = errors.New("missing path parameter")
+)
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the captured values, keyed by
+// capture token as returned by urlpathpatternhandler.Handler.Params.
+func NewContext(ctx context.Context, params map[string]string) context.Context {
+	return context.WithValue(ctx, contextKey{}, params)
+}
+
+// FromContext returns the captured values stored in ctx, if any.
+func FromContext(ctx context.Context) map[string]string {
+	params, _ := ctx.Value(contextKey{}).(map[string]string)
+	return params
+}
+
+// String returns the value captured by the token named name, given with or
+// without its leading ":" or "*", from params keyed by capture token or by
+// variable name.
+func String(params map[string]string, name string) (string, error) {
+	name = strings.TrimLeft(name, ":*")
+	for _, key := range [...]string{name, ":" + name, "*" + name} {
+		if v, ok := params[key]; ok {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrMissingParam, name)
+}
+
+// Int returns the named value as an int.
+func Int(params map[string]string, name string) (int, error) {
+	raw, err := String(params, name)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %w", ErrInvalidParam, name, err)
+	}
+	return v, nil
+}
+
+// Int64 returns the named value as an int64.
+func Int64(params map[string]string, name string) (int64, error) {
+	raw, err := String(params, name)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %w", ErrInvalidParam, name, err)
+	}
+	return v, nil
+}
+
+// UUID is a universally unique identifier, see RFC 9562.
+type UUID [16]byte
+
+// ParseUUID parses the hyphenated hexadecimal form of a UUID, e.g.
+// "f81d4fae-7dec-11d0-a765-00a0c91e6bf6", in either case.
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, errors.New("not a hyphenated UUID")
+	}
+	digits := s[:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+	if _, err := hex.Decode(u[:], []byte(digits)); err != nil {
+		return UUID{}, err
+	}
+	return u, nil
+}
+
+// String returns the lowercase hyphenated form of the UUID.
+func (u UUID) String() string {
+	s := hex.EncodeToString(u[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// UUIDParam returns the named value as a UUID.
+func UUIDParam(params map[string]string, name string) (UUID, error) {
+	raw, err := String(params, name)
+	if err != nil {
+		return UUID{}, err
+	}
+	v, err := ParseUUID(raw)
+	if err != nil {
+		return UUID{}, fmt.Errorf("%w: %s: %w", ErrInvalidParam, name, err)
+	}
+	return v, nil
+}
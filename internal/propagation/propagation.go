@@ -0,0 +1,269 @@
+// Package propagation carries correlation data, such as the request ID and
+// trace context, from inbound requests to the outbound requests made while
+// serving them.
+package propagation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/sampling"
+)
+
+const (
+	HeaderBaggage     = "baggage"
+	HeaderRequestID   = "x-request-id"
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+
+	// maxRequestIDLength bounds client supplied request IDs, which are
+	// replaced when longer.
+	maxRequestIDLength = 128
+	// maxBaggageLength bounds baggage headers, which are not propagated
+	// when longer, see the W3C Baggage specification.
+	maxBaggageLength = 8192
+)
+
+var logger = common.NewLogger("propagation")
+
+// Values holds the correlation data of an inbound request.
+type Values struct {
+	RequestID   string
+	TraceParent string
+	TraceState  string
+	// Sampled is the decision of the trace sampler, if any, which is also
+	// reflected in the flags of TraceParent.
+	Sampled bool
+	// Headers holds the inbound headers configured for propagation, keyed by
+	// canonical name.
+	Headers http.Header
+}
+
+// Config configures the middleware handler.
+type Config struct {
+	// Headers lists the inbound headers copied into the request context and
+	// propagated to outbound requests, e.g. "baggage" or "Accept-Language".
+	Headers []string
+	// Sampler samples the trace context, as described for Sample, when set.
+	Sampler sampling.Sampler
+}
+
+type contextKey struct{}
+
+// FromContext returns the values stored in ctx by the middleware handler.
+func FromContext(ctx context.Context) (Values, bool) {
+	v, ok := ctx.Value(contextKey{}).(Values)
+	return v, ok
+}
+
+// NewContext returns a copy of ctx carrying v.
+func NewContext(ctx context.Context, v Values) context.Context {
+	return context.WithValue(ctx, contextKey{}, v)
+}
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		logger.Debug("NewRequestID", "Error reading random bytes: %s", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewMiddlewareHandler returns a handler storing the request's correlation
+// data in its context. Requests without a usable X-Request-ID header are
+// assigned a new ID, which is echoed in the response.
+func NewMiddlewareHandler(next http.Handler, config Config) common.MiddlewareHandler {
+	headers := make([]string, 0, len(config.Headers))
+	for _, name := range config.Headers {
+		headers = append(headers, http.CanonicalHeaderKey(name))
+	}
+	return &handler{next: next, headers: headers, sampler: config.Sampler}
+}
+
+type handler struct {
+	next    http.Handler
+	headers []string
+	sampler sampling.Sampler
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v := Values{
+		RequestID:   r.Header.Get(HeaderRequestID),
+		TraceParent: r.Header.Get(HeaderTraceParent),
+		TraceState:  r.Header.Get(HeaderTraceState),
+	}
+	if v.RequestID == "" || len(v.RequestID) > maxRequestIDLength {
+		v.RequestID = NewRequestID()
+	}
+	for _, name := range h.headers {
+		values := r.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		if name == "Baggage" && len(strings.Join(values, ",")) > maxBaggageLength {
+			logger.Debug("ServeHTTP", "Dropping baggage exceeding %d bytes", maxBaggageLength)
+			continue
+		}
+		if v.Headers == nil {
+			v.Headers = make(http.Header, len(h.headers))
+		}
+		v.Headers[name] = values
+	}
+	if h.sampler != nil {
+		v = Sample(r, v, h.sampler)
+	}
+	w.Header().Set(HeaderRequestID, v.RequestID)
+	h.next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), v)))
+}
+
+// Sample returns v with the sampling decision of sampler for r. The trace
+// context of r is continued with the sampled flag set accordingly, or a new
+// trace is started if r lacks a valid one.
+func Sample(r *http.Request, v Values, sampler sampling.Sampler) Values {
+	p := sampling.Parameters{Request: r}
+	traceID, parentID, flags, ok := parseTraceParent(r.Header.Get(HeaderTraceParent))
+	if ok {
+		p.HasParent = true
+		p.ParentSampled = flags&flagSampled != 0
+	} else if traceID, parentID, flags, ok = parseTraceParent(v.TraceParent); !ok {
+		// The request starts a new trace, unless it was started when the
+		// request was sampled before, e.g. by the application before a route
+		// overrides its sampler.
+		_, _ = rand.Read(traceID[:])
+		_, _ = rand.Read(parentID[:])
+		flags = 0
+		v.TraceState = ""
+	}
+	p.TraceID = traceID
+	v.Sampled = sampler.ShouldSample(p)
+	if v.Sampled {
+		flags |= flagSampled
+	} else {
+		flags &^= flagSampled
+	}
+	v.TraceParent = fmt.Sprintf("00-%x-%x-%02x", p.TraceID, parentID, flags)
+	return v
+}
+
+// ParseBaggage returns the members of a W3C baggage header, with their values
+// percent-decoded and their properties dropped. Malformed members are
+// skipped.
+func ParseBaggage(s string) map[string]string {
+	result := make(map[string]string)
+	for _, member := range strings.Split(s, ",") {
+		member, _, _ = strings.Cut(member, ";")
+		key, value, found := strings.Cut(member, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			continue
+		}
+		decoded, err := url.PathUnescape(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		result[key] = decoded
+	}
+	return result
+}
+
+const flagSampled = 0x01
+
+// parseTraceParent parses a version 00 traceparent header, see the W3C Trace
+// Context specification. Headers of later versions are parsed as far as
+// version 00 defines them.
+func parseTraceParent(s string) (traceID [16]byte, parentID [8]byte, flags byte, ok bool) {
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' || (len(s) > 55 && s[55] != '-') {
+		return traceID, parentID, 0, false
+	}
+	version, err := hex.DecodeString(s[:2])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(s) != 55) {
+		return traceID, parentID, 0, false
+	}
+	var flagsBytes [1]byte
+	for _, part := range []struct {
+		dst []byte
+		src string
+	}{
+		{traceID[:], s[3:35]},
+		{parentID[:], s[36:52]},
+		{flagsBytes[:], s[53:55]},
+	} {
+		// Only lowercase hexadecimal digits are valid.
+		if strings.ToLower(part.src) != part.src {
+			return traceID, parentID, 0, false
+		}
+		if _, err := hex.Decode(part.dst, []byte(part.src)); err != nil {
+			return traceID, parentID, 0, false
+		}
+	}
+	if traceID == [16]byte{} || parentID == [8]byte{} {
+		return traceID, parentID, 0, false
+	}
+	return traceID, parentID, flagsBytes[0], true
+}
+
+// NewTransport returns a RoundTripper adding the correlation data found in
+// each outbound request's context to its headers, along with an
+// X-Request-Timeout header reflecting the context deadline. Headers already
+// set on the request are left untouched. A nil base uses
+// http.DefaultTransport.
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	v, found := FromContext(ctx)
+	deadline, hasDeadline := ctx.Deadline()
+	if !found && !hasDeadline {
+		return t.base.RoundTrip(r)
+	}
+	// RoundTrippers must not modify the request they are given.
+	r = r.Clone(ctx)
+	setDefault(r.Header, HeaderRequestID, v.RequestID)
+	setDefault(r.Header, HeaderTraceParent, v.TraceParent)
+	setDefault(r.Header, HeaderTraceState, v.TraceState)
+	for name, values := range v.Headers {
+		if len(r.Header.Values(name)) == 0 {
+			r.Header[name] = slices.Clone(values)
+		}
+	}
+	if hasDeadline {
+		if remaining := time.Until(deadline); remaining > 0 {
+			setDefault(r.Header, "x-request-timeout", strconv.FormatFloat(remaining.Seconds(), 'f', 3, 64))
+		}
+	}
+	return t.base.RoundTrip(r)
+}
+
+func setDefault(h http.Header, key, value string) {
+	if value != "" && h.Get(key) == "" {
+		h.Set(key, value)
+	}
+}
@@ -0,0 +1,125 @@
+// Package query provides typed query parameter parsing and an HTTP
+// middleware handler enforcing limits on query strings.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+var (
+	ErrDuplicateKey      = errors.New("duplicate query parameter")
+	ErrInvalidParam      = errors.New("invalid query parameter")
+	ErrMalformedQuery    = errors.New("malformed query string")
+	ErrQueryTooLong      = errors.New("query string too long")
+	ErrTooManyParameters = errors.New("too many query parameters")
+
+	logger = common.NewLogger("query")
+)
+
+// Limits configures query string validation. Zero values disable the
+// corresponding check.
+type Limits struct {
+	// MaxLength is the maximum length of the raw query string.
+	MaxLength int
+	// MaxParams is the maximum number of parameters, counting repeated keys
+	// once per occurrence.
+	MaxParams int
+	// RejectDuplicateKeys rejects queries repeating a key.
+	RejectDuplicateKeys bool
+	// Strict rejects query strings that do not parse cleanly, e.g. due to
+	// invalid percent-encoding or semicolon separators.
+	Strict bool
+}
+
+// Validate checks the raw query string against the limits. The returned error
+// wraps one of the package's error values.
+func Validate(rawQuery string, limits Limits) error {
+	if limits.MaxLength > 0 && len(rawQuery) > limits.MaxLength {
+		return fmt.Errorf("%w: %d bytes", ErrQueryTooLong, len(rawQuery))
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil && limits.Strict {
+		return fmt.Errorf("%w: %w", ErrMalformedQuery, err)
+	}
+	count := 0
+	for key, v := range values {
+		count += len(v)
+		if limits.RejectDuplicateKeys && len(v) > 1 {
+			return fmt.Errorf("%w: %s", ErrDuplicateKey, key)
+		}
+	}
+	if limits.MaxParams > 0 && count > limits.MaxParams {
+		return fmt.Errorf("%w: %d parameters", ErrTooManyParameters, count)
+	}
+	return nil
+}
+
+// Int returns the named parameter as an int, or defaultValue when absent.
+func Int(values url.Values, name string, defaultValue int) (int, error) {
+	raw := values.Get(name)
+	if raw == "" {
+		return defaultValue, nil
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %w", ErrInvalidParam, name, err)
+	}
+	return v, nil
+}
+
+// Time returns the named parameter parsed using layout, or defaultValue when
+// absent.
+func Time(values url.Values, name, layout string, defaultValue time.Time) (time.Time, error) {
+	raw := values.Get(name)
+	if raw == "" {
+		return defaultValue, nil
+	}
+	v, err := time.Parse(layout, strings.TrimSpace(raw))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s: %w", ErrInvalidParam, name, err)
+	}
+	return v, nil
+}
+
+type Dependencies interface {
+	HandleStatusBadRequest(http.ResponseWriter, *http.Request, error)
+}
+
+func NewMiddlewareHandler(deps Dependencies, next http.Handler, limits Limits) common.MiddlewareHandler {
+	return &handler{
+		deps:   deps,
+		next:   next,
+		limits: limits,
+	}
+}
+
+type handler struct {
+	deps   Dependencies
+	next   http.Handler
+	limits Limits
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := Validate(r.URL.RawQuery, h.limits); err != nil {
+		logger.Debug("ServeHTTP", "Rejecting request: %s", err)
+		h.deps.HandleStatusBadRequest(w, r, err)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
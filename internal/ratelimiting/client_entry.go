@@ -7,20 +7,53 @@ import (
 type clientEntry struct {
 	sessions      []session
 	lastUpdatedAt time.Time
+	tier          string
+	// retainedBans are the banned sessions of the client's previous tiers,
+	// kept until their bans expire, so that a client cannot escape a ban by
+	// changing tier.
+	retainedBans []retainedBan
 }
 
-func (c clientEntry) isBanned() bool {
-	var timeZero time.Time
-	for _, s := range c.sessions {
-		if s.bannedAt != timeZero {
-			return true
+// retainedBan is a banned session of a tier the client has left.
+type retainedBan struct {
+	tier    string
+	session session
+}
+
+func (c clientEntry) isBanned(t time.Time) bool {
+	_, _, banned := c.activeBan(t)
+	return banned
+}
+
+// activeBan returns the session whose ban of the client at t lasts longest,
+// along with the tier it belongs to. Bans of sessions without a ban duration
+// last until the entry is evicted.
+func (c clientEntry) activeBan(t time.Time) (string, session, bool) {
+	var tier string
+	var result session
+	found := false
+	consider := func(sessionTier string, s session) {
+		if !s.isBanned(t) {
+			return
 		}
+		if !found ||
+			(!result.banExpiresAt().IsZero() &&
+				(s.banExpiresAt().IsZero() || s.banExpiresAt().After(result.banExpiresAt()))) {
+			tier, result = sessionTier, s
+			found = true
+		}
+	}
+	for _, s := range c.sessions {
+		consider(c.tier, s)
 	}
-	return false
+	for _, b := range c.retainedBans {
+		consider(b.tier, b.session)
+	}
+	return tier, result, found
 }
 
-func newClientEntry(t time.Time, sessionConfigs []sessionConfig) clientEntry {
-	logger.Debug("", "Inside newClientEntry")
+func newClientEntry(t time.Time, tier string, sessionConfigs []sessionConfig) clientEntry {
+	hotPathLogger.Debug("", "Inside newClientEntry")
 	s := []session{}
 	for _, c := range sessionConfigs {
 		s = append(s, session{
@@ -31,20 +64,46 @@ func newClientEntry(t time.Time, sessionConfigs []sessionConfig) clientEntry {
 	return clientEntry{
 		sessions:      s,
 		lastUpdatedAt: t,
+		tier:          tier,
 	}
 }
 
+// newTierEntry returns the entry of a client whose tier has changed, starting
+// afresh under the new tier's session configs while keeping its active bans.
+func newTierEntry(existingEntry clientEntry, t time.Time, tier string, sessionConfigs []sessionConfig) clientEntry {
+	result := newClientEntry(t, tier, sessionConfigs)
+	for _, s := range existingEntry.sessions {
+		if s.isBanned(t) {
+			result.retainedBans = append(result.retainedBans, retainedBan{tier: existingEntry.tier, session: s})
+		}
+	}
+	for _, b := range existingEntry.retainedBans {
+		if b.session.isBanned(t) {
+			result.retainedBans = append(result.retainedBans, b)
+		}
+	}
+	return result
+}
+
 func newUpdatedEntry(existingEntry clientEntry, t time.Time) clientEntry {
 	updatedEntry := clientEntry{
 		sessions:      make([]session, 0, len(existingEntry.sessions)),
 		lastUpdatedAt: t,
+		tier:          existingEntry.tier,
+	}
+	for _, b := range existingEntry.retainedBans {
+		if b.session.isBanned(t) {
+			updatedEntry.retainedBans = append(updatedEntry.retainedBans, b)
+		}
 	}
 	for _, s := range existingEntry.sessions {
 		updatedSession := session{
-			bannedAt:  s.bannedAt,
 			startedAt: s.startedAt,
 			config:    s.config,
 		}
+		if s.isBanned(t) {
+			updatedSession.bannedAt = s.bannedAt
+		}
 		currentSessionLength := t.Sub(s.startedAt)
 		if currentSessionLength >= s.config.sessionDuration {
 			if s.requestCount > s.config.maxRequests {
@@ -58,6 +117,6 @@ func newUpdatedEntry(existingEntry clientEntry, t time.Time) clientEntry {
 		}
 		updatedEntry.sessions = append(updatedEntry.sessions, updatedSession)
 	}
-	logger.Debug("newUpdatedEntry", "updated client entry: %+v", updatedEntry)
+	hotPathLogger.Debug("newUpdatedEntry", "updated client entry: %+v", updatedEntry)
 	return updatedEntry
 }
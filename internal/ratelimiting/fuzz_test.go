@@ -0,0 +1,50 @@
+package ratelimiting
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// FuzzResolveHost parses client-controlled forwarding headers into the key
+// clients are rate limited by, which must never panic and, when resolved,
+// must be an IP address or IPv6 prefix.
+func FuzzResolveHost(f *testing.F) {
+	log.SetOutput(io.Discard)
+	f.Add("192.0.2.1:1234", "", "", "", 0, false)
+	f.Add("192.0.2.1:1234", "203.0.113.7, 198.51.100.2", "", "", 64, false)
+	f.Add("[2001:db8::1]:443", "", `for="[2001:db8:cafe::17]:4711";proto=https, for=unknown`, "", 56, true)
+	f.Add("192.0.2.1:1234", "not-an-ip", "", "", 0, true)
+	f.Add("", "", `for="_hidden", for="\"`, "fe80::1%eth0", 128, false)
+	f.Fuzz(func(t *testing.T, remoteAddr, xForwardedFor, forwarded, fastlyClientIP string, prefixLength int, fallback bool) {
+		h := NewMiddlewareHandler(nil, http.NotFoundHandler()).(*handler)
+		h.SetIPv6PrefixLength(prefixLength)
+		h.SetUseRemoteAddrForInvalidAddress(fallback)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		for name, value := range map[string]string{
+			"x-forwarded-for":  xForwardedFor,
+			"forwarded":        forwarded,
+			"fastly-client-ip": fastlyClientIP,
+		} {
+			if value != "" {
+				r.Header.Set(name, value)
+			}
+		}
+		host, err := h.resolveHost(r)
+		if err != nil {
+			return
+		}
+		if _, err := netip.ParseAddr(host); err == nil {
+			return
+		}
+		if _, err := netip.ParsePrefix(host); err == nil && strings.Contains(host, ":") {
+			return
+		}
+		t.Errorf("resolved invalid host %q", host)
+	})
+}
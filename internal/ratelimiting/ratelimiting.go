@@ -6,13 +6,24 @@ import (
 	"maps"
 	"net"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/realip"
+	"github.com/jakewan/sudsy/internal/tenant"
 )
 
-var logger = common.NewLogger("ratelimiting")
+var (
+	ErrInvalidClientAddress = errors.New("invalid client address")
+
+	logger = common.NewLogger("ratelimiting")
+	// hotPathLogger logs the messages of every request.
+	hotPathLogger = common.NewSampledLogger("ratelimiting")
+)
 
 func NewMiddlewareHandler(deps Dependencies, next http.Handler) MiddlewareHandler {
 	result := handler{
@@ -21,7 +32,9 @@ func NewMiddlewareHandler(deps Dependencies, next http.Handler) MiddlewareHandle
 		remoteHosts:                map[string]clientEntry{},
 		hostCacheLocker:            &sync.Mutex{},
 		sessionConfigs:             []sessionConfig{},
+		tierSessionConfigs:         map[string][]sessionConfig{},
 		hostCacheEntryIdleDuration: 20 * time.Minute,
+		hostCacheGroomingInterval:  10 * time.Second,
 	}
 	return &result
 }
@@ -29,15 +42,110 @@ func NewMiddlewareHandler(deps Dependencies, next http.Handler) MiddlewareHandle
 type Dependencies interface {
 	Now() time.Time
 	HandleStatusBadRequest(http.ResponseWriter, *http.Request, error)
-	HandleStatusTooManyRequests(http.ResponseWriter, *http.Request)
+	HandleStatusTooManyRequests(http.ResponseWriter, *http.Request, RateInfo)
+	// AddCounter and SetGauge report metrics, labeled by the caller.
+	AddCounter(name string, delta float64)
+	SetGauge(name string, value float64)
+}
+
+// Ban describes a client currently being rejected.
+type Ban struct {
+	// Host is the client key, prefixed with the tenant ID when partitioned
+	// by tenant.
+	Host string `json:"host"`
+	RateInfo
+}
+
+// RateInfo describes a client's ban and the session config that triggered it.
+type RateInfo struct {
+	Tier     string    `json:"tier,omitempty"`
+	BannedAt time.Time `json:"bannedAt"`
+	// ExpiresAt is when the ban ends, or the zero time if the session config
+	// has no ban duration, in which case it lasts until the client has been
+	// idle for the host cache entry idle duration.
+	ExpiresAt       time.Time     `json:"expiresAt"`
+	MaxRequests     int64         `json:"maxRequests"`
+	SessionDuration time.Duration `json:"sessionDuration"`
+	BanDuration     time.Duration `json:"banDuration"`
+}
+
+// RetryAfter returns how long after t the client should wait before retrying,
+// or false if the ban has no fixed end.
+func (i RateInfo) RetryAfter(t time.Time) (time.Duration, bool) {
+	if i.ExpiresAt.IsZero() {
+		return 0, false
+	}
+	return max(i.ExpiresAt.Sub(t), 0), true
+}
+
+// BanError is the error passed to the section's 429 handler when a client is
+// rejected.
+type BanError struct {
+	RateInfo
+}
+
+// Error implements error.
+func (e *BanError) Error() string {
+	if e.ExpiresAt.IsZero() {
+		return fmt.Sprintf("client banned since %s", e.BannedAt.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("client banned until %s", e.ExpiresAt.Format(time.RFC3339))
+}
+
+func newRateInfo(tier string, s session) RateInfo {
+	return RateInfo{
+		Tier:            tier,
+		BannedAt:        s.bannedAt,
+		ExpiresAt:       s.banExpiresAt(),
+		MaxRequests:     s.config.maxRequests,
+		SessionDuration: s.config.sessionDuration,
+		BanDuration:     s.config.banDuration,
+	}
 }
 
 type MiddlewareHandler interface {
 	common.MiddlewareHandler
+	// AddExemptFunc excludes requests for which f returns true from rate
+	// limiting; they are neither counted nor rejected.
+	AddExemptFunc(f func(*http.Request) bool)
 	AddSessionConfig(maxRequests int64, sessionDuration, banDuration time.Duration)
+	AddTierSessionConfig(tier string, maxRequests int64, sessionDuration, banDuration time.Duration)
+	// GroomNow evicts the cache entries that have been idle for longer than
+	// the host cache entry idle duration.
+	GroomNow()
+	// ListBans returns the banned clients, sorted by host.
+	ListBans() []Ban
 	SetHostCacheEntryIdleDuration(d time.Duration)
+	// SetHostCacheGroomingInterval sets how often idle cache entries are
+	// evicted in the background. Zero disables the background goroutine, in
+	// which case idle entries are only expired when their client makes
+	// another request or by calling GroomNow.
+	SetHostCacheGroomingInterval(d time.Duration)
+	SetHostResolver(realip.Resolver)
+	// SetIPv6PrefixLength groups IPv6 clients by network prefix of the given
+	// length, e.g. 64, since a single client often controls a whole /64.
+	// Zero keys each address separately.
+	SetIPv6PrefixLength(bits int)
+	SetTierResolver(TierResolver)
+	// SetUseRemoteAddrForInvalidAddress attributes requests whose resolved
+	// client address is not a valid IP address, e.g. due to a forged
+	// x-forwarded-for header, to the connection's remote address instead of
+	// rejecting them.
+	SetUseRemoteAddrForInvalidAddress(bool)
+	// Wrap returns a handler limiting requests to next using this handler's
+	// cache and session configs, so that a client's budget is shared across
+	// handler chains. Responses and metrics go through deps, and requests for
+	// which any of exemptFuncs returns true are exempt in addition to those
+	// exempted by AddExemptFunc. The returned handler's BeforeStart and
+	// AfterShutdown do nothing; those of this handler manage the cache.
+	Wrap(deps Dependencies, next http.Handler, exemptFuncs ...func(*http.Request) bool) common.MiddlewareHandler
 }
 
+// TierResolver returns the tier, such as a tenant's plan, whose session
+// configs apply to a request. Requests resolved to a tier without session
+// configs use the default session configs.
+type TierResolver func(*http.Request) string
+
 type sessionConfig struct {
 	banDuration     time.Duration
 	sessionDuration time.Duration
@@ -58,11 +166,34 @@ type handler struct {
 
 	hostCacheGroomingTicker *time.Ticker
 
+	hostCacheGroomingInterval time.Duration
+
 	sessionConfigs []sessionConfig
 
+	// tierSessionConfigs maps tiers to the session configs replacing the
+	// defaults for requests resolved to them.
+	tierSessionConfigs map[string][]sessionConfig
+
+	tierResolver TierResolver
+
+	exemptFuncs []func(*http.Request) bool
+
+	ipv6PrefixLength int
+
+	useRemoteAddrForInvalidAddress bool
+
 	// hostCacheEntryIdleDuration is how long a cache entry can go without an
 	// update before being eligible for eviction.
 	hostCacheEntryIdleDuration time.Duration
+
+	// hostResolver overrides the client address resolved by the realip
+	// middleware handler, if any.
+	hostResolver realip.Resolver
+}
+
+// AddExemptFunc implements MiddlewareHandler.
+func (h *handler) AddExemptFunc(f func(*http.Request) bool) {
+	h.exemptFuncs = append(h.exemptFuncs, f)
 }
 
 // AddSessionConfig implements MiddlewareHandler.
@@ -74,24 +205,195 @@ func (h *handler) AddSessionConfig(maxRequests int64, sessionDuration time.Durat
 	})
 }
 
+// AddTierSessionConfig implements MiddlewareHandler.
+func (h *handler) AddTierSessionConfig(tier string, maxRequests int64, sessionDuration time.Duration, banDuration time.Duration) {
+	h.tierSessionConfigs[tier] = append(h.tierSessionConfigs[tier], sessionConfig{
+		sessionDuration: sessionDuration,
+		maxRequests:     maxRequests,
+		banDuration:     banDuration,
+	})
+}
+
 // AfterShutdown implements MiddlewareHandler.
 func (h *handler) AfterShutdown() {
+	if h.hostCacheGroomingTicker == nil {
+		return
+	}
 	h.stopHostCacheGroomingLoop(h.quitHostCacheGrooming)
 }
 
 // BeforeStart implements MiddlewareHandler.
 func (h *handler) BeforeStart(wg *sync.WaitGroup) {
-	h.hostCacheGroomingTicker = time.NewTicker(10 * time.Second)
+	if h.hostCacheGroomingInterval <= 0 {
+		logger.Debug("BeforeStart", "Background grooming disabled")
+		return
+	}
+	h.hostCacheGroomingTicker = time.NewTicker(h.hostCacheGroomingInterval)
 	h.quitHostCacheGrooming = make(chan bool)
 	wg.Add(1)
 	go h.startHostCacheGroomingLoop(wg, h.quitHostCacheGrooming)
 }
 
+// GroomNow implements MiddlewareHandler.
+func (h *handler) GroomNow() {
+	h.onHostCacheGroomingTick(h.deps.Now())
+}
+
+// ListBans implements MiddlewareHandler.
+func (h *handler) ListBans() []Ban {
+	h.hostCacheLocker.Lock()
+	defer h.hostCacheLocker.Unlock()
+	now := h.deps.Now()
+	result := []Ban{}
+	for host, entry := range h.remoteHosts {
+		if now.Sub(entry.lastUpdatedAt) > h.hostCacheEntryIdleDuration {
+			// Expired, awaiting grooming.
+			continue
+		}
+		if tier, s, banned := entry.activeBan(now); banned {
+			result = append(result, Ban{Host: host, RateInfo: newRateInfo(tier, s)})
+		}
+	}
+	slices.SortFunc(result, func(a, b Ban) int {
+		return strings.Compare(a.Host, b.Host)
+	})
+	return result
+}
+
 // SetHostCacheEntryIdleDuration implements MiddlewareHandler.
 func (h *handler) SetHostCacheEntryIdleDuration(d time.Duration) {
 	h.hostCacheEntryIdleDuration = d
 }
 
+// SetHostCacheGroomingInterval implements MiddlewareHandler.
+func (h *handler) SetHostCacheGroomingInterval(d time.Duration) {
+	h.hostCacheGroomingInterval = d
+}
+
+// SetHostResolver implements MiddlewareHandler.
+func (h *handler) SetHostResolver(r realip.Resolver) {
+	h.hostResolver = r
+}
+
+// SetIPv6PrefixLength implements MiddlewareHandler.
+func (h *handler) SetIPv6PrefixLength(bits int) {
+	h.ipv6PrefixLength = bits
+}
+
+// SetUseRemoteAddrForInvalidAddress implements MiddlewareHandler.
+func (h *handler) SetUseRemoteAddrForInvalidAddress(v bool) {
+	h.useRemoteAddrForInvalidAddress = v
+}
+
+// SetTierResolver implements MiddlewareHandler.
+func (h *handler) SetTierResolver(r TierResolver) {
+	h.tierResolver = r
+}
+
+// Wrap implements MiddlewareHandler.
+func (h *handler) Wrap(deps Dependencies, next http.Handler, exemptFuncs ...func(*http.Request) bool) common.MiddlewareHandler {
+	return &wrappedHandler{
+		owner:       h,
+		deps:        deps,
+		next:        next,
+		exemptFuncs: exemptFuncs,
+	}
+}
+
+// wrappedHandler shares the cache of the handler that created it.
+type wrappedHandler struct {
+	owner       *handler
+	deps        Dependencies
+	next        http.Handler
+	exemptFuncs []func(*http.Request) bool
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *wrappedHandler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *wrappedHandler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *wrappedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, f := range h.exemptFuncs {
+		if f(r) {
+			hotPathLogger.Debug("ServeHTTP", "Request for %s is exempt", r.URL.Path)
+			h.next.ServeHTTP(w, r)
+			return
+		}
+	}
+	h.owner.serve(w, r, h.deps, h.next)
+}
+
+// resolveTier returns the request's tier along with the session configs
+// applying to it.
+func (h *handler) resolveTier(r *http.Request) (string, []sessionConfig) {
+	if h.tierResolver == nil {
+		return "", h.sessionConfigs
+	}
+	tier := h.tierResolver(r)
+	if configs, found := h.tierSessionConfigs[tier]; found {
+		return tier, configs
+	}
+	return "", h.sessionConfigs
+}
+
+// resolveHost returns the key identifying the client, partitioned by tenant
+// when one has been resolved for the request.
+func (h *handler) resolveHost(r *http.Request) (string, error) {
+	address, err := h.resolveClientAddress(r)
+	if err != nil {
+		return "", err
+	}
+	host, err := h.normalizeAddress(address)
+	if err != nil && h.useRemoteAddrForInvalidAddress {
+		hotPathLogger.Debug("resolveHost", "Falling back to remote address: %s", err)
+		remoteAddr, _, splitErr := net.SplitHostPort(r.RemoteAddr)
+		if splitErr != nil {
+			return "", errors.Join(err, splitErr)
+		}
+		host, err = h.normalizeAddress(remoteAddr)
+	}
+	if err != nil {
+		return "", err
+	}
+	if id, ok := tenant.FromContext(r.Context()); ok {
+		return id + "|" + host, nil
+	}
+	return host, nil
+}
+
+// normalizeAddress validates the client address and returns its canonical
+// form, so that equivalent spellings share a cache entry and arbitrary text
+// never becomes a key. Zones are stripped, IPv4-mapped IPv6 addresses are
+// unmapped and IPv6 addresses are grouped by prefix if configured.
+func (h *handler) normalizeAddress(address string) (string, error) {
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(address, "["), "]"))
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", ErrInvalidClientAddress, address)
+	}
+	addr = addr.WithZone("").Unmap()
+	if addr.Is6() && h.ipv6PrefixLength > 0 && h.ipv6PrefixLength < 128 {
+		prefix, err := addr.Prefix(h.ipv6PrefixLength)
+		if err != nil {
+			return "", err
+		}
+		return prefix.String(), nil
+	}
+	return addr.String(), nil
+}
+
+func (h *handler) resolveClientAddress(r *http.Request) (string, error) {
+	if h.hostResolver != nil {
+		return h.hostResolver(r)
+	}
+	if ip, ok := realip.FromContext(r.Context()); ok {
+		return ip, nil
+	}
+	return realip.DefaultResolver(r)
+}
+
 func (h *handler) startHostCacheGroomingLoop(wg *sync.WaitGroup, quit <-chan bool) {
 	defer logger.Debug("startHostCacheGroomingLoop", "exited")
 	defer wg.Done()
@@ -128,6 +430,15 @@ func (h *handler) onHostCacheGroomingTick(t time.Time) {
 			}
 		})
 	afterCount := len(h.remoteHosts)
+	banCount := 0
+	for _, entry := range h.remoteHosts {
+		if entry.isBanned(t) {
+			banCount++
+		}
+	}
+	h.deps.AddCounter("sudsy_ratelimit_evictions_total", float64(beforeCount-afterCount))
+	h.deps.SetGauge("sudsy_ratelimit_cache_entries", float64(afterCount))
+	h.deps.SetGauge("sudsy_ratelimit_active_bans", float64(banCount))
 	if afterCount != beforeCount {
 		logger.Debug("onHostCacheGroomingTick",
 			"Removed %d entries (current length %d)",
@@ -137,48 +448,62 @@ func (h *handler) onHostCacheGroomingTick(t time.Time) {
 	}
 }
 
-func getApplicableHost(r *http.Request) (string, error) {
-	if ip := r.Header.Get("fastly-client-ip"); ip != "" {
-		return ip, nil
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, h.deps, h.next)
+}
+
+// serve limits the request before passing it to next, responding through
+// deps.
+func (h *handler) serve(w http.ResponseWriter, r *http.Request, deps Dependencies, next http.Handler) {
+	for _, f := range h.exemptFuncs {
+		if f(r) {
+			hotPathLogger.Debug("serve", "Request for %s is exempt", r.URL.Path)
+			next.ServeHTTP(w, r)
+			return
+		}
 	}
-	forwardedForIPs := r.Header.Values("x-forwarded-for")
-	if len(forwardedForIPs) > 0 {
-		return forwardedForIPs[len(forwardedForIPs)-1], nil
+	host, err := h.resolveHost(r)
+	if err != nil {
+		logger.Debug("serve", "Error determining applicable host: %s", err)
+		deps.HandleStatusBadRequest(w, r, fmt.Errorf("determining host: %w", err))
+		return
 	}
-	logger.Debug("getApplicableHost", "Remote address: %s", r.RemoteAddr)
-	if host, _, err := net.SplitHostPort(r.RemoteAddr); err != nil {
-		return "", err
-	} else if host != "" {
-		return host, nil
+	hotPathLogger.Debug("serve", "Processing host: %s", host)
+	deps.AddCounter("sudsy_ratelimit_requests_total", 1)
+	if info, banned := h.recordRequest(r, host); banned {
+		hotPathLogger.Debug("serve", "Host %s is banned", host)
+		deps.AddCounter("sudsy_ratelimit_rejections_total", 1)
+		deps.HandleStatusTooManyRequests(w, r, info)
+		return
 	}
-	return "", errors.New("no applicable host")
+	next.ServeHTTP(w, r)
 }
 
-// ServeHTTP implements http.Handler.
-func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+// recordRequest updates the host's cache entry, reporting whether it is
+// banned. The lock is only held while the entry is updated, so requests are
+// served concurrently.
+func (h *handler) recordRequest(r *http.Request, host string) (RateInfo, bool) {
+	tier, configs := h.resolveTier(r)
 	h.hostCacheLocker.Lock()
 	defer h.hostCacheLocker.Unlock()
-	if host, err := getApplicableHost(r); err != nil {
-		logger.Debug("ServeHTTP", "Error determining applicable host: %s", err)
-		h.deps.HandleStatusBadRequest(w, r, fmt.Errorf("determining host: %w", err))
-	} else {
-		logger.Debug("ServeHTTP", "Processing host: %s", host)
-		if value, found := h.remoteHosts[host]; found {
-			h.remoteHosts[host] = newUpdatedEntry(
-				value,
-				h.deps.Now(),
-			)
-		} else {
-			h.remoteHosts[host] = newClientEntry(
-				h.deps.Now(),
-				h.sessionConfigs,
-			)
-		}
-		if h.remoteHosts[host].isBanned() {
-			logger.Debug("ServeHTTP", "Host %s is banned", host)
-			h.deps.HandleStatusTooManyRequests(w, r)
-		} else {
-			h.next.ServeHTTP(w, r)
-		}
+	now := h.deps.Now()
+	// A change of tier starts the client's sessions afresh under the new
+	// limits, keeping its active bans. An entry that has been idle for long
+	// enough to be evicted starts afresh entirely, in case grooming has not
+	// yet run.
+	value, found := h.remoteHosts[host]
+	switch {
+	case !found || now.Sub(value.lastUpdatedAt) > h.hostCacheEntryIdleDuration:
+		h.remoteHosts[host] = newClientEntry(now, tier, configs)
+	case value.tier != tier:
+		h.remoteHosts[host] = newTierEntry(value, now, tier, configs)
+	default:
+		h.remoteHosts[host] = newUpdatedEntry(value, now)
+	}
+	entry := h.remoteHosts[host]
+	if tier, s, banned := entry.activeBan(now); banned {
+		return newRateInfo(tier, s), true
 	}
+	return RateInfo{}, false
 }
@@ -0,0 +1,64 @@
+package ratelimiting
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testDependencies struct {
+	now time.Time
+}
+
+func (d *testDependencies) Now() time.Time { return d.now }
+
+func (d *testDependencies) HandleStatusBadRequest(w http.ResponseWriter, _ *http.Request, _ error) {
+	w.WriteHeader(http.StatusBadRequest)
+}
+
+func (d *testDependencies) HandleStatusTooManyRequests(w http.ResponseWriter, _ *http.Request, _ RateInfo) {
+	w.WriteHeader(http.StatusTooManyRequests)
+}
+
+func (d *testDependencies) AddCounter(string, float64) {}
+
+func (d *testDependencies) SetGauge(string, float64) {}
+
+// TestBanSurvivesTierChange checks that a client banned under one tier
+// remains banned after its requests resolve to another, until the ban
+// expires.
+func TestBanSurvivesTierChange(t *testing.T) {
+	deps := &testDependencies{now: time.Unix(0, 0)}
+	h := NewMiddlewareHandler(deps, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	h.AddTierSessionConfig("free", 1, time.Minute, time.Hour)
+	h.AddTierSessionConfig("paid", 100, time.Minute, time.Hour)
+	h.SetTierResolver(func(r *http.Request) string {
+		return r.Header.Get("tier")
+	})
+	serve := func(tier string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("tier", tier)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	for range 3 {
+		serve("free")
+	}
+	deps.now = deps.now.Add(time.Minute)
+	if code := serve("free"); code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d once over the free tier's limit, want %d", code, http.StatusTooManyRequests)
+	}
+	deps.now = deps.now.Add(time.Second)
+	if code := serve("paid"); code != http.StatusTooManyRequests {
+		t.Errorf("got status %d after changing tier while banned, want %d", code, http.StatusTooManyRequests)
+	}
+	if bans := h.ListBans(); len(bans) != 1 || bans[0].Tier != "free" {
+		t.Errorf("got bans %+v, want the free tier's ban", bans)
+	}
+	deps.now = deps.now.Add(time.Hour)
+	if code := serve("paid"); code != http.StatusOK {
+		t.Errorf("got status %d once the ban expired, want %d", code, http.StatusOK)
+	}
+}
@@ -8,3 +8,20 @@ type session struct {
 	bannedAt     time.Time
 	startedAt    time.Time
 }
+
+// banExpiresAt returns when the session's ban ends, or the zero time if it
+// lasts until the client entry is evicted.
+func (s session) banExpiresAt() time.Time {
+	if s.bannedAt.IsZero() || s.config.banDuration <= 0 {
+		return time.Time{}
+	}
+	return s.bannedAt.Add(s.config.banDuration)
+}
+
+func (s session) isBanned(t time.Time) bool {
+	if s.bannedAt.IsZero() {
+		return false
+	}
+	expiresAt := s.banExpiresAt()
+	return expiresAt.IsZero() || t.Before(expiresAt)
+}
@@ -0,0 +1,72 @@
+// Package realip resolves the address of the client behind any proxies and
+// provides an HTTP middleware handler exposing it to the rest of the request
+// pipeline.
+package realip
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+var logger = common.NewLogger("realip")
+
+type contextKey struct{}
+
+// FromContext returns the client address stored in ctx by the middleware
+// handler.
+func FromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(contextKey{}).(string)
+	return ip, ok
+}
+
+// NewMiddlewareHandler returns a handler storing the address resolved by
+// resolver in the request context and rewriting the request's RemoteAddr to
+// match, so handlers, logs and authentication agree on the client address.
+func NewMiddlewareHandler(resolver Resolver, next http.Handler) common.MiddlewareHandler {
+	return &handler{
+		next:     next,
+		resolver: resolver,
+	}
+}
+
+type handler struct {
+	next     http.Handler
+	resolver Resolver
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ip, err := h.resolver(r)
+	if err != nil {
+		logger.Debug("ServeHTTP", "Error resolving client address: %s", err)
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	// Forwarding headers are client controlled, so anything other than an IP
+	// address leaves the connection's address in place.
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		logger.Debug("ServeHTTP", "Ignoring invalid client address %q", ip)
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	ip = addr.WithZone("").Unmap().String()
+	port := "0"
+	if _, p, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		port = p
+	}
+	r = r.WithContext(context.WithValue(r.Context(), contextKey{}, ip))
+	r.RemoteAddr = net.JoinHostPort(ip, port)
+	h.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,145 @@
+package realip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/jakewan/sudsy/internal/forwarded"
+)
+
+// Resolver determines the client address a request is attributed to.
+type Resolver func(r *http.Request) (string, error)
+
+// NewLeftmostForwardedForResolver uses the leftmost x-forwarded-for address,
+// i.e. the address claimed by the original client. It is only trustworthy
+// when every proxy in front of the server overwrites the header.
+func NewLeftmostForwardedForResolver() Resolver {
+	return func(r *http.Request) (string, error) {
+		if addrs := forwardedForAddresses(r); len(addrs) > 0 {
+			return addrs[0], nil
+		}
+		return remoteHost(r)
+	}
+}
+
+// NewSkipForwardedForResolver uses the x-forwarded-for address appended by
+// the proxy n hops away, e.g. n is 1 when there are two proxies in front of
+// the server. When the chain is shorter than n+1 the leftmost address is
+// used.
+func NewSkipForwardedForResolver(n int) Resolver {
+	return newSkipResolver(forwardedForAddresses, n)
+}
+
+// NewSkipForwardedResolver is like NewSkipForwardedForResolver, using the
+// for= addresses of the standard Forwarded header instead of
+// x-forwarded-for. Only use it when the proxies in front of the server
+// append to the Forwarded header, since clients can send it too.
+func NewSkipForwardedResolver(n int) Resolver {
+	return newSkipResolver(forwardedAddresses, n)
+}
+
+// NewRightmostUntrustedForwardedForResolver uses the rightmost
+// x-forwarded-for address not belonging to one of the trusted proxy
+// networks.
+func NewRightmostUntrustedForwardedForResolver(trustedCIDRs ...string) (Resolver, error) {
+	return newRightmostUntrustedResolver(forwardedForAddresses, trustedCIDRs)
+}
+
+// NewRightmostUntrustedForwardedResolver is like
+// NewRightmostUntrustedForwardedForResolver, using the for= addresses of the
+// standard Forwarded header instead of x-forwarded-for.
+func NewRightmostUntrustedForwardedResolver(trustedCIDRs ...string) (Resolver, error) {
+	return newRightmostUntrustedResolver(forwardedAddresses, trustedCIDRs)
+}
+
+func newSkipResolver(addresses func(*http.Request) []string, n int) Resolver {
+	return func(r *http.Request) (string, error) {
+		addrs := addresses(r)
+		if len(addrs) < 1 {
+			return remoteHost(r)
+		}
+		return addrs[max(len(addrs)-1-n, 0)], nil
+	}
+}
+
+func newRightmostUntrustedResolver(addresses func(*http.Request) []string, trustedCIDRs []string) (Resolver, error) {
+	trusted := make([]netip.Prefix, 0, len(trustedCIDRs))
+	for _, c := range trustedCIDRs {
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("parsing trusted CIDR %q: %w", c, err)
+		}
+		trusted = append(trusted, p.Masked())
+	}
+	isTrusted := func(s string) bool {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return false
+		}
+		addr = addr.Unmap()
+		for _, p := range trusted {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(r *http.Request) (string, error) {
+		addrs := addresses(r)
+		for i := len(addrs) - 1; i >= 0; i-- {
+			if !isTrusted(addrs[i]) {
+				return addrs[i], nil
+			}
+		}
+		return remoteHost(r)
+	}, nil
+}
+
+// DefaultResolver prefers the fastly-client-ip header, then the
+// rightmost x-forwarded-for address, then the connection's remote address.
+func DefaultResolver(r *http.Request) (string, error) {
+	if ip := r.Header.Get("fastly-client-ip"); ip != "" {
+		return ip, nil
+	}
+	return NewSkipForwardedForResolver(0)(r)
+}
+
+// forwardedForAddresses returns every x-forwarded-for address, from
+// leftmost to rightmost.
+func forwardedForAddresses(r *http.Request) []string {
+	result := []string{}
+	for _, v := range r.Header.Values("x-forwarded-for") {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				result = append(result, part)
+			}
+		}
+	}
+	return result
+}
+
+// forwardedAddresses returns every for= address of the standard Forwarded
+// header, from leftmost to rightmost.
+func forwardedAddresses(r *http.Request) []string {
+	result := []string{}
+	for _, e := range forwarded.Parse(r.Header.Values("forwarded")) {
+		if host, ok := e.ForHost(); ok {
+			result = append(result, host)
+		}
+	}
+	return result
+}
+
+func remoteHost(r *http.Request) (string, error) {
+	logger.Debug("remoteHost", "Remote address: %s", r.RemoteAddr)
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err != nil {
+		return "", err
+	} else if host != "" {
+		return host, nil
+	}
+	return "", errors.New("no applicable host")
+}
@@ -0,0 +1,158 @@
+// Package recovery tracks handler panics per route and decides when a route
+// panicking too frequently should be disabled.
+package recovery
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+var logger = common.NewLogger("recovery")
+
+// Route identifies a route by its pattern and the methods its handler
+// serves, so that the handlers of a pattern serving different methods are
+// tracked apart.
+type Route struct {
+	Pattern string
+	// Methods are the sorted methods, separated by commas, empty meaning
+	// all methods.
+	Methods string
+}
+
+// NewRoute returns the route of the handler of pattern serving methods.
+func NewRoute(pattern string, methods []string) Route {
+	for i := 1; i < len(methods); i++ {
+		if methods[i-1] >= methods[i] {
+			// The methods are copied only if they need sorting.
+			methods = slices.Clone(methods)
+			slices.Sort(methods)
+			methods = slices.Compact(methods)
+			break
+		}
+	}
+	return Route{Pattern: pattern, Methods: strings.Join(methods, ",")}
+}
+
+func (r Route) String() string {
+	if r.Methods == "" {
+		return r.Pattern
+	}
+	return r.Pattern + " (" + r.Methods + ")"
+}
+
+type RouteStats struct {
+	Route    string `json:"route"`
+	Methods  string `json:"methods,omitempty"`
+	Panics   int64  `json:"panics"`
+	Disabled bool   `json:"disabled"`
+}
+
+type Tracker interface {
+	// Disabled reports whether the route has been disabled.
+	Disabled(route Route) bool
+	// Enable re-enables a disabled route, reporting whether it was disabled.
+	Enable(route Route) bool
+	// Record registers a panic for the route at t and reports whether the
+	// route is disabled as a result.
+	Record(route Route, t time.Time) bool
+	// SetMaxPanicsPerMinute sets the number of panics within a minute beyond
+	// which a route is disabled. Zero means routes are never disabled.
+	SetMaxPanicsPerMinute(n int)
+	Stats() []RouteStats
+}
+
+func NewTracker() Tracker {
+	return &tracker{
+		locker: &sync.Mutex{},
+		routes: map[Route]*routeEntry{},
+	}
+}
+
+type routeEntry struct {
+	panics       int64
+	recentPanics []time.Time
+	disabled     bool
+}
+
+type tracker struct {
+	locker             sync.Locker
+	routes             map[Route]*routeEntry
+	maxPanicsPerMinute int
+}
+
+// Disabled implements Tracker.
+func (t *tracker) Disabled(route Route) bool {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	if e, found := t.routes[route]; found {
+		return e.disabled
+	}
+	return false
+}
+
+// Enable implements Tracker.
+func (t *tracker) Enable(route Route) bool {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	e, found := t.routes[route]
+	if !found || !e.disabled {
+		return false
+	}
+	e.disabled = false
+	e.recentPanics = nil
+	logger.Debug("Enable", "Route %s re-enabled", route)
+	return true
+}
+
+// Record implements Tracker.
+func (t *tracker) Record(route Route, now time.Time) bool {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	e, found := t.routes[route]
+	if !found {
+		e = &routeEntry{}
+		t.routes[route] = e
+	}
+	e.panics++
+	windowStart := now.Add(-time.Minute)
+	e.recentPanics = slices.DeleteFunc(e.recentPanics, func(p time.Time) bool {
+		return p.Before(windowStart)
+	})
+	e.recentPanics = append(e.recentPanics, now)
+	if t.maxPanicsPerMinute > 0 && len(e.recentPanics) > t.maxPanicsPerMinute && !e.disabled {
+		logger.Debug("Record", "Disabling route %s after %d panics within a minute", route, len(e.recentPanics))
+		e.disabled = true
+	}
+	return e.disabled
+}
+
+// SetMaxPanicsPerMinute implements Tracker.
+func (t *tracker) SetMaxPanicsPerMinute(n int) {
+	t.maxPanicsPerMinute = n
+}
+
+// Stats implements Tracker.
+func (t *tracker) Stats() []RouteStats {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	result := make([]RouteStats, 0, len(t.routes))
+	for route, e := range t.routes {
+		result = append(result, RouteStats{
+			Route:    route.Pattern,
+			Methods:  route.Methods,
+			Panics:   e.panics,
+			Disabled: e.disabled,
+		})
+	}
+	slices.SortFunc(result, func(l, r RouteStats) int {
+		if c := strings.Compare(l.Route, r.Route); c != 0 {
+			return c
+		}
+		return strings.Compare(l.Methods, r.Methods)
+	})
+	return result
+}
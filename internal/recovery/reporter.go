@@ -0,0 +1,39 @@
+package recovery
+
+import (
+	"context"
+	"fmt"
+)
+
+// Reporter receives recovered panics and internal failures, e.g. to forward
+// them to an error tracker. stack holds the goroutine stack trace captured
+// when a panic was recovered and is nil for other failures.
+type Reporter func(ctx context.Context, err error, stack []byte)
+
+// Report invokes the reporter, if any.
+func (r Reporter) Report(ctx context.Context, err error, stack []byte) {
+	if r == nil {
+		return
+	}
+	r(ctx, err, stack)
+}
+
+// PanicError is the error a recovered handler panic is reported and handled
+// with.
+type PanicError struct {
+	Route string
+	Value any
+	// Stack is the goroutine stack trace captured when the panic was
+	// recovered.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in route %s: %v", e.Route, e.Value)
+}
+
+// Unwrap returns the value panicked with, if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
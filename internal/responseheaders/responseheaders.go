@@ -0,0 +1,110 @@
+// Package responseheaders provides an HTTP middleware handler adding default
+// headers to responses, unless the handler writing the response already set
+// them, and overriding others regardless.
+package responseheaders
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+var logger = common.NewLogger("responseheaders")
+
+// NewMiddlewareHandler returns a handler adding the defaults missing from
+// responses and replacing the overrides, deleting those without values.
+func NewMiddlewareHandler(next http.Handler, defaults, overrides http.Header) common.MiddlewareHandler {
+	return &handler{next: next, defaults: defaults.Clone(), overrides: overrides.Clone()}
+}
+
+type handler struct {
+	next      http.Handler
+	defaults  http.Header
+	overrides http.Header
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, defaults: h.defaults, overrides: h.overrides}
+	h.next.ServeHTTP(rw, r)
+	// Responses left unwritten by the handler are written by the server once
+	// it returns.
+	rw.apply()
+}
+
+// responseWriter adds the default headers missing from the response and
+// applies the overrides when its header is written.
+type responseWriter struct {
+	http.ResponseWriter
+	defaults  http.Header
+	overrides http.Header
+	applied   bool
+}
+
+func (w *responseWriter) apply() {
+	if w.applied {
+		return
+	}
+	w.applied = true
+	header := w.ResponseWriter.Header()
+	for name, values := range w.defaults {
+		if _, found := header[name]; !found {
+			header[name] = slices.Clone(values)
+		}
+	}
+	for name, values := range w.overrides {
+		if len(values) == 0 {
+			delete(header, name)
+		} else {
+			header[name] = slices.Clone(values)
+		}
+	}
+}
+
+// Flush implements http.Flusher.
+func (w *responseWriter) Flush() {
+	w.apply()
+	if err := http.NewResponseController(w.ResponseWriter).Flush(); err != nil {
+		logger.Debug("Flush", "Error flushing response: %s", err)
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Write implements http.ResponseWriter.
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.apply()
+	return w.ResponseWriter.Write(b)
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *responseWriter) WriteHeader(code int) {
+	// Informational responses leave the final header to come.
+	if code >= 200 {
+		w.apply()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
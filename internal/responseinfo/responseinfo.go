@@ -0,0 +1,151 @@
+// Package responseinfo provides an HTTP middleware handler recording details
+// of each response and passing them to hooks once the request completes.
+package responseinfo
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/propagation"
+)
+
+var logger = common.NewLogger("responseinfo")
+
+// Info describes a completed request.
+type Info struct {
+	Request *http.Request
+	// Status is the response status code.
+	Status int
+	// Size is the number of response body bytes written.
+	Size    int64
+	Latency time.Duration
+	// Route is the pattern of the route that handled the request, if any.
+	Route string
+	// Tenant is the ID of the tenant the request belongs to, if resolved.
+	Tenant string
+	// PropagatedHeaders holds the inbound headers configured for propagation,
+	// to be included as fields in log entries.
+	PropagatedHeaders http.Header
+	// TimeoutCause is what ended the request before it completed, if its
+	// section enforces request timeouts: "client", "handler" or "upstream".
+	TimeoutCause string
+	// ClientGone reports whether the client disconnected before the response
+	// completed, in which case Err is likely a consequence rather than a
+	// failure of the server.
+	ClientGone bool
+	// Err describes why an error response was produced, if known.
+	Err error
+}
+
+type Hook func(*Info)
+
+type contextKey struct{}
+
+// FromContext returns the Info being recorded for the request, allowing
+// downstream handlers to fill in the route and error.
+func FromContext(ctx context.Context) (*Info, bool) {
+	info, ok := ctx.Value(contextKey{}).(*Info)
+	return info, ok
+}
+
+type Dependencies interface {
+	Now() time.Time
+}
+
+func NewMiddlewareHandler(deps Dependencies, next http.Handler, hooks ...Hook) common.MiddlewareHandler {
+	return &handler{
+		deps:  deps,
+		next:  next,
+		hooks: hooks,
+	}
+}
+
+type handler struct {
+	deps  Dependencies
+	next  http.Handler
+	hooks []Hook
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	startedAt := h.deps.Now()
+	// An outer handler may already be recording the response, in which case
+	// the writer is not wrapped again.
+	info, found := FromContext(r.Context())
+	if !found {
+		info = &Info{}
+		r = r.WithContext(context.WithValue(r.Context(), contextKey{}, info))
+		w = &responseWriter{ResponseWriter: w, info: info}
+	}
+	info.Request = r
+	if v, found := propagation.FromContext(r.Context()); found {
+		info.PropagatedHeaders = v.Headers
+	}
+	defer func() {
+		if info.Status == 0 {
+			info.Status = http.StatusOK
+		}
+		info.Latency = h.deps.Now().Sub(startedAt)
+		for _, hook := range h.hooks {
+			hook(info)
+		}
+	}()
+	h.next.ServeHTTP(w, r)
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	info *Info
+}
+
+// Flush implements http.Flusher.
+func (w *responseWriter) Flush() {
+	if err := http.NewResponseController(w.ResponseWriter).Flush(); err != nil {
+		logger.Debug("Flush", "Error flushing response: %s", err)
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	w.info.Status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Write implements http.ResponseWriter.
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.info.Status == 0 {
+		w.info.Status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.info.Size += int64(n)
+	return n, err
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *responseWriter) WriteHeader(code int) {
+	if w.info.Status == 0 || w.info.Status < 200 {
+		w.info.Status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
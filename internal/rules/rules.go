@@ -0,0 +1,123 @@
+// Package rules declares request validation rules checked before a route's
+// handler runs.
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/jakewan/sudsy/internal/binding"
+)
+
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// Set is a collection of validation rules for a route. The zero value accepts
+// every request.
+type Set struct {
+	// RequiredHeaders lists headers that must be present and non-empty.
+	RequiredHeaders []string
+	// PathParams maps capture variable names, without the leading ":", to
+	// the format their values must match. Formats are not implicitly
+	// anchored.
+	PathParams map[string]*regexp.Regexp
+	// QueryParams maps query parameter names to the format their values must
+	// match when present. Formats are not implicitly anchored.
+	QueryParams map[string]*regexp.Regexp
+}
+
+// Empty reports whether the set contains no rules.
+func (s Set) Empty() bool {
+	return len(s.RequiredHeaders) == 0 &&
+		len(s.PathParams) == 0 &&
+		len(s.QueryParams) == 0
+}
+
+// Check applies the rules to the request. pathParams holds the captured path
+// segments keyed by capture token, including the leading ":". Violations are
+// reported as a *binding.ValidationError listing every failing field.
+func (s Set) Check(r *http.Request, pathParams map[string]string) error {
+	var fields []binding.FieldError
+	for _, name := range s.RequiredHeaders {
+		if strings.TrimSpace(r.Header.Get(name)) == "" {
+			fields = append(fields, binding.FieldError{
+				Field:   "header." + http.CanonicalHeaderKey(name),
+				Message: "required",
+			})
+		}
+	}
+	for _, name := range sortedKeys(s.PathParams) {
+		if v := pathParams[":"+name]; !s.PathParams[name].MatchString(v) {
+			fields = append(fields, binding.FieldError{
+				Field:   "path." + name,
+				Message: "must match " + s.PathParams[name].String(),
+			})
+		}
+	}
+	if len(s.QueryParams) > 0 {
+		query := r.URL.Query()
+		for _, name := range sortedKeys(s.QueryParams) {
+			for _, v := range query[name] {
+				if !s.QueryParams[name].MatchString(v) {
+					fields = append(fields, binding.FieldError{
+						Field:   "query." + name,
+						Message: "must match " + s.QueryParams[name].String(),
+					})
+					break
+				}
+			}
+		}
+	}
+	if len(fields) > 0 {
+		return &binding.ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// CheckContentType rejects requests carrying a body whose media type is not
+// one of allowed. An empty allowed list accepts every request. The returned
+// error wraps ErrUnsupportedMediaType.
+func CheckContentType(r *http.Request, allowed []string) error {
+	if len(allowed) == 0 || !HasBody(r) {
+		return nil
+	}
+	value := r.Header.Get("content-type")
+	if MatchContentType(value, allowed) {
+		return nil
+	}
+	if value == "" {
+		return fmt.Errorf("%w: missing content type, expected one of %s", ErrUnsupportedMediaType, strings.Join(allowed, ", "))
+	}
+	return fmt.Errorf("%w: %s, expected one of %s", ErrUnsupportedMediaType, value, strings.Join(allowed, ", "))
+}
+
+// HasBody reports whether the request carries a body.
+func HasBody(r *http.Request) bool {
+	return r.ContentLength > 0 || (r.ContentLength < 0 && r.Body != nil && r.Body != http.NoBody)
+}
+
+// MatchContentType reports whether the media type of the Content-Type header
+// value is one of allowed, ignoring case and parameters.
+func MatchContentType(value string, allowed []string) bool {
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(allowed, func(a string) bool {
+		return strings.EqualFold(a, mediaType)
+	})
+}
+
+func sortedKeys(m map[string]*regexp.Regexp) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,90 @@
+// Package runtimestats collects process and Go runtime statistics for quick
+// operational triage.
+package runtimestats
+
+import (
+	"os"
+	"runtime"
+	"time"
+)
+
+// processStartedAt approximates when the process started.
+var processStartedAt = time.Now()
+
+// Stats is a snapshot of process and runtime statistics.
+type Stats struct {
+	StartedAt time.Time `json:"startedAt"`
+	// Uptime is rendered as a duration string such as "1h2m3s".
+	Uptime     string `json:"uptime"`
+	GoVersion  string `json:"goVersion"`
+	NumCPU     int    `json:"numCPU"`
+	GOMAXPROCS int    `json:"gomaxprocs"`
+	Goroutines int    `json:"goroutines"`
+	// OpenFileDescriptors is -1 where the count is unavailable.
+	OpenFileDescriptors int         `json:"openFileDescriptors"`
+	Memory              MemoryStats `json:"memory"`
+	GC                  GCStats     `json:"gc"`
+}
+
+// MemoryStats reports memory usage in bytes.
+type MemoryStats struct {
+	HeapAlloc   uint64 `json:"heapAlloc"`
+	HeapInuse   uint64 `json:"heapInuse"`
+	HeapObjects uint64 `json:"heapObjects"`
+	StackInuse  uint64 `json:"stackInuse"`
+	Sys         uint64 `json:"sys"`
+	TotalAlloc  uint64 `json:"totalAlloc"`
+}
+
+type GCStats struct {
+	NumGC      uint32        `json:"numGC"`
+	LastGC     *time.Time    `json:"lastGC,omitempty"`
+	PauseTotal time.Duration `json:"pauseTotalNs"`
+	// NextGC is the heap size in bytes targeted by the next collection.
+	NextGC uint64 `json:"nextGC"`
+}
+
+// Collect returns the current statistics. It briefly stops the world to read
+// memory statistics, so it should not be called on hot paths.
+func Collect(now time.Time) Stats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	result := Stats{
+		StartedAt:           processStartedAt,
+		Uptime:              now.Sub(processStartedAt).Round(time.Second).String(),
+		GoVersion:           runtime.Version(),
+		NumCPU:              runtime.NumCPU(),
+		GOMAXPROCS:          runtime.GOMAXPROCS(0),
+		Goroutines:          runtime.NumGoroutine(),
+		OpenFileDescriptors: countOpenFileDescriptors(),
+		Memory: MemoryStats{
+			HeapAlloc:   m.HeapAlloc,
+			HeapInuse:   m.HeapInuse,
+			HeapObjects: m.HeapObjects,
+			StackInuse:  m.StackInuse,
+			Sys:         m.Sys,
+			TotalAlloc:  m.TotalAlloc,
+		},
+		GC: GCStats{
+			NumGC:      m.NumGC,
+			PauseTotal: time.Duration(m.PauseTotalNs),
+			NextGC:     m.NextGC,
+		},
+	}
+	if m.LastGC > 0 {
+		lastGC := time.Unix(0, int64(m.LastGC))
+		result.GC.LastGC = &lastGC
+	}
+	return result
+}
+
+// countOpenFileDescriptors counts the entries of /proc/self/fd, returning -1
+// on platforms without it.
+func countOpenFileDescriptors() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	// The directory handle used to read the entries is itself counted.
+	return len(entries) - 1
+}
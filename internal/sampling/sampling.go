@@ -0,0 +1,111 @@
+// Package sampling provides samplers deciding which requests are traced, so
+// that high-volume routes do not flood the trace backend.
+package sampling
+
+import (
+	"encoding/binary"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Parameters describes a request to sample.
+type Parameters struct {
+	Request *http.Request
+	TraceID [16]byte
+	// HasParent reports whether the request carried a valid trace context,
+	// in which case ParentSampled is its sampled flag.
+	HasParent     bool
+	ParentSampled bool
+}
+
+// Sampler decides whether a request is traced.
+type Sampler interface {
+	ShouldSample(Parameters) bool
+}
+
+// SamplerFunc adapts an ordinary function to the Sampler interface.
+type SamplerFunc func(Parameters) bool
+
+// ShouldSample implements Sampler.
+func (f SamplerFunc) ShouldSample(p Parameters) bool {
+	return f(p)
+}
+
+// Always samples every request.
+func Always() Sampler {
+	return SamplerFunc(func(Parameters) bool { return true })
+}
+
+// Never samples no request.
+func Never() Sampler {
+	return SamplerFunc(func(Parameters) bool { return false })
+}
+
+// TraceIDRatio samples the given fraction of traces. The decision depends on
+// the trace ID only, so that services sampling the same trace with the same
+// ratio agree.
+func TraceIDRatio(ratio float64) Sampler {
+	switch {
+	case ratio >= 1:
+		return Always()
+	case ratio <= 0:
+		return Never()
+	}
+	bound := uint64(ratio * math.MaxInt64)
+	return SamplerFunc(func(p Parameters) bool {
+		// The lower bytes of trace IDs are random, see the W3C Trace
+		// Context specification.
+		return binary.BigEndian.Uint64(p.TraceID[8:])>>1 < bound
+	})
+}
+
+// RateLimited samples up to perSecond requests per second, allowing bursts of
+// up to a second's worth.
+func RateLimited(perSecond float64) Sampler {
+	if perSecond <= 0 {
+		return Never()
+	}
+	return &rateLimited{
+		perSecond: perSecond,
+		burst:     math.Max(perSecond, 1),
+		tokens:    math.Max(perSecond, 1),
+	}
+}
+
+type rateLimited struct {
+	perSecond float64
+	burst     float64
+
+	locker sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// ShouldSample implements Sampler.
+func (s *rateLimited) ShouldSample(Parameters) bool {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	now := time.Now()
+	if !s.last.IsZero() {
+		s.tokens = math.Min(s.burst, s.tokens+now.Sub(s.last).Seconds()*s.perSecond)
+	}
+	s.last = now
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+// ParentBased follows the sampled flag of requests carrying a trace context,
+// and defers to root for the others.
+func ParentBased(root Sampler) Sampler {
+	return SamplerFunc(func(p Parameters) bool {
+		if p.HasParent {
+			return p.ParentSampled
+		}
+		return root.ShouldSample(p)
+	})
+}
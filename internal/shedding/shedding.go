@@ -0,0 +1,187 @@
+// Package shedding provides an HTTP middleware handler that classifies
+// requests into priorities and rejects low-priority traffic first when the
+// number of requests in flight or their latency exceeds the configured limits.
+package shedding
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+var (
+	ErrOverloaded = errors.New("server overloaded")
+
+	logger = common.NewLogger("shedding")
+)
+
+// Priority ranks requests for shedding. Requests of lower priority are shed
+// first.
+type Priority int
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+	// PriorityCritical requests are never shed.
+	PriorityCritical
+)
+
+// String returns the lowercase name of the priority, used as a metric label.
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	case PriorityCritical:
+		return "critical"
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+// Classifier returns the priority of a request.
+type Classifier func(*http.Request) Priority
+
+// NewHeaderClassifier returns a Classifier mapping values of the named request
+// header to priorities. Values are matched case-insensitively, and requests
+// without a mapped value have normal priority.
+func NewHeaderClassifier(header string, priorities map[string]Priority) Classifier {
+	normalized := make(map[string]Priority, len(priorities))
+	for k, v := range priorities {
+		normalized[strings.ToLower(k)] = v
+	}
+	return func(r *http.Request) Priority {
+		if p, found := normalized[strings.ToLower(strings.TrimSpace(r.Header.Get(header)))]; found {
+			return p
+		}
+		return PriorityNormal
+	}
+}
+
+// Limits configures when requests are shed. Low priority requests are shed
+// once half of MaxInFlight is in use or the average latency exceeds
+// LatencyTarget, normal priority requests once 80% is in use or the latency
+// exceeds twice the target, and high priority requests once MaxInFlight is
+// reached. Zero values disable the corresponding check.
+type Limits struct {
+	MaxInFlight   int64
+	LatencyTarget time.Duration
+}
+
+type Dependencies interface {
+	Now() time.Time
+	// Classify returns the priority of the request.
+	Classify(*http.Request) Priority
+	HandleStatusServiceUnavailable(http.ResponseWriter, *http.Request, error)
+	// AddCounter reports a metric, labeled by the caller as well as with the
+	// given labels.
+	AddCounter(name string, labels map[string]string, delta float64)
+}
+
+func NewMiddlewareHandler(deps Dependencies, next http.Handler, limits Limits) common.MiddlewareHandler {
+	return &handler{
+		deps:   deps,
+		next:   next,
+		limits: limits,
+		locker: &sync.Mutex{},
+	}
+}
+
+// latencyWeight is the weight of each new observation in the exponentially
+// weighted moving average of latency.
+const latencyWeight = 0.1
+
+type handler struct {
+	deps     Dependencies
+	next     http.Handler
+	limits   Limits
+	inFlight atomic.Int64
+
+	locker sync.Locker
+	// latency is the moving average of request latency.
+	latency time.Duration
+	// observedAt is when latency was last updated. A stale average is
+	// disregarded, since requests shed because of it are not observed and
+	// could otherwise never bring it down.
+	observedAt time.Time
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	priority := h.deps.Classify(r)
+	inFlight := h.inFlight.Add(1)
+	defer h.inFlight.Add(-1)
+	if h.shed(priority, inFlight) {
+		logger.Debug("ServeHTTP", "Shedding %s priority request for %s", priority, r.URL.Path)
+		h.deps.AddCounter("sudsy_shed_requests_total", map[string]string{"priority": priority.String()}, 1)
+		h.deps.HandleStatusServiceUnavailable(w, r, fmt.Errorf("%w: shedding %s priority requests", ErrOverloaded, priority))
+		return
+	}
+	startedAt := h.deps.Now()
+	defer func() {
+		now := h.deps.Now()
+		h.observe(now, now.Sub(startedAt))
+	}()
+	h.next.ServeHTTP(w, r)
+}
+
+// shed reports whether a request of the priority should be rejected given the
+// number of requests in flight, including itself.
+func (h *handler) shed(priority Priority, inFlight int64) bool {
+	if priority >= PriorityCritical {
+		return false
+	}
+	var inFlightThreshold float64
+	var latencyFactor time.Duration
+	switch {
+	case priority <= PriorityLow:
+		inFlightThreshold, latencyFactor = 0.5, 1
+	case priority == PriorityNormal:
+		inFlightThreshold, latencyFactor = 0.8, 2
+	default:
+		inFlightThreshold, latencyFactor = 1, 0
+	}
+	if h.limits.MaxInFlight > 0 && float64(inFlight) > inFlightThreshold*float64(h.limits.MaxInFlight) {
+		return true
+	}
+	if h.limits.LatencyTarget > 0 && latencyFactor > 0 &&
+		h.averageLatency(h.deps.Now()) > latencyFactor*h.limits.LatencyTarget {
+		return true
+	}
+	return false
+}
+
+func (h *handler) averageLatency(t time.Time) time.Duration {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	if t.Sub(h.observedAt) > max(time.Second, 4*h.limits.LatencyTarget) {
+		return 0
+	}
+	return h.latency
+}
+
+func (h *handler) observe(t time.Time, d time.Duration) {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	if h.observedAt.IsZero() {
+		h.latency = d
+	} else {
+		h.latency += time.Duration(latencyWeight * float64(d-h.latency))
+	}
+	h.observedAt = t
+}
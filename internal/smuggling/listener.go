@@ -0,0 +1,320 @@
+package smuggling
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxHeadBytes bounds the request head held back for inspection, matching the
+// net/http default limit plus its slack. Longer heads are passed on
+// uninspected, to be rejected by the server.
+const maxHeadBytes = 1<<20 + 4096
+
+// maxChunkLineBytes bounds a chunk size line, extensions included.
+const maxChunkLineBytes = 4096
+
+// NewListener returns a listener whose connections are inspected for HTTP/1.x
+// request heads framing their bodies ambiguously, which the net/http parser
+// resolves silently: it prefers Transfer-Encoding over Content-Length and
+// ignores Transfer-Encoding in HTTP/1.0 requests. The findings are reported by
+// the middleware handler serving the request, provided that NewConnContext is
+// used as the server's ConnContext.
+//
+// Inspection requires cleartext connections, so TLS servers only benefit from
+// the request checks of the middleware handler.
+func NewListener(ln net.Listener) net.Listener {
+	return &listener{Listener: ln}
+}
+
+type connContextKey struct{}
+
+// NewConnContext is intended for use as http.Server.ConnContext. It attaches
+// the findings of connections accepted by a listener returned by NewListener.
+func NewConnContext(ctx context.Context, c net.Conn) context.Context {
+	if c, ok := c.(*conn); ok {
+		return context.WithValue(ctx, connContextKey{}, c)
+	}
+	return ctx
+}
+
+type listener struct {
+	net.Listener
+}
+
+// Accept implements net.Listener.
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, buf: make([]byte, 4096)}, nil
+}
+
+// Framing states of a connection.
+const (
+	stateHead = iota
+	stateBody
+	stateChunkSize
+	stateChunkData
+	stateChunkDataEnd
+	stateTrailer
+	// statePassthrough means the framing of the connection could not be
+	// followed, so the rest of it is not inspected.
+	statePassthrough
+)
+
+// conn follows the framing of the requests read from it, holding back each
+// request head until it is complete so that its findings are recorded before
+// the server parses it.
+type conn struct {
+	net.Conn
+	buf []byte
+
+	// ready holds bytes read and inspected but not yet returned.
+	ready []byte
+	// head holds the bytes of an incomplete request head.
+	head []byte
+	// line holds the bytes of an incomplete chunk size or trailer line.
+	line      []byte
+	state     int
+	remaining int64
+	err       error
+
+	locker sync.Mutex
+	// findings holds the reasons found for each request head released, in
+	// order, until taken by the handler serving the request.
+	findings [][]string
+}
+
+// Read implements net.Conn.
+func (c *conn) Read(p []byte) (int, error) {
+	for len(c.ready) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		n, err := c.Conn.Read(c.buf)
+		c.scan(c.buf[:n])
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// The server interrupts reads with deadlines and resumes
+				// them, so the inspection carries on.
+				if len(c.ready) == 0 {
+					return 0, err
+				}
+				break
+			}
+			// Whatever remains is handed over for the server to reject.
+			c.ready = append(c.ready, c.head...)
+			c.head = nil
+			c.state = statePassthrough
+			c.err = err
+		}
+	}
+	n := copy(p, c.ready)
+	c.ready = c.ready[n:]
+	return n, nil
+}
+
+// takeFindings returns the reasons found for the oldest request head not yet
+// taken.
+func (c *conn) takeFindings() []string {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if len(c.findings) == 0 {
+		return nil
+	}
+	result := c.findings[0]
+	c.findings = c.findings[1:]
+	return result
+}
+
+func (c *conn) scan(b []byte) {
+	for len(b) > 0 {
+		switch c.state {
+		case statePassthrough:
+			c.ready = append(c.ready, b...)
+			return
+		case stateHead:
+			if len(c.head) == 0 {
+				// Blank lines preceding a request line are tolerated and
+				// skipped by the server.
+				i := 0
+				for i < len(b) && (b[i] == '\r' || b[i] == '\n') {
+					i++
+				}
+				c.ready = append(c.ready, b[:i]...)
+				b = b[i:]
+				if len(b) == 0 {
+					return
+				}
+			}
+			searchFrom := max(len(c.head)-2, 0)
+			c.head = append(c.head, b...)
+			end := headEnd(c.head, searchFrom)
+			if end < 0 {
+				if len(c.head) > maxHeadBytes {
+					c.ready = append(c.ready, c.head...)
+					c.head = nil
+					c.state = statePassthrough
+				}
+				return
+			}
+			head := c.head[:end]
+			b = c.head[end:]
+			c.head = nil
+			c.inspectHead(head)
+			c.ready = append(c.ready, head...)
+		case stateBody, stateChunkData:
+			n := min(int64(len(b)), c.remaining)
+			c.ready = append(c.ready, b[:n]...)
+			b = b[n:]
+			c.remaining -= n
+			if c.remaining == 0 {
+				if c.state == stateBody {
+					c.state = stateHead
+				} else {
+					c.state = stateChunkDataEnd
+				}
+			}
+		default:
+			i := bytes.IndexByte(b, '\n')
+			if i < 0 {
+				c.line = append(c.line, b...)
+				c.ready = append(c.ready, b...)
+				if len(c.line) > maxChunkLineBytes {
+					c.state = statePassthrough
+				}
+				return
+			}
+			c.line = append(c.line, b[:i]...)
+			c.ready = append(c.ready, b[:i+1]...)
+			b = b[i+1:]
+			c.endLine(strings.TrimSuffix(string(c.line), "\r"))
+			c.line = c.line[:0]
+		}
+	}
+}
+
+// endLine advances the chunked framing past a complete line.
+func (c *conn) endLine(line string) {
+	switch c.state {
+	case stateChunkSize:
+		size, _, _ := strings.Cut(line, ";")
+		n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+		switch {
+		case err != nil || n < 0:
+			c.state = statePassthrough
+		case n == 0:
+			c.state = stateTrailer
+		default:
+			c.remaining = n
+			c.state = stateChunkData
+		}
+	case stateChunkDataEnd:
+		if line != "" {
+			c.state = statePassthrough
+			return
+		}
+		c.state = stateChunkSize
+	case stateTrailer:
+		if line == "" {
+			c.state = stateHead
+		}
+	}
+}
+
+// headEnd returns the index just past the blank line ending the head in b,
+// searching from the given index, or -1 if the head is incomplete.
+func headEnd(b []byte, from int) int {
+	for i := from; i < len(b); i++ {
+		if b[i] != '\n' {
+			continue
+		}
+		switch {
+		case i+1 < len(b) && b[i+1] == '\n':
+			return i + 2
+		case i+2 < len(b) && b[i+1] == '\r' && b[i+2] == '\n':
+			return i + 3
+		}
+	}
+	return -1
+}
+
+// inspectHead records the findings for a complete request head and sets the
+// framing of the body following it, the way the server will.
+func (c *conn) inspectHead(head []byte) {
+	lines := strings.Split(string(head), "\n")
+	method, rest, _ := strings.Cut(strings.TrimSuffix(lines[0], "\r"), " ")
+	target, proto, _ := strings.Cut(rest, " ")
+	if proto != "HTTP/1.1" && proto != "HTTP/1.0" {
+		c.state = statePassthrough
+		return
+	}
+	var transferEncodings, contentLengths []string
+	upgrade := false
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(strings.TrimSuffix(line, "\r"), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(name) {
+		case "transfer-encoding":
+			transferEncodings = append(transferEncodings, value)
+		case "content-length":
+			contentLengths = append(contentLengths, value)
+		case "upgrade":
+			upgrade = true
+		}
+	}
+
+	var reasons []string
+	if len(transferEncodings) > 0 {
+		if len(contentLengths) > 0 {
+			reasons = append(reasons, ReasonFramingConflict)
+		} else if proto == "HTTP/1.0" {
+			reasons = append(reasons, ReasonTransferEncodingHTTP10)
+		}
+	}
+	// The server answers "OPTIONS *" itself, without calling the handler.
+	if method != "OPTIONS" || target != "*" {
+		c.locker.Lock()
+		c.findings = append(c.findings, reasons)
+		c.locker.Unlock()
+	}
+
+	switch {
+	case upgrade || method == "CONNECT":
+		// The connection may carry another protocol from here on.
+		c.state = statePassthrough
+	case len(transferEncodings) > 0 && proto == "HTTP/1.1":
+		if len(transferEncodings) != 1 || !strings.EqualFold(transferEncodings[0], "chunked") {
+			// The server rejects the request and closes the connection.
+			c.state = statePassthrough
+			return
+		}
+		c.state = stateChunkSize
+	case len(contentLengths) > 0:
+		n, err := strconv.ParseInt(contentLengths[0], 10, 64)
+		if err != nil || n < 0 {
+			c.state = statePassthrough
+			return
+		}
+		for _, v := range contentLengths[1:] {
+			if v != contentLengths[0] {
+				c.state = statePassthrough
+				return
+			}
+		}
+		if n > 0 {
+			c.remaining = n
+			c.state = stateBody
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Package smuggling provides an HTTP middleware handler hardening servers
+// directly exposed to the internet against request smuggling. Requests whose
+// framing or headers may be interpreted differently by other HTTP
+// implementations are reported, and optionally rejected or normalized before
+// routing.
+package smuggling
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+// Reasons a request is found suspicious.
+const (
+	// ReasonFramingConflict is a request carrying both Transfer-Encoding and
+	// Content-Length headers.
+	ReasonFramingConflict = "framing_conflict"
+	// ReasonTransferEncodingHTTP10 is an HTTP/1.0 request carrying a
+	// Transfer-Encoding header, which HTTP/1.0 does not define.
+	ReasonTransferEncodingHTTP10 = "transfer_encoding_http10"
+	// ReasonUnderscoreHeader is a request carrying a header whose name
+	// contains an underscore, which some proxies treat as a hyphen.
+	ReasonUnderscoreHeader = "underscore_header"
+	// ReasonUnexpectedBody is a GET, HEAD, OPTIONS or TRACE request carrying
+	// a body, which some proxies ignore.
+	ReasonUnexpectedBody = "unexpected_body"
+)
+
+var logger = common.NewLogger("smuggling")
+
+// Error lists the reasons a request was found suspicious.
+type Error struct {
+	Reasons []string
+}
+
+func (e *Error) Error() string {
+	return "suspicious request: " + strings.Join(e.Reasons, ", ")
+}
+
+// Config configures the handling of suspicious requests, which are only
+// reported by default.
+type Config struct {
+	// Strict rejects suspicious requests with 400 Bad Request and closes
+	// their connections.
+	Strict bool
+	// Normalize removes underscored headers and unexpected bodies from
+	// suspicious requests that are not rejected. Framing conflicts are
+	// always resolved by the server in favor of Transfer-Encoding.
+	Normalize bool
+}
+
+type Dependencies interface {
+	// ReportSuspiciousRequest is called for every suspicious request before
+	// it is rejected or normalized.
+	ReportSuspiciousRequest(*http.Request, *Error)
+	// AddCounter reports a metric with the given labels.
+	AddCounter(name string, labels map[string]string, delta float64)
+}
+
+func NewMiddlewareHandler(deps Dependencies, next http.Handler, config Config) common.MiddlewareHandler {
+	return &handler{deps: deps, next: next, config: config}
+}
+
+type handler struct {
+	deps   Dependencies
+	next   http.Handler
+	config Config
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reasons := inspect(r)
+	if len(reasons) == 0 {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	err := &Error{Reasons: reasons}
+	logger.Debug("ServeHTTP", "Request for %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+	for _, reason := range reasons {
+		h.deps.AddCounter("sudsy_suspicious_requests_total", map[string]string{"reason": reason}, 1)
+	}
+	h.deps.ReportSuspiciousRequest(r, err)
+	if h.config.Strict {
+		w.Header().Set("Connection", "close")
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if h.config.Normalize {
+		r = normalize(r)
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// inspect returns the reasons r is suspicious, including those recorded on
+// its connection.
+func inspect(r *http.Request) []string {
+	var result []string
+	if c, ok := r.Context().Value(connContextKey{}).(*conn); ok && r.ProtoMajor == 1 {
+		result = append(result, c.takeFindings()...)
+	}
+	for name := range r.Header {
+		if strings.Contains(name, "_") {
+			result = append(result, ReasonUnderscoreHeader)
+			break
+		}
+	}
+	if hasUnexpectedBody(r) {
+		result = append(result, ReasonUnexpectedBody)
+	}
+	return result
+}
+
+func hasUnexpectedBody(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return r.ContentLength != 0
+	}
+	return false
+}
+
+// normalize returns a copy of r without underscored headers and unexpected
+// bodies. The server still discards the original body.
+func normalize(r *http.Request) *http.Request {
+	r = r.Clone(r.Context())
+	for name := range r.Header {
+		if strings.Contains(name, "_") {
+			delete(r.Header, name)
+		}
+	}
+	if hasUnexpectedBody(r) {
+		r.Body = http.NoBody
+		r.ContentLength = 0
+		r.TransferEncoding = nil
+		r.Header.Del("Content-Length")
+	}
+	return r
+}
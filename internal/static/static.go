@@ -0,0 +1,188 @@
+// Package static serves files from an fs.FS, with validators for conditional
+// requests and configurable caching.
+package static
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+var logger = common.NewLogger("static")
+
+// indexFile is served for directory requests.
+const indexFile = "index.html"
+
+// Config configures the handler.
+type Config struct {
+	// CacheControl is the value of the Cache-Control header of the files
+	// served, if not empty.
+	CacheControl string
+	// CacheControlFunc returns the Cache-Control value of the file with the
+	// given name, e.g. to cache fingerprinted assets longer than index.html,
+	// overriding CacheControl if not nil.
+	CacheControlFunc func(name string) string
+	// DisableDirectoryListing responds 404 to requests for directories
+	// without an index.html.
+	DisableDirectoryListing bool
+}
+
+// Dependencies produces the handler's error responses.
+type Dependencies interface {
+	HandleStatus(w http.ResponseWriter, r *http.Request, code int, err error)
+}
+
+// NewHandler returns a handler serving the files of fsys, named by the
+// request path. Directories are served their index.html, or listed.
+func NewHandler(deps Dependencies, fsys fs.FS, c Config) http.Handler {
+	return &handler{
+		deps:       deps,
+		fsys:       fsys,
+		config:     c,
+		fileServer: http.FileServerFS(fsys),
+	}
+}
+
+type handler struct {
+	deps       Dependencies
+	fsys       fs.FS
+	config     Config
+	fileServer http.Handler
+	// contentETags caches the validators of files without a modification
+	// time, such as those embedded in the binary, keyed by name.
+	contentETags sync.Map
+}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	f, info, err := h.open(name)
+	if err != nil {
+		h.handleOpenError(w, r, err)
+		return
+	}
+	defer f.Close()
+	if info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			// Relative, since the request path may lack the prefixes
+			// stripped by the section.
+			redirect(w, r, path.Base(r.URL.Path)+"/")
+			return
+		}
+		index, indexInfo, err := h.open(path.Join(name, indexFile))
+		if errors.Is(err, fs.ErrNotExist) {
+			if h.config.DisableDirectoryListing {
+				h.deps.HandleStatus(w, r, http.StatusNotFound, nil)
+				return
+			}
+			h.fileServer.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			h.handleOpenError(w, r, err)
+			return
+		}
+		defer index.Close()
+		name, f, info = path.Join(name, indexFile), index, indexInfo
+	}
+	h.serveFile(w, r, name, f, info)
+}
+
+// open opens the named file and returns its info.
+func (h *handler) open(name string) (fs.File, fs.FileInfo, error) {
+	f, err := h.fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+func (h *handler) handleOpenError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		h.deps.HandleStatus(w, r, http.StatusNotFound, nil)
+	case errors.Is(err, fs.ErrPermission):
+		h.deps.HandleStatus(w, r, http.StatusForbidden, err)
+	default:
+		h.deps.HandleStatus(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+// serveFile serves the file, leaving range and conditional requests and the
+// content type to http.ServeContent.
+func (h *handler) serveFile(w http.ResponseWriter, r *http.Request, name string, f fs.File, info fs.FileInfo) {
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			h.deps.HandleStatus(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		content = bytes.NewReader(b)
+	}
+	header := w.Header()
+	if etag, err := h.etag(name, content, info); err == nil {
+		header.Set("etag", etag)
+	} else {
+		logger.Debug("serveFile", "Not setting ETag for %s: %s", name, err)
+	}
+	cacheControl := h.config.CacheControl
+	if h.config.CacheControlFunc != nil {
+		cacheControl = h.config.CacheControlFunc(name)
+	}
+	if cacheControl != "" {
+		header.Set("cache-control", cacheControl)
+	}
+	header.Set("x-content-type-options", "nosniff")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), content)
+}
+
+// etag returns a strong validator from the size and modification time of
+// the file, or from its content if its modification time is unknown, leaving
+// content positioned at its start.
+func (h *handler) etag(name string, content io.ReadSeeker, info fs.FileInfo) (string, error) {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()), nil
+	}
+	if etag, found := h.contentETags.Load(name); found {
+		return etag.(string), nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	h.contentETags.Store(name, etag)
+	return etag, nil
+}
+
+// redirect responds with a redirect to the relative target, keeping the
+// query.
+func redirect(w http.ResponseWriter, r *http.Request, target string) {
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("location", target)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
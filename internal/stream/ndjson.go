@@ -0,0 +1,160 @@
+// Package stream writes incremental responses such as newline-delimited JSON.
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+// ContentTypeNDJSON is the media type of newline-delimited JSON.
+const ContentTypeNDJSON = "application/x-ndjson"
+
+var (
+	ErrStreamClosed = errors.New("stream closed")
+
+	logger = common.NewLogger("stream")
+)
+
+// NDJSONConfig configures an NDJSONWriter. Zero values select the defaults.
+type NDJSONConfig struct {
+	// FlushEvery flushes after the given number of records. Defaults to 1,
+	// flushing every record.
+	FlushEvery int
+	// FlushInterval flushes when the given time has passed since the last
+	// flush, regardless of FlushEvery. Records are only flushed when written,
+	// so an idle stream is not flushed.
+	FlushInterval time.Duration
+	// WriteTimeout bounds each write and flush, so a client that stops
+	// reading fails the stream rather than blocking the handler indefinitely.
+	WriteTimeout time.Duration
+}
+
+// NDJSONErrorRecord is the final record written by NDJSONWriter.Fail,
+// signaling to clients that the stream ended early.
+type NDJSONErrorRecord struct {
+	Error string `json:"error"`
+}
+
+// NDJSONWriter encodes records as newline-delimited JSON.
+type NDJSONWriter struct {
+	config     NDJSONConfig
+	controller *http.ResponseController
+	encoder    *json.Encoder
+	lastFlush  time.Time
+	pending    int
+	request    *http.Request
+	closed     bool
+	started    bool
+	w          http.ResponseWriter
+}
+
+func NewNDJSONWriter(w http.ResponseWriter, r *http.Request, config NDJSONConfig) *NDJSONWriter {
+	if config.FlushEvery < 1 {
+		config.FlushEvery = 1
+	}
+	return &NDJSONWriter{
+		config:     config,
+		controller: http.NewResponseController(w),
+		encoder:    json.NewEncoder(w),
+		request:    r,
+		w:          w,
+	}
+}
+
+// Encode writes v as a single record. It returns the request context's error
+// once the client has gone away, and any error encountered writing or
+// flushing, after which the stream should be abandoned.
+func (s *NDJSONWriter) Encode(v any) error {
+	if s.closed {
+		return ErrStreamClosed
+	}
+	if err := s.request.Context().Err(); err != nil {
+		logger.Debug("Encode", "Client gone: %s", err)
+		s.closed = true
+		return err
+	}
+	s.start()
+	if err := s.setWriteDeadline(); err != nil {
+		return err
+	}
+	if err := s.encoder.Encode(v); err != nil {
+		s.closed = true
+		return err
+	}
+	s.pending++
+	if s.pending >= s.config.FlushEvery ||
+		(s.config.FlushInterval > 0 && time.Since(s.lastFlush) >= s.config.FlushInterval) {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Fail ends the stream with an NDJSONErrorRecord describing err. Since the
+// status code has usually been sent already, this is the conventional way of
+// reporting failures after streaming has begun.
+func (s *NDJSONWriter) Fail(err error) error {
+	if encodeErr := s.Encode(NDJSONErrorRecord{Error: err.Error()}); encodeErr != nil {
+		return encodeErr
+	}
+	return s.Close()
+}
+
+// Flush sends buffered records to the client.
+func (s *NDJSONWriter) Flush() error {
+	if s.closed {
+		return ErrStreamClosed
+	}
+	s.start()
+	if err := s.setWriteDeadline(); err != nil {
+		return err
+	}
+	if err := s.controller.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		s.closed = true
+		return err
+	}
+	s.pending = 0
+	s.lastFlush = time.Now()
+	return nil
+}
+
+// Close flushes any buffered records. Further writes fail with
+// ErrStreamClosed.
+func (s *NDJSONWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	err := s.Flush()
+	s.closed = true
+	return err
+}
+
+// start writes the response header ahead of the first record.
+func (s *NDJSONWriter) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	h := s.w.Header()
+	if h.Get("content-type") == "" {
+		h.Set("content-type", ContentTypeNDJSON)
+	}
+	h.Set("x-content-type-options", "nosniff")
+	s.w.WriteHeader(http.StatusOK)
+	s.lastFlush = time.Now()
+}
+
+func (s *NDJSONWriter) setWriteDeadline() error {
+	if s.config.WriteTimeout <= 0 {
+		return nil
+	}
+	err := s.controller.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		s.closed = true
+		return err
+	}
+	return nil
+}
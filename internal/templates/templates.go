@@ -0,0 +1,79 @@
+// Package templates parses sets of HTML templates from a file system, once in
+// production and afresh for every use in development mode, so that edits
+// show without restarting the application.
+package templates
+
+import (
+	"errors"
+	"html/template"
+	"io/fs"
+	"sync"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+var (
+	ErrNoPatterns = errors.New("no template patterns")
+
+	logger = common.NewLogger("templates")
+)
+
+type Set struct {
+	fsys     fs.FS
+	funcs    template.FuncMap
+	patterns []string
+
+	locker sync.Mutex
+	// parsed is never executed, so that it can be cloned, while executable
+	// is a clone shared by uses not binding functions of their own.
+	parsed     *template.Template
+	executable *template.Template
+}
+
+func NewSet(fsys fs.FS, funcs template.FuncMap, patterns ...string) *Set {
+	return &Set{fsys: fsys, funcs: funcs, patterns: patterns}
+}
+
+// Template returns the parsed templates, parsing them if reload is true or
+// they have not been parsed successfully before. The templates last parsed
+// successfully are kept for later uses without reload.
+func (s *Set) Template(reload bool) (*template.Template, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if err := s.parse(reload); err != nil {
+		return nil, err
+	}
+	return s.executable, nil
+}
+
+// Clone is like Template, but returns a copy of the templates whose functions
+// can be replaced before it is executed, e.g. to bind values of a request.
+func (s *Set) Clone(reload bool) (*template.Template, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if err := s.parse(reload); err != nil {
+		return nil, err
+	}
+	return s.parsed.Clone()
+}
+
+func (s *Set) parse(reload bool) error {
+	if s.parsed != nil && !reload {
+		return nil
+	}
+	if len(s.patterns) == 0 {
+		return ErrNoPatterns
+	}
+	t, err := template.New("").Funcs(s.funcs).ParseFS(s.fsys, s.patterns...)
+	if err != nil {
+		return err
+	}
+	executable, err := t.Clone()
+	if err != nil {
+		return err
+	}
+	logger.Debug("parse", "Parsed templates matching %v", s.patterns)
+	s.parsed = t
+	s.executable = executable
+	return nil
+}
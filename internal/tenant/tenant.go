@@ -0,0 +1,132 @@
+// Package tenant resolves the tenant a request belongs to and provides an HTTP
+// middleware handler exposing it to the rest of the request pipeline.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/responseinfo"
+)
+
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+
+	logger = common.NewLogger("tenant")
+)
+
+// Resolver returns the ID of the tenant a request belongs to.
+type Resolver func(*http.Request) (string, error)
+
+// NewSubdomainResolver returns a Resolver taking the tenant ID from the label
+// of the request's host immediately preceding baseDomain, e.g. "acme" for
+// "acme.example.com" with a base domain of "example.com".
+func NewSubdomainResolver(baseDomain string) Resolver {
+	suffix := "." + strings.ToLower(strings.Trim(baseDomain, "."))
+	return func(r *http.Request) (string, error) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		rest, found := strings.CutSuffix(host, suffix)
+		if !found || rest == "" {
+			return "", fmt.Errorf("%w: host %s", ErrTenantNotFound, r.Host)
+		}
+		if i := strings.LastIndexByte(rest, '.'); i >= 0 {
+			rest = rest[i+1:]
+		}
+		return rest, nil
+	}
+}
+
+// NewHeaderResolver returns a Resolver taking the tenant ID from the named
+// request header.
+func NewHeaderResolver(name string) Resolver {
+	return func(r *http.Request) (string, error) {
+		if v := strings.TrimSpace(r.Header.Get(name)); v != "" {
+			return v, nil
+		}
+		return "", fmt.Errorf("%w: missing %s header", ErrTenantNotFound, name)
+	}
+}
+
+// NewPathSegmentResolver returns a Resolver taking the tenant ID from the
+// path segment at the given zero-based index, e.g. "acme" at index 1 of
+// "/tenants/acme/orders".
+func NewPathSegmentResolver(index int) Resolver {
+	return func(r *http.Request) (string, error) {
+		// Segments are those the section routes, so that escaped slashes
+		// are data within a segment.
+		segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+		if index < 0 || index >= len(segments) || segments[index] == "" {
+			return "", fmt.Errorf("%w: path %s", ErrTenantNotFound, r.URL.Path)
+		}
+		id, err := url.PathUnescape(segments[index])
+		if err != nil {
+			return "", fmt.Errorf("%w: path %s: %s", ErrTenantNotFound, r.URL.Path, err)
+		}
+		return id, nil
+	}
+}
+
+type contextKey struct{}
+
+// FromContext returns the tenant ID stored in ctx by the middleware handler.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok
+}
+
+// NewContext returns a copy of ctx carrying the tenant ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+type Dependencies interface {
+	HandleStatusBadRequest(http.ResponseWriter, *http.Request, error)
+}
+
+// NewMiddlewareHandler returns a handler storing the tenant ID resolved by
+// resolver in the request context. Requests whose tenant cannot be resolved
+// are passed to the bad request handler.
+func NewMiddlewareHandler(deps Dependencies, resolver Resolver, next http.Handler) common.MiddlewareHandler {
+	return &handler{
+		deps:     deps,
+		next:     next,
+		resolver: resolver,
+	}
+}
+
+type handler struct {
+	deps     Dependencies
+	next     http.Handler
+	resolver Resolver
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(*sync.WaitGroup) {}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := h.resolver(r)
+	if err != nil {
+		logger.Debug("ServeHTTP", "Error resolving tenant: %s", err)
+		h.deps.HandleStatusBadRequest(w, r, err)
+		return
+	}
+	if info, found := responseinfo.FromContext(r.Context()); found {
+		info.Tenant = id
+	}
+	h.next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+}
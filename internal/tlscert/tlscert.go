@@ -0,0 +1,285 @@
+// Package tlscert manages the server certificate loaded from files, keeping
+// an OCSP staple fresh and rotating TLS session ticket keys.
+package tlscert
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/common"
+	"golang.org/x/crypto/ocsp"
+)
+
+var logger = common.NewLogger("tlscert")
+
+const (
+	// ocspRetryInterval is how long to wait before retrying a failed OCSP
+	// fetch.
+	ocspRetryInterval = 5 * time.Minute
+
+	// ocspMinRefreshInterval bounds how frequently a staple is refreshed.
+	ocspMinRefreshInterval = time.Minute
+)
+
+// SessionTicketKeyProvider supplies TLS session ticket keys. The first key
+// encrypts new tickets; all keys are accepted for decryption. Implementations
+// backed by shared storage allow several instances to resume each other's
+// sessions.
+type SessionTicketKeyProvider interface {
+	SessionTicketKeys() ([][32]byte, error)
+}
+
+type Dependencies interface {
+	Now() time.Time
+	// ReportError is called when a background refresh fails.
+	ReportError(error)
+}
+
+type Manager interface {
+	AfterShutdown()
+	BeforeStart(*sync.WaitGroup)
+	// Configure loads the certificate and installs it in c along with the
+	// initial session ticket keys. Rotated keys are installed in c as well,
+	// so that connections must be accepted with c itself, e.g. by a listener
+	// returned by tls.NewListener, rather than with a clone of it.
+	Configure(c *tls.Config) error
+	SetOCSPStapling(bool)
+	SetSessionTicketKeyProvider(SessionTicketKeyProvider)
+	SetSessionTicketKeyRotationInterval(time.Duration)
+}
+
+func NewManager(deps Dependencies, certFile, keyFile string) Manager {
+	return &manager{
+		deps:                             deps,
+		certFile:                         certFile,
+		keyFile:                          keyFile,
+		certLocker:                       &sync.RWMutex{},
+		httpClient:                       &http.Client{Timeout: 30 * time.Second},
+		sessionTicketKeyProvider:         newRandomSessionTicketKeyProvider(3),
+		sessionTicketKeyRotationInterval: 12 * time.Hour,
+		quit:                             make(chan bool),
+	}
+}
+
+type manager struct {
+	deps Dependencies
+
+	certFile string
+
+	keyFile string
+
+	certLocker *sync.RWMutex
+
+	cert *tls.Certificate
+
+	tlsConfig *tls.Config
+
+	httpClient *http.Client
+
+	ocspStapling bool
+
+	sessionTicketKeyProvider SessionTicketKeyProvider
+
+	sessionTicketKeyRotationInterval time.Duration
+
+	quit chan bool
+}
+
+// AfterShutdown implements Manager.
+func (m *manager) AfterShutdown() {
+	close(m.quit)
+}
+
+// BeforeStart implements Manager.
+func (m *manager) BeforeStart(wg *sync.WaitGroup) {
+	if m.sessionTicketKeyRotationInterval > 0 {
+		wg.Add(1)
+		go m.startSessionTicketKeyRotationLoop(wg)
+	}
+	if m.ocspStapling {
+		wg.Add(1)
+		go m.startOCSPRefreshLoop(wg)
+	}
+}
+
+// Configure implements Manager.
+func (m *manager) Configure(c *tls.Config) error {
+	cert, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading key pair: %w", err)
+	}
+	m.cert = &cert
+	m.tlsConfig = c
+	c.GetCertificate = m.getCertificate
+	if err := m.rotateSessionTicketKeys(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetOCSPStapling implements Manager.
+func (m *manager) SetOCSPStapling(v bool) {
+	m.ocspStapling = v
+}
+
+// SetSessionTicketKeyProvider implements Manager.
+func (m *manager) SetSessionTicketKeyProvider(p SessionTicketKeyProvider) {
+	m.sessionTicketKeyProvider = p
+}
+
+// SetSessionTicketKeyRotationInterval implements Manager.
+func (m *manager) SetSessionTicketKeyRotationInterval(d time.Duration) {
+	m.sessionTicketKeyRotationInterval = d
+}
+
+func (m *manager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.certLocker.RLock()
+	defer m.certLocker.RUnlock()
+	return m.cert, nil
+}
+
+func (m *manager) rotateSessionTicketKeys() error {
+	keys, err := m.sessionTicketKeyProvider.SessionTicketKeys()
+	if err != nil {
+		return fmt.Errorf("getting session ticket keys: %w", err)
+	}
+	if len(keys) < 1 {
+		return errors.New("no session ticket keys provided")
+	}
+	m.tlsConfig.SetSessionTicketKeys(keys)
+	return nil
+}
+
+func (m *manager) startSessionTicketKeyRotationLoop(wg *sync.WaitGroup) {
+	defer logger.Debug("startSessionTicketKeyRotationLoop", "exited")
+	defer wg.Done()
+	ticker := time.NewTicker(m.sessionTicketKeyRotationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.quit:
+			return
+		case <-ticker.C:
+			if err := m.rotateSessionTicketKeys(); err != nil {
+				logger.Debug("startSessionTicketKeyRotationLoop", "Error rotating session ticket keys: %s", err)
+				m.deps.ReportError(fmt.Errorf("rotating session ticket keys: %w", err))
+			}
+		}
+	}
+}
+
+func (m *manager) startOCSPRefreshLoop(wg *sync.WaitGroup) {
+	defer logger.Debug("startOCSPRefreshLoop", "exited")
+	defer wg.Done()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-m.quit:
+			return
+		case <-timer.C:
+			next, err := m.refreshOCSPStaple()
+			if err != nil {
+				logger.Debug("startOCSPRefreshLoop", "Error refreshing OCSP staple: %s", err)
+				m.deps.ReportError(fmt.Errorf("refreshing OCSP staple: %w", err))
+				next = ocspRetryInterval
+			}
+			timer.Reset(next)
+		}
+	}
+}
+
+// refreshOCSPStaple fetches a new OCSP response for the certificate and
+// returns how long to wait before the next refresh.
+func (m *manager) refreshOCSPStaple() (time.Duration, error) {
+	m.certLocker.RLock()
+	current := m.cert
+	m.certLocker.RUnlock()
+	if len(current.Certificate) < 2 {
+		return 0, errors.New("certificate file does not include the issuer")
+	}
+	leaf, err := x509.ParseCertificate(current.Certificate[0])
+	if err != nil {
+		return 0, fmt.Errorf("parsing leaf certificate: %w", err)
+	}
+	issuer, err := x509.ParseCertificate(current.Certificate[1])
+	if err != nil {
+		return 0, fmt.Errorf("parsing issuer certificate: %w", err)
+	}
+	if len(leaf.OCSPServer) < 1 {
+		return 0, errors.New("certificate does not name an OCSP server")
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return 0, fmt.Errorf("creating OCSP request: %w", err)
+	}
+	httpResp, err := m.httpClient.Post(
+		leaf.OCSPServer[0],
+		"application/ocsp-request",
+		bytes.NewReader(req),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("sending OCSP request: %w", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected OCSP response status %d", httpResp.StatusCode)
+	}
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return 0, fmt.Errorf("reading OCSP response: %w", err)
+	}
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return 0, fmt.Errorf("parsing OCSP response: %w", err)
+	}
+	if resp.Status != ocsp.Good {
+		return 0, fmt.Errorf("OCSP status is %d", resp.Status)
+	}
+	updated := *current
+	updated.OCSPStaple = raw
+	m.certLocker.Lock()
+	m.cert = &updated
+	m.certLocker.Unlock()
+	logger.Debug("refreshOCSPStaple", "Stapled OCSP response valid until %s", resp.NextUpdate)
+
+	// Refresh halfway through the validity window.
+	next := resp.NextUpdate.Sub(resp.ThisUpdate) / 2
+	if elapsed := m.deps.Now().Sub(resp.ThisUpdate); elapsed > 0 {
+		next -= elapsed
+	}
+	return max(next, ocspMinRefreshInterval), nil
+}
+
+// randomSessionTicketKeyProvider generates a new random key on every call,
+// retaining a number of previous keys so recently issued tickets remain
+// valid.
+type randomSessionTicketKeyProvider struct {
+	keys     [][32]byte
+	retained int
+}
+
+// SessionTicketKeys implements SessionTicketKeyProvider.
+func (p *randomSessionTicketKeyProvider) SessionTicketKeys() ([][32]byte, error) {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, fmt.Errorf("generating session ticket key: %w", err)
+	}
+	p.keys = append([][32]byte{key}, p.keys...)
+	if len(p.keys) > p.retained {
+		p.keys = p.keys[:p.retained]
+	}
+	return p.keys, nil
+}
+
+func newRandomSessionTicketKeyProvider(retained int) SessionTicketKeyProvider {
+	return &randomSessionTicketKeyProvider{retained: retained}
+}
@@ -0,0 +1,165 @@
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testDependencies struct {
+	t *testing.T
+}
+
+func (testDependencies) Now() time.Time { return time.Now() }
+
+func (d testDependencies) ReportError(err error) { d.t.Error(err) }
+
+// testKeyProvider returns the keys it is set to.
+type testKeyProvider struct {
+	keys [][32]byte
+}
+
+// SessionTicketKeys implements SessionTicketKeyProvider.
+func (p *testKeyProvider) SessionTicketKeys() ([][32]byte, error) {
+	return p.keys, nil
+}
+
+// TestSessionTicketKeyRotation checks that sessions are resumed with the
+// tickets encrypted with any of the keys the provider returns after a
+// rotation, and not with those encrypted with keys it no longer returns.
+func TestSessionTicketKeyRotation(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	provider := &testKeyProvider{keys: [][32]byte{{1}}}
+	m := NewManager(testDependencies{t: t}, certFile, keyFile).(*manager)
+	m.SetSessionTicketKeyProvider(provider)
+	config := &tls.Config{}
+	if err := m.Configure(config); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serveTestConnections(tls.NewListener(ln, config))
+
+	rotated := newTestClient(t, ln.Addr().String())
+	expired := newTestClient(t, ln.Addr().String())
+	for _, c := range []*testClient{rotated, expired} {
+		if c.connect() {
+			t.Fatal("first connection resumed a session")
+		}
+	}
+
+	// The ticket encrypted with the first key is accepted while the key is
+	// retained, and the client is issued one encrypted with the new key.
+	provider.keys = [][32]byte{{2}, {1}}
+	if err := m.rotateSessionTicketKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if !rotated.connect() {
+		t.Error("session not resumed with the retained key")
+	}
+
+	provider.keys = [][32]byte{{3}, {2}}
+	if err := m.rotateSessionTicketKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if !rotated.connect() {
+		t.Error("session not resumed with the rotated key")
+	}
+	if expired.connect() {
+		t.Error("session resumed with an expired key")
+	}
+}
+
+type testClient struct {
+	t      *testing.T
+	addr   string
+	config *tls.Config
+}
+
+func newTestClient(t *testing.T, addr string) *testClient {
+	return &testClient{
+		t:    t,
+		addr: addr,
+		config: &tls.Config{
+			InsecureSkipVerify: true,
+			ClientSessionCache: tls.NewLRUClientSessionCache(1),
+		},
+	}
+}
+
+// connect reports whether the connection resumed a session. The client reads
+// the server's response, so that it receives the ticket issued after the
+// handshake.
+func (c *testClient) connect() bool {
+	conn, err := tls.Dial("tcp", c.addr, c.config)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.ReadFull(conn, make([]byte, 1)); err != nil {
+		c.t.Fatal(err)
+	}
+	return conn.ConnectionState().DidResume
+}
+
+func serveTestConnections(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			_, _ = conn.Write([]byte{0})
+			_, _ = io.Copy(io.Discard, conn)
+		}()
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate and its key to
+// files, returning their names.
+func writeTestCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
@@ -0,0 +1,49 @@
+// Package tlspolicy builds *tls.Config values for the server listener from a
+// small set of typed settings with secure defaults.
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"slices"
+)
+
+type Policy struct {
+	MinVersion       uint16
+	CipherSuites     []uint16
+	CurvePreferences []tls.CurveID
+	NextProtos       []string
+	ClientAuth       tls.ClientAuthType
+	ClientCAs        *x509.CertPool
+}
+
+// NewDefaultPolicy returns a policy requiring TLS 1.2 or later with AEAD
+// cipher suites only.
+func NewDefaultPolicy() Policy {
+	return Policy{
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		NextProtos:       []string{"h2", "http/1.1"},
+	}
+}
+
+// NewConfig returns a new *tls.Config applying the policy. Cipher suites only
+// affect TLS 1.2 and earlier; TLS 1.3 suites are not configurable.
+func (p Policy) NewConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:       p.MinVersion,
+		CipherSuites:     slices.Clone(p.CipherSuites),
+		CurvePreferences: slices.Clone(p.CurvePreferences),
+		NextProtos:       slices.Clone(p.NextProtos),
+		ClientAuth:       p.ClientAuth,
+		ClientCAs:        p.ClientCAs,
+	}
+}
@@ -0,0 +1,71 @@
+// Package unmatched counts request paths that matched no route, keeping a
+// bounded number of distinct paths.
+package unmatched
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+)
+
+// OtherPath is the path under which requests are counted once the number of
+// distinct paths tracked reaches the limit.
+const OtherPath = "(other)"
+
+type PathCount struct {
+	Path  string `json:"path"`
+	Count int64  `json:"count"`
+}
+
+type Tracker interface {
+	Record(path string)
+	SetLimit(n int)
+	// Stats returns the counts recorded, most frequent first.
+	Stats() []PathCount
+}
+
+func NewTracker() Tracker {
+	return &tracker{
+		locker: &sync.Mutex{},
+		counts: map[string]int64{},
+		limit:  100,
+	}
+}
+
+type tracker struct {
+	locker sync.Locker
+	counts map[string]int64
+	limit  int
+}
+
+// Record implements Tracker.
+func (t *tracker) Record(path string) {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	if _, found := t.counts[path]; !found && len(t.counts) >= t.limit {
+		path = OtherPath
+	}
+	t.counts[path]++
+}
+
+// SetLimit implements Tracker.
+func (t *tracker) SetLimit(n int) {
+	t.limit = n
+}
+
+// Stats implements Tracker.
+func (t *tracker) Stats() []PathCount {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	result := make([]PathCount, 0, len(t.counts))
+	for p, c := range t.counts {
+		result = append(result, PathCount{Path: p, Count: c})
+	}
+	slices.SortFunc(result, func(l, r PathCount) int {
+		if c := cmp.Compare(r.Count, l.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(l.Path, r.Path)
+	})
+	return result
+}
@@ -0,0 +1,53 @@
+package urlpathpatternhandler
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/jakewan/sudsy/internal/pathparams"
+)
+
+// BuildPath returns the path matching pattern with the capture tokens
+// replaced by the values of params, keyed by token name without its leading
+// ":" or "*". Values are escaped, except for the slashes of catch-all values.
+// Params not used by the pattern are added as query parameters.
+func BuildPath(pattern string, params map[string]string) (string, error) {
+	used := make(map[string]bool, len(params))
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		if !strings.HasPrefix(part, ":") && !isCatchAll(part) {
+			continue
+		}
+		name := part[1:]
+		v, found := params[name]
+		if !found {
+			return "", fmt.Errorf("%w: %s", pathparams.ErrMissingParam, name)
+		}
+		used[name] = true
+		if isCatchAll(part) {
+			segments := strings.Split(v, "/")
+			for j, segment := range segments {
+				segments[j] = url.PathEscape(segment)
+			}
+			parts[i] = strings.Join(segments, "/")
+			continue
+		}
+		if v == "" {
+			return "", fmt.Errorf("%w: %s: empty segment", pathparams.ErrInvalidParam, name)
+		}
+		parts[i] = url.PathEscape(v)
+	}
+	result := strings.Join(parts, "/")
+	if len(used) == len(params) {
+		return result, nil
+	}
+	query := url.Values{}
+	for name, v := range params {
+		if !used[name] {
+			query.Set(name, v)
+		}
+	}
+	// Encode sorts the parameters by name.
+	return result + "?" + query.Encode(), nil
+}
@@ -0,0 +1,58 @@
+package urlpathpatternhandler
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// RoutingPath returns the path of u matched against patterns: its decoded
+// path, except for slashes escaped as "%2F" in the request URL, which are
+// kept escaped, so that per RFC 3986 they are data within a segment rather
+// than separate segments, e.g. "/files/a%2Fb" has the segments "files" and
+// "a%2Fb".
+func RoutingPath(u *url.URL) string {
+	if u.RawPath == "" {
+		return u.Path
+	}
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i, s := range segments {
+		decoded, err := url.PathUnescape(s)
+		if err != nil {
+			continue
+		}
+		segments[i] = strings.ReplaceAll(decoded, "/", "%2F")
+	}
+	return strings.Join(segments, "/")
+}
+
+// URLParams returns the values captured by h from the path of u, keyed as
+// h.Params does, percent-decoded unless h is configured with RawPathParams.
+// Values are captured from the escaped path, so that a value can contain
+// slashes escaped in the request URL. An error wrapping
+// ErrInvalidParamEncoding is returned if the escaped path of u is not a valid
+// encoding of its path, or a value is not validly encoded.
+func URLParams(h Handler, u *url.URL) (map[string]string, error) {
+	if u.RawPath != "" && u.EscapedPath() != u.RawPath {
+		// The escaped path set, e.g. by a proxy, is not a valid encoding of
+		// the path, so that the values cannot be told.
+		return nil, fmt.Errorf("%w: %q", ErrInvalidParamEncoding, u.RawPath)
+	}
+	raw := h.Config().RawPathParams
+	if u.RawPath == "" && !raw {
+		// The decoded path has the same segments.
+		return h.Params(u.Path), nil
+	}
+	result := h.Params(u.EscapedPath())
+	if raw {
+		return result, nil
+	}
+	for token, v := range result {
+		decoded, err := url.PathUnescape(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrInvalidParamEncoding, token, err)
+		}
+		result[token] = decoded
+	}
+	return result, nil
+}
@@ -0,0 +1,44 @@
+package urlpathpatternhandler
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func FuzzMatchPattern(f *testing.F) {
+	log.SetOutput(io.Discard)
+	for _, seed := range [][2]string{
+		{"/api/users/:id", "/api/users/42"},
+		{"/static/*", "/static/css/site.css"},
+		{"/files/*rest", "/files/a/b.txt"},
+		{"/files/*rest", "/files"},
+		{"*", "/"},
+		{"", ""},
+		{"/a/:b/c", "/a//c"},
+		{"/:a/:a", "/x/y/z"},
+	} {
+		f.Add(seed[0], seed[1])
+	}
+	f.Fuzz(func(t *testing.T, pattern, requestPath string) {
+		matched := MatchPattern(pattern, requestPath)
+		h := NewHandler(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}), struct{}{}, Config{})
+		found := ComparePatternHandlerToPath(h, requestPath) == 0
+		if found && !matched {
+			t.Errorf("ComparePatternHandlerToPath matched %q to %q but MatchPattern did not", pattern, requestPath)
+		}
+		h.Params(requestPath)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL = &url.URL{Path: requestPath}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if found && w.Code != http.StatusNoContent {
+			t.Errorf("unexpected status %d serving %q with %q", w.Code, requestPath, pattern)
+		}
+	})
+}
@@ -4,25 +4,172 @@ import (
 	"cmp"
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/cors"
+	"github.com/jakewan/sudsy/internal/flags"
+	"github.com/jakewan/sudsy/internal/rules"
+	"github.com/jakewan/sudsy/internal/sampling"
+	"github.com/jakewan/sudsy/internal/shedding"
 )
 
 var (
 	ErrAmbiguousCaptureVariableNames = errors.New("ambiguous capture variable names")
+	ErrContextKeyConflict            = errors.New("context key conflict")
+	ErrDuplicateRouteName            = errors.New("duplicate route name")
+	ErrInvalidMethod                 = errors.New("invalid method")
+	ErrInvalidParamEncoding          = errors.New("invalid path parameter encoding")
+	ErrMisplacedCatchAll             = errors.New("catch-all token not in last path segment")
+	ErrMatchersNotSatisfied          = errors.New("request does not satisfy the route matchers")
+	ErrMethodNotAllowed              = errors.New("method not allowed")
+	ErrUnnamedCatchAll               = errors.New("catch-all token without a name")
+	ErrUnknownRouteName              = errors.New("unknown route name")
 
 	logger = common.NewLogger("urlpathpatternhandler")
+	// hotPathLogger logs the messages of every request.
+	hotPathLogger = common.NewSampledLogger("urlpathpatternhandler")
 )
 
+// MethodAny in a route's methods serves the requests whose method no other
+// handler of the pattern serves, e.g. for proxies and WebDAV services, GET
+// handlers still serving HEAD requests before it.
+const MethodAny = "*"
+
+// ConflictError reports a pattern differing from another only in the names
+// of its capture variables, for methods both serve. It wraps
+// ErrAmbiguousCaptureVariableNames.
+type ConflictError struct {
+	Pattern            string
+	ConflictingPattern string
+	// Methods are the methods served by both patterns, empty meaning all
+	// methods.
+	Methods []string
+}
+
+func (e *ConflictError) Error() string {
+	methods := "all methods"
+	if len(e.Methods) > 0 {
+		methods = strings.Join(e.Methods, ", ")
+	}
+	return fmt.Sprintf("%s: pattern %q conflicts with %q for %s",
+		ErrAmbiguousCaptureVariableNames, e.Pattern, e.ConflictingPattern, methods)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrAmbiguousCaptureVariableNames
+}
+
+// ContextKeyConflictError reports a route storing its params under the same
+// context key as another route capturing different variables, neither
+// route's variables including the other's, so that a handler written for one
+// of the routes would find its variables missing if registered for the
+// other. It wraps ErrContextKeyConflict.
+type ContextKeyConflictError struct {
+	Pattern            string
+	ConflictingPattern string
+	ContextKey         any
+}
+
+func (e *ContextKeyConflictError) Error() string {
+	return fmt.Sprintf("%s: pattern %q shares context key %v (%T) with %q, which captures different variables",
+		ErrContextKeyConflict, e.Pattern, e.ContextKey, e.ContextKey, e.ConflictingPattern)
+}
+
+func (e *ContextKeyConflictError) Unwrap() error {
+	return ErrContextKeyConflict
+}
+
 type Handler interface {
 	http.Handler
+	Config() Config
+	// ContextKey returns the key the params are stored under in the request
+	// context.
+	ContextKey() any
+	// HandlerName identifies the handler routed to, by function name for
+	// http.HandlerFunc values and by type otherwise, e.g. "main.getUser" or
+	// "*main.userHandler".
+	HandlerName() string
+	// Params returns the path segments captured from requestPath, keyed by
+	// capture token including the leading ":", and the remainder of the path
+	// captured by a final catch-all token, keyed including the leading "*".
+	Params(requestPath string) map[string]string
 	Pattern() string
+	// ServeHTTPWithParams serves req, whose path is known to match the
+	// pattern, with the values captured from it as URLParams returns them,
+	// so that sections do not capture them twice. The handler may modify
+	// params.
+	ServeHTTPWithParams(w http.ResponseWriter, req *http.Request, params map[string]string)
+}
+
+// Config holds the optional settings of a pattern handler.
+type Config struct {
+	Metadata Metadata
+	// ContentTypes lists the media types accepted for requests with a body,
+	// overriding the section's list when non-empty.
+	ContentTypes []string
+	// CORS overrides the section's cross-origin configuration for the route.
+	CORS *cors.Config
+	// DisableAutoHead keeps the route from serving HEAD requests when it
+	// serves GET requests, so that HEAD requests are answered by another
+	// handler of the pattern or with 405 Method Not Allowed.
+	DisableAutoHead bool
+	// DisableAutoOptions keeps OPTIONS requests to the route's pattern from
+	// being answered with the methods served, see AnswersOptions.
+	DisableAutoOptions bool
+	// FeatureFlag gates the route behind a runtime feature flag.
+	FeatureFlag flags.Gate
+	// Matchers restrict the route to requests with all of the given
+	// attributes. Handlers of the same pattern serving the same methods must
+	// be told apart by their matchers, see ValidateResponders.
+	Matchers []Matcher
+	// PrefixedParamNames stores the captured values in the request context
+	// keyed by capture token, e.g. ":id", as sudsy did originally, instead
+	// of by variable name, e.g. "id".
+	PrefixedParamNames bool
+	// RawPathParams captures values as escaped in the request URL, e.g.
+	// "a%20b", instead of percent-decoding them, see URLParams.
+	RawPathParams bool
+	// Methods restricts the route to requests with the given methods, and to
+	// HEAD requests if GET is among them, unless DisableAutoHead is set.
+	// Methods are case-sensitive tokens, so that extension methods such as
+	// PROPFIND and REPORT can be served, or MethodAny. Empty means all
+	// methods. Handlers of the same pattern can serve disjoint sets of
+	// methods.
+	Methods []string
+	// Name identifies the route for building URLs to it, and must be unique
+	// within the application if not empty.
+	Name string
+	// Priority overrides the section's classification of requests to the
+	// route for load shedding.
+	Priority *shedding.Priority
+	// Rules are checked before the handler runs.
+	Rules rules.Set
+	// TraceSampler overrides the application's trace sampler for the route.
+	TraceSampler sampling.Sampler
 }
 
-func NewHandler(pattern string, handler http.Handler, contextKey any) Handler {
+// Metadata documents a route.
+type Metadata struct {
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Deprecated  bool     `json:"deprecated,omitempty"`
+	// DeprecatedAt is when the route was or will be deprecated.
+	DeprecatedAt *time.Time `json:"deprecatedAt,omitempty"`
+	// Sunset is when the route is expected to be removed.
+	Sunset *time.Time `json:"sunset,omitempty"`
+}
+
+func NewHandler(pattern string, handler http.Handler, contextKey any, config Config) Handler {
 	return &urlPatternHandler{
+		config:      config,
 		contextKey:  contextKey,
 		pattern:     pattern,
 		httpHandler: handler,
@@ -30,38 +177,124 @@ func NewHandler(pattern string, handler http.Handler, contextKey any) Handler {
 }
 
 type urlPatternHandler struct {
+	config      Config
 	contextKey  any
 	pattern     string
 	httpHandler http.Handler
 }
 
+// Config implements Handler.
+func (r *urlPatternHandler) Config() Config {
+	return r.config
+}
+
+// ContextKey implements Handler.
+func (r *urlPatternHandler) ContextKey() any {
+	return r.contextKey
+}
+
+// HandlerName implements Handler.
+func (r *urlPatternHandler) HandlerName() string {
+	return HandlerName(r.httpHandler)
+}
+
+// HandlerName identifies h, by function name for http.HandlerFunc values and
+// by type otherwise. Handlers wrapping another can report the wrapped handler
+// with an Unwrap method returning it.
+func HandlerName(h http.Handler) string {
+	for {
+		u, ok := h.(interface{ Unwrap() http.Handler })
+		if !ok {
+			break
+		}
+		h = u.Unwrap()
+	}
+	if f, ok := h.(http.HandlerFunc); ok {
+		if fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); fn != nil {
+			return fn.Name()
+		}
+	}
+	return fmt.Sprintf("%T", h)
+}
+
 // ServeHTTP implements Handler.
 func (r *urlPatternHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	logger.Debug("", "Inside urlPatternHandler.ServeHTTP")
-	pathParts := splitParts(req.URL.Path)
+	hotPathLogger.Debug("", "Inside urlPatternHandler.ServeHTTP")
+	if !MatchPattern(r.pattern, RoutingPath(req.URL)) {
+		// Sections only pass requests matching the pattern, but the handler
+		// must not trust its caller with client-controlled paths.
+		logger.Debug("", "Path %q does not match pattern %q", req.URL.Path, r.pattern)
+		http.NotFound(w, req)
+		return
+	}
+	params, err := URLParams(r, req.URL)
+	if err != nil {
+		// Sections pass requests with invalid values to their bad request
+		// handler.
+		logger.Debug("", "Invalid path parameters in %q: %s", req.URL.EscapedPath(), err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.ServeHTTPWithParams(w, req, params)
+}
+
+// ServeHTTPWithParams implements Handler.
+func (r *urlPatternHandler) ServeHTTPWithParams(w http.ResponseWriter, req *http.Request, params map[string]string) {
+	if m := r.config.Metadata; m.Deprecated {
+		// See RFC 9745 and RFC 8594.
+		if m.DeprecatedAt != nil {
+			w.Header().Set("deprecation", "@"+strconv.FormatInt(m.DeprecatedAt.Unix(), 10))
+		} else {
+			w.Header().Set("deprecation", "true")
+		}
+		if m.Sunset != nil {
+			w.Header().Set("sunset", m.Sunset.UTC().Format(http.TimeFormat))
+		}
+	}
+	contextVal := params
+	if !r.config.PrefixedParamNames {
+		contextVal = BareParamNames(contextVal)
+	}
+	if len(contextVal) > 0 {
+		req = req.WithContext(
+			context.WithValue(
+				req.Context(),
+				r.contextKey,
+				contextVal,
+			),
+		)
+	}
+	r.httpHandler.ServeHTTP(w, req)
+}
+
+// Params implements Handler.
+func (r *urlPatternHandler) Params(requestPath string) map[string]string {
+	pathParts := splitParts(requestPath)
 	patternParts := splitParts(r.pattern)
-	pathPartsLen := len(pathParts)
-	if pathPartsLen != len(patternParts) {
-		panic("unimplemented")
-	} else {
-		contextVal := make(map[string]string)
-		for i := 0; i < pathPartsLen; i++ {
-			patternToken := patternParts[i]
-			if strings.HasPrefix(patternToken, ":") {
-				contextVal[patternToken] = pathParts[i]
-			}
+	result := make(map[string]string)
+	if last := len(patternParts) - 1; isCatchAll(patternParts[last]) {
+		if len(pathParts) >= last {
+			result[patternParts[last]] = strings.Join(pathParts[last:], "/")
 		}
-		if len(contextVal) > 0 {
-			req = req.WithContext(
-				context.WithValue(
-					req.Context(),
-					r.contextKey,
-					contextVal,
-				),
-			)
+		patternParts = patternParts[:last]
+	}
+	for i := 0; i < len(pathParts) && i < len(patternParts); i++ {
+		if strings.HasPrefix(patternParts[i], ":") {
+			result[patternParts[i]] = pathParts[i]
 		}
-		r.httpHandler.ServeHTTP(w, req)
 	}
+	return result
+}
+
+// BareParamNames returns params, keyed by capture token as returned by
+// Handler.Params, keyed by variable name instead, without the leading ":" or
+// "*" of the token.
+func BareParamNames(params map[string]string) map[string]string {
+	result := make(map[string]string, len(params))
+	for token, v := range params {
+		result[strings.TrimLeft(token, ":*")] = v
+	}
+	return result
 }
 
 // Pattern implements Responder.
@@ -69,58 +302,378 @@ func (r *urlPatternHandler) Pattern() string {
 	return r.pattern
 }
 
-// ComparePatternHandlers compares two PatternResponder objects without respect to the names of any capture variable names.
+// ComparePatternHandlers orders handlers by the number of segments of their
+// patterns, then segment by segment: static segments first, in lexical order,
+// followed by capture tokens and then catch-all tokens, without respect to
+// the names of their variables.
 func ComparePatternHandlers(l, r Handler) int {
 	lparts := splitParts(l.Pattern())
 	rparts := splitParts(r.Pattern())
-	return compareParts(lparts, rparts)
+	if c := cmp.Compare(len(lparts), len(rparts)); c != 0 {
+		return c
+	}
+	for i := range lparts {
+		lrank, rrank := segmentRank(lparts[i]), segmentRank(rparts[i])
+		if c := cmp.Compare(lrank, rrank); c != 0 {
+			return c
+		}
+		if lrank == staticSegment {
+			if c := cmp.Compare(lparts[i], rparts[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
 }
 
+// ComparePatternHandlerToPath returns 0 if requestPath matches the pattern of
+// h, which has no catch-all token, and otherwise orders the pattern and the
+// path as compareParts does.
 func ComparePatternHandlerToPath(h Handler, requestPath string) int {
 	lparts := splitParts(h.Pattern())
 	rparts := splitParts(requestPath)
 	return compareParts(lparts, rparts)
 }
 
+// Lookup returns the handlers matching requestPath among handlers, in the
+// order they are selected, e.g. sorted stably with ComparePatternHandlers.
+// Several handlers are returned when handlers of the same pattern serve
+// different methods. Static segments are preferred to capture tokens, from
+// the first segment on, so that "/users/me" matches "/users/me" rather than
+// "/users/:id". Patterns ending with a catch-all token are only considered
+// when no other pattern matches, the longest of them matching first. Lookup
+// indexes handlers on every call; see Routes for repeated lookups.
+func Lookup(handlers []Handler, requestPath string) []Handler {
+	return newRouteNode(handlers).find(requestPath)
+}
+
+// SelectMethod returns the handler among matches serving the method, see
+// methodCandidates.
+func SelectMethod(matches []Handler, method string) (Handler, bool) {
+	if candidates := methodCandidates(matches, method); len(candidates) > 0 {
+		return candidates[0], true
+	}
+	return nil, false
+}
+
+// methodCandidates returns the handlers among matches serving the method
+// itself, or else, for HEAD requests, the GET handlers not disabling it, or
+// else the handlers serving MethodAny.
+func methodCandidates(matches []Handler, method string) []Handler {
+	candidates := servingMethod(matches, method)
+	if len(candidates) == 0 && method == http.MethodHead {
+		candidates = servingMethod(autoHeadHandlers(matches), http.MethodGet)
+	}
+	if len(candidates) == 0 {
+		candidates = servingMethod(matches, MethodAny)
+	}
+	return candidates
+}
+
+// ServesAsGet reports whether h serves requests with the method only as GET
+// requests, i.e. HEAD requests without serving HEAD itself, so that the body
+// it writes must be discarded.
+func ServesAsGet(h Handler, method string) bool {
+	methods := h.Config().Methods
+	return method == http.MethodHead &&
+		len(methods) > 0 &&
+		!slices.Contains(methods, http.MethodHead) &&
+		!slices.Contains(methods, MethodAny)
+}
+
+// autoHeadHandlers returns the handlers among matches that may serve HEAD
+// requests as GET requests.
+func autoHeadHandlers(matches []Handler) []Handler {
+	var result []Handler
+	for _, h := range matches {
+		if !h.Config().DisableAutoHead {
+			result = append(result, h)
+		}
+	}
+	return result
+}
+
+// AnswersOptions reports whether OPTIONS requests to the pattern of matches
+// that no handler serves are answered with the methods served in the Allow
+// header, which is the case unless every handler disables it.
+func AnswersOptions(matches []Handler) bool {
+	for _, h := range matches {
+		if !h.Config().DisableAutoOptions {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedMethods returns the sorted methods served by matches, for use in
+// the Allow header of 405 responses and of answers to OPTIONS requests.
+func AllowedMethods(matches []Handler) []string {
+	result := []string{}
+	for _, h := range matches {
+		for _, m := range h.Config().Methods {
+			if m != MethodAny && !slices.Contains(result, m) {
+				result = append(result, m)
+			}
+		}
+		if slices.Contains(h.Config().Methods, http.MethodGet) && !h.Config().DisableAutoHead && !slices.Contains(result, http.MethodHead) {
+			result = append(result, http.MethodHead)
+		}
+	}
+	if AnswersOptions(matches) && !slices.Contains(result, http.MethodOptions) {
+		result = append(result, http.MethodOptions)
+	}
+	slices.Sort(result)
+	return result
+}
+
+// MatchPattern reports whether requestPath matches pattern, treating tokens
+// with a leading ":" as matching any single path segment and a final token
+// with a leading "*" as matching any remaining segments, e.g. "/files/*rest".
+// An unnamed "*", e.g. in "/static/*", is accepted here for the exemption
+// patterns, which capture nothing, while ValidateResponders rejects it in
+// route patterns.
+func MatchPattern(pattern, requestPath string) bool {
+	patternParts := splitParts(pattern)
+	pathParts := splitParts(requestPath)
+	if last := len(patternParts) - 1; isCatchAll(patternParts[last]) {
+		if len(pathParts) < last {
+			return false
+		}
+		patternParts = patternParts[:last]
+		pathParts = pathParts[:last]
+	}
+	return compareParts(patternParts, pathParts) == 0
+}
+
 // ValidateResponders should be called on a set of handlers to ensure there
-// are no ambiguous patterns found.
+// are no ambiguous patterns found. Patterns differing only in the names of
+// their capture variables are ambiguous unless their handlers serve disjoint
+// sets of methods or have matchers telling requests apart, i.e. one
+// handler's matchers include the other's and more, or they require
+// different values of an attribute. Ambiguity is reported as a
+// *ConflictError naming the later of the handlers' patterns first. Catch-all
+// tokens must be in the last path segment and named, an unnamed "*" being
+// reported as ErrUnnamedCatchAll, and route names must be unique.
 func ValidateResponders(handlers []Handler) error {
-	staticPatterns := make([][]string, 0, len(handlers))
+	var v Validator
 	for _, h := range handlers {
-		staticPattern := []string{}
-		for _, part := range splitParts(h.Pattern()) {
-			if strings.HasPrefix(part, ":") {
-				staticPattern = append(staticPattern, "")
-			} else {
-				staticPattern = append(staticPattern, part)
-			}
+		if err := v.Validate(h); err != nil {
+			return err
 		}
-		if len(staticPatterns) < 1 {
-			staticPatterns = append(staticPatterns, staticPattern)
-		} else {
-			alreadySaved := true
-			staticPatternCount := len(staticPattern)
-			for _, savedStaticPattern := range staticPatterns {
-				if staticPatternCount != len(savedStaticPattern) {
-					alreadySaved = false
-					break
-				}
-				for i := 0; i < staticPatternCount; i++ {
-					if staticPattern[i] != savedStaticPattern[i] {
-						alreadySaved = false
-						break
-					}
-				}
-			}
-			if alreadySaved {
-				return ErrAmbiguousCaptureVariableNames
+		v.Add(h)
+	}
+	return nil
+}
+
+// CheckContextKey returns a *ContextKeyConflictError if h shares its context
+// key with one of handlers while capturing different variables, neither
+// handler's variables including the other's. Handlers capturing no variables
+// store nothing in the context and never conflict.
+func CheckContextKey(handlers []Handler, h Handler) error {
+	var v Validator
+	for _, other := range handlers {
+		if other != h {
+			v.addVariables(other)
+		}
+	}
+	return v.CheckContextKey(h)
+}
+
+// Validator checks handlers as they are added to a set, comparing each with
+// the handlers of the same shape only, so that the cost of adding a handler
+// does not grow with the set. The zero value is an empty set.
+type Validator struct {
+	byShape map[string][]Handler
+	names   map[string]struct{}
+	// variables holds, by context key, the distinct sets of variables
+	// captured by the handlers added, each with the first pattern to
+	// capture it.
+	variables map[any][]patternVariables
+}
+
+// patternVariables is a set of capture variables and a pattern capturing
+// them.
+type patternVariables struct {
+	pattern string
+	vars    []string
+}
+
+// Validate returns the error ValidateResponders would return for the
+// handlers added followed by h.
+func (v *Validator) Validate(h Handler) error {
+	if name := h.Config().Name; name != "" {
+		if _, found := v.names[name]; found {
+			return fmt.Errorf("%w: %q", ErrDuplicateRouteName, name)
+		}
+	}
+	for _, m := range h.Config().Methods {
+		if !validMethod(m) {
+			return fmt.Errorf("%w %q for pattern %q", ErrInvalidMethod, m, h.Pattern())
+		}
+	}
+	key, err := shape(h.Pattern())
+	if err != nil {
+		return err
+	}
+	for _, other := range v.byShape[key] {
+		methods, overlap := overlappingMethods(h.Config().Methods, other.Config().Methods)
+		if overlap && !distinguishable(h.Config().Matchers, other.Config().Matchers) {
+			return &ConflictError{
+				Pattern:            h.Pattern(),
+				ConflictingPattern: other.Pattern(),
+				Methods:            methods,
 			}
-			staticPatterns = append(staticPatterns, staticPattern)
 		}
 	}
 	return nil
 }
 
+// CheckContextKey returns the error CheckContextKey would return for the
+// handlers added and h.
+func (v *Validator) CheckContextKey(h Handler) error {
+	key := h.ContextKey()
+	if key == nil || !reflect.TypeOf(key).Comparable() {
+		return nil
+	}
+	vars := captureVariables(h.Pattern())
+	if len(vars) == 0 {
+		return nil
+	}
+	for _, other := range v.variables[key] {
+		if containsAll(vars, other.vars) || containsAll(other.vars, vars) {
+			continue
+		}
+		return &ContextKeyConflictError{
+			Pattern:            h.Pattern(),
+			ConflictingPattern: other.pattern,
+			ContextKey:         key,
+		}
+	}
+	return nil
+}
+
+// Add adds h, which Validate must have accepted, to the set.
+func (v *Validator) Add(h Handler) {
+	if name := h.Config().Name; name != "" {
+		if v.names == nil {
+			v.names = make(map[string]struct{})
+		}
+		v.names[name] = struct{}{}
+	}
+	key, err := shape(h.Pattern())
+	if err == nil {
+		if v.byShape == nil {
+			v.byShape = make(map[string][]Handler)
+		}
+		v.byShape[key] = append(v.byShape[key], h)
+	}
+	v.addVariables(h)
+}
+
+// addVariables records the variables h captures under its context key,
+// unless a handler capturing the same ones was added before.
+func (v *Validator) addVariables(h Handler) {
+	key := h.ContextKey()
+	if key == nil || !reflect.TypeOf(key).Comparable() {
+		return
+	}
+	vars := captureVariables(h.Pattern())
+	if len(vars) == 0 {
+		return
+	}
+	for _, other := range v.variables[key] {
+		if slices.Equal(other.vars, vars) {
+			return
+		}
+	}
+	if v.variables == nil {
+		v.variables = make(map[any][]patternVariables)
+	}
+	v.variables[key] = append(v.variables[key], patternVariables{pattern: h.Pattern(), vars: vars})
+}
+
+// shape returns pattern with its capture tokens replaced by ":" and its
+// catch-all token by "*", patterns of the same shape matching the same
+// paths.
+func shape(pattern string) (string, error) {
+	parts := splitParts(pattern)
+	for i, part := range parts {
+		switch {
+		case strings.HasPrefix(part, ":"):
+			parts[i] = ":"
+		case isCatchAll(part) && i < len(parts)-1:
+			return "", fmt.Errorf("%w: %q", ErrMisplacedCatchAll, pattern)
+		case part == "*":
+			// The remainder must be named to be told from the other
+			// values, e.g. by BuildPath.
+			return "", fmt.Errorf("%w: %q", ErrUnnamedCatchAll, pattern)
+		case isCatchAll(part):
+			parts[i] = "*"
+		}
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// captureVariables returns the capture and catch-all tokens of pattern.
+func captureVariables(pattern string) []string {
+	var result []string
+	for _, part := range splitParts(pattern) {
+		if strings.HasPrefix(part, ":") || isCatchAll(part) {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+// containsAll reports whether l contains every element of r.
+func containsAll(l, r []string) bool {
+	for _, v := range r {
+		if !slices.Contains(l, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// validMethod reports whether m is MethodAny or a method token as defined
+// by RFC 9110.
+func validMethod(m string) bool {
+	if m == MethodAny {
+		return true
+	}
+	if m == "" {
+		return false
+	}
+	for _, c := range []byte(m) {
+		isAlnum := 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+		if !isAlnum && !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// overlappingMethods returns the methods two routes restricted to the given
+// methods can both serve, and whether there are any, empty meaning all
+// methods.
+func overlappingMethods(l, r []string) ([]string, bool) {
+	switch {
+	case len(l) == 0 && len(r) == 0:
+		return nil, true
+	case len(l) == 0:
+		return r, true
+	case len(r) == 0:
+		return l, true
+	}
+	var result []string
+	for _, m := range l {
+		if slices.Contains(r, m) {
+			result = append(result, m)
+		}
+	}
+	return result, len(result) > 0
+}
+
 // compareParts is used internally to compare patterns.
 //
 // lparts should always be derived from a pattern specification (i.e. from
@@ -148,6 +701,60 @@ func compareParts(lparts []string, rparts []string) int {
 	}
 }
 
+// Ranks of pattern segments, see ComparePatternHandlers.
+const (
+	staticSegment = iota
+	captureSegment
+	catchAllSegment
+)
+
+func segmentRank(part string) int {
+	switch {
+	case strings.HasPrefix(part, ":"):
+		return captureSegment
+	case isCatchAll(part):
+		return catchAllSegment
+	}
+	return staticSegment
+}
+
+// isCatchAll reports whether a pattern token matches any remaining path
+// segments when in the last segment.
+func isCatchAll(part string) bool {
+	return strings.HasPrefix(part, "*")
+}
+
 func splitParts(s string) []string {
 	return strings.Split(strings.TrimPrefix(s, "/"), "/")
 }
+
+// TrailingSlash is the handling of requests whose path matches no route, but
+// would with its trailing slash removed, or added where the route's pattern
+// has one.
+type TrailingSlash int
+
+const (
+	// TrailingSlashStrict treats paths differing in their trailing slash as
+	// distinct, so that such requests are not found.
+	TrailingSlashStrict TrailingSlash = iota
+	// TrailingSlashRedirect redirects to the matching path, with 301 Moved
+	// Permanently for GET and HEAD requests and 308 Permanent Redirect for
+	// others, so that clients repeat them with the same method and body.
+	TrailingSlashRedirect
+	// TrailingSlashStrip serves the request as if it was for the matching
+	// path.
+	TrailingSlashStrip
+)
+
+// ToggleTrailingSlash returns requestPath with its trailing slash removed, or
+// added if it has none. The root path has no alternative, reported by false.
+func ToggleTrailingSlash(requestPath string) (string, bool) {
+	switch {
+	case requestPath == "" || requestPath == "/":
+		return "", false
+	case strings.HasSuffix(requestPath, "/"):
+		return strings.TrimSuffix(requestPath, "/"), true
+	default:
+		return requestPath + "/", true
+	}
+}
@@ -0,0 +1,153 @@
+package urlpathpatternhandler
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Matcher requires a request attribute for a route to be selected, so that
+// handlers of the same pattern and methods can be told apart by the request,
+// e.g. by its Accept header or a version query parameter.
+type Matcher struct {
+	// Header is the name of the header to match, if not empty.
+	Header string
+	// Query is the name of the query parameter to match, if Header is empty.
+	Query string
+	// Value is the value required, or empty to only require the attribute
+	// to be present. Header values match any of their comma-separated
+	// elements, case-insensitively and ignoring parameters, so that
+	// "application/json" matches "Accept: text/html, application/json;q=0.9".
+	// Query values match exactly.
+	Value string
+}
+
+func (m Matcher) String() string {
+	kind, name := "query", m.Query
+	if m.Header != "" {
+		kind, name = "header", http.CanonicalHeaderKey(m.Header)
+	}
+	if m.Value == "" {
+		return fmt.Sprintf("%s %s", kind, name)
+	}
+	return fmt.Sprintf("%s %s=%s", kind, name, m.Value)
+}
+
+// Matches reports whether r has the attribute required.
+func (m Matcher) Matches(r *http.Request) bool {
+	if m.Header == "" {
+		values, found := r.URL.Query()[m.Query]
+		return found && (m.Value == "" || slices.Contains(values, m.Value))
+	}
+	values := r.Header.Values(m.Header)
+	if m.Value == "" {
+		return len(values) > 0
+	}
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			element, _, _ = strings.Cut(element, ";")
+			if strings.EqualFold(strings.TrimSpace(element), m.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key identifies the attribute matched.
+func (m Matcher) key() string {
+	if m.Header != "" {
+		return "header " + http.CanonicalHeaderKey(m.Header)
+	}
+	return "query " + m.Query
+}
+
+// equal reports whether m requires the same attribute as o.
+func (m Matcher) equal(o Matcher) bool {
+	if m.key() != o.key() {
+		return false
+	}
+	if m.Header != "" {
+		return strings.EqualFold(m.Value, o.Value)
+	}
+	return m.Value == o.Value
+}
+
+// SelectRequest returns the handler among matches serving the request's
+// method whose matchers the request satisfies, those with more matchers
+// first, and whether any of matches serves the method regardless of their
+// matchers. HEAD requests are served by GET handlers unless a handler serves
+// HEAD itself or they disable it, and requests with methods no handler
+// serves by the handlers serving MethodAny.
+func SelectRequest(matches []Handler, r *http.Request) (Handler, bool) {
+	candidates := methodCandidates(matches, r.Method)
+	if len(candidates) == 0 {
+		return nil, false
+	}
+	slices.SortStableFunc(candidates, func(l, r Handler) int {
+		return cmp.Compare(len(r.Config().Matchers), len(l.Config().Matchers))
+	})
+	for _, h := range candidates {
+		if satisfies(r, h.Config().Matchers) {
+			return h, true
+		}
+	}
+	return nil, true
+}
+
+// servingMethod returns the handlers among matches serving method.
+func servingMethod(matches []Handler, method string) []Handler {
+	var result []Handler
+	for _, h := range matches {
+		if methods := h.Config().Methods; len(methods) == 0 || slices.Contains(methods, method) {
+			result = append(result, h)
+		}
+	}
+	return result
+}
+
+// satisfies reports whether r has every attribute required by matchers.
+func satisfies(r *http.Request, matchers []Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// distinguishable reports whether a request can be matched to one of two
+// handlers by their matchers: when one handler's matchers include the
+// other's and more, the handler with more matchers is selected first, and
+// when they require different values of an attribute at most one matches,
+// except for requests repeating the attribute.
+func distinguishable(l, r []Matcher) bool {
+	lContainsR, rContainsL := containsMatchers(l, r), containsMatchers(r, l)
+	if lContainsR != rContainsL {
+		return true
+	}
+	if lContainsR {
+		// The matchers are the same.
+		return false
+	}
+	for _, lm := range l {
+		for _, rm := range r {
+			if lm.key() == rm.key() && lm.Value != "" && rm.Value != "" && !lm.equal(rm) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containsMatchers reports whether l requires every attribute r does.
+func containsMatchers(l, r []Matcher) bool {
+	for _, rm := range r {
+		if !slices.ContainsFunc(l, rm.equal) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,253 @@
+package urlpathpatternhandler
+
+import (
+	"container/list"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Routes indexes handlers for Lookup in a tree of their patterns' segments,
+// finding the handlers of patterns without capture tokens, which dominate
+// most routing tables, with a map lookup before walking the tree. The
+// handlers matching other paths can be cached, see NewRoutes.
+type Routes struct {
+	tree *routeNode
+	// static maps the paths matched exactly by patterns without capture
+	// tokens to the handlers Lookup returns for them.
+	static map[string][]Handler
+	// cache holds the handlers matching the paths last requested, and the
+	// values they capture, or is nil.
+	cache *routeCache
+}
+
+// NewRoutes returns the index of handlers, given in the order they are
+// selected as for Lookup. If cacheSize is positive, the handlers matching up
+// to that many of the paths last looked up are cached, with the values they
+// capture, so that workloads requesting a small set of deep patterns with
+// captures skip the walk. The cache belongs to the index, so that replacing
+// the index invalidates it.
+func NewRoutes(handlers []Handler, cacheSize int) *Routes {
+	result := &Routes{tree: newRouteNode(handlers), static: map[string][]Handler{}}
+	for _, h := range handlers {
+		if _, found := result.static[h.Pattern()]; found || !isStatic(h.Pattern()) {
+			continue
+		}
+		result.static[h.Pattern()] = result.tree.find(h.Pattern())
+	}
+	if cacheSize > 0 {
+		result.cache = &routeCache{
+			entries: make(map[string]*list.Element, cacheSize),
+			order:   list.New(),
+			size:    cacheSize,
+		}
+	}
+	return result
+}
+
+// Lookup returns the handlers matching requestPath, as Lookup does.
+func (r *Routes) Lookup(requestPath string) []Handler {
+	if result, found := r.static[requestPath]; found {
+		return result
+	}
+	if r.cache == nil {
+		return r.tree.find(requestPath)
+	}
+	if e, found := r.cache.get(requestPath); found {
+		return e.matches
+	}
+	result := r.tree.find(requestPath)
+	// Paths matching no route are not cached, so that scans for missing
+	// paths do not evict the paths served.
+	if len(result) > 0 {
+		r.cache.add(newRouteCacheEntry(requestPath, result))
+	}
+	return result
+}
+
+// Params returns the values captured from the path of u by h, one of the
+// handlers Lookup returned for its routing path, as URLParams does. Cached
+// values are shared by the requests for the path and must not be modified.
+func (r *Routes) Params(h Handler, u *url.URL) (map[string]string, error) {
+	// Cached values are captured from the decoded path.
+	if r.cache != nil && u.RawPath == "" && !h.Config().RawPathParams {
+		if e, found := r.cache.get(u.Path); found {
+			for i, m := range e.matches {
+				if m == h {
+					return e.params[i], nil
+				}
+			}
+		}
+	}
+	return URLParams(h, u)
+}
+
+// isStatic reports whether pattern has no capture tokens, so that the
+// handlers matching the path it spells can be looked up in advance.
+func isStatic(pattern string) bool {
+	for _, part := range splitParts(pattern) {
+		if strings.HasPrefix(part, ":") || isCatchAll(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// routeNode indexes the patterns whose segments before the node's are those
+// on the way to it from the root of the tree, capture tokens regardless of
+// their names.
+type routeNode struct {
+	static  map[string]*routeNode
+	capture *routeNode
+	// handlers are those whose pattern ends with the node's segment.
+	handlers []Handler
+	// catchAll are those whose pattern ends with a catch-all token following
+	// the node's segment.
+	catchAll []Handler
+}
+
+// newRouteNode returns the root of the tree indexing handlers, keeping their
+// order among the handlers of each pattern.
+func newRouteNode(handlers []Handler) *routeNode {
+	root := &routeNode{}
+	for _, h := range handlers {
+		parts := splitParts(h.Pattern())
+		n := root
+		for _, part := range parts[:len(parts)-1] {
+			n = n.child(part)
+		}
+		if last := parts[len(parts)-1]; isCatchAll(last) {
+			n.catchAll = append(n.catchAll, h)
+		} else {
+			n = n.child(last)
+			n.handlers = append(n.handlers, h)
+		}
+	}
+	return root
+}
+
+// child returns the node following n with the pattern segment part, adding
+// it if needed.
+func (n *routeNode) child(part string) *routeNode {
+	if strings.HasPrefix(part, ":") {
+		if n.capture == nil {
+			n.capture = &routeNode{}
+		}
+		return n.capture
+	}
+	if n.static == nil {
+		n.static = map[string]*routeNode{}
+	}
+	c, found := n.static[part]
+	if !found {
+		c = &routeNode{}
+		n.static[part] = c
+	}
+	return c
+}
+
+// find returns the handlers matching requestPath as Lookup does.
+func (n *routeNode) find(requestPath string) []Handler {
+	parts := splitParts(requestPath)
+	if result := n.findExact(parts); len(result) > 0 {
+		return result
+	}
+	var result []Handler
+	n.findCatchAll(parts, 0, new(int), &result)
+	return result
+}
+
+// findExact returns the handlers of the pattern matching the path segments
+// following n without a catch-all token, preferring static segments to
+// capture tokens.
+func (n *routeNode) findExact(parts []string) []Handler {
+	if len(parts) == 0 {
+		return n.handlers
+	}
+	if c, found := n.static[parts[0]]; found {
+		if result := c.findExact(parts[1:]); len(result) > 0 {
+			return result
+		}
+	}
+	if n.capture != nil {
+		return n.capture.findExact(parts[1:])
+	}
+	return nil
+}
+
+// findCatchAll sets result to the handlers with the longest catch-all
+// pattern matching the path segments following n, which is depth segments
+// deep, given the number of segments longest preceding the catch-all tokens
+// of result.
+func (n *routeNode) findCatchAll(parts []string, depth int, longest *int, result *[]Handler) {
+	if len(n.catchAll) > 0 {
+		switch {
+		case len(*result) == 0 || depth > *longest:
+			*result = slices.Clone(n.catchAll)
+			*longest = depth
+		case depth == *longest:
+			*result = append(*result, n.catchAll...)
+		}
+	}
+	if len(parts) == 0 {
+		return
+	}
+	if c, found := n.static[parts[0]]; found {
+		c.findCatchAll(parts[1:], depth+1, longest, result)
+	}
+	if n.capture != nil {
+		n.capture.findCatchAll(parts[1:], depth+1, longest, result)
+	}
+}
+
+type routeCacheEntry struct {
+	path    string
+	matches []Handler
+	// params are the values captured by matches, in the same order.
+	params []map[string]string
+}
+
+func newRouteCacheEntry(requestPath string, matches []Handler) *routeCacheEntry {
+	result := &routeCacheEntry{path: requestPath, matches: matches}
+	for _, h := range matches {
+		result.params = append(result.params, h.Params(requestPath))
+	}
+	return result
+}
+
+// routeCache is a least recently used cache of route lookups.
+type routeCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order holds the entries, the most recently used first.
+	order *list.List
+	size  int
+}
+
+func (c *routeCache) get(requestPath string) (*routeCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, found := c.entries[requestPath]
+	if !found {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*routeCacheEntry), true
+}
+
+func (c *routeCache) add(entry *routeCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, found := c.entries[entry.path]; found {
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[entry.path] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*routeCacheEntry).path)
+	}
+}
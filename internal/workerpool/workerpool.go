@@ -0,0 +1,179 @@
+// Package workerpool provides an HTTP middleware handler running requests on a
+// bounded pool of worker goroutines, so that the work in flight is capped even
+// when each request spawns heavy CPU-bound tasks. Requests wait in a bounded
+// queue for a free worker and are rejected when it is full or they wait too
+// long.
+package workerpool
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jakewan/sudsy/internal/common"
+)
+
+var (
+	ErrQueueFull    = errors.New("worker pool queue full")
+	ErrQueueTimeout = errors.New("timed out waiting for a worker")
+
+	logger = common.NewLogger("workerpool")
+)
+
+// Config configures the pool. Zero values select the defaults.
+type Config struct {
+	// Workers is the number of worker goroutines, defaulting to
+	// runtime.GOMAXPROCS.
+	Workers int
+	// QueueSize is the number of requests that can wait for a worker,
+	// defaulting to Workers. Negative means requests never wait.
+	QueueSize int
+	// QueueTimeout is how long a request can wait for a worker. Zero means
+	// it waits until its context is done.
+	QueueTimeout time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.Workers <= 0 {
+		c.Workers = runtime.GOMAXPROCS(0)
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = c.Workers
+	}
+	return c
+}
+
+type Dependencies interface {
+	HandleStatusServiceUnavailable(http.ResponseWriter, *http.Request, error)
+	// AddCounter reports a metric, labeled by the caller as well as with the
+	// given labels.
+	AddCounter(name string, labels map[string]string, delta float64)
+}
+
+func NewMiddlewareHandler(deps Dependencies, next http.Handler, config Config) common.MiddlewareHandler {
+	config = config.withDefaults()
+	return &handler{
+		deps:   deps,
+		next:   next,
+		config: config,
+		jobs:   make(chan *job, max(config.QueueSize, 0)),
+	}
+}
+
+// Job states.
+const (
+	jobQueued int32 = iota
+	jobStarted
+	jobAbandoned
+)
+
+type job struct {
+	w     http.ResponseWriter
+	r     *http.Request
+	state atomic.Int32
+	done  chan struct{}
+	// panicValue is the value the handler panicked with, re-raised on the
+	// request's goroutine so that the server handles it as usual.
+	panicValue any
+}
+
+type handler struct {
+	deps   Dependencies
+	next   http.Handler
+	config Config
+	jobs   chan *job
+	quit   chan struct{}
+}
+
+// AfterShutdown implements common.MiddlewareHandler.
+func (h *handler) AfterShutdown() {
+	close(h.quit)
+}
+
+// BeforeStart implements common.MiddlewareHandler.
+func (h *handler) BeforeStart(wg *sync.WaitGroup) {
+	h.quit = make(chan struct{})
+	for range h.config.Workers {
+		wg.Add(1)
+		go h.work(wg)
+	}
+}
+
+func (h *handler) work(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-h.quit:
+			return
+		case j := <-h.jobs:
+			h.run(j)
+		}
+	}
+}
+
+func (h *handler) run(j *job) {
+	if !j.state.CompareAndSwap(jobQueued, jobStarted) {
+		return
+	}
+	defer close(j.done)
+	defer func() {
+		j.panicValue = recover()
+	}()
+	h.next.ServeHTTP(j.w, j.r)
+}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	j := &job{w: w, r: r, done: make(chan struct{})}
+	if !h.enqueue(j) {
+		logger.Debug("ServeHTTP", "Queue full, rejecting request for %s", r.URL.Path)
+		h.reject(w, r, "queue_full", ErrQueueFull)
+		return
+	}
+	var timeout <-chan time.Time
+	if h.config.QueueTimeout > 0 {
+		timer := time.NewTimer(h.config.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-j.done:
+	case <-timeout:
+		if j.state.CompareAndSwap(jobQueued, jobAbandoned) {
+			logger.Debug("ServeHTTP", "Timed out waiting for a worker for %s", r.URL.Path)
+			h.reject(w, r, "queue_timeout", ErrQueueTimeout)
+			return
+		}
+		<-j.done
+	case <-r.Context().Done():
+		if j.state.CompareAndSwap(jobQueued, jobAbandoned) {
+			logger.Debug("ServeHTTP", "Request for %s canceled while queued", r.URL.Path)
+			h.reject(w, r, "canceled", fmt.Errorf("waiting for a worker: %w", r.Context().Err()))
+			return
+		}
+		<-j.done
+	}
+	if j.panicValue != nil {
+		panic(j.panicValue)
+	}
+}
+
+// enqueue hands the job to a worker, reporting false if none is free and the
+// queue is full. Without a queue the job is only accepted by an idle worker.
+func (h *handler) enqueue(j *job) bool {
+	select {
+	case h.jobs <- j:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *handler) reject(w http.ResponseWriter, r *http.Request, reason string, err error) {
+	h.deps.AddCounter("sudsy_workerpool_rejections_total", map[string]string{"reason": reason}, 1)
+	h.deps.HandleStatusServiceUnavailable(w, r, err)
+}
@@ -1,15 +1,694 @@
 package sudsy
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"maps"
+	"net"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jakewan/sudsy/internal/accesslog"
+	"github.com/jakewan/sudsy/internal/admin"
 	"github.com/jakewan/sudsy/internal/application"
+	"github.com/jakewan/sudsy/internal/auth"
+	"github.com/jakewan/sudsy/internal/binding"
+	"github.com/jakewan/sudsy/internal/buffering"
+	"github.com/jakewan/sudsy/internal/clientcert"
+	"github.com/jakewan/sudsy/internal/clientgone"
+	"github.com/jakewan/sudsy/internal/common"
+	"github.com/jakewan/sudsy/internal/concurrency"
+	"github.com/jakewan/sudsy/internal/cors"
+	"github.com/jakewan/sudsy/internal/csp"
+	"github.com/jakewan/sudsy/internal/deadline"
+	"github.com/jakewan/sudsy/internal/download"
+	"github.com/jakewan/sudsy/internal/errorpages"
+	"github.com/jakewan/sudsy/internal/events"
+	"github.com/jakewan/sudsy/internal/flags"
+	"github.com/jakewan/sudsy/internal/forwarded"
+	"github.com/jakewan/sudsy/internal/headers"
+	"github.com/jakewan/sudsy/internal/lifecycle"
+	"github.com/jakewan/sudsy/internal/logsink"
+	"github.com/jakewan/sudsy/internal/methodoverride"
+	"github.com/jakewan/sudsy/internal/metrics"
+	"github.com/jakewan/sudsy/internal/minify"
+	"github.com/jakewan/sudsy/internal/pathclean"
+	"github.com/jakewan/sudsy/internal/pathparams"
+	"github.com/jakewan/sudsy/internal/propagation"
+	"github.com/jakewan/sudsy/internal/query"
+	"github.com/jakewan/sudsy/internal/ratelimiting"
+	"github.com/jakewan/sudsy/internal/realip"
+	"github.com/jakewan/sudsy/internal/recovery"
+	"github.com/jakewan/sudsy/internal/responseinfo"
+	"github.com/jakewan/sudsy/internal/rules"
+	"github.com/jakewan/sudsy/internal/sampling"
+	"github.com/jakewan/sudsy/internal/shedding"
+	"github.com/jakewan/sudsy/internal/smuggling"
+	"github.com/jakewan/sudsy/internal/static"
+	"github.com/jakewan/sudsy/internal/stream"
+	"github.com/jakewan/sudsy/internal/templates"
+	"github.com/jakewan/sudsy/internal/tenant"
+	"github.com/jakewan/sudsy/internal/tlscert"
+	"github.com/jakewan/sudsy/internal/urlpathpatternhandler"
+	"github.com/jakewan/sudsy/internal/workerpool"
 )
 
+// SessionTicketKeyProvider supplies TLS session ticket keys, the first of
+// which encrypts new tickets. Implementations backed by shared storage let
+// several instances resume each other's sessions.
+type SessionTicketKeyProvider = tlscert.SessionTicketKeyProvider
+
+// Authenticator implements a single authentication scheme.
+type Authenticator = auth.Authenticator
+
+// Principal identifies an authenticated client.
+type Principal = auth.Principal
+
+// NewBearerAuthenticator returns an Authenticator for bearer tokens such as
+// JWTs. The validate function verifies the token and returns the principal
+// it identifies.
+func NewBearerAuthenticator(
+	realm string,
+	validate func(ctx context.Context, token string) (*Principal, error),
+) Authenticator {
+	return auth.NewBearerAuthenticator(realm, validate)
+}
+
+// PrincipalFromContext returns the principal authenticated for the request.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	return auth.PrincipalFromContext(ctx)
+}
+
+// ClientCertificate describes the verified certificate presented by a client
+// on a mutual TLS connection.
+type ClientCertificate = clientcert.Certificate
+
+// ClientCertificateFromContext returns the verified client certificate for the
+// request, available when WithTLSClientCAFile is configured.
+func ClientCertificateFromContext(ctx context.Context) (*ClientCertificate, bool) {
+	return clientcert.FromContext(ctx)
+}
+
+// ForwardedElement holds the parameters added by one proxy to the Forwarded
+// header (RFC 7239).
+type ForwardedElement = forwarded.Element
+
+// ForwardedFromContext returns the parsed Forwarded header of the request,
+// ordered from the proxy furthest from the server to the nearest.
+func ForwardedFromContext(ctx context.Context) ([]ForwardedElement, bool) {
+	return forwarded.FromContext(ctx)
+}
+
+// ResponseInfo describes a completed request: its status, size, latency,
+// matched route and, for error responses, the error that caused them.
+type ResponseInfo = responseinfo.Info
+
+// ClientGone reports whether the client of r has disconnected, or err, if not
+// nil, was caused by the connection to the client breaking, so that handlers
+// can skip logging and alerting on errors they cannot do anything about.
+// Sections count such requests in the sudsy_client_disconnects_total metric,
+// skip the status handlers for them, and report them to OnResponse hooks with
+// ClientGone set.
+func ClientGone(r *http.Request, err error) bool {
+	return clientgone.Detect(r, err)
+}
+
+// Event describes something that happened while serving requests or during
+// the application lifecycle.
+type Event = events.Event
+
+// EventType identifies the kind of an Event.
+type EventType = events.Type
+
+const (
+	EventAuthFailed      = events.AuthFailed
+	EventIdleShutdown    = events.IdleShutdown
+	EventPanicRecovered  = events.PanicRecovered
+	EventRateLimited     = events.RateLimited
+	EventRouteMatched    = events.RouteMatched
+	EventShutdownStarted = events.ShutdownStarted
+	// EventSuspiciousRequest carries a *SuspiciousRequestError.
+	EventSuspiciousRequest = events.SuspiciousRequest
+)
+
+// HeaderLimits configures the header validation enabled using
+// WithHeaderValidation.
+type HeaderLimits = headers.Limits
+
+var (
+	// ErrHeaderTooLarge is wrapped by the error passed to the bad request
+	// handler when a header exceeds the configured size limits.
+	ErrHeaderTooLarge = headers.ErrHeaderTooLarge
+	// ErrMalformedHeader is wrapped by the error passed to the bad request
+	// handler when a header name or value is malformed.
+	ErrMalformedHeader = headers.ErrMalformedHeader
+	// ErrTooManyHeaders is wrapped by the error passed to the bad request
+	// handler when a request has more headers than configured.
+	ErrTooManyHeaders = headers.ErrTooManyHeaders
+)
+
+// HeaderList returns the elements of a comma-separated header across all of
+// its field lines, trimmed and with empty elements removed.
+func HeaderList(h http.Header, name string) []string {
+	return headers.SplitList(h, name)
+}
+
+// ErrInvalidRequestTimeout is wrapped by the error passed to the bad request
+// handler when a client requests a malformed timeout.
+var ErrInvalidRequestTimeout = deadline.ErrInvalidTimeout
+
+// ErrNonCanonicalPath is wrapped by the error passed to the bad request
+// handler when a request path is not clean, see
+// WithNonCanonicalPathRejection.
+var ErrNonCanonicalPath = pathclean.ErrNonCanonicalPath
+
+// ErrRequestTimeout is the context cause of requests whose timeout, set by
+// WithRequestTimeoutHeader, has passed.
+var ErrRequestTimeout = deadline.ErrRequestTimeout
+
+// TenantResolver returns the ID of the tenant a request belongs to.
+type TenantResolver = tenant.Resolver
+
+// ErrTenantNotFound is wrapped by errors returned from the tenant resolvers
+// provided by this package.
+var ErrTenantNotFound = tenant.ErrTenantNotFound
+
+// NewSubdomainTenantResolver returns a TenantResolver taking the tenant ID
+// from the subdomain immediately below baseDomain.
+func NewSubdomainTenantResolver(baseDomain string) TenantResolver {
+	return tenant.NewSubdomainResolver(baseDomain)
+}
+
+// NewHeaderTenantResolver returns a TenantResolver taking the tenant ID from
+// the named request header.
+func NewHeaderTenantResolver(name string) TenantResolver {
+	return tenant.NewHeaderResolver(name)
+}
+
+// NewPathSegmentTenantResolver returns a TenantResolver taking the tenant ID
+// from the request path segment at the given zero-based index.
+func NewPathSegmentTenantResolver(index int) TenantResolver {
+	return tenant.NewPathSegmentResolver(index)
+}
+
+// RequestIDFromContext returns the ID of the request being served, taken from
+// its X-Request-ID header or generated if absent.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	v, ok := propagation.FromContext(ctx)
+	return v.RequestID, ok
+}
+
+// PropagatedHeaders returns the inbound headers of the request being served
+// that were configured using WithPropagatedHeaders. The result must not be
+// modified.
+func PropagatedHeaders(ctx context.Context) http.Header {
+	v, _ := propagation.FromContext(ctx)
+	return v.Headers
+}
+
+// BaggageFromContext returns the members of the W3C baggage header of the
+// request being served, with their properties dropped, if "baggage" was
+// configured using WithPropagatedHeaders.
+func BaggageFromContext(ctx context.Context) map[string]string {
+	v, _ := propagation.FromContext(ctx)
+	return propagation.ParseBaggage(strings.Join(v.Headers.Values(propagation.HeaderBaggage), ","))
+}
+
+// TraceSampled reports whether the request being served was sampled by the
+// trace sampler set using WithTraceSampler or WithRouteTraceSampler, so that
+// exporters and instrumentation can skip the others.
+func TraceSampled(ctx context.Context) bool {
+	v, _ := propagation.FromContext(ctx)
+	return v.Sampled
+}
+
+// TraceSampler decides whether a request is traced.
+type TraceSampler = sampling.Sampler
+
+// TraceSamplerFunc adapts an ordinary function to the TraceSampler interface.
+type TraceSamplerFunc = sampling.SamplerFunc
+
+// TraceSamplingParameters describes a request to sample.
+type TraceSamplingParameters = sampling.Parameters
+
+// AlwaysSampleTraces samples every request.
+func AlwaysSampleTraces() TraceSampler {
+	return sampling.Always()
+}
+
+// NeverSampleTraces samples no request, e.g. for health check routes.
+func NeverSampleTraces() TraceSampler {
+	return sampling.Never()
+}
+
+// NewTraceIDRatioSampler samples the given fraction of traces, deciding
+// consistently for each trace ID.
+func NewTraceIDRatioSampler(ratio float64) TraceSampler {
+	return sampling.TraceIDRatio(ratio)
+}
+
+// NewRateLimitedTraceSampler samples up to perSecond requests per second.
+func NewRateLimitedTraceSampler(perSecond float64) TraceSampler {
+	return sampling.RateLimited(perSecond)
+}
+
+// NewParentBasedTraceSampler follows the sampled flag of requests carrying a
+// traceparent header, and defers to root for the others.
+func NewParentBasedTraceSampler(root TraceSampler) TraceSampler {
+	return sampling.ParentBased(root)
+}
+
+// NewHTTPClient returns a client for calls made while serving a request. Each
+// outbound request created with the inbound request's context, e.g. using
+// http.NewRequestWithContext(r.Context(), ...), carries the inbound request
+// ID, trace context and configured propagated headers, along with an
+// X-Request-Timeout header reflecting the context deadline, so downstream
+// calls are correlated by default. Calls ended by the request timeout set by
+// WithRequestTimeoutHeader attribute the timeout to the upstream server. A
+// nil base uses http.DefaultTransport.
+func NewHTTPClient(base http.RoundTripper, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: deadline.NewTransport(propagation.NewTransport(base)),
+		Timeout:   timeout,
+	}
+}
+
+// LogEntry is a message logged by the framework, see SetLogger.
+type LogEntry = common.LogEntry
+
+// LogLevel is the level of a LogEntry.
+type LogLevel = common.LogLevel
+
+const (
+	LogLevelDebug = common.LogLevelDebug
+	LogLevelInfo  = common.LogLevelInfo
+	LogLevelWarn  = common.LogLevelWarn
+	LogLevelError = common.LogLevelError
+)
+
+// Logger receives the messages logged by the framework, see SetLogger.
+type Logger = common.LogSink
+
+// LoggerFunc adapts an ordinary function to the Logger interface.
+type LoggerFunc func(LogEntry)
+
+// Log implements Logger.
+func (f LoggerFunc) Log(e LogEntry) {
+	f(e)
+}
+
+// SetLogger sends the messages logged by the framework, which are debug
+// messages, to l instead of the standard logger, e.g. to an adapter for a
+// structured logging library. It applies to every application in the
+// process. A nil l restores the standard logger.
+func SetLogger(l Logger) {
+	common.SetLogSink(l)
+}
+
+// LogSampling limits the framework's messages logged for every request, in
+// the routing and rate limiting paths, so that logging can stay enabled in
+// production without flooding the logs. Each path is limited separately.
+type LogSampling = common.LogSampling
+
+// SetLogSampling limits the framework's messages logged for every request,
+// to one in c.EveryN and to c.PerSecond per second. Logged messages report
+// how many were dropped before them. It applies to every application in the
+// process. The zero value disables sampling.
+func SetLogSampling(c LogSampling) {
+	common.SetLogSampling(c)
+}
+
+// LogSeverity is a syslog severity, see RFC 5424, which is also the priority
+// of journal entries.
+type LogSeverity = logsink.Severity
+
+const (
+	LogSeverityEmergency = logsink.SeverityEmergency
+	LogSeverityAlert     = logsink.SeverityAlert
+	LogSeverityCritical  = logsink.SeverityCritical
+	LogSeverityError     = logsink.SeverityError
+	LogSeverityWarning   = logsink.SeverityWarning
+	LogSeverityNotice    = logsink.SeverityNotice
+	LogSeverityInfo      = logsink.SeverityInfo
+	LogSeverityDebug     = logsink.SeverityDebug
+)
+
+// SystemLoggerConfig configures the loggers returned by NewSyslogLogger and
+// NewJournaldLogger. Its Severities map log levels to severities, e.g. to
+// raise the framework's debug messages to LogSeverityInfo for daemons
+// discarding debug messages.
+type SystemLoggerConfig = logsink.Config
+
+// ErrLoggerUnsupported is wrapped by the errors returned for loggers the
+// platform does not provide, such as the journal outside of Linux.
+var ErrLoggerUnsupported = logsink.ErrUnsupported
+
+// NewSyslogLogger returns a Logger for SetLogger sending messages to the
+// syslog daemon at raddr over network, e.g. "udp", or to the local daemon if
+// network is empty, with the daemon facility.
+func NewSyslogLogger(network, raddr string, c SystemLoggerConfig) (Logger, error) {
+	return logsink.NewSyslog(network, raddr, c)
+}
+
+// NewJournaldLogger returns a Logger for SetLogger sending messages to the
+// systemd journal, with the logging component and ID in the SUDSY_COMPONENT
+// and SUDSY_ID fields.
+func NewJournaldLogger(c SystemLoggerConfig) (Logger, error) {
+	return logsink.NewJournald(c)
+}
+
+// ShutdownNotify returns a channel closed when the application starts
+// shutting down. Handlers that hijack connections should watch it and close
+// their connections gracefully, since the HTTP server does not wait for them.
+// It returns nil, which blocks forever, outside of a request served by the
+// application.
+func ShutdownNotify(ctx context.Context) <-chan struct{} {
+	ch, _ := lifecycle.ShutdownFromContext(ctx)
+	return ch
+}
+
+// TenantFromContext returns the tenant ID resolved for the request, which may
+// be used to key per-tenant state such as session stores.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	return tenant.FromContext(ctx)
+}
+
+// FlagProvider reports whether a feature flag is enabled for a request.
+type FlagProvider = flags.Provider
+
+// FlagProviderFunc adapts an ordinary function to the FlagProvider interface.
+type FlagProviderFunc = flags.ProviderFunc
+
+// ErrFeatureDisabled is wrapped by the error passed to status handlers for
+// requests to sections or routes whose feature flag is disabled.
+var ErrFeatureDisabled = flags.ErrFeatureDisabled
+
+// ErrUnsupportedMediaType is wrapped by the error passed to the 415 handler
+// when a request body's media type is not allowed.
+var ErrUnsupportedMediaType = rules.ErrUnsupportedMediaType
+
+// ServeDownload replies with content as an attachment named name. The
+// Content-Type is derived from the name's extension unless already set, and
+// range requests for resuming interrupted downloads are supported.
+func ServeDownload(w http.ResponseWriter, r *http.Request, name string, content io.ReadSeeker, modtime time.Time) {
+	download.Serve(w, r, name, content, modtime)
+}
+
+// NDJSONConfig configures the flushing behavior of an NDJSONWriter.
+type NDJSONConfig = stream.NDJSONConfig
+
+// NDJSONWriter streams newline-delimited JSON records to a client.
+type NDJSONWriter = stream.NDJSONWriter
+
+// ErrStreamClosed is returned when writing to an NDJSONWriter that has been
+// closed or has failed.
+var ErrStreamClosed = stream.ErrStreamClosed
+
+// NDJSONErrorRecord is the final record of a stream ended by
+// NDJSONWriter.Fail.
+type NDJSONErrorRecord = stream.NDJSONErrorRecord
+
+// NewNDJSONWriter returns a writer streaming records to w as
+// newline-delimited JSON. The response header is written along with the
+// first record; call Close when done to flush any buffered records.
+func NewNDJSONWriter(w http.ResponseWriter, r *http.Request, config NDJSONConfig) *NDJSONWriter {
+	return stream.NewNDJSONWriter(w, r, config)
+}
+
+// QueryLimits configures the query string validation enabled using
+// WithQueryLimits.
+type QueryLimits = query.Limits
+
+var (
+	// ErrDuplicateQueryKey is wrapped by the error passed to the bad request
+	// handler when a query repeats a key and duplicates are rejected.
+	ErrDuplicateQueryKey = query.ErrDuplicateKey
+	// ErrInvalidQueryParam is wrapped by the error passed to the bad request
+	// handler when a typed query helper fails to convert a parameter.
+	ErrInvalidQueryParam = query.ErrInvalidParam
+	// ErrMalformedQuery is wrapped by the error passed to the bad request
+	// handler when a query does not parse cleanly in strict mode.
+	ErrMalformedQuery = query.ErrMalformedQuery
+	// ErrQueryTooLong is wrapped by the error passed to the bad request
+	// handler when a query string exceeds the configured length.
+	ErrQueryTooLong = query.ErrQueryTooLong
+	// ErrTooManyQueryParams is wrapped by the error passed to the bad request
+	// handler when a query has more parameters than configured.
+	ErrTooManyQueryParams = query.ErrTooManyParameters
+)
+
+// QueryInt returns the named query parameter as an int, or defaultValue when
+// absent. If the value is not an integer the section's bad request handler is
+// invoked and false is returned.
+func QueryInt(w http.ResponseWriter, r *http.Request, name string, defaultValue int) (int, bool) {
+	v, err := query.Int(r.URL.Query(), name, defaultValue)
+	if err != nil {
+		application.HandleStatusBadRequest(w, r, err)
+		return 0, false
+	}
+	return v, true
+}
+
+// QueryTime returns the named query parameter parsed using layout, or
+// defaultValue when absent. If the value does not parse the section's bad
+// request handler is invoked and false is returned.
+func QueryTime(
+	w http.ResponseWriter,
+	r *http.Request,
+	name string,
+	layout string,
+	defaultValue time.Time,
+) (time.Time, bool) {
+	v, err := query.Time(r.URL.Query(), name, layout, defaultValue)
+	if err != nil {
+		application.HandleStatusBadRequest(w, r, err)
+		return time.Time{}, false
+	}
+	return v, true
+}
+
+// UUID is a universally unique identifier, as returned by PathParamValues.UUID.
+type UUID = pathparams.UUID
+
+var (
+	// ErrInvalidPathParam is wrapped by the error passed to the section's bad
+	// request handler when a path parameter does not convert to the requested
+	// type.
+	ErrInvalidPathParam = pathparams.ErrInvalidParam
+	// ErrMissingPathParam is wrapped by the error passed to the section's
+	// internal server error handler when the route's pattern does not capture
+	// the requested path parameter.
+	ErrMissingPathParam = pathparams.ErrMissingParam
+)
+
+// PathParamValues converts the values captured from the request path by the
+// route's pattern, as returned by PathParams.
+type PathParamValues struct {
+	w      http.ResponseWriter
+	r      *http.Request
+	values map[string]string
+}
+
+// PathParams returns the values captured from the request path by the pattern
+// of the route serving r. The accessors take the names of capture tokens with
+// or without their leading ":" or "*". When a value does not convert they
+// invoke the section's bad request handler and return false, so that handlers
+// can simply return.
+func PathParams(w http.ResponseWriter, r *http.Request) PathParamValues {
+	return PathParamValues{w: w, r: r, values: pathparams.FromContext(r.Context())}
+}
+
+// RouteParamsKey is a context key for the values captured by the patterns of
+// routes, created by NewRouteParamsKey. Unlike strings and other values used
+// as keys, it can only equal copies of itself, so that routes using
+// different keys never collide.
+type RouteParamsKey struct {
+	key *routeParamsKey
+}
+
+type routeParamsKey struct {
+	// The field keeps distinct keys from sharing an address.
+	_ byte
+}
+
+// NewRouteParamsKey returns a new key to pass as the contextKey of
+// WithPathPatternHandler and its variants, whose methods read the values
+// captured by the routes registered with it, e.g.
+//
+//	var userParams = sudsy.NewRouteParamsKey()
+//	...
+//	sudsy.WithPathPatternHandler("/users/:id", handler, userParams)
+//	...
+//	id, found := userParams.Param(r, "id")
+func NewRouteParamsKey() RouteParamsKey {
+	return RouteParamsKey{key: &routeParamsKey{}}
+}
+
+// Params returns the values captured by the pattern of the route serving r,
+// if registered with the key, keyed by variable name, or by capture token
+// including its leading ":" or "*" in sections using WithPrefixedParamNames.
+func (k RouteParamsKey) Params(r *http.Request) map[string]string {
+	params, _ := r.Context().Value(k).(map[string]string)
+	return params
+}
+
+// Param returns the value captured by the token named name, given with or
+// without its leading ":" or "*", if the route serving r was registered with
+// the key.
+func (k RouteParamsKey) Param(r *http.Request, name string) (string, bool) {
+	v, err := pathparams.String(k.Params(r), name)
+	return v, err == nil
+}
+
+// Values returns the values captured for the key, converting them as
+// PathParams does.
+func (k RouteParamsKey) Values(w http.ResponseWriter, r *http.Request) PathParamValues {
+	return PathParamValues{w: w, r: r, values: k.Params(r)}
+}
+
+// String returns the named value.
+func (p PathParamValues) String(name string) (string, bool) {
+	v, err := pathparams.String(p.values, name)
+	return v, p.handle(err)
+}
+
+// Int returns the named value as an int.
+func (p PathParamValues) Int(name string) (int, bool) {
+	v, err := pathparams.Int(p.values, name)
+	return v, p.handle(err)
+}
+
+// Int64 returns the named value as an int64.
+func (p PathParamValues) Int64(name string) (int64, bool) {
+	v, err := pathparams.Int64(p.values, name)
+	return v, p.handle(err)
+}
+
+// UUID returns the named value as a UUID, which must be in the hyphenated
+// hexadecimal form.
+func (p PathParamValues) UUID(name string) (UUID, bool) {
+	v, err := pathparams.UUIDParam(p.values, name)
+	return v, p.handle(err)
+}
+
+// handle passes err, if any, to the section's handler for its status.
+func (p PathParamValues) handle(err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, pathparams.ErrMissingParam):
+		// The handler asks for a parameter its pattern lacks.
+		application.HandleStatus(p.w, p.r, http.StatusInternalServerError, err)
+	default:
+		application.HandleStatusBadRequest(p.w, p.r, err)
+	}
+	return false
+}
+
+// MetricsRecorder receives the metrics reported by the application.
+type MetricsRecorder = metrics.Recorder
+
+// MetricsLabels holds the label values attached to a metric.
+type MetricsLabels = metrics.Labels
+
+// StatsDConfig configures a StatsDRecorder: the server's address, a prefix
+// for metric names, the style of tags labels are sent as, and how often
+// buffered metrics are sent.
+type StatsDConfig = metrics.StatsDConfig
+
+// StatsDTagStyle selects how a StatsDRecorder sends labels.
+type StatsDTagStyle = metrics.TagStyle
+
+const (
+	StatsDTagStyleDatadog  = metrics.TagStyleDatadog
+	StatsDTagStyleInfluxDB = metrics.TagStyleInfluxDB
+	StatsDTagStyleNone     = metrics.TagStyleNone
+)
+
+// StatsDRecorder is a MetricsRecorder pushing metrics over UDP to a StatsD
+// server, such as the Datadog agent or Telegraf.
+type StatsDRecorder = metrics.StatsDRecorder
+
+// NewStatsDRecorder returns a recorder for WithMetricsRecorder sending
+// metrics to the StatsD server configured by c. Close it after the
+// application has shut down to send the last buffered metrics.
+func NewStatsDRecorder(c StatsDConfig) (*StatsDRecorder, error) {
+	return metrics.NewStatsDRecorder(c)
+}
+
+// Validator is run once on every value bound by BindJSON, BindQuery and
+// BindForm within a section configured using WithValidator.
+type Validator = binding.Validator
+
+// ValidatorFunc adapts an ordinary function to the Validator interface.
+type ValidatorFunc = binding.ValidatorFunc
+
+// ValidationError is passed to the section's bad request handler when a bound
+// value fails validation. Validators may return one directly to report
+// individual field errors.
+type ValidationError = binding.ValidationError
+
+// FieldError describes a single invalid field within a ValidationError.
+type FieldError = binding.FieldError
+
+// CORSConfig describes the cross-origin requests allowed. Configurations set
+// with WithCORS, WithSectionCORS and WithRouteCORS are merged in that order,
+// fields set in a more specific configuration replacing those of the less
+// specific one. Nil slices, a nil AllowCredentials and a zero MaxAge are
+// treated as unset.
+type CORSConfig = cors.Config
+
+// ErrInvalidSectionRoot is wrapped by the error returned from
+// AddApplicationSection for a root that is not a plain absolute path.
+var ErrInvalidSectionRoot = application.ErrInvalidSectionRoot
+
+// RouteInfo describes a route: its section root, name, pattern, methods,
+// which are all methods if empty, handler and metadata. Handler is the name
+// of the handler function, or the type of the handler otherwise, e.g.
+// "main.getUser" or "*main.userHandler".
+type RouteInfo = application.RouteInfo
+
 type Application interface {
+	// AddApplicationSection adds a section, returning an error if its root
+	// is invalid, duplicates another section's root, or nests within or
+	// contains another section's root on the same port, or if any of its
+	// routes could not be registered. Roots are normalized to end with a
+	// slash, so "/api" and "/api/" are equivalent.
 	AddApplicationSection(section application.Section) error
+	// AddDrainFunc registers f to stop background work, such as a streaming
+	// response or task queue, when the application shuts down. Drain
+	// functions run in parallel with server shutdown, each bounded by its own
+	// timeout, or by the shutdown grace period if timeout is zero. Call the
+	// returned function once the work ends on its own.
+	AddDrainFunc(name string, timeout time.Duration, f func(ctx context.Context) error) (unregister func())
+	// Drain causes readiness checks to fail so load balancers stop routing
+	// traffic to the instance ahead of shutdown.
+	Drain()
+	// GroomNow evicts idle rate limiting cache entries, which is otherwise
+	// done periodically unless lazy expiration is enabled.
+	GroomNow()
 	ListenAndServe()
+	// Routes returns the routes of the application's sections, in the order
+	// the sections were added, e.g. to print a route table at startup. The
+	// patterns of sections stripping their prefix are relative to their root.
+	Routes() []RouteInfo
+	// URLFor returns the path of the route with the given name, in any of the
+	// application's sections, with its capture tokens replaced by the values
+	// of params, given as name and value pairs, e.g.
+	// URLFor("user-detail", "id", "42") for a route named "user-detail" with
+	// the pattern "/users/:id". Values are escaped, and params not captured
+	// by the pattern are added as query parameters. The error wraps
+	// ErrUnknownRoute if there is no such route, and ErrMissingPathParam if a
+	// capture token has no value.
+	URLFor(name string, params ...string) (string, error)
 }
 
 type applicationSectionOpt func(application.Section)
@@ -36,83 +715,1866 @@ func WithBasicAuth(username, password, realm string) applicationSectionOpt {
 	}
 }
 
-func WithPathPatternHandler(
-	pattern string,
-	handler http.Handler,
-	contextKey any,
-) applicationSectionOpt {
+// WithBasicAuthExemptPatterns excludes requests matching any of the patterns
+// (e.g. "/healthz", "/.well-known/acme-challenge/:token" or "/static/*") from
+// the section's authentication.
+func WithBasicAuthExemptPatterns(patterns ...string) applicationSectionOpt {
 	return func(s application.Section) {
-		s.AddPathPatternHandler(pattern, handler, contextKey)
+		for _, p := range patterns {
+			s.AddAuthExemptPattern(p)
+		}
 	}
 }
 
-func WithSimpleHandler(handler http.Handler) applicationSectionOpt {
+// WithAuthenticator adds an authentication scheme to the section. Requests
+// are accepted when any scheme authenticates them; schemes are evaluated in
+// the order added, followed by basic auth when configured using
+// WithBasicAuth. Rejected requests receive a 401 response challenging for
+// every scheme.
+func WithAuthenticator(a Authenticator) applicationSectionOpt {
 	return func(s application.Section) {
-		s.SetSimpleHandler(handler)
+		s.AddAuthenticator(a)
 	}
 }
 
-func WithRateLimitingHostCacheEntryIdleDuration(d time.Duration) applicationSectionOpt {
+// WithHeaderValidation rejects requests with malformed or oversized headers
+// before authentication, rate limiting and routing. Rejections are passed to
+// the section's bad request handler with an error wrapping one of
+// ErrHeaderTooLarge, ErrMalformedHeader or ErrTooManyHeaders, so the handler
+// can map them to other responses.
+func WithHeaderValidation(limits HeaderLimits) applicationSectionOpt {
 	return func(s application.Section) {
-		s.SetRateLimitingHostCacheEntryIdleDuration(d)
+		s.SetHeaderLimits(limits)
 	}
 }
 
-func WithRateLimitingSessionConfig(
-	maxRequests int64,
-	sessionDuration time.Duration,
-	banDuration time.Duration,
-) applicationSectionOpt {
+// WithSectionCORS overrides the application's cross-origin configuration for
+// the section. Preflight requests are answered before authentication.
+func WithSectionCORS(c CORSConfig) applicationSectionOpt {
 	return func(s application.Section) {
-		s.AddRateLimitingSessionConfig(maxRequests, sessionDuration, banDuration)
+		s.SetCORSConfig(c)
 	}
 }
 
-func WithStatusBadRequestHandlerFunc(h application.HandlerFuncWithError) applicationSectionOpt {
+// WithHost binds the section to requests for host, such as
+// "api.example.com", or for the subdomains of a wildcard such as
+// "*.example.com", so that several domains served by one listener can have
+// sections with the same root. It can be given several times. Requests are
+// dispatched to the sections bound to their Host header, then to those bound
+// to the most specific matching wildcard, and otherwise to the sections bound
+// to no host, which serve as the default.
+func WithHost(host string) applicationSectionOpt {
 	return func(s application.Section) {
-		s.SetStatusBadRequestHandlerFunc(h)
+		s.AddHost(host)
 	}
 }
 
-func WithStatusNotFoundHandlerFunc(h http.HandlerFunc) applicationSectionOpt {
+// WithStripSectionPrefix makes the section's handlers, route patterns and
+// middleware see request paths relative to the section root, so that with a
+// root of /api/v1/ a request for /api/v1/items matches the pattern /items.
+// The same handlers can then be mounted under different roots. OnResponse
+// hooks still see the full path.
+func WithStripSectionPrefix() applicationSectionOpt {
 	return func(s application.Section) {
-		s.SetStatusNotFoundHandlerFunc(h)
+		s.SetStripPrefix(true)
 	}
 }
 
-func WithStatusTooManyRequestsHandlerFunc(h http.HandlerFunc) applicationSectionOpt {
+// WithStrictContextKeys rejects the routes added after it that share their
+// context key with a route capturing different variables, see
+// ContextKeyConflictError, instead of logging a warning. It should precede
+// the section's routes.
+func WithStrictContextKeys() applicationSectionOpt {
 	return func(s application.Section) {
-		s.SetStatusTooManyRequestsHandlerFunc(h)
+		s.SetStrictContextKeys(true)
 	}
 }
 
-type applicationWrapper struct {
-	application application.Application
+// WithPrefixedParamNames keys the values captured by the routes added after
+// it by capture token, e.g. ":id" rather than "id", as sudsy did originally,
+// for compatibility with handlers reading the map stored under the route's
+// context key. It should precede the section's routes. PathParams and
+// RouteParamsKey.Param accept both forms of the name.
+func WithPrefixedParamNames() applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetPrefixedParamNames(true)
+	}
 }
 
-// AddApplicationSection implements Application.
-func (a *applicationWrapper) AddApplicationSection(section application.Section) error {
-	return a.application.AddSection(section)
+// WithNonCanonicalPathRejection passes requests whose paths contain "." or
+// ".." segments or duplicate slashes to the section's bad request handler,
+// with an error wrapping ErrNonCanonicalPath. By default such paths are
+// cleaned before routing, e.g. "//a/../b" is routed as "/b", with a trailing
+// slash kept for the section's TrailingSlashPolicy.
+func WithNonCanonicalPathRejection() applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetRejectNonCanonicalPaths(true)
+	}
 }
 
-// ListenAndServe implements Application.
-func (a *applicationWrapper) ListenAndServe() {
-	a.application.ListenAndServe()
+// WithRequestTimeoutHeader derives a deadline for the request context from the
+// timeout a client requests in an X-Request-Timeout header, given as a Go
+// duration or number of seconds, or a grpc-timeout header, capped at max.
+// Requests with malformed timeouts are passed to the section's bad request
+// handler with an error wrapping ErrInvalidRequestTimeout. Requests ended by
+// their timeout or by the client disconnecting are counted in the
+// sudsy_request_timeouts_total metric, labeled with the cause, which is also
+// reported as the TimeoutCause of ResponseInfo.
+func WithRequestTimeoutHeader(max time.Duration) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetRequestTimeoutMax(max)
+	}
 }
 
-type applicationOpt = func(application.Application)
+// WithTenantResolver partitions the section's requests by tenant. The ID
+// returned by r is stored in the request context, keys the section's rate
+// limiting, is added as the tenant label of per-request metrics and is
+// reported to OnResponse hooks. Requests whose tenant cannot be resolved are
+// passed to the section's bad request handler.
+func WithTenantResolver(r TenantResolver) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetTenantResolver(r)
+	}
+}
 
-func NewApplication(opts ...applicationOpt) Application {
-	a := application.NewApplication()
-	for _, o := range opts {
-		o(a)
+// WithSectionFeatureFlag gates the section behind the named feature flag.
+// While the flag is disabled requests are passed to the section's handler for
+// disabledStatus, which defaults to 404 if zero, with an error wrapping
+// ErrFeatureDisabled.
+func WithSectionFeatureFlag(flag string, disabledStatus int) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetFeatureFlag(flags.Gate{Flag: flag, DisabledStatus: disabledStatus})
 	}
-	return &applicationWrapper{application: a}
 }
 
-func WithServerListenPort(port int) applicationOpt {
-	return func(a application.Application) {
-		a.SetServerListenPort(port)
+// WithAllowedContentTypes rejects requests to the section's routes that carry
+// a body whose media type is not one of mediaTypes. Parameters such as
+// charset are ignored when matching. Rejected requests are passed to the
+// section's 415 handler with an error wrapping ErrUnsupportedMediaType.
+func WithAllowedContentTypes(mediaTypes ...string) applicationSectionOpt {
+	return func(s application.Section) {
+		s.AddAllowedContentTypes(mediaTypes...)
+	}
+}
+
+// MethodOverrideConfig configures WithMethodOverride.
+type MethodOverrideConfig = methodoverride.Config
+
+// ErrInvalidMethodOverride is wrapped by the error passed to the section's bad
+// request handler when a request asks to be overridden with a method other
+// than PUT, PATCH or DELETE.
+var ErrInvalidMethodOverride = methodoverride.ErrInvalidMethod
+
+// WithMethodOverride translates POST requests into PUT, PATCH or DELETE
+// requests as directed by the X-HTTP-Method-Override header or, if configured,
+// a form field such as "_method", for HTML-form clients. The override applies
+// before authentication, rate limiting and routing, so handlers only see the
+// overriding method.
+func WithMethodOverride(c MethodOverrideConfig) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetMethodOverride(c)
+	}
+}
+
+// MinificationConfig configures WithMinification.
+type MinificationConfig = minify.Config
+
+// WithMinification minifies the section's HTML, CSS and JavaScript responses
+// of at least the configured size, 1 KiB by default, removing comments and
+// collapsing whitespace. Responses are buffered like for WithResponseMutator,
+// so larger and flushed responses, as well as responses with a
+// Content-Encoding, are written unmodified. Minification runs after the
+// mutators added using WithResponseMutator.
+func WithMinification(c MinificationConfig) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetMinification(c)
+	}
+}
+
+// WithQueryLimits rejects requests whose query strings exceed the limits
+// before authentication, rate limiting and routing, passing an error wrapping
+// one of the ErrQuery values to the section's bad request handler.
+func WithQueryLimits(limits QueryLimits) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetQueryLimits(limits)
+	}
+}
+
+// WithOptionalAuth lets requests without valid credentials through to the
+// section's handlers instead of rejecting them. Authenticated requests still
+// carry their principal, so handlers can serve both public and personalized
+// responses.
+func WithOptionalAuth() applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetAuthOptional(true)
+	}
+}
+
+// WithPathPatternHandler routes requests whose path matches pattern to the
+// handler. Tokens with a leading ":" match any single path segment, and a
+// final token with a leading "*" matches the remainder of the path, e.g.
+// "/files/*rest", and must be named. Captured values are stored in the request
+// context under contextKey, as a map[string]string keyed by variable name,
+// e.g. "id" for the token ":id", see NewRouteParamsKey and
+// WithPrefixedParamNames. Values are percent-decoded per RFC 3986, so that
+// "/files/a%20b" captures "a b" and "/files/a%2Fb" captures "a/b" as a single
+// segment, unless the route uses WithRouteRawPathParams. Invalid encodings are
+// passed to the section's bad request handler with an error wrapping
+// ErrInvalidPathParamEncoding. Invalid patterns, such as those with an unnamed
+// catch-all token, and patterns differing from another only in the names of
+// their capture variables, are not registered, and AddApplicationSection
+// returns an error for the section, such as a *RouteConflictError.
+func WithPathPatternHandler(
+	pattern string,
+	handler http.Handler,
+	contextKey any,
+	opts ...routeOpt,
+) applicationSectionOpt {
+	return func(s application.Section) {
+		config := urlpathpatternhandler.Config{}
+		for _, o := range opts {
+			o(&config)
+		}
+		s.AddPathPatternHandler(pattern, handler, contextKey, config)
+	}
+}
+
+// WithPathPatternHandlerForMethods is like WithPathPatternHandler, but only
+// routes requests with the given methods to the handler, and HEAD requests if
+// GET is among them. The same pattern can be registered for other methods
+// with other handlers. Requests to the pattern with other methods are passed
+// to the section's 405 handler, which by default responds with an Allow header
+// listing the methods served, and an error wrapping ErrMethodNotAllowed,
+// except OPTIONS requests, which are answered with the Allow header and 204
+// No Content. Bodies written by GET handlers serving HEAD requests are
+// discarded. See WithRouteNoAutoHead and WithRouteNoAutoOptions.
+//
+// Methods are case-sensitive, and may be extension methods such as PROPFIND
+// or REPORT, or MethodAny to serve the methods no other handler of the
+// pattern serves. Invalid methods are not registered, and
+// AddApplicationSection returns an error wrapping ErrInvalidMethod.
+func WithPathPatternHandlerForMethods(
+	methods []string,
+	pattern string,
+	handler http.Handler,
+	contextKey any,
+	opts ...routeOpt,
+) applicationSectionOpt {
+	return WithPathPatternHandler(pattern, handler, contextKey, append(opts, func(c *urlpathpatternhandler.Config) {
+		c.Methods = append(c.Methods, methods...)
+	})...)
+}
+
+// WithMountedHandler passes requests for prefix and the paths beneath it to
+// handler, such as an http.ServeMux, a third-party router or the pprof
+// handlers, with prefix removed from their path. Requests for prefix itself
+// have the path "/". The mount conflicts with a "*" pattern registered for
+// the same prefix, which is reported like WithPathPatternHandler's conflicts.
+func WithMountedHandler(prefix string, handler http.Handler, opts ...routeOpt) applicationSectionOpt {
+	return func(s application.Section) {
+		config := urlpathpatternhandler.Config{}
+		for _, o := range opts {
+			o(&config)
+		}
+		// Errors are reported by the section, see WithPathPatternHandler.
+		_ = s.Mount(prefix, handler, config)
+	}
+}
+
+// StaticFilesConfig configures the files served by WithStaticFiles.
+type StaticFilesConfig = static.Config
+
+// WithStaticFiles serves the files of fsys under prefix, e.g. "/assets/", for
+// GET and HEAD requests. A directory can be served with os.DirFS. Range and
+// conditional requests are supported, with an ETag derived from the size
+// and modification time of files, or their content if the time is unknown,
+// as for embed.FS. Directories are served their index.html, or listed unless
+// c.DisableDirectoryListing is set. Missing files are passed to the
+// section's 404 handler.
+func WithStaticFiles(prefix string, fsys fs.FS, c StaticFilesConfig, opts ...routeOpt) applicationSectionOpt {
+	return func(s application.Section) {
+		config := urlpathpatternhandler.Config{Methods: []string{http.MethodGet}}
+		for _, o := range opts {
+			o(&config)
+		}
+		// Errors are reported by the section, see WithPathPatternHandler.
+		_ = s.Mount(prefix, static.NewHandler(staticFilesDependencies{}, fsys, c), config)
+	}
+}
+
+// WithSPAFallback serves the requests matching no route of the section with
+// handler, typically serving a single-page application's index.html, instead
+// of the 404 handler. Only page navigations fall back, i.e. GET and HEAD
+// requests whose Accept header includes text/html, so that unmatched API and
+// asset requests still receive 404 responses. The section's middleware and
+// panic handling apply to handler as to routes.
+func WithSPAFallback(handler http.Handler) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetSPAFallback(handler)
+	}
+}
+
+// TrailingSlashPolicy is the handling of requests whose path matches no
+// route of a section, but would with its trailing slash removed, or added
+// where the route's pattern has one, e.g. "/users/42/" when only
+// "/users/:id" is registered.
+type TrailingSlashPolicy = urlpathpatternhandler.TrailingSlash
+
+const (
+	// TrailingSlashStrict treats paths differing in their trailing slash as
+	// distinct, so that such requests are not found. This is the default.
+	TrailingSlashStrict = urlpathpatternhandler.TrailingSlashStrict
+	// TrailingSlashRedirect redirects to the matching path, with 301 Moved
+	// Permanently for GET and HEAD requests and 308 Permanent Redirect for
+	// others.
+	TrailingSlashRedirect = urlpathpatternhandler.TrailingSlashRedirect
+	// TrailingSlashStrip serves the request as if it was for the matching
+	// path, which is the path handlers see.
+	TrailingSlashStrip = urlpathpatternhandler.TrailingSlashStrip
+)
+
+// WithTrailingSlashPolicy sets the handling of requests whose path matches a
+// route of the section only with its trailing slash removed or added.
+func WithTrailingSlashPolicy(p TrailingSlashPolicy) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetTrailingSlash(p)
+	}
+}
+
+// WithRouteCache caches the routes matching up to size of the paths last
+// requested, with the values their patterns capture, so that workloads
+// requesting a small set of paths matching deep patterns with capture
+// variables skip the route search. Paths matching patterns without capture
+// variables are always found without a search, and paths matching no route
+// are not cached. The cache is discarded with the section's handler.
+func WithRouteCache(size int) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetRouteCacheSize(size)
+	}
+}
+
+type staticFilesDependencies struct{}
+
+// HandleStatus implements static.Dependencies.
+func (staticFilesDependencies) HandleStatus(w http.ResponseWriter, r *http.Request, code int, err error) {
+	application.HandleStatus(w, r, code, err)
+}
+
+// WithNamedPathPatternHandler is like WithPathPatternHandler, naming the
+// route, see WithRouteName.
+func WithNamedPathPatternHandler(
+	name string,
+	pattern string,
+	handler http.Handler,
+	contextKey any,
+	opts ...routeOpt,
+) applicationSectionOpt {
+	return WithPathPatternHandler(pattern, handler, contextKey, append(opts, WithRouteName(name))...)
+}
+
+// ErrMethodNotAllowed is wrapped by the error passed to the section's 405
+// handler.
+var ErrMethodNotAllowed = urlpathpatternhandler.ErrMethodNotAllowed
+
+// ErrInvalidPathParamEncoding is wrapped by the error passed to the section's
+// bad request handler for requests whose captured path values are not validly
+// percent-encoded.
+var ErrInvalidPathParamEncoding = urlpathpatternhandler.ErrInvalidParamEncoding
+
+// ErrInvalidMethod is wrapped by the error returned for routes registered
+// for methods that are not valid tokens, see
+// WithPathPatternHandlerForMethods.
+var ErrInvalidMethod = urlpathpatternhandler.ErrInvalidMethod
+
+// MethodAny, passed to WithPathPatternHandlerForMethods, serves the requests
+// to the pattern whose method no other handler of the pattern serves, so that
+// e.g. a proxy can forward methods it does not know of. HEAD requests are
+// still served by the pattern's GET handlers first.
+const MethodAny = urlpathpatternhandler.MethodAny
+
+// ErrRouteMatchFailed is the error passed to the section's 404 handler for
+// requests whose path and method match routes, none of whose matches they
+// satisfy, see WithRouteHeaderMatch.
+var ErrRouteMatchFailed = urlpathpatternhandler.ErrMatchersNotSatisfied
+
+// RouteConflictError is returned by AddApplicationSection for a section with
+// a pattern differing from another only in the names of its capture
+// variables, for methods both serve, unless their matches tell requests
+// apart, see WithRouteHeaderMatch. It wraps ErrAmbiguousRoute.
+type RouteConflictError = urlpathpatternhandler.ConflictError
+
+// ContextKeyConflictError reports a route storing its params under the same
+// context key as another route of the section capturing different
+// variables, neither route's variables including the other's, e.g.
+// "/users/:id" and "/orgs/:org". It is logged as a warning, or returned by
+// AddApplicationSection for sections using WithStrictContextKeys. It wraps
+// ErrContextKeyConflict.
+type ContextKeyConflictError = urlpathpatternhandler.ContextKeyConflictError
+
+var (
+	// ErrAmbiguousRoute is wrapped by the errors returned for conflicting
+	// patterns.
+	ErrAmbiguousRoute = urlpathpatternhandler.ErrAmbiguousCaptureVariableNames
+	// ErrContextKeyConflict is wrapped by the errors returned for routes
+	// sharing their context key with routes capturing different variables.
+	ErrContextKeyConflict = urlpathpatternhandler.ErrContextKeyConflict
+	// ErrDuplicateRouteName is wrapped by the error returned for a route
+	// named like another of the application.
+	ErrDuplicateRouteName = urlpathpatternhandler.ErrDuplicateRouteName
+	// ErrUnknownRoute is wrapped by the error of Application.URLFor for a
+	// name no route has.
+	ErrUnknownRoute = urlpathpatternhandler.ErrUnknownRouteName
+	// ErrMisplacedCatchAll is wrapped by the error returned for a pattern
+	// with a catch-all token before its last path segment.
+	ErrMisplacedCatchAll = urlpathpatternhandler.ErrMisplacedCatchAll
+	// ErrUnnamedCatchAll is wrapped by the error returned for a pattern
+	// with a catch-all token without a name, such as "/static/*".
+	ErrUnnamedCatchAll = urlpathpatternhandler.ErrUnnamedCatchAll
+)
+
+type routeOpt func(*urlpathpatternhandler.Config)
+
+// WithRouteDescription documents what the route does.
+func WithRouteDescription(description string) routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.Metadata.Description = description
+	}
+}
+
+// WithRouteTags adds tags grouping the route in documentation.
+func WithRouteTags(tags ...string) routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.Metadata.Tags = append(c.Metadata.Tags, tags...)
+	}
+}
+
+// WithRouteDeprecated flags the route as deprecated. Responses carry a
+// Deprecation header and each request is counted in the
+// sudsy_deprecated_route_requests_total metric.
+func WithRouteDeprecated() routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.Metadata.Deprecated = true
+	}
+}
+
+// WithRouteDeprecatedAt flags the route as deprecated as of t, which is
+// reported in the Deprecation header.
+func WithRouteDeprecatedAt(t time.Time) routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.Metadata.Deprecated = true
+		c.Metadata.DeprecatedAt = &t
+	}
+}
+
+// WithRouteSunset flags the route as deprecated and due for removal at t,
+// which is reported in the Sunset header.
+func WithRouteSunset(t time.Time) routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.Metadata.Deprecated = true
+		c.Metadata.Sunset = &t
+	}
+}
+
+// WithRouteCORS overrides the section's cross-origin configuration for the
+// route, e.g. to allow credentialed requests from specific origins only.
+func WithRouteCORS(c CORSConfig) routeOpt {
+	return func(rc *urlpathpatternhandler.Config) {
+		rc.CORS = &c
+	}
+}
+
+// WithRouteFeatureFlag gates the route behind the named feature flag. While
+// the flag is disabled requests are passed to the section's handler for
+// disabledStatus, which defaults to 404 if zero.
+func WithRouteFeatureFlag(flag string, disabledStatus int) routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.FeatureFlag = flags.Gate{Flag: flag, DisabledStatus: disabledStatus}
+	}
+}
+
+// WithRouteName names the route, so that Application.URLFor can build paths
+// to it. Names must be unique within the application, otherwise
+// AddApplicationSection returns an error wrapping ErrDuplicateRouteName.
+func WithRouteName(name string) routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.Name = name
+	}
+}
+
+// WithRouteTraceSampler overrides the application's trace sampler for the
+// route, e.g. with NeverSampleTraces for high-volume health checks.
+func WithRouteTraceSampler(s TraceSampler) routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.TraceSampler = s
+	}
+}
+
+// WithRoutePriority sets the load shedding priority of requests to the route,
+// overriding the section's classifier.
+func WithRoutePriority(p Priority) routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.Priority = &p
+	}
+}
+
+// WithRouteHeaderMatch selects the route only for requests whose named header
+// has value among its comma-separated elements, compared case-insensitively
+// and ignoring parameters, or has the header at all if value is empty, so
+// that several handlers can share a pattern and methods, e.g. one for
+// "Accept: application/json" and one for other requests. Handlers with more
+// matches are tried first. Requests satisfying no handler of the pattern are
+// passed to the section's 404 handler with an error wrapping
+// ErrRouteMatchFailed.
+func WithRouteHeaderMatch(name, value string) routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.Matchers = append(c.Matchers, urlpathpatternhandler.Matcher{Header: name, Value: value})
+	}
+}
+
+// WithRouteQueryMatch selects the route only for requests with the named
+// query parameter set to value, or set at all if value is empty, e.g.
+// "?version=2", as WithRouteHeaderMatch does for headers.
+func WithRouteQueryMatch(name, value string) routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.Matchers = append(c.Matchers, urlpathpatternhandler.Matcher{Query: name, Value: value})
+	}
+}
+
+// WithRouteNoAutoHead keeps a route serving GET requests from also serving
+// HEAD requests with their body discarded, which it does by default. HEAD
+// requests are then answered by a handler of the pattern serving HEAD, or
+// with 405 Method Not Allowed.
+func WithRouteNoAutoHead() routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.DisableAutoHead = true
+	}
+}
+
+// WithRouteNoAutoOptions keeps OPTIONS requests to the route's pattern that no
+// handler serves from being answered with 204 No Content and the methods
+// served in the Allow header, which they are by default unless every handler
+// of the pattern disables it. Registering a handler for OPTIONS overrides the
+// automatic answer as well.
+func WithRouteNoAutoOptions() routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.DisableAutoOptions = true
+	}
+}
+
+// WithRouteRawPathParams passes the route's handler the values captured from
+// the request path as escaped in the request URL, e.g. "a%20b" for
+// "/files/a%20b", instead of percent-decoding them.
+func WithRouteRawPathParams() routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.RawPathParams = true
+	}
+}
+
+// WithRouteRequiredHeaders rejects requests to the route missing any of the
+// named headers. Rule violations are passed to the section's bad request
+// handler as a *ValidationError before the route's handler runs.
+func WithRouteRequiredHeaders(names ...string) routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.Rules.RequiredHeaders = append(c.Rules.RequiredHeaders, names...)
+	}
+}
+
+// WithRouteContentTypes rejects requests to the route that carry a body whose
+// media type is not one of mediaTypes, overriding any list set with
+// WithAllowedContentTypes. Rejected requests are passed to the section's 415
+// handler with an error wrapping ErrUnsupportedMediaType.
+func WithRouteContentTypes(mediaTypes ...string) routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		c.ContentTypes = append(c.ContentTypes, mediaTypes...)
+	}
+}
+
+// WithRoutePathParamFormat rejects requests to the route whose capture
+// variable name, given without the leading ":", does not match format.
+func WithRoutePathParamFormat(name string, format *regexp.Regexp) routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		if c.Rules.PathParams == nil {
+			c.Rules.PathParams = map[string]*regexp.Regexp{}
+		}
+		c.Rules.PathParams[strings.TrimPrefix(name, ":")] = format
+	}
+}
+
+// WithRouteQueryParamFormat rejects requests to the route with a value for
+// the named query parameter that does not match format.
+func WithRouteQueryParamFormat(name string, format *regexp.Regexp) routeOpt {
+	return func(c *urlpathpatternhandler.Config) {
+		if c.Rules.QueryParams == nil {
+			c.Rules.QueryParams = map[string]*regexp.Regexp{}
+		}
+		c.Rules.QueryParams[name] = format
+	}
+}
+
+// ErrorPage is the data error page templates given to WithHTMLErrorPages are
+// executed with.
+type ErrorPage = errorpages.Page
+
+// WithHTMLErrorPages marks the section as serving HTML, so that its 404, 429
+// and 500 responses are styled error pages rather than plain text, unless a
+// custom handler is set for the status code. Pages are rendered with the
+// template named after the status code, e.g. "404", or else the one named
+// "error", from t if not nil, falling back to a built-in template. In
+// development mode the pages also detail the error.
+func WithHTMLErrorPages(t *Templates) applicationSectionOpt {
+	return func(s application.Section) {
+		var set *templates.Set
+		if t != nil {
+			set = t.set
+		}
+		s.SetHTMLErrorPages(set)
+	}
+}
+
+// WithDefaultResponseHeader adds a header, such as X-Frame-Options or an API
+// version header, to every response of the section, including those written
+// by the built-in middleware, unless the handler already set it. Calling it
+// repeatedly with the same name adds multiple values.
+func WithDefaultResponseHeader(name, value string) applicationSectionOpt {
+	return func(s application.Section) {
+		s.AddDefaultResponseHeader(name, value)
+	}
+}
+
+// ContentSecurityPolicy configures WithContentSecurityPolicy.
+type ContentSecurityPolicy = csp.Config
+
+// WithContentSecurityPolicy generates a nonce for every request to the section
+// and sets the Content-Security-Policy header of its responses, unless the
+// handler replaces it, to the policy with every "{nonce}" replaced by the
+// nonce source expression, e.g. "script-src 'self' {nonce}". The nonce is
+// returned by CSPNonceFromContext and the cspNonce function of Templates.
+func WithContentSecurityPolicy(p ContentSecurityPolicy) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetContentSecurityPolicy(p)
+	}
+}
+
+// CSPNonceFromContext returns the nonce generated for the request when
+// WithContentSecurityPolicy is configured.
+func CSPNonceFromContext(ctx context.Context) (string, bool) {
+	return csp.NonceFromContext(ctx)
+}
+
+// BufferedResponse is a response buffered for the mutators added using
+// WithResponseMutator, which may change any of its fields.
+type BufferedResponse = buffering.Response
+
+// WithResponseMutator buffers the section's responses, so that m can rewrite
+// them once the handler has returned, e.g. to inject a banner into HTML pages.
+// Mutators run in the order added. Responses larger than the buffer limit,
+// 1 MiB unless set using WithResponseBufferLimit, and responses flushed by
+// the handler are written unmodified as they are produced, so that streaming
+// keeps working.
+func WithResponseMutator(m func(*http.Request, *BufferedResponse)) applicationSectionOpt {
+	return func(s application.Section) {
+		s.AddResponseMutator(m)
+	}
+}
+
+// WithResponseBufferLimit sets the size beyond which responses are no longer
+// buffered for the mutators added using WithResponseMutator.
+func WithResponseBufferLimit(n int64) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetResponseBufferLimit(n)
+	}
+}
+
+// Middleware wraps an http.Handler, as added using WithMiddleware.
+type Middleware = func(http.Handler) http.Handler
+
+// WithMiddleware wraps the section's handlers with mw. Middleware runs after
+// the built-in middleware, such as authentication and rate limiting, has let
+// the request through, the first added outermost. If the handler mw returns
+// has the methods BeforeStart(*sync.WaitGroup) and AfterShutdown(), they are
+// called when the application starts and after it shuts down, e.g. to run
+// and stop background work. See WithMiddlewareAt for other positions.
+func WithMiddleware(mw Middleware) applicationSectionOpt {
+	return func(s application.Section) {
+		s.AddMiddleware(mw, nil)
+	}
+}
+
+// MiddlewarePosition is the position in the section's middleware chain of
+// middleware added using WithMiddlewareAt.
+type MiddlewarePosition = application.MiddlewarePosition
+
+const (
+	// MiddlewareInnermost runs middleware next to the route's handler, as
+	// WithMiddleware does.
+	MiddlewareInnermost = application.MiddlewareInnermost
+	// MiddlewareAfterAuth runs middleware once the request is authenticated,
+	// e.g. to read the principal, ahead of load shedding, adaptive
+	// concurrency and the worker pool.
+	MiddlewareAfterAuth = application.MiddlewareAfterAuth
+	// MiddlewareBeforeAuth runs middleware ahead of authentication, e.g. to
+	// translate credentials, once the request has passed rate limiting and
+	// CORS preflights have been answered.
+	MiddlewareBeforeAuth = application.MiddlewareBeforeAuth
+)
+
+// WithMiddlewareAt is like WithMiddleware, installing mw at position in the
+// section's middleware chain. Middleware added at the same position runs in
+// the order added.
+func WithMiddlewareAt(position MiddlewarePosition, mw Middleware) applicationSectionOpt {
+	return func(s application.Section) {
+		s.AddMiddlewareAt(position, mw, nil)
+	}
+}
+
+// WithConditionalMiddleware is like WithMiddleware, but only runs mw for the
+// requests for which predicate returns true, so that expensive middleware
+// such as decompression or body logging can be limited to the requests
+// needing it. Other requests go straight to the next middleware in the chain.
+func WithConditionalMiddleware(predicate func(*http.Request) bool, mw Middleware) applicationSectionOpt {
+	return func(s application.Section) {
+		s.AddMiddleware(mw, predicate)
+	}
+}
+
+type groupOpt func(application.Group)
+
+// WithGroup registers routes under a shared path prefix, e.g. "/api/v1",
+// configured by opts. The group's basic auth, rate limiting and middleware
+// apply to requests whose path starts with the prefix, after the section's
+// authentication and rate limiting and before the section's middleware.
+func WithGroup(prefix string, opts ...groupOpt) applicationSectionOpt {
+	return func(s application.Section) {
+		g := s.Group(prefix)
+		for _, o := range opts {
+			o(g)
+		}
+	}
+}
+
+// WithNestedGroup registers a group within the group, whose prefix follows
+// the enclosing group's. The enclosing group's settings apply too.
+func WithNestedGroup(prefix string, opts ...groupOpt) groupOpt {
+	return func(g application.Group) {
+		nested := g.Group(prefix)
+		for _, o := range opts {
+			o(nested)
+		}
+	}
+}
+
+// WithGroupPathPatternHandler is like WithPathPatternHandler, for the group's
+// prefix followed by pattern.
+func WithGroupPathPatternHandler(
+	pattern string,
+	handler http.Handler,
+	contextKey any,
+	opts ...routeOpt,
+) groupOpt {
+	return func(g application.Group) {
+		config := urlpathpatternhandler.Config{}
+		for _, o := range opts {
+			o(&config)
+		}
+		// Errors are reported by the section, see WithPathPatternHandler.
+		_ = g.AddPathPatternHandler(pattern, handler, contextKey, config)
+	}
+}
+
+// WithGroupPathPatternHandlerForMethods is like
+// WithPathPatternHandlerForMethods, for the group's prefix followed by
+// pattern.
+func WithGroupPathPatternHandlerForMethods(
+	methods []string,
+	pattern string,
+	handler http.Handler,
+	contextKey any,
+	opts ...routeOpt,
+) groupOpt {
+	return WithGroupPathPatternHandler(pattern, handler, contextKey, append(opts, func(c *urlpathpatternhandler.Config) {
+		c.Methods = append(c.Methods, methods...)
+	})...)
+}
+
+// WithGroupMountedHandler mounts handler at the prefix following the
+// group's, like WithMountedHandler.
+func WithGroupMountedHandler(prefix string, handler http.Handler, opts ...routeOpt) groupOpt {
+	return func(g application.Group) {
+		config := urlpathpatternhandler.Config{}
+		for _, o := range opts {
+			o(&config)
+		}
+		// Errors are reported by the section, see WithPathPatternHandler.
+		_ = g.Mount(prefix, handler, config)
+	}
+}
+
+// WithGroupRouteOptions applies opts to the routes registered in the group
+// and its nested groups after it, before the routes' own options.
+func WithGroupRouteOptions(opts ...routeOpt) groupOpt {
+	return func(g application.Group) {
+		for _, o := range opts {
+			g.AddRouteConfigFunc(o)
+		}
+	}
+}
+
+// WithGroupBasicAuth requires basic auth credentials for the group's routes,
+// in addition to the section's authentication if any.
+func WithGroupBasicAuth(username, password, realm string) groupOpt {
+	return func(g application.Group) {
+		g.SetBasicAuth(username, password, realm)
+	}
+}
+
+// WithGroupRateLimitingSessionConfig rate limits requests to the group's
+// routes like WithRateLimitingSessionConfig, counting them separately from
+// the section's other requests. The metrics have the "group" scope.
+func WithGroupRateLimitingSessionConfig(
+	maxRequests int64,
+	sessionDuration time.Duration,
+	banDuration time.Duration,
+) groupOpt {
+	return func(g application.Group) {
+		g.AddRateLimitingSessionConfig(maxRequests, sessionDuration, banDuration)
+	}
+}
+
+// WithGroupMiddleware wraps the group's routes with mw, the first added
+// outermost.
+func WithGroupMiddleware(mw Middleware) groupOpt {
+	return func(g application.Group) {
+		g.AddMiddleware(mw)
+	}
+}
+
+func WithSimpleHandler(handler http.Handler) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetSimpleHandler(handler)
+	}
+}
+
+// WithConnectionClose closes the connection after every response served by
+// the section, e.g. for one-shot webhook receivers.
+func WithConnectionClose() applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetConnectionClose(true)
+	}
+}
+
+// ServerTimeouts configures the timeouts of a section's own server.
+type ServerTimeouts = application.ServerTimeouts
+
+// WithSectionListenPort binds the section to its own server listening on
+// port, e.g. to keep an admin API off the public listener. The server shares
+// the application lifecycle and shutdown.
+func WithSectionListenPort(port int) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetListenPort(port)
+	}
+}
+
+// WithSectionServerTimeouts sets the timeouts of the section's own server.
+func WithSectionServerTimeouts(t ServerTimeouts) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetServerTimeouts(t)
+	}
+}
+
+// WithSectionTLSCertificateFiles serves the section's own server over HTTPS
+// using the given certificate and key files and the application's TLS
+// policy.
+func WithSectionTLSCertificateFiles(certFile, keyFile string) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetTLSCertificateFiles(certFile, keyFile)
+	}
+}
+
+// WithPanicAutoDisable disables a route once it panics more than
+// maxPanicsPerMinute times within a minute. Routes are told apart by pattern
+// and methods, so that the handlers of a pattern serving other methods
+// remain enabled. Disabled routes respond with 503 until re-enabled through
+// the admin API.
+func WithPanicAutoDisable(maxPanicsPerMinute int) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetMaxPanicsPerMinute(maxPanicsPerMinute)
+	}
+}
+
+// WithUnmatchedPathLimit sets how many distinct unmatched paths the section
+// counts for the admin API; further paths are counted together. The default
+// is 100.
+func WithUnmatchedPathLimit(n int) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetUnmatchedPathLimit(n)
+	}
+}
+
+// WithOnResponse adds a hook invoked after each request served by the section
+// completes, as a lightweight alternative to middleware for telemetry.
+func WithOnResponse(f func(*ResponseInfo)) applicationSectionOpt {
+	return func(s application.Section) {
+		s.AddOnResponseHook(f)
+	}
+}
+
+func WithRateLimitingHostCacheEntryIdleDuration(d time.Duration) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetRateLimitingHostCacheEntryIdleDuration(d)
+	}
+}
+
+// WithRateLimitingGroomingInterval sets how often the background goroutine
+// evicts idle rate limiting cache entries, 10 seconds by default.
+func WithRateLimitingGroomingInterval(d time.Duration) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetRateLimitingGroomingInterval(d)
+	}
+}
+
+// WithRateLimitingLazyExpiration disables the background goroutine evicting
+// idle rate limiting cache entries, for serverless and scale-to-zero
+// environments. Idle entries are instead expired when their client makes
+// another request, and evicted by Application.GroomNow.
+func WithRateLimitingLazyExpiration() applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetRateLimitingLazyExpiration(true)
+	}
+}
+
+// HostResolver determines the client address a request is attributed to.
+type HostResolver = realip.Resolver
+
+// NewLeftmostForwardedForResolver attributes requests to the leftmost
+// x-forwarded-for address. Only use it when every proxy in front of the
+// server overwrites the header.
+func NewLeftmostForwardedForResolver() HostResolver {
+	return realip.NewLeftmostForwardedForResolver()
+}
+
+// NewSkipForwardedForResolver attributes requests to the x-forwarded-for
+// address appended by the proxy n hops away from the server.
+func NewSkipForwardedForResolver(n int) HostResolver {
+	return realip.NewSkipForwardedForResolver(n)
+}
+
+// NewRightmostUntrustedForwardedForResolver attributes requests to the
+// rightmost x-forwarded-for address outside the trusted proxy networks.
+func NewRightmostUntrustedForwardedForResolver(trustedCIDRs ...string) (HostResolver, error) {
+	return realip.NewRightmostUntrustedForwardedForResolver(trustedCIDRs...)
+}
+
+// NewSkipForwardedResolver attributes requests to the for= address of the
+// standard Forwarded header appended by the proxy n hops away from the
+// server. The x-forwarded-for resolvers ignore the Forwarded header, which
+// clients can send as well, so only use it when the proxies in front of the
+// server append to it.
+func NewSkipForwardedResolver(n int) HostResolver {
+	return realip.NewSkipForwardedResolver(n)
+}
+
+// NewRightmostUntrustedForwardedResolver attributes requests to the rightmost
+// for= address of the standard Forwarded header outside the trusted proxy
+// networks.
+func NewRightmostUntrustedForwardedResolver(trustedCIDRs ...string) (HostResolver, error) {
+	return realip.NewRightmostUntrustedForwardedResolver(trustedCIDRs...)
+}
+
+// WithRateLimitingHostResolver replaces the resolution of the client address
+// for the section's rate limiter. By default the address resolved by
+// WithRealIP is used, or else the fastly-client-ip header, then the rightmost
+// x-forwarded-for address, then the connection's remote address.
+func WithRateLimitingHostResolver(r HostResolver) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetRateLimitingHostResolver(r)
+	}
+}
+
+// WithRateLimitingExemptPatterns excludes requests matching any of the
+// patterns (e.g. "/healthz" or "/static/*") from the section's rate limiting,
+// so frequent infrastructure probes do not get a load balancer's address
+// banned. A final "*" segment matches any remaining path segments.
+func WithRateLimitingExemptPatterns(patterns ...string) applicationSectionOpt {
+	return func(s application.Section) {
+		for _, p := range patterns {
+			s.AddRateLimitingExemptPattern(p)
+		}
+	}
+}
+
+// WithRateLimitingIPv6Prefix groups IPv6 clients by network prefix of the
+// given length for rate limiting, typically 64, since a single client is
+// often assigned a whole /64 and could otherwise rotate addresses to evade
+// bans.
+func WithRateLimitingIPv6Prefix(bits int) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetRateLimitingIPv6PrefixLength(bits)
+	}
+}
+
+// WithRateLimitingRemoteAddrFallback attributes requests whose resolved
+// client address is not a valid IP address, e.g. because a forwarding header
+// holds arbitrary text, to the connection's remote address for rate limiting.
+// By default such requests are passed to the section's bad request handler.
+func WithRateLimitingRemoteAddrFallback() applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetRateLimitingUseRemoteAddrForInvalidAddress(true)
+	}
+}
+
+// WithRateLimitingPreflightBypass excludes CORS preflight requests, which
+// browsers send ahead of cross-origin requests, from the section's rate
+// limiting so single-page applications do not use up their quota at twice
+// the rate. Only preflights answered using a CORS configuration bypass rate
+// limiting.
+func WithRateLimitingPreflightBypass() applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetRateLimitingPreflightExempt(true)
+	}
+}
+
+// WithRateLimitingSessionConfig enables rate limiting for the section, banning
+// clients making more than maxRequests requests within sessionDuration.
+// Clients are keyed by their normalized IP address; requests whose client
+// address is not a valid IP address are passed to the section's bad request
+// handler with an error wrapping ErrInvalidClientAddress.
+//
+// Rate limiting reports the sudsy_ratelimit_requests_total,
+// sudsy_ratelimit_rejections_total and sudsy_ratelimit_evictions_total
+// counters and the sudsy_ratelimit_active_bans and
+// sudsy_ratelimit_cache_entries gauges, labeled by section and with the
+// "section" scope, and banned clients are listed by the admin API.
+func WithRateLimitingSessionConfig(
+	maxRequests int64,
+	sessionDuration time.Duration,
+	banDuration time.Duration,
+) applicationSectionOpt {
+	return func(s application.Section) {
+		s.AddRateLimitingSessionConfig(maxRequests, sessionDuration, banDuration)
+	}
+}
+
+// ErrInvalidClientAddress is wrapped by the error passed to the bad request
+// handler when rate limiting cannot parse a request's client address.
+var ErrInvalidClientAddress = ratelimiting.ErrInvalidClientAddress
+
+// RateLimitingTierResolver returns the tier, such as a tenant's plan, whose
+// session configs apply to a request.
+type RateLimitingTierResolver = ratelimiting.TierResolver
+
+// WithRateLimitingTierResolver sets the callback resolving each request's
+// tier. Requests resolved to a tier registered with
+// WithRateLimitingTierSessionConfig are limited by that tier's session configs
+// instead of the section's defaults. A client whose tier changes is counted
+// afresh under the new tier's limits, but remains banned until the bans
+// imposed under its previous tiers expire.
+func WithRateLimitingTierResolver(r RateLimitingTierResolver) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetRateLimitingTierResolver(r)
+	}
+}
+
+// WithRateLimitingTierSessionConfig adds a session config applying to
+// requests resolved to the given tier. An empty tier adds a default session
+// config, as WithRateLimitingSessionConfig does.
+func WithRateLimitingTierSessionConfig(
+	tier string,
+	maxRequests int64,
+	sessionDuration time.Duration,
+	banDuration time.Duration,
+) applicationSectionOpt {
+	return func(s application.Section) {
+		s.AddRateLimitingTierSessionConfig(tier, maxRequests, sessionDuration, banDuration)
+	}
+}
+
+// WithStatusHandlerFunc sets the handler producing responses with the given
+// status code, e.g. 401, 403, 405, 413, 500 or 503, so that every error
+// response of the section can be branded consistently. The error describes
+// why the response is being produced and may be nil.
+func WithStatusHandlerFunc(code int, h application.HandlerFuncWithError) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetStatusHandlerFunc(code, h)
+	}
+}
+
+func WithStatusBadRequestHandlerFunc(h application.HandlerFuncWithError) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetStatusBadRequestHandlerFunc(h)
+	}
+}
+
+func WithStatusNotFoundHandlerFunc(h http.HandlerFunc) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetStatusNotFoundHandlerFunc(h)
+	}
+}
+
+func WithStatusTooManyRequestsHandlerFunc(h http.HandlerFunc) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetStatusTooManyRequestsHandlerFunc(h)
+	}
+}
+
+// WorkerPoolConfig configures WithWorkerPool. Zero values select the
+// defaults.
+type WorkerPoolConfig = workerpool.Config
+
+var (
+	// ErrWorkerPoolQueueFull is the error passed to the section's 503 handler
+	// when a request finds the worker pool queue full.
+	ErrWorkerPoolQueueFull = workerpool.ErrQueueFull
+	// ErrWorkerPoolQueueTimeout is the error passed to the section's 503
+	// handler when a request waits longer than the queue timeout.
+	ErrWorkerPoolQueueTimeout = workerpool.ErrQueueTimeout
+)
+
+// WithWorkerPool runs the section's handlers on a bounded pool of worker
+// goroutines rather than on each connection's goroutine, capping the work in
+// flight for handlers doing heavy CPU-bound processing. Requests wait in a
+// bounded queue for a free worker, and are passed to the section's 503
+// handler if it is full or they wait longer than the queue timeout.
+// Rejections are counted by the sudsy_workerpool_rejections_total metric,
+// labeled by reason.
+func WithWorkerPool(c WorkerPoolConfig) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetWorkerPool(c)
+	}
+}
+
+// AdaptiveConcurrencyConfig configures WithAdaptiveConcurrency. Zero values
+// select the defaults.
+type AdaptiveConcurrencyConfig = concurrency.Config
+
+// ErrConcurrencyLimitExceeded is the error passed to the section's 503
+// handler when a request is rejected by WithAdaptiveConcurrency.
+var ErrConcurrencyLimitExceeded = concurrency.ErrLimitExceeded
+
+// WithAdaptiveConcurrency caps the number of requests to the section in
+// flight with a limit adjusted to observed latency. The limit grows while
+// latency stays within the configured tolerance of the lowest recently
+// observed latency, and shrinks when latency rises beyond it. Requests beyond
+// the limit are passed to the section's 503 handler. The limit is reported by
+// the sudsy_concurrency_limit gauge and rejections by the
+// sudsy_concurrency_rejections_total counter.
+func WithAdaptiveConcurrency(c AdaptiveConcurrencyConfig) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetAdaptiveConcurrency(c)
+	}
+}
+
+// Priority ranks requests for load shedding. Requests of lower priority are
+// shed first, and PriorityCritical requests are never shed.
+type Priority = shedding.Priority
+
+const (
+	PriorityLow      = shedding.PriorityLow
+	PriorityNormal   = shedding.PriorityNormal
+	PriorityHigh     = shedding.PriorityHigh
+	PriorityCritical = shedding.PriorityCritical
+)
+
+// LoadSheddingLimits configures when WithLoadShedding sheds requests.
+type LoadSheddingLimits = shedding.Limits
+
+// LoadSheddingClassifier returns the priority of a request. Classifiers run
+// after authentication, so they can use PrincipalFromContext.
+type LoadSheddingClassifier = shedding.Classifier
+
+// ErrOverloaded is wrapped by the error passed to the section's 503 handler
+// when a request is shed.
+var ErrOverloaded = shedding.ErrOverloaded
+
+// NewHeaderPriorityClassifier returns a LoadSheddingClassifier mapping values
+// of the named request header to priorities. Requests without a mapped value
+// have normal priority.
+func NewHeaderPriorityClassifier(header string, priorities map[string]Priority) LoadSheddingClassifier {
+	return shedding.NewHeaderClassifier(header, priorities)
+}
+
+// WithLoadShedding enables load shedding for the section. Requests are
+// classified by the priority set with WithRoutePriority, or else by the
+// classifier set with WithLoadSheddingClassifier, and low priority requests
+// are rejected first when the limits are exceeded. Shed requests are passed
+// to the section's 503 handler with an error wrapping ErrOverloaded and
+// counted by the sudsy_shed_requests_total metric.
+func WithLoadShedding(l LoadSheddingLimits) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetLoadSheddingLimits(l)
+	}
+}
+
+// WithLoadSheddingClassifier sets the classifier used by WithLoadShedding for
+// requests to routes without a priority. Without one such requests have
+// normal priority.
+func WithLoadSheddingClassifier(c LoadSheddingClassifier) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetLoadSheddingClassifier(c)
+	}
+}
+
+// RateInfo describes a rate limiting ban and the session config that
+// triggered it.
+type RateInfo = ratelimiting.RateInfo
+
+// RateLimitingBanError is the error passed to the section's 429 handler, when
+// set through WithStatusHandlerFunc, describing the client's ban.
+type RateLimitingBanError = ratelimiting.BanError
+
+// HandlerFuncWithRateInfo produces responses to rate limited requests.
+type HandlerFuncWithRateInfo = application.HandlerFuncWithRateInfo
+
+// WithStatusTooManyRequestsHandlerFuncWithRateInfo sets the handler producing
+// responses to rate limited requests, given when the client's ban expires and
+// the limits it exceeded. It takes precedence over
+// WithStatusTooManyRequestsHandlerFunc. A Retry-After header is set before
+// the handler is called whenever the ban has a fixed end.
+func WithStatusTooManyRequestsHandlerFuncWithRateInfo(h HandlerFuncWithRateInfo) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetStatusTooManyRequestsHandlerFuncWithRateInfo(h)
+	}
+}
+
+func WithStatusUnsupportedMediaTypeHandlerFunc(h application.HandlerFuncWithError) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetStatusUnsupportedMediaTypeHandlerFunc(h)
+	}
+}
+
+// WithValidator sets the Validator invoked after request data is bound.
+func WithValidator(v Validator) applicationSectionOpt {
+	return func(s application.Section) {
+		s.SetValidator(v)
+	}
+}
+
+// BindJSON decodes the JSON request body into v and validates it. On failure
+// the section's bad request handler is invoked and false is returned.
+func BindJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	return bind(w, r, v, binding.JSON)
+}
+
+// BindQuery decodes the query string into the struct pointed to by v and
+// validates it. On failure the section's bad request handler is invoked and
+// false is returned.
+func BindQuery(w http.ResponseWriter, r *http.Request, v any) bool {
+	return bind(w, r, v, binding.Query)
+}
+
+// BindForm decodes the request form into the struct pointed to by v and
+// validates it. On failure the section's bad request handler is invoked and
+// false is returned.
+func BindForm(w http.ResponseWriter, r *http.Request, v any) bool {
+	return bind(w, r, v, binding.Form)
+}
+
+func bind(
+	w http.ResponseWriter,
+	r *http.Request,
+	v any,
+	f func(*http.Request, any) error,
+) bool {
+	if err := f(r, v); err != nil {
+		application.HandleStatusBadRequest(w, r, err)
+		return false
+	}
+	return true
+}
+
+type applicationWrapper struct {
+	application application.Application
+}
+
+// AddApplicationSection implements Application.
+func (a *applicationWrapper) AddApplicationSection(section application.Section) error {
+	return a.application.AddSection(section)
+}
+
+// AddDrainFunc implements Application.
+func (a *applicationWrapper) AddDrainFunc(
+	name string,
+	timeout time.Duration,
+	f func(ctx context.Context) error,
+) func() {
+	return a.application.AddDrainFunc(name, timeout, f)
+}
+
+// Drain implements Application.
+func (a *applicationWrapper) Drain() {
+	a.application.Drain()
+}
+
+// GroomNow implements Application.
+func (a *applicationWrapper) GroomNow() {
+	a.application.GroomRateLimiting()
+}
+
+// ListenAndServe implements Application.
+func (a *applicationWrapper) ListenAndServe() {
+	a.application.ListenAndServe()
+}
+
+// Routes implements Application.
+func (a *applicationWrapper) Routes() []RouteInfo {
+	return a.application.Routes()
+}
+
+// URLFor implements Application.
+func (a *applicationWrapper) URLFor(name string, params ...string) (string, error) {
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("route %q: odd number of params", name)
+	}
+	values := make(map[string]string, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		values[params[i]] = params[i+1]
+	}
+	return a.application.URLFor(name, values)
+}
+
+type applicationOpt = func(application.Application)
+
+func NewApplication(opts ...applicationOpt) Application {
+	a := application.NewApplication()
+	for _, o := range opts {
+		o(a)
+	}
+	return &applicationWrapper{application: a}
+}
+
+// ErrInvalidConfig is wrapped by the errors ValidateConfig and
+// NewApplicationFromConfig return.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// Config declares an application along with its sections, so that it can be
+// validated with ValidateConfig, e.g. in a deploy pipeline, before being
+// built with NewApplicationFromConfig.
+type Config struct {
+	Options  []applicationOpt
+	Sections []SectionConfig
+}
+
+// SectionConfig declares a section of a Config.
+type SectionConfig struct {
+	Root    string
+	Options []applicationSectionOpt
+}
+
+// ValidateConfig builds the application declared by cfg without starting it,
+// returning the errors NewApplicationFromConfig or ListenAndServe would fail
+// with, such as conflicting routes, overlapping section roots, ports bound by
+// several sections and unreadable TLS certificates. Options panicking on
+// misuse are reported as errors. The access log is not opened.
+func ValidateConfig(cfg Config) error {
+	a, err := newApplicationFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if err := a.DryRun(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	return nil
+}
+
+// NewApplicationFromConfig returns the application declared by cfg, or the
+// errors of its sections.
+func NewApplicationFromConfig(cfg Config) (Application, error) {
+	a, err := newApplicationFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &applicationWrapper{application: a}, nil
+}
+
+func newApplicationFromConfig(cfg Config) (result application.Application, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			result, err = nil, fmt.Errorf("%w: %v", ErrInvalidConfig, v)
+		}
+	}()
+	a := application.NewApplication()
+	for _, o := range cfg.Options {
+		o(a)
+	}
+	var errs []error
+	if err := a.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, c := range cfg.Sections {
+		if err := a.AddSection(NewApplicationSection(c.Root, c.Options...)); err != nil {
+			errs = append(errs, fmt.Errorf("section %s: %w", c.Root, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, errors.Join(errs...))
+	}
+	return a, nil
+}
+
+func WithServerListenPort(port int) applicationOpt {
+	return func(a application.Application) {
+		a.SetServerListenPort(port)
+	}
+}
+
+// WithListenHost restricts the interface the application and its sections
+// bind to, such as "127.0.0.1" or "::1". By default all interfaces are bound,
+// over both IPv4 and IPv6 where the platform supports it.
+func WithListenHost(host string) applicationOpt {
+	return func(a application.Application) {
+		a.SetServerListenHost(host)
+	}
+}
+
+// WithListenAddress sets the host and port the application binds to from an
+// address such as "127.0.0.1:8080" or "[::1]:8080". Sections bound to their
+// own ports use the same host. It panics if the address is invalid.
+func WithListenAddress(addr string) applicationOpt {
+	return func(a application.Application) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			panic(fmt.Errorf("invalid listen address %q: %w", addr, err))
+		}
+		portNumber, err := strconv.Atoi(port)
+		if err != nil || portNumber < 0 || portNumber > 65535 {
+			panic(fmt.Errorf("invalid listen address %q: bad port", addr))
+		}
+		a.SetServerListenHost(host)
+		a.SetServerListenPort(portNumber)
+	}
+}
+
+// WithRealIP resolves the client address of every request once, storing it
+// in the request context and rewriting the request's RemoteAddr, so
+// handlers, logs, authentication and rate limiting all agree on it. A nil
+// resolver uses the fastly-client-ip header, then the rightmost
+// x-forwarded-for address, then the connection's remote address. Resolved
+// values that are not IP addresses are ignored, leaving the connection's
+// remote address in place.
+func WithRealIP(r HostResolver) applicationOpt {
+	return func(a application.Application) {
+		if r == nil {
+			r = realip.DefaultResolver
+		}
+		a.SetRealIPResolver(r)
+	}
+}
+
+// SmugglingProtectionConfig configures WithSmugglingProtection.
+type SmugglingProtectionConfig = smuggling.Config
+
+// SuspiciousRequestError lists the reasons a request was found suspicious by
+// WithSmugglingProtection.
+type SuspiciousRequestError = smuggling.Error
+
+// Reasons listed by SuspiciousRequestError.
+const (
+	SuspiciousFramingConflict        = smuggling.ReasonFramingConflict
+	SuspiciousTransferEncodingHTTP10 = smuggling.ReasonTransferEncodingHTTP10
+	SuspiciousUnderscoreHeader       = smuggling.ReasonUnderscoreHeader
+	SuspiciousUnexpectedBody         = smuggling.ReasonUnexpectedBody
+)
+
+// WithSmugglingProtection hardens servers directly exposed to the internet
+// against request smuggling. Requests framed with both Transfer-Encoding and
+// Content-Length, HTTP/1.0 requests with Transfer-Encoding, requests with
+// underscored header names and GET, HEAD, OPTIONS or TRACE requests with a
+// body are published as EventSuspiciousRequest events and counted by the
+// sudsy_suspicious_requests_total metric, labeled by reason, before routing.
+// Depending on the config they are then rejected, normalized or served as
+// is. Framing is only inspected on servers without TLS.
+func WithSmugglingProtection(c SmugglingProtectionConfig) applicationOpt {
+	return func(a application.Application) {
+		a.SetSmugglingConfig(c)
+	}
+}
+
+// WithPropagatedHeaders copies the named inbound headers, e.g. "baggage",
+// "X-Tenant-ID" or "Accept-Language", into the request context. They are
+// available from PropagatedHeaders, set on outbound requests made by clients
+// from NewHTTPClient unless already set, and reported to OnResponse hooks
+// for use as log fields. Baggage headers over 8192 bytes are dropped.
+func WithPropagatedHeaders(names ...string) applicationOpt {
+	return func(a application.Application) {
+		for _, name := range names {
+			a.AddPropagatedHeader(name)
+		}
+	}
+}
+
+// WithTraceSampler samples the trace context of requests. Requests carrying
+// a valid traceparent header continue its trace, and others start a new one.
+// The decision is available from TraceSampled, and sets the sampled flag of
+// the traceparent header propagated by clients from NewHTTPClient. Without a
+// sampler, the trace context is propagated as received.
+func WithTraceSampler(s TraceSampler) applicationOpt {
+	return func(a application.Application) {
+		a.SetTraceSampler(s)
+	}
+}
+
+// RealIPFromContext returns the client address resolved for the request when
+// WithRealIP is configured.
+func RealIPFromContext(ctx context.Context) (string, bool) {
+	return realip.FromContext(ctx)
+}
+
+// WithGlobalOnResponse adds a hook invoked after every request served by the
+// application completes.
+func WithGlobalOnResponse(f func(*ResponseInfo)) applicationOpt {
+	return func(a application.Application) {
+		a.AddOnResponseHook(f)
+	}
+}
+
+// WithAccessLog writes an entry per request served by the application, in
+// the combined log format, to the writer returned by open. It is called when
+// the application starts, which fails if it returns an error, and the writer
+// is closed after shutdown. A rotating writer such as lumberjack's can be
+// returned, or see WithAccessLogFile.
+func WithAccessLog(open func() (io.WriteCloser, error)) applicationOpt {
+	return func(a application.Application) {
+		a.SetAccessLog(open)
+	}
+}
+
+// AccessLogFileConfig configures the access log file of WithAccessLogFile.
+type AccessLogFileConfig = accesslog.FileConfig
+
+// WithAccessLogFile writes the access log to the file at c.Path, rotated when
+// it exceeds c.MaxSize bytes or every c.Interval. Rotated files are renamed
+// with the rotation time, e.g. access-20261017T205231.000.log, optionally
+// gzipped, and removed beyond c.MaxBackups files or once older than c.MaxAge.
+func WithAccessLogFile(c AccessLogFileConfig) applicationOpt {
+	return WithAccessLog(func() (io.WriteCloser, error) {
+		return accesslog.OpenFile(c)
+	})
+}
+
+// ErrorReporter receives recovered handler panics, along with the stack
+// captured at recovery, and internal failures such as servers failing to
+// start or stop and TLS background refreshes failing, for which stack is nil.
+// It may be called concurrently.
+type ErrorReporter = recovery.Reporter
+
+// WithCORS sets the cross-origin configuration of every section, which
+// WithSectionCORS and WithRouteCORS can override.
+func WithCORS(c CORSConfig) applicationOpt {
+	return func(a application.Application) {
+		a.SetCORSConfig(c)
+	}
+}
+
+// WithErrorReporter sets the hook forwarding panics and internal failures to
+// an error tracker such as Sentry or Rollbar.
+func WithErrorReporter(r func(ctx context.Context, err error, stack []byte)) applicationOpt {
+	return func(a application.Application) {
+		a.SetErrorReporter(r)
+	}
+}
+
+// WithFlagProvider sets the provider consulted for sections and routes gated
+// with WithSectionFeatureFlag and WithRouteFeatureFlag. Without a provider
+// every feature is enabled.
+func WithFlagProvider(p FlagProvider) applicationOpt {
+	return func(a application.Application) {
+		a.SetFlagProvider(p)
+	}
+}
+
+// WithGlobalRateLimitingSessionConfig adds a session config to an
+// application-wide rate limiter whose cache is shared by every section, so
+// that a client cannot multiply its budget by spreading requests across
+// section roots. It applies in addition to any section's own limits, and
+// requests exempted from a section's rate limiting are exempt from it too.
+// Rejected requests are answered by the serving section's 429 handler, and
+// its metrics are labeled with the "application" scope.
+func WithGlobalRateLimitingSessionConfig(
+	maxRequests int64,
+	sessionDuration time.Duration,
+	banDuration time.Duration,
+) applicationOpt {
+	return func(a application.Application) {
+		a.AddGlobalRateLimitingSessionConfig(maxRequests, sessionDuration, banDuration)
+	}
+}
+
+// WithGlobalMiddleware wraps the handler dispatching each server's requests
+// to the sections with mw, the first added outermost, so that cross-cutting
+// concerns such as request IDs, logging or recovery are configured once
+// rather than on every section. Global middleware sees every request,
+// including those matching no section, once their client address and trace
+// context have been resolved, and the response hooks and access log see the
+// responses it writes. As with WithMiddleware, a handler returned by mw with
+// the methods BeforeStart(*sync.WaitGroup) and AfterShutdown() is started
+// and shut down with the application. mw is called once per server.
+func WithGlobalMiddleware(mw Middleware) applicationOpt {
+	return func(a application.Application) {
+		a.AddGlobalMiddleware(mw)
+	}
+}
+
+// WithGlobalRateLimitingLazyExpiration disables the background goroutine
+// evicting idle cache entries of the application-wide rate limiter, as
+// WithRateLimitingLazyExpiration does for a section.
+func WithGlobalRateLimitingLazyExpiration() applicationOpt {
+	return func(a application.Application) {
+		a.SetGlobalRateLimitingLazyExpiration(true)
+	}
+}
+
+// WithEventObserver registers f to be called synchronously for every event
+// published by the application and its sections.
+func WithEventObserver(f func(Event)) applicationOpt {
+	return func(a application.Application) {
+		a.AddEventObserver(f)
+	}
+}
+
+// WithMetricsRecorder sets the recorder receiving metrics from the
+// application and all of its sections. The values of the "host", "route" and
+// "tenant" labels are limited to 1000 distinct values each, beyond which
+// they are reported as MetricsOtherLabelValue, see
+// WithMetricsLabelValueLimits.
+func WithMetricsRecorder(r MetricsRecorder) applicationOpt {
+	return func(a application.Application) {
+		a.SetMetricsRecorder(r)
+	}
+}
+
+// MetricsOtherLabelValue replaces the values of limited labels beyond their
+// limit.
+const MetricsOtherLabelValue = metrics.OtherLabelValue
+
+// WithMetricsLabelValueLimits replaces the default limits of the number of
+// distinct values of labels reported to the metrics recorder, keyed by label
+// name, so that scanners requesting random paths or hosts cannot create
+// unbounded numbers of series. Values beyond a label's limit are reported as
+// MetricsOtherLabelValue. Labels missing from limits are not limited.
+func WithMetricsLabelValueLimits(limits map[string]int) applicationOpt {
+	return func(a application.Application) {
+		a.SetMetricsLabelValueLimits(limits)
+	}
+}
+
+// WithRouteTable writes a table of the application's sections, with their
+// listen ports and middleware, outermost first, and routes, with their
+// methods, names and handlers, to w once the servers are configured at
+// startup, e.g. os.Stderr, to help track down shadowed routes. Sections and
+// routes are sorted by root and pattern. A nil w logs the table instead.
+func WithRouteTable(w io.Writer) applicationOpt {
+	return func(a application.Application) {
+		a.SetRouteTable(w)
+	}
+}
+
+// WithAdminSection adds a section at root serving the admin API, which
+// exposes operational introspection and controls for the application. The
+// admin API has no authentication of its own, while it drains the
+// application (POST /drain), re-enables routes (POST /routes/enable) and
+// reports shutdown progress (GET /shutdown), so pass authentication options
+// such as WithBasicAuth in opts, or bind the section to a private port with
+// WithSectionListenPort. An error adding the section is returned by
+// NewApplicationFromConfig and ValidateConfig, and fails ListenAndServe.
+func WithAdminSection(root string, opts ...applicationSectionOpt) applicationOpt {
+	return func(a application.Application) {
+		opts = append(opts, WithSimpleHandler(admin.NewHandler(a, root)))
+		if err := a.AddSection(NewApplicationSection(root, opts...)); err != nil {
+			a.AddConfigError(fmt.Errorf("admin section %s: %w", root, err))
+		}
+	}
+}
+
+// WithDevMode switches the application to development mode: Templates are
+// parsed afresh for every request, 500 responses not handled by a custom
+// handler detail the error and the stack trace of recovered panics, and
+// responses carry "Cache-Control: no-store" without the other caching headers
+// or Strict-Transport-Security, so that browsers neither cache them nor pin
+// the host to HTTPS. It must not be enabled in production.
+func WithDevMode() applicationOpt {
+	return func(a application.Application) {
+		a.SetDevMode(true)
+	}
+}
+
+// DevModeFromContext reports whether the section serving the request is in
+// development mode.
+func DevModeFromContext(ctx context.Context) bool {
+	return application.DevModeFromContext(ctx)
+}
+
+// Templates is a set of HTML templates parsed from a file system, once or, in
+// development mode, afresh for every request.
+type Templates struct {
+	set *templates.Set
+}
+
+// NewTemplates returns the templates matching the patterns in fsys, with the
+// given functions available to them, as well as cspNonce, returning the
+// nonce set by WithContentSecurityPolicy. They are parsed on first use, so
+// that errors are reported by Execute.
+func NewTemplates(fsys fs.FS, funcs template.FuncMap, patterns ...string) *Templates {
+	merged := template.FuncMap{"cspNonce": func() string { return "" }}
+	maps.Copy(merged, funcs)
+	return &Templates{set: templates.NewSet(fsys, merged, patterns...)}
+}
+
+// Execute renders the named template with data to w, parsing the templates
+// afresh if the section serving r is in development mode.
+func (t *Templates) Execute(w io.Writer, r *http.Request, name string, data any) error {
+	reload := DevModeFromContext(r.Context())
+	nonce, ok := csp.NonceFromContext(r.Context())
+	if !ok {
+		tmpl, err := t.set.Template(reload)
+		if err != nil {
+			return err
+		}
+		return tmpl.ExecuteTemplate(w, name, data)
+	}
+	tmpl, err := t.set.Clone(reload)
+	if err != nil {
+		return err
+	}
+	return tmpl.Funcs(template.FuncMap{"cspNonce": func() string { return nonce }}).ExecuteTemplate(w, name, data)
+}
+
+// WithDrainConnectionClose adds a "Connection: close" header to responses
+// served while the application is draining.
+func WithDrainConnectionClose() applicationOpt {
+	return func(a application.Application) {
+		a.SetDrainConnectionClose(true)
+	}
+}
+
+// WithKeepAlivesDisabled disables HTTP keep-alives for the server.
+func WithKeepAlivesDisabled() applicationOpt {
+	return func(a application.Application) {
+		a.SetKeepAlivesEnabled(false)
+	}
+}
+
+// WithMaxRequestsPerConnection closes each connection after it has served n
+// requests.
+func WithMaxRequestsPerConnection(n int64) applicationOpt {
+	return func(a application.Application) {
+		a.SetMaxRequestsPerConnection(n)
+	}
+}
+
+// WithTLSCertificateFiles serves HTTPS using the given certificate and key
+// files. Unless overridden, connections require TLS 1.2 or later with AEAD
+// cipher suites, prefer X25519 and P-256 and negotiate h2 or http/1.1.
+func WithTLSCertificateFiles(certFile, keyFile string) applicationOpt {
+	return func(a application.Application) {
+		a.SetTLSCertificateFiles(certFile, keyFile)
+	}
+}
+
+// WithTLSMinVersion sets the minimum TLS version accepted, e.g.
+// tls.VersionTLS13.
+func WithTLSMinVersion(v uint16) applicationOpt {
+	return func(a application.Application) {
+		a.SetTLSMinVersion(v)
+	}
+}
+
+// WithTLSCipherSuites sets the cipher suites enabled for TLS 1.2 and earlier.
+func WithTLSCipherSuites(ids ...uint16) applicationOpt {
+	return func(a application.Application) {
+		a.SetTLSCipherSuites(ids...)
+	}
+}
+
+// WithTLSClientCAFile enables mutual TLS, verifying client certificates
+// against the PEM encoded CA certificates in file. Unless overridden using
+// WithTLSClientAuth, clients must present a valid certificate.
+func WithTLSClientCAFile(file string) applicationOpt {
+	return func(a application.Application) {
+		a.SetTLSClientCAFile(file)
+	}
+}
+
+// WithTLSClientAuth sets the client certificate policy, e.g.
+// tls.VerifyClientCertIfGiven.
+func WithTLSClientAuth(t tls.ClientAuthType) applicationOpt {
+	return func(a application.Application) {
+		a.SetTLSClientAuth(t)
+	}
+}
+
+// WithTLSCurvePreferences sets the elliptic curves used in ECDHE handshakes,
+// in order of preference.
+func WithTLSCurvePreferences(ids ...tls.CurveID) applicationOpt {
+	return func(a application.Application) {
+		a.SetTLSCurvePreferences(ids...)
+	}
+}
+
+// WithTLSNextProtos sets the ALPN protocols offered, in order of preference.
+func WithTLSNextProtos(protos ...string) applicationOpt {
+	return func(a application.Application) {
+		a.SetTLSNextProtos(protos...)
+	}
+}
+
+// WithOCSPStapling fetches and staples an OCSP response for the certificate
+// configured using WithTLSCertificateFiles, refreshing it before it expires.
+// The certificate file must include the issuer certificate.
+func WithOCSPStapling() applicationOpt {
+	return func(a application.Application) {
+		a.SetOCSPStapling(true)
+	}
+}
+
+// WithSessionTicketKeyProvider replaces the default provider, which generates
+// random keys local to the process.
+func WithSessionTicketKeyProvider(p SessionTicketKeyProvider) applicationOpt {
+	return func(a application.Application) {
+		a.SetSessionTicketKeyProvider(p)
+	}
+}
+
+// WithSessionTicketKeyRotationInterval sets how often session ticket keys are
+// rotated. The default is 12 hours.
+func WithSessionTicketKeyRotationInterval(d time.Duration) applicationOpt {
+	return func(a application.Application) {
+		a.SetSessionTicketKeyRotationInterval(d)
+	}
+}
+
+// WithHijackedConnectionShutdownTimeout sets how long shutdown waits for
+// hijacked connections, such as websockets, to be closed by their handlers
+// after ShutdownNotify channels are closed. Connections still open afterwards
+// are closed forcibly. Defaults to 5 seconds.
+func WithHijackedConnectionShutdownTimeout(d time.Duration) applicationOpt {
+	return func(a application.Application) {
+		a.SetHijackedConnectionTimeout(d)
+	}
+}
+
+// WithListenRetry retries binding a listen address that is in use at startup
+// with exponential backoff for up to d, e.g. while the previous instance
+// releases the port during a rolling restart, instead of failing immediately.
+func WithListenRetry(d time.Duration) applicationOpt {
+	return func(a application.Application) {
+		a.SetListenRetryPeriod(d)
+	}
+}
+
+// WithIdleShutdown gracefully stops the application once no requests have
+// been served for d, for on-demand container platforms that scale to zero.
+// An EventIdleShutdown event is published before shutdown starts. Requests
+// in flight and hijacked connections keep the application active.
+func WithIdleShutdown(d time.Duration) applicationOpt {
+	return func(a application.Application) {
+		a.SetIdleShutdownTimeout(d)
+	}
+}
+
+// WithShutdownProgressInterval sets how often the number of in-flight requests
+// and hijacked connections is logged while the server shuts down.
+func WithShutdownProgressInterval(d time.Duration) applicationOpt {
+	return func(a application.Application) {
+		a.SetShutdownProgressInterval(d)
 	}
 }
 